@@ -0,0 +1,38 @@
+package noiseconn
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrAborted is returned by the I/O methods of a Conn, including the ones in
+// progress, after Abort.
+var ErrAborted = errors.New("connection aborted")
+
+// Abort closes the connection without any grace: unlike Close, it doesn't
+// wait for Options.Linger or send the close-notify, and TCP connections are
+// reset, discarding data that wasn't sent yet. Reads and writes, including
+// the ones in progress, fail with ErrAborted, which is also the reason
+// passed to Options.OnClosed if the connection didn't fail before.
+func (c *Conn) Abort() error {
+	atomic.StoreUint32(&c.aborted, 1)
+	l := &c.lifecycle
+	l.mu.Lock()
+	if l.reason == nil {
+		l.reason = ErrAborted
+	}
+	l.mu.Unlock()
+	if conn, ok := c.Conn.(interface{ SetLinger(sec int) error }); ok {
+		// closing then resets the connection.
+		_ = conn.SetLinger(0)
+	}
+	return c.close(false)
+}
+
+// abortedErr returns ErrAborted in place of err after Abort.
+func (c *Conn) abortedErr(err error) error {
+	if err == nil || atomic.LoadUint32(&c.aborted) == 0 {
+		return err
+	}
+	return ErrAborted
+}
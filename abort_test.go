@@ -0,0 +1,60 @@
+package noiseconn
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestAbort(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	c1, c2 := tcpPair()
+	reason := make(chan error, 1)
+	client, err := NewConn(c1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true},
+		WithLinger(5*time.Second), WithOnClosed(func(c *Conn, err error) { reason <- err }))
+	if err != nil {
+		panic(err)
+	}
+	server, err := NewConn(c2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN},
+		WithHandshakeExtensions())
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+	var eg errgroup.Group
+	eg.Go(client.Handshake)
+	eg.Go(server.Handshake)
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+
+	read := make(chan error, 1)
+	go func() {
+		var b [1]byte
+		_, err := client.Read(b[:])
+		read <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := client.Abort(); err != nil {
+		panic(err)
+	}
+	if err := <-read; !errors.Is(err, ErrAborted) {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	if _, err := client.Write([]byte("hi")); !errors.Is(err, ErrAborted) {
+		t.Fatalf("unexpected write error: %v", err)
+	}
+	if err := <-reason; !errors.Is(err, ErrAborted) {
+		t.Fatalf("unexpected close reason: %v", err)
+	}
+
+	// no close-notify was sent, and the connection was reset.
+	var b [1]byte
+	if _, err := server.Read(b[:]); err == nil || errors.Is(err, io.EOF) {
+		t.Fatalf("unexpected server read error: %v", err)
+	}
+}
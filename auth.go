@@ -0,0 +1,137 @@
+package noiseconn
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/flynn/noise"
+	"github.com/zeebo/errs"
+)
+
+// ErrTokenRejected is returned when the responder rejects the
+// Options.AuthToken of the initiator.
+var ErrTokenRejected = errors.New("token rejected")
+
+// The token exchange consists of the first transport message of each
+// side: the initiator sends the token, and the responder answers with a
+// result byte, followed by the reason for rejections.
+const (
+	authAccepted = 0
+	authRejected = 1
+
+	maxRejectReason = 1024
+)
+
+// authenticate runs the token exchange once the handshake is complete, if
+// enabled. Failures are permanent.
+func (c *Conn) authenticate() error {
+	if c.authToken == nil && c.verifyToken == nil {
+		return nil
+	}
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	if !c.authDone {
+		c.authErr = c.exchangeToken()
+		c.authDone = true
+	}
+	return c.authErr
+}
+
+func (c *Conn) exchangeToken() (err error) {
+	if err := c.handshake(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			c.log(LogWarn, "token exchange failed", "error", err)
+		}
+	}()
+	if c.authToken != nil {
+		if err := c.writeTransport(c.authToken); err != nil {
+			return err
+		}
+		result, err := c.readTransport()
+		if err != nil {
+			return err
+		}
+		switch {
+		case len(result) == 1 && result[0] == authAccepted:
+			return nil
+		case len(result) > 0 && result[0] == authRejected:
+			return fmt.Errorf("%w: %q", ErrTokenRejected, result[1:])
+		}
+		return errs.New("malformed token exchange result")
+	}
+
+	token, err := c.readTransport()
+	if err != nil {
+		return err
+	}
+	verifyErr := c.verifyToken(c.Conn.RemoteAddr(), c.PeerStatic(), token)
+	result := []byte{authAccepted}
+	if verifyErr != nil {
+		reason := verifyErr.Error()
+		if len(reason) > maxRejectReason {
+			reason = reason[:maxRejectReason]
+		}
+		result = append([]byte{authRejected}, reason...)
+	}
+	if err := c.writeTransport(result); err != nil {
+		return err
+	}
+	if verifyErr != nil {
+		return fmt.Errorf("%w: %v", ErrTokenRejected, verifyErr)
+	}
+	return nil
+}
+
+// writeTransport sends b in a transport message.
+func (c *Conn) writeTransport(b []byte) (err error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.writeMsgBuf, err = c.send.Encrypt(append(c.writeMsgBuf[:0], make([]byte, 4)...), nil, b)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	if err := c.frame(c.writeMsgBuf, c.writeMsgBuf[4:]); err != nil {
+		return err
+	}
+	return c.writeFrames(c.writeMsgBuf)
+}
+
+// readTransport returns the payload of the next transport message,
+// handling control frames on the way.
+func (c *Conn) readTransport() ([]byte, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	for {
+		var control bool
+		var err error
+		c.readMsgBuf, control, err = c.readMsg(c.readMsgBuf[:0])
+		if err != nil {
+			return nil, err
+		}
+		if control {
+			if err := c.readControl(c.readMsgBuf); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		msg, err := c.decrypt(nil, c.readMsgBuf)
+		return msg, errs.Wrap(err)
+	}
+}
+
+// validateAuth checks the token options.
+func validateAuth(config noise.Config, opts Options) error {
+	if opts.AuthToken != nil && !config.Initiator {
+		return errs.New("AuthToken is only sent by initiators")
+	}
+	if opts.VerifyToken != nil && config.Initiator {
+		return errs.New("VerifyToken is only used by responders")
+	}
+	if len(opts.AuthToken) > noise.MaxMsgLen-16 {
+		return errs.New("token too large: %d", len(opts.AuthToken))
+	}
+	return nil
+}
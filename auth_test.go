@@ -0,0 +1,72 @@
+package noiseconn
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/flynn/noise"
+	"github.com/zeebo/errs"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestAuthToken(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	verifyToken := func(addr net.Addr, peerStatic, token []byte) error {
+		if !bytes.Equal(token, []byte("secret")) {
+			return errs.New("bad token")
+		}
+		return nil
+	}
+
+	exchange := func(token string) (clientErr, serverErr error) {
+		p1, p2 := net.Pipe()
+		client, err := NewConnWithOptions(p1, noise.Config{
+			CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true,
+		}, Options{AuthToken: []byte(token)})
+		if err != nil {
+			panic(err)
+		}
+		defer func() { _ = client.Close() }()
+		server, err := NewConnWithOptions(p2, noise.Config{
+			CipherSuite: cs, Pattern: noise.HandshakeNN,
+		}, Options{VerifyToken: verifyToken})
+		if err != nil {
+			panic(err)
+		}
+		defer func() { _ = server.Close() }()
+
+		var eg errgroup.Group
+		eg.Go(func() error {
+			if _, clientErr = client.Write([]byte("hello")); clientErr == nil {
+				_, clientErr = io.ReadFull(client, make([]byte, 5))
+			}
+			return nil
+		})
+		eg.Go(func() error {
+			buf := make([]byte, 5)
+			if _, serverErr = io.ReadFull(server, buf); serverErr == nil {
+				_, serverErr = server.Write(buf)
+			}
+			return nil
+		})
+		_ = eg.Wait()
+		return clientErr, serverErr
+	}
+
+	clientErr, serverErr := exchange("secret")
+	if clientErr != nil || serverErr != nil {
+		t.Fatal(clientErr, serverErr)
+	}
+
+	clientErr, serverErr = exchange("wrong")
+	if !errors.Is(clientErr, ErrTokenRejected) || !strings.Contains(clientErr.Error(), "bad token") {
+		t.Fatal("unexpected client error", clientErr)
+	}
+	if !errors.Is(serverErr, ErrTokenRejected) {
+		t.Fatal("unexpected server error", serverErr)
+	}
+}
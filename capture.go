@@ -0,0 +1,126 @@
+package noiseconn
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+// CaptureKind is the kind of a CaptureRecord.
+type CaptureKind byte
+
+const (
+	// CaptureSentFrame is an encrypted Noise message that was sent.
+	CaptureSentFrame CaptureKind = 1
+	// CaptureReceivedFrame is an encrypted Noise message that was
+	// received.
+	CaptureReceivedFrame CaptureKind = 2
+	// CaptureSentPlaintext is plaintext passed to Write.
+	CaptureSentPlaintext CaptureKind = 3
+	// CaptureReceivedPlaintext is plaintext returned by Read.
+	CaptureReceivedPlaintext CaptureKind = 4
+)
+
+const captureHeaderLen = 1 + 8 + 8 + 4
+
+// CaptureRecord is a single event recorded by a CaptureWriter.
+type CaptureRecord struct {
+	Kind CaptureKind
+	// Conn identifies the connection within the capture.
+	Conn uint64
+	Time time.Time
+	// Data is the Noise message, without the stream framing, for frames,
+	// and the plaintext otherwise.
+	Data []byte
+}
+
+// CaptureWriter records the traffic of the connections it is set as
+// Options.Capture for, with timestamps and direction. Records are written
+// in a simple binary format, readable with ReadCaptureRecord.
+//
+// Recording plaintext compromises the security of the connections and
+// should only be enabled for debugging.
+type CaptureWriter struct {
+	plaintext bool
+	nextID    uint64
+
+	mu  sync.Mutex
+	w   io.Writer
+	buf []byte
+	err error
+}
+
+// NewCaptureWriter returns a CaptureWriter writing to w. Plaintext is only
+// recorded if plaintext is true.
+func NewCaptureWriter(w io.Writer, plaintext bool) *CaptureWriter {
+	return &CaptureWriter{w: w, plaintext: plaintext}
+}
+
+// Err returns the first error encountered writing the capture. Once it
+// fails, nothing more is recorded, but the connections are unaffected.
+func (c *CaptureWriter) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *CaptureWriter) newConn() uint64 {
+	return atomic.AddUint64(&c.nextID, 1)
+}
+
+func (c *CaptureWriter) record(conn uint64, kind CaptureKind, data []byte) {
+	if !c.plaintext && (kind == CaptureSentPlaintext || kind == CaptureReceivedPlaintext) {
+		return
+	}
+	now := time.Now().UnixNano()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.buf = append(c.buf[:0], byte(kind))
+	c.buf = binary.BigEndian.AppendUint64(c.buf, conn)
+	c.buf = binary.BigEndian.AppendUint64(c.buf, uint64(now))
+	c.buf = binary.BigEndian.AppendUint32(c.buf, uint32(len(data)))
+	c.buf = append(c.buf, data...)
+	_, c.err = c.w.Write(c.buf)
+}
+
+// recordFrames records every message of a buffer of framed messages.
+func (c *CaptureWriter) recordFrames(conn uint64, buf []byte) {
+	for len(buf) >= 4 {
+		size := int(binary.BigEndian.Uint32(buf[:4]) & 0xffffff)
+		c.record(conn, CaptureSentFrame, buf[4:4+size])
+		buf = buf[4+size:]
+	}
+}
+
+// ReadCaptureRecord reads the next record written by a CaptureWriter. It
+// returns io.EOF at the end of the capture.
+func ReadCaptureRecord(r io.Reader) (*CaptureRecord, error) {
+	var hdr [captureHeaderLen]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, errs.Wrap(err)
+	}
+	size := binary.BigEndian.Uint32(hdr[17:])
+	if size > 1<<(8*3) {
+		return nil, errs.New("capture record too large: %d", size)
+	}
+	rec := &CaptureRecord{
+		Kind: CaptureKind(hdr[0]),
+		Conn: binary.BigEndian.Uint64(hdr[1:]),
+		Time: time.Unix(0, int64(binary.BigEndian.Uint64(hdr[9:]))),
+		Data: make([]byte, size),
+	}
+	if _, err := io.ReadFull(r, rec.Data); err != nil {
+		return nil, errs.Wrap(err)
+	}
+	return rec, nil
+}
@@ -0,0 +1,86 @@
+package noiseconn
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestCapture(t *testing.T) {
+	p1, p2 := net.Pipe()
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+
+	var buf bytes.Buffer
+	capture := NewCaptureWriter(&buf, true)
+	client, err := NewConnWithOptions(p1, noise.Config{
+		CipherSuite: cs,
+		Pattern:     noise.HandshakeNN,
+		Initiator:   true,
+	}, Options{Capture: capture})
+	if err != nil {
+		panic(err)
+	}
+	defer client.Close()
+	server, err := NewConn(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN})
+	if err != nil {
+		panic(err)
+	}
+	defer server.Close()
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		if _, err := server.Write([]byte("pong")); err != nil {
+			return err
+		}
+		_, err := io.ReadFull(server, make([]byte, 4))
+		return err
+	})
+	if _, err := client.Write([]byte("ping")); err != nil {
+		panic(err)
+	}
+	if _, err := io.ReadFull(client, make([]byte, 4)); err != nil {
+		panic(err)
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	if err := capture.Err(); err != nil {
+		panic(err)
+	}
+
+	var kinds []CaptureKind
+	var plaintext []string
+	for {
+		rec, err := ReadCaptureRecord(&buf)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			panic(err)
+		}
+		if rec.Conn != 1 {
+			t.Fatalf("unexpected conn id %d", rec.Conn)
+		}
+		kinds = append(kinds, rec.Kind)
+		if rec.Kind == CaptureSentPlaintext || rec.Kind == CaptureReceivedPlaintext {
+			plaintext = append(plaintext, string(rec.Data))
+		}
+	}
+	// ping is sent in the first handshake message, and pong is received
+	// in the second.
+	want := []CaptureKind{CaptureSentFrame, CaptureSentPlaintext, CaptureReceivedFrame, CaptureReceivedPlaintext}
+	if len(kinds) != len(want) {
+		t.Fatalf("unexpected records %v", kinds)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Fatalf("unexpected records %v", kinds)
+		}
+	}
+	if len(plaintext) != 2 || plaintext[0] != "ping" || plaintext[1] != "pong" {
+		t.Fatalf("unexpected plaintext %q", plaintext)
+	}
+}
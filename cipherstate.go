@@ -0,0 +1,68 @@
+package noiseconn
+
+import (
+	"math"
+
+	"github.com/flynn/noise"
+)
+
+// cipherState is a transport cipher state with the semantics of
+// noise.CipherState, which doesn't allow access to its key and nonce. Both
+// are needed to hand a connection off with Conn.ExportState.
+type cipherState struct {
+	suite   noise.CipherSuite
+	c       noise.Cipher
+	k       [32]byte
+	n       uint64
+	invalid bool
+	// opaque is set while k is unknown, for a state taken over from a
+	// noise.CipherState.
+	opaque bool
+}
+
+func newCipherState(suite noise.CipherSuite, k [32]byte, n uint64) *cipherState {
+	return &cipherState{suite: suite, c: suite.Cipher(k), k: k, n: n}
+}
+
+// takeCipherState takes over cs, which must not be used afterwards.
+func takeCipherState(suite noise.CipherSuite, cs *noise.CipherState) *cipherState {
+	n := cs.Nonce()
+	return &cipherState{suite: suite, c: cs.Cipher(), n: n, opaque: true}
+}
+
+func (s *cipherState) Encrypt(out, ad, plaintext []byte) ([]byte, error) {
+	if s.invalid {
+		return nil, ErrStateExported
+	}
+	if s.n > noise.MaxNonce {
+		return nil, noise.ErrMaxNonce
+	}
+	out = s.c.Encrypt(out, s.n, ad, plaintext)
+	s.n++
+	return out, nil
+}
+
+func (s *cipherState) Decrypt(out, ad, ciphertext []byte) ([]byte, error) {
+	if s.invalid {
+		return nil, ErrStateExported
+	}
+	if s.n > noise.MaxNonce {
+		return nil, noise.ErrMaxNonce
+	}
+	out, err := s.c.Decrypt(out, s.n, ad, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	s.n++
+	return out, nil
+}
+
+// Rekey is the Noise REKEY function.
+func (s *cipherState) Rekey() {
+	var zeros [32]byte
+	out := s.c.Encrypt(nil, math.MaxUint64, []byte{}, zeros[:])
+	copy(s.k[:], out)
+	zero(out)
+	s.c = s.suite.Cipher(s.k)
+	s.opaque = false
+}
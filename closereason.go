@@ -0,0 +1,105 @@
+package noiseconn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+// CloseCode is a machine-readable reason for closing a connection, sent to
+// the peer in an authenticated control frame by Conn.CloseWithReason and
+// Options.SendCloseReason.
+type CloseCode uint16
+
+const (
+	// CloseUnspecified gives no reason.
+	CloseUnspecified CloseCode = 0
+	// CloseProtocolViolation is sent when the peer sent frames that failed
+	// authentication or were malformed.
+	CloseProtocolViolation CloseCode = 1
+	// ClosePolicy is sent when the peer was rejected by a policy of the
+	// application, such as an authorization check.
+	ClosePolicy CloseCode = 2
+	// CloseShutdown is sent when the application is shutting down.
+	CloseShutdown CloseCode = 3
+
+	// CloseApplication and larger codes are defined by applications.
+	CloseApplication CloseCode = 0x1000
+)
+
+// closeReasonTimeout bounds sending the close reason, unless Options.Linger
+// is longer.
+const closeReasonTimeout = time.Second
+
+// maxCloseMessage is the longest message sent with a close reason.
+const maxCloseMessage = 1024
+
+func (code CloseCode) String() string {
+	switch {
+	case code == CloseUnspecified:
+		return "unspecified"
+	case code == CloseProtocolViolation:
+		return "protocol violation"
+	case code == ClosePolicy:
+		return "policy"
+	case code == CloseShutdown:
+		return "shutdown"
+	case code >= CloseApplication:
+		return fmt.Sprintf("application %d", uint16(code-CloseApplication))
+	default:
+		return fmt.Sprintf("unknown %d", uint16(code))
+	}
+}
+
+// PeerCloseError is returned by reads once the peer closed the connection
+// with a reason, and by every read afterwards.
+type PeerCloseError struct {
+	Code    CloseCode
+	Message string
+}
+
+func (e *PeerCloseError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("peer closed the connection: %v", e.Code)
+	}
+	return fmt.Sprintf("peer closed the connection: %v: %s", e.Code, e.Message)
+}
+
+// CloseWithReason is like Close, but first tells the peer why the connection
+// is closed, so that its reads fail with a PeerCloseError carrying code and
+// message. Writes in progress may finish first, within Options.Linger or a
+// second, whichever is longer. The reason is only sent once the handshake
+// completed, to peers that support control frames, and replaces the
+// close-notify.
+func (c *Conn) CloseWithReason(code CloseCode, message string) error {
+	c.sendCloseReason(code, message)
+	return c.close(false)
+}
+
+// sendCloseReason sends the close reason control frame.
+func (c *Conn) sendCloseReason(code CloseCode, message string) {
+	if len(message) > maxCloseMessage {
+		message = message[:maxCloseMessage]
+	}
+	timeout := closeReasonTimeout
+	if c.lingerState.timeout > timeout {
+		timeout = c.lingerState.timeout
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	c.sendFinal(controlCloseReason, append(payload, message...), timeout)
+}
+
+// readCloseReason returns the error of a received close reason, which fails
+// every later read. c.readMu must be held.
+func (c *Conn) readCloseReason(payload []byte) error {
+	if len(payload) < 2 {
+		return errs.New("malformed close reason")
+	}
+	c.peerCloseErr = &PeerCloseError{
+		Code:    CloseCode(binary.BigEndian.Uint16(payload)),
+		Message: string(payload[2:]),
+	}
+	return c.peerCloseErr
+}
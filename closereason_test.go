@@ -0,0 +1,70 @@
+package noiseconn
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestCloseWithReason(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	pair := func(serverOpts ...Option) (*tamperConn, *Conn, *Conn) {
+		p1, p2 := tcpPair()
+		tamper := &tamperConn{Conn: p1, offset: -1}
+		client, err := NewConn(tamper, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true},
+			WithHandshakeExtensions())
+		if err != nil {
+			panic(err)
+		}
+		server, err := NewConn(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, serverOpts...)
+		if err != nil {
+			panic(err)
+		}
+		var eg errgroup.Group
+		eg.Go(client.Handshake)
+		eg.Go(server.Handshake)
+		if err := eg.Wait(); err != nil {
+			panic(err)
+		}
+		return tamper, client, server
+	}
+	expectReason := func(c *Conn, code CloseCode) {
+		t.Helper()
+		var b [16]byte
+		for i := 0; i < 2; i++ {
+			_, err := c.Read(b[:])
+			var closeErr *PeerCloseError
+			if !errors.As(err, &closeErr) || closeErr.Code != code {
+				t.Fatalf("expected close reason %v, got %v", code, err)
+			}
+		}
+	}
+
+	// the application rejects the peer.
+	_, client, server := pair(WithHandshakeExtensions())
+	if err := server.CloseWithReason(ClosePolicy, "not allowed"); err != nil {
+		panic(err)
+	}
+	expectReason(client, ClosePolicy)
+	var b [1]byte
+	_, err := client.Read(b[:])
+	if closeErr := (*PeerCloseError)(nil); !errors.As(err, &closeErr) || closeErr.Message != "not allowed" {
+		t.Fatalf("unexpected close reason: %v", err)
+	}
+	_ = client.Close()
+
+	// the server tears the connection down after a corrupt frame.
+	tamper, client, server := pair(WithSendCloseReason())
+	defer func() { _ = server.Close() }()
+	defer func() { _ = client.Close() }()
+	tamper.offset = 5
+	if _, err := client.Write([]byte("corrupt")); err != nil {
+		panic(err)
+	}
+	if _, err := server.Read(b[:]); !errors.Is(err, ErrDecryptFailed) {
+		t.Fatalf("expected ErrDecryptFailed, got %v", err)
+	}
+	expectReason(client, CloseProtocolViolation)
+}
@@ -0,0 +1,295 @@
+// Command noisebench measures handshake rate, streaming throughput, and
+// round-trip latency of noiseconn between two endpoints.
+//
+// Run a server on one machine and a client on another:
+//
+//	noisebench -mode server -listen :7777
+//	noisebench -mode client -target host:7777 -bench throughput
+//
+// or measure over loopback in a single process with -mode local.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/flynn/noise"
+	"github.com/jtolio/noiseconn"
+	"github.com/jtolio/noiseconn/internal/cmdutil"
+)
+
+// the first byte a client sends after the handshake selects what the
+// server does with the connection.
+const (
+	opHandshake  = 'h'
+	opThroughput = 't'
+	opLatency    = 'l'
+)
+
+type benchmark struct {
+	target      string
+	bench       string
+	duration    time.Duration
+	concurrency int
+	size        int
+	dialer      noiseconn.Dialer
+}
+
+func main() {
+	var client, server cmdutil.Endpoint
+	client.RegisterFlags(flag.CommandLine, "")
+	mode := flag.String("mode", "local", "client, server, or local (both over loopback)")
+	listen := flag.String("listen", "127.0.0.1:0", "server address to listen on")
+	var b benchmark
+	flag.StringVar(&b.target, "target", "", "server address for client mode")
+	flag.StringVar(&b.bench, "bench", "all", "handshake, throughput, latency, or all")
+	flag.DurationVar(&b.duration, "duration", 5*time.Second, "duration of each benchmark")
+	flag.IntVar(&b.concurrency, "concurrency", 1, "number of concurrent connections")
+	flag.IntVar(&b.size, "size", 32*1024, "write size for throughput, message size for latency")
+	flag.Parse()
+
+	var err error
+	switch *mode {
+	case "server":
+		err = serve(&client, *listen, nil)
+	case "client":
+		err = b.run(&client)
+	case "local":
+		// the server gets its own key, which the client pins, so patterns
+		// with a known responder key work out of the box.
+		var key noise.DHKey
+		key, err = noise.DH25519.GenerateKeypair(rand.Reader)
+		if err != nil {
+			break
+		}
+		server = cmdutil.Endpoint{Protocol: client.Protocol, Key: base64.StdEncoding.EncodeToString(key.Private)}
+		client.Peer, client.PeerFile = cmdutil.FormatPublicKey(key.Public), ""
+		ready := make(chan string, 1)
+		go func() {
+			if err := serve(&server, *listen, ready); err != nil {
+				fmt.Fprintln(os.Stderr, "noisebench:", err)
+				os.Exit(1)
+			}
+		}()
+		b.target = <-ready
+		err = b.run(&client)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "noisebench:", err)
+		os.Exit(1)
+	}
+}
+
+func serve(endpoint *cmdutil.Endpoint, address string, ready chan<- string) error {
+	config, opts, err := endpoint.Config(false)
+	if err != nil {
+		return err
+	}
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	if ready != nil {
+		ready <- lis.Addr().String()
+	} else {
+		fmt.Fprintf(os.Stderr, "listening on %v\n", lis.Addr())
+	}
+	nlis := noiseconn.NewListenerWithOptions(lis, config, opts)
+	for {
+		conn, err := nlis.Accept()
+		if err != nil {
+			return err
+		}
+		go handle(conn)
+	}
+}
+
+func handle(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	var op [1]byte
+	if _, err := io.ReadFull(conn, op[:]); err != nil {
+		return
+	}
+	switch op[0] {
+	case opHandshake:
+		// the client closes once it has the reply.
+		_, _ = conn.Write(op[:])
+	case opThroughput:
+		_, _ = io.Copy(io.Discard, conn)
+	case opLatency:
+		_, _ = io.Copy(conn, conn)
+	}
+}
+
+func (b *benchmark) run(endpoint *cmdutil.Endpoint) error {
+	if b.target == "" {
+		return errors.New("no target")
+	}
+	config, opts, err := endpoint.Config(true)
+	if err != nil {
+		return err
+	}
+	b.dialer = noiseconn.Dialer{Config: config, Options: opts}
+	fmt.Printf("protocol %s, %d connection(s), %v per benchmark\n", endpoint.Protocol, b.concurrency, b.duration)
+
+	benches := map[string]func() error{
+		"handshake":  b.handshakes,
+		"throughput": b.throughput,
+		"latency":    b.latency,
+	}
+	names := []string{b.bench}
+	if b.bench == "all" {
+		names = []string{"handshake", "throughput", "latency"}
+	}
+	for _, name := range names {
+		fn, ok := benches[name]
+		if !ok {
+			return fmt.Errorf("unknown benchmark %q", name)
+		}
+		if err := fn(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// parallel runs fn on every connection slot until the duration elapses.
+func (b *benchmark) parallel(fn func(ctx context.Context) error) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), b.duration)
+	defer cancel()
+	start := time.Now()
+	var wg sync.WaitGroup
+	errc := make(chan error, b.concurrency)
+	for i := 0; i < b.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(ctx); err != nil && ctx.Err() == nil {
+				errc <- err
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	select {
+	case err := <-errc:
+		return elapsed, err
+	default:
+		return elapsed, nil
+	}
+}
+
+func (b *benchmark) open(ctx context.Context, op byte) (net.Conn, error) {
+	conn, err := b.dialer.DialContext(ctx, "tcp", b.target)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write([]byte{op}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (b *benchmark) handshakes() error {
+	var count int64
+	elapsed, err := b.parallel(func(ctx context.Context) error {
+		for ctx.Err() == nil {
+			conn, err := b.open(ctx, opHandshake)
+			if err != nil {
+				return err
+			}
+			var reply [1]byte
+			_, err = io.ReadFull(conn, reply[:])
+			_ = conn.Close()
+			if err != nil {
+				return err
+			}
+			atomic.AddInt64(&count, 1)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("handshake:  %.1f handshakes/s\n", float64(count)/elapsed.Seconds())
+	return nil
+}
+
+func (b *benchmark) throughput() error {
+	var total int64
+	elapsed, err := b.parallel(func(ctx context.Context) error {
+		conn, err := b.open(ctx, opThroughput)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = conn.Close() }()
+		buf := make([]byte, b.size)
+		for ctx.Err() == nil {
+			n, err := conn.Write(buf)
+			atomic.AddInt64(&total, int64(n))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("throughput: %.1f MB/s\n", float64(total)/elapsed.Seconds()/1e6)
+	return nil
+}
+
+func (b *benchmark) latency() error {
+	var mu sync.Mutex
+	var samples []time.Duration
+	_, err := b.parallel(func(ctx context.Context) error {
+		conn, err := b.open(ctx, opLatency)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = conn.Close() }()
+		buf := make([]byte, b.size)
+		var local []time.Duration
+		for ctx.Err() == nil {
+			start := time.Now()
+			if _, err := conn.Write(buf); err != nil {
+				return err
+			}
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				return err
+			}
+			local = append(local, time.Since(start))
+		}
+		mu.Lock()
+		samples = append(samples, local...)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(samples) == 0 {
+		return errors.New("no samples")
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	pct := func(p float64) time.Duration { return samples[int(p*float64(len(samples)-1))] }
+	fmt.Printf("latency:    p50 %v, p90 %v, p99 %v (%d round trips of %d bytes)\n",
+		pct(0.5), pct(0.9), pct(0.99), len(samples), b.size)
+	return nil
+}
@@ -0,0 +1,103 @@
+// Command noisecat connects to or listens for a single Noise connection
+// and pipes stdin and stdout through it, like netcat.
+//
+// Usage:
+//
+//	noisecat [flags] host:port
+//	noisecat -l [flags] [host]:port
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/jtolio/noiseconn"
+	"github.com/jtolio/noiseconn/internal/cmdutil"
+)
+
+func main() {
+	var endpoint cmdutil.Endpoint
+	endpoint.RegisterFlags(flag.CommandLine, "")
+	listen := flag.Bool("l", false, "listen for a connection instead of connecting")
+	timeout := flag.Duration("timeout", 30*time.Second, "handshake timeout")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: noisecat [-l] [flags] address\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(&endpoint, *listen, flag.Arg(0), *timeout); err != nil {
+		fmt.Fprintln(os.Stderr, "noisecat:", err)
+		os.Exit(1)
+	}
+}
+
+func run(endpoint *cmdutil.Endpoint, listen bool, address string, timeout time.Duration) error {
+	config, opts, err := endpoint.Config(!listen)
+	if err != nil {
+		return err
+	}
+
+	var raw net.Conn
+	if listen {
+		lis, err := net.Listen("tcp", address)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "listening on %v\n", lis.Addr())
+		raw, err = lis.Accept()
+		_ = lis.Close()
+		if err != nil {
+			return err
+		}
+	} else {
+		raw, err = net.DialTimeout("tcp", address, timeout)
+		if err != nil {
+			return err
+		}
+	}
+	conn, err := noiseconn.NewConnWithOptions(raw, config, opts)
+	if err != nil {
+		_ = raw.Close()
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return err
+	}
+	if peer := conn.PeerStatic(); peer != nil {
+		fmt.Fprintf(os.Stderr, "connected to %v with peer key %s\n", conn.RemoteAddr(), cmdutil.FormatPublicKey(peer))
+	}
+
+	// there is no half-close in Noise, so the end of stdin isn't sent to
+	// the peer. the session ends when the peer closes the connection, or
+	// when writing to it fails.
+	errc := make(chan error, 2)
+	go func() {
+		if _, err := io.Copy(conn, os.Stdin); err != nil {
+			errc <- err
+		}
+	}()
+	go func() {
+		_, err := io.Copy(os.Stdout, conn)
+		errc <- err
+	}()
+	err = <-errc
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return err
+}
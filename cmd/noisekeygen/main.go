@@ -0,0 +1,121 @@
+// Command noisekeygen generates Curve25519 static keypairs in the
+// noiseconn key-file format, and prints key fingerprints.
+//
+// Usage:
+//
+//	noisekeygen -out name        writes name (private) and name.pub
+//	noisekeygen -out name -format encrypted -passphrase-file file
+//	noisekeygen -fingerprint [-hex] [-randomart] file...
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/flynn/noise"
+	"github.com/jtolio/noiseconn"
+	"github.com/jtolio/noiseconn/internal/cmdutil"
+)
+
+func main() {
+	out := flag.String("out", "", "file to write the private key to; the public key is written to the same name with .pub appended")
+	force := flag.Bool("force", false, "overwrite existing files")
+	format := flag.String("format", "pem", "private key format: pem, raw or encrypted")
+	passphraseFile := flag.String("passphrase-file", "", "file with the passphrase for -format encrypted")
+	fingerprint := flag.Bool("fingerprint", false, "print the fingerprints of the key files given as arguments")
+	hexFingerprint := flag.Bool("hex", false, "print fingerprints in hex instead of base64")
+	randomart := flag.Bool("randomart", false, "print the randomart of fingerprints")
+	flag.Parse()
+
+	var err error
+	switch {
+	case *fingerprint:
+		err = printFingerprints(flag.Args(), *hexFingerprint, *randomart)
+	case *out != "" && flag.NArg() == 0:
+		err = generate(*out, *force, *format, *passphraseFile)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "noisekeygen:", err)
+		os.Exit(1)
+	}
+}
+
+func generate(out string, force bool, format, passphraseFile string) error {
+	opts := noiseconn.KeyFileOptions{Overwrite: force}
+	switch format {
+	case "pem":
+		opts.Format = noiseconn.KeyFormatPEM
+	case "raw":
+		opts.Format = noiseconn.KeyFormatRaw
+	case "encrypted":
+		if passphraseFile == "" {
+			return errors.New("-format encrypted requires -passphrase-file")
+		}
+		passphrase, err := cmdutil.ReadPassphrase(passphraseFile)
+		if err != nil {
+			return err
+		}
+		opts.Format, opts.Passphrase = noiseconn.KeyFormatEncrypted, passphrase
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	key, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		return err
+	}
+	if err := noiseconn.SaveKeypair(out, key, opts); err != nil {
+		return err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	if err := writeFile(out+".pub", flags, 0o644, noiseconn.EncodePublicKey(key.Public)); err != nil {
+		return err
+	}
+	fmt.Printf("public key:  %s\n", base64.StdEncoding.EncodeToString(key.Public))
+	fmt.Printf("fingerprint: %s\n", noiseconn.Fingerprint(key.Public))
+	return nil
+}
+
+func writeFile(name string, flags int, perm os.FileMode, data []byte) error {
+	f, err := os.OpenFile(name, flags, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func printFingerprints(names []string, hex, randomart bool) error {
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		public, err := noiseconn.DecodePublicKey(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		fingerprint := noiseconn.Fingerprint(public)
+		if hex {
+			fingerprint = noiseconn.FingerprintHex(public)
+		}
+		fmt.Printf("%s %s\n", fingerprint, name)
+		if randomart {
+			fmt.Println(noiseconn.Randomart(public))
+		}
+	}
+	return nil
+}
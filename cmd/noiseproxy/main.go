@@ -0,0 +1,175 @@
+// Command noiseproxy protects TCP services with Noise without changing
+// them.
+//
+// In client mode, it accepts plaintext connections and forwards each of
+// them over Noise to a noiseproxy in server mode (or any other noiseconn
+// endpoint). In server mode, it accepts Noise connections and forwards each
+// of them in plaintext to the target service.
+//
+// On SIGINT or SIGTERM, noiseproxy stops accepting connections and waits
+// up to the grace period for active connections to finish.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jtolio/noiseconn"
+	"github.com/jtolio/noiseconn/internal/cmdutil"
+)
+
+func main() {
+	var endpoint cmdutil.Endpoint
+	endpoint.RegisterFlags(flag.CommandLine, "")
+	mode := flag.String("mode", "client", "client (plaintext in, Noise out) or server (Noise in, plaintext out)")
+	listen := flag.String("listen", "", "address to listen on")
+	target := flag.String("target", "", "address to forward connections to")
+	timeout := flag.Duration("timeout", 10*time.Second, "dial and handshake timeout")
+	grace := flag.Duration("grace", 30*time.Second, "how long to wait for active connections on shutdown")
+	flag.Parse()
+	if *listen == "" || *target == "" || (*mode != "client" && *mode != "server") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	p := &proxy{target: *target, timeout: *timeout}
+	if err := p.run(ctx, &endpoint, *mode == "server", *listen, *grace); err != nil {
+		fmt.Fprintln(os.Stderr, "noiseproxy:", err)
+		os.Exit(1)
+	}
+}
+
+type proxy struct {
+	target  string
+	timeout time.Duration
+	dialer  noiseconn.Dialer
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+func (p *proxy) run(ctx context.Context, endpoint *cmdutil.Endpoint, server bool, address string, grace time.Duration) error {
+	config, opts, err := endpoint.Config(!server)
+	if err != nil {
+		return err
+	}
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	if server {
+		lis = noiseconn.NewListenerWithOptions(lis, config, opts)
+	} else {
+		p.dialer = noiseconn.Dialer{Config: config, Options: opts, HandshakeTimeout: p.timeout}
+	}
+	log.Printf("forwarding %v to %v", lis.Addr(), p.target)
+
+	p.conns = map[net.Conn]struct{}{}
+	go func() {
+		<-ctx.Done()
+		_ = lis.Close()
+	}()
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return err
+		}
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			if err := p.handle(conn, server); err != nil {
+				log.Printf("%v: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+
+	log.Printf("shutting down, waiting up to %v for active connections", grace)
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(grace):
+		p.mu.Lock()
+		for conn := range p.conns {
+			_ = conn.Close()
+		}
+		p.mu.Unlock()
+		<-done
+	}
+	return nil
+}
+
+func (p *proxy) handle(in net.Conn, server bool) error {
+	p.track(in)
+	defer p.untrack(in)
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	var out net.Conn
+	var err error
+	if server {
+		if err := in.(*noiseconn.Conn).HandshakeContext(ctx); err != nil {
+			return err
+		}
+		out, err = (&net.Dialer{}).DialContext(ctx, "tcp", p.target)
+	} else {
+		out, err = p.dialer.DialContext(ctx, "tcp", p.target)
+	}
+	if err != nil {
+		return err
+	}
+	p.track(out)
+	defer p.untrack(out)
+
+	relay(in, out)
+	return nil
+}
+
+// relay copies data in both directions until one of them ends. Noise has
+// no half-close, so the end of either direction closes both connections.
+func relay(a, b net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	copyClose := func(dst, src net.Conn) {
+		defer wg.Done()
+		_, _ = io.Copy(dst, src)
+		_ = dst.Close()
+		_ = src.Close()
+	}
+	go copyClose(a, b)
+	go copyClose(b, a)
+	wg.Wait()
+}
+
+func (p *proxy) track(conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.conns[conn] = struct{}{}
+}
+
+func (p *proxy) untrack(conn net.Conn) {
+	p.mu.Lock()
+	delete(p.conns, conn)
+	p.mu.Unlock()
+	_ = conn.Close()
+}
@@ -1,17 +1,27 @@
 package noiseconn
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/flynn/noise"
 	"github.com/zeebo/errs"
 )
 
 const HeaderByte = 0x80
+
+// controlHeaderByte replaces HeaderByte in the frame header of control
+// frames, which are only sent to peers that announced support for them.
+const controlHeaderByte = 0x81
 const flushLimit = 640 * 1024
 
 // MessageInspector is a callback that gets informed about unparsed
@@ -27,6 +37,435 @@ type Options struct {
 	// (see github.com/jtolio/noiseconn/debounce), and other issues,
 	// but is not safe for use as replay attack prevention.
 	ResponderFirstMessageValidator MessageInspector
+
+	// VerifyPeer, if set, is called with the static public key of the peer
+	// as soon as it is known: when it is received in a handshake message,
+	// or, if it was provided in noise.Config, before the first handshake
+	// message is sent. If it returns an error, the handshake fails and no
+	// data sent by the peer is returned.
+	VerifyPeer PeerVerifier
+
+	// ProxyProtocol, if set, expects the underlying net.Conn to start with
+	// a PROXY protocol v2 header, such as the ones sent by L4 load
+	// balancers. The header is consumed before the handshake, and
+	// RemoteAddr and LocalAddr report the original client's addresses.
+	// Calling RemoteAddr or LocalAddr blocks until the header is read,
+	// which must happen within 10 seconds.
+	// The header must come from a trusted source, as it is not
+	// authenticated. It is not supported for a MessageTransport.
+	ProxyProtocol bool
+
+	// KeyLog, if set, receives the traffic secrets of the connection once
+	// the handshake completes, so that captured traffic can be decrypted
+	// by analysis tooling. Two lines are written per connection:
+	//
+	//	NOISE_INITIATOR_TRAFFIC_SECRET <handshake hash> <key> <protocol>
+	//	NOISE_RESPONDER_TRAFFIC_SECRET <handshake hash> <key> <protocol>
+	//
+	// where the handshake hash and keys are hex encoded, and the protocol
+	// is the Noise protocol name without the pattern. Writes are
+	// serialized across connections. Using KeyLog compromises the security
+	// of the connection and should only be done for debugging.
+	KeyLog io.Writer
+
+	// Capture, if set, records the encrypted frames, and optionally the
+	// plaintext, of the connection.
+	Capture *CaptureWriter
+
+	// Transcript, if set, is called with a transcript of the handshake
+	// once it completes or fails. It is called with the handshake lock
+	// held, so it must not use the Conn.
+	Transcript func(*HandshakeTranscript)
+
+	// Random, if set, is the source of randomness for the ephemeral keys
+	// of the handshake, overriding noise.Config.Random. A deterministic
+	// source makes handshakes reproducible, which is only ever appropriate
+	// in tests.
+	Random io.Reader
+
+	// HandshakeExtensions enables handshake extensions, which carry
+	// options such as Identity and NextStatic alongside handshake
+	// payloads. They change the format of handshake payloads, so they must
+	// be enabled on both peers or neither; they are bound into the
+	// prologue, so a mismatch fails the handshake. The options that need
+	// them enable them implicitly.
+	HandshakeExtensions bool
+
+	// Identity, if set, is the certificate chain of the local static key,
+	// leaf first, which is sent to the peer in the first handshake message
+	// written after the static key.
+	Identity []Certificate
+
+	// IdentityRoots, if set, are the Ed25519 keys trusted to issue the
+	// peer's certificates. The handshake fails unless the peer presents a
+	// chain that VerifyCertificateChain accepts with these roots, and its
+	// leaf is returned by PeerIdentity. No data sent by the peer is
+	// returned before its identity is verified.
+	//
+	// Identity and IdentityRoots enable HandshakeExtensions.
+	IdentityRoots []ed25519.PublicKey
+
+	// NextStatic, if set, is the static public key this peer will switch
+	// to in the future. It is advertised to peers that support it, in an
+	// authenticated control frame, as soon as the handshake completes; see
+	// AdvertiseNextStatic. It enables HandshakeExtensions.
+	NextStatic []byte
+
+	// NextPeerStatic, if set, is called when the peer advertises the
+	// static public key it will switch to, so that pinned keys can be
+	// updated before the switch. It is called from Read, with the address
+	// and current static key of the peer, and an error fails the Read. It
+	// enables HandshakeExtensions, and the peer is told that control
+	// frames are supported, unless the underlying net.Conn is a
+	// MessageTransport.
+	NextPeerStatic func(addr net.Addr, peerStatic, next []byte) error
+
+	// PostQuantum selects whether the handshake is augmented with a
+	// post-quantum KEM; see PostQuantumMode. Modes other than
+	// PostQuantumDisabled enable HandshakeExtensions.
+	PostQuantum PostQuantumMode
+
+	// StaticHint, if set on an initiator, is sent to the responder before
+	// the first handshake message, so that a responder hosting several
+	// identities can select the static key for the handshake with
+	// SelectStatic. The hint is sent in cleartext, but it is mixed into
+	// the prologue on both sides, so the handshake fails if it was
+	// tampered with. It may be at most 1024 bytes long.
+	StaticHint []byte
+
+	// ServerName, if set on an initiator, is the name of the service it
+	// connects to, for responders hosting several services on one address,
+	// such as a Listener with VirtualHosts. It is sent as the StaticHint,
+	// so it can't be used along with one: in cleartext before the first
+	// handshake message, as the responder needs it to pick the keys and
+	// pattern to process that message with, and bound to the handshake.
+	// Conn.ServerName returns it on the responder.
+	ServerName string
+
+	// SelectStatic, if set on a responder, is called with the StaticHint
+	// of the initiator and returns the static keypair to use for the
+	// handshake, overriding noise.Config.StaticKeypair. Initiators must
+	// send a hint, and an error fails the handshake. It can't be used
+	// with Identity.
+	SelectStatic func(addr net.Addr, hint []byte) (noise.DHKey, error)
+
+	// StaticKeys, if set, is the static keypair, overriding
+	// noise.Config.StaticKeypair, which can be rotated at runtime, such as
+	// for a Listener, without affecting established connections. With
+	// patterns where the initiator knows the static key of the responder
+	// beforehand, such as IK, responders also accept the keys replaced
+	// during their grace period, by trying each on the first handshake
+	// message. It can't be used with StaticKey, SelectStatic or Identity.
+	StaticKeys *StaticKeys
+
+	// FallbackPattern, if set on a responder whose pattern has the
+	// initiator know its static key beforehand, such as IK, is the
+	// pattern used instead when the first handshake message can't be read
+	// with that pattern, such as HandshakeXX for initiators that don't
+	// know the static key yet (see Dialer.SessionCache). Both patterns
+	// must be usable with the rest of the configuration.
+	FallbackPattern noise.HandshakePattern
+
+	// StaticKey, if set, is the static keypair, overriding
+	// noise.Config.StaticKeypair. The DH operations with its private key
+	// are performed by StaticKey, so the private key doesn't need to be in
+	// process memory.
+	StaticKey StaticKey
+
+	// AuthToken, if set on an initiator, is an application token, such as
+	// a JWT or a macaroon, presented to the responder in the first
+	// transport message after the handshake. No data is exchanged until
+	// the responder accepted the token: Read and Write complete the
+	// handshake and the exchange first, without handshake payloads, so
+	// 0-RTT data isn't sent. If the token is rejected, they fail with an
+	// error wrapping ErrTokenRejected and the reason of the responder.
+	AuthToken []byte
+
+	// VerifyToken, if set on a responder, is called with the address,
+	// static public key and AuthToken of the initiator before any data is
+	// exchanged, and accepts the token by returning nil. The error of a
+	// rejection is sent to the initiator as the reason. It must be set
+	// exactly when the initiator sets AuthToken.
+	VerifyToken func(addr net.Addr, peerStatic, token []byte) error
+
+	// SendTimestamp, if set on an initiator, includes the current time and
+	// a random nonce in the first handshake message, so that responders
+	// with MaxTimestampAge can reject replays of it. This matters for
+	// patterns like IK, where the first message can carry 0-RTT data that
+	// an attacker could replay. It enables HandshakeExtensions.
+	SendTimestamp bool
+
+	// MaxTimestampAge, if nonzero on a responder, rejects first handshake
+	// messages without a timestamp, or with a timestamp more than
+	// MaxTimestampAge away from the current time, failing the handshake
+	// with an error wrapping ErrReplayedHandshake before their payload is
+	// returned. It enables HandshakeExtensions.
+	MaxTimestampAge time.Duration
+
+	// ReplayCache, if set on a responder with MaxTimestampAge, is used to
+	// also reject first handshake messages whose timestamp was already
+	// seen within the freshness window.
+	ReplayCache ReplayCache
+
+	// EarlyDataTokens, if set on a responder, only accepts 0-RTT data,
+	// that is, data in the first handshake message, with a token it issued
+	// and that wasn't used before. First handshake messages with data but
+	// without such a token fail the handshake with an error wrapping
+	// ErrReplayedHandshake. Once a handshake completes, a new token is
+	// sent to initiators that support control frames, for their next
+	// connection. Initiators without a token must complete the handshake
+	// before writing. It enables HandshakeExtensions.
+	EarlyDataTokens EarlyDataTokenStore
+
+	// EarlyDataToken, if set on an initiator, is a token issued by the
+	// responder with EarlyDataTokens, and sent in the first handshake
+	// message to allow 0-RTT data. Each token can be used once. It enables
+	// HandshakeExtensions.
+	EarlyDataToken []byte
+
+	// OnEarlyDataToken, if set on an initiator, is called with the tokens
+	// the responder issues, to be used as EarlyDataToken of a later
+	// connection. It is called from Read, so it must not block or call
+	// methods of the Conn. It enables HandshakeExtensions.
+	OnEarlyDataToken func(token []byte)
+
+	// Hooks, if set, observe the connection.
+	Hooks *Hooks
+
+	// Logger, if set, receives notable events of the connection, and of
+	// the Listener or Dialer it is used with.
+	Logger Logger
+
+	// OnConnected, if set, is called once the handshake completed, before
+	// the call that completed it returns. Unlike Hooks, it is called
+	// without internal locks held, so it may use the Conn.
+	OnConnected func(c *Conn)
+
+	// OnClosed, if set, is called once the Conn is closed, after Close
+	// released its resources. reason is the first error that ended the
+	// connection, such as a failed handshake, the peer closing the
+	// connection or a decryption failure, and is nil if the connection
+	// didn't fail before Close. Timeouts aren't considered failures.
+	OnClosed func(c *Conn, reason error)
+
+	// ExplicitHandshake makes Read, ReadBuffers, Peek, Write and the
+	// methods of MessageConn fail with ErrHandshakeRequired until Handshake
+	// or HandshakeContext completed, instead of driving the handshake
+	// themselves.
+	ExplicitHandshake bool
+
+	// WriteWaitsForHandshake makes Write and MessageConn.WriteMsg, while it
+	// is the turn of the peer to send a handshake message, wait for Read,
+	// Handshake or HandshakeContext to receive it, instead of reading it
+	// themselves. This keeps a Write, such as a greeting of a responder,
+	// from doing handshake reads that belong to a concurrent Read loop.
+	// Writes then block until the handshake is read elsewhere or the Conn
+	// is closed, regardless of write deadlines.
+	WriteWaitsForHandshake bool
+
+	// DetectConcurrentUse is a debugging aid that panics with a
+	// description of the misuse when reads, such as Read, Peek or
+	// MessageConn.ReadMsg, or writes, such as Write or
+	// MessageConn.WriteMsg, are called concurrently with each other, or
+	// when a read and a write overlap before the handshake completed. The
+	// detection is best effort and has a small cost on every call.
+	DetectConcurrentUse bool
+
+	// InsecureNullCipher DISABLES ENCRYPTION, for reading wire captures
+	// during development. The handshake and framing are kept, but every
+	// message is sent in plaintext with a zero tag, so there is neither
+	// confidentiality nor integrity. It only works when the package is
+	// built with the noiseconn_insecure build tag (InsecureBuildTag), and
+	// both peers must set it, since it changes the protocol name.
+	InsecureNullCipher bool
+
+	// RequireMutualAuth makes construction fail unless the pattern
+	// authenticates both peers, with static keys or a preshared key, so a
+	// misconfigured pattern such as NN, or NX for a responder, can't
+	// silently result in anonymous connections.
+	RequireMutualAuth bool
+
+	// Registry, if set, tracks the Conn while it is open.
+	Registry *Registry
+
+	// ProfileLabels tags the handshake and the encryption and decryption
+	// of transport frames with pprof labels: noiseconn.peer, the
+	// Fingerprint of the static key of the peer if known,
+	// noiseconn.direction, "outbound" for initiators and "inbound" for
+	// responders, and noiseconn.op. The labels replace those of the calling
+	// goroutine while the Conn works, and are cleared afterwards. Handshake
+	// messages and rekeying are also runtime/trace regions while tracing is
+	// enabled, regardless of ProfileLabels.
+	ProfileLabels bool
+
+	// MaxHandshakePayload, if positive, limits how many bytes of
+	// handshake payloads, such as 0-RTT data, are buffered until they are
+	// read. The handshake fails if a peer sends more, instead of the data
+	// being held in memory until the application reads it.
+	MaxHandshakePayload int
+
+	// MaxDecryptFailures is how many received transport frames may fail
+	// authentication before the connection is torn down, which defaults
+	// to 1, so that a live connection can't be probed as an oracle. Frames
+	// that fail below the limit are skipped, with Read returning an error
+	// wrapping ErrDecryptFailed. Framing errors always tear the connection
+	// down. Failures are reported to Hooks.DecryptFailed.
+	MaxDecryptFailures int
+
+	// FIPS restricts the Conn to approved primitives, for environments
+	// that audit cipher selection: construction fails unless the cipher
+	// suite uses AESGCM with SHA256 or SHA512, such as
+	// Noise_XX_25519_AESGCM_SHA256. The Noise specification only defines
+	// the 25519 and 448 DH functions, so the DH function isn't
+	// restricted; a custom noise.DHFunc may be used where those aren't
+	// acceptable. ConnectionState.FIPS reports the mode.
+	FIPS bool
+
+	// AdaptiveFrameSize makes Write tune the payload size of transport
+	// frames between MinFrameSize and noise.MaxMsgLen, instead of always
+	// using the largest frames. The size follows the sizes of recent
+	// writes, so interactive traffic gets small frames that the peer can
+	// decrypt as soon as they arrive and bulk traffic gets large frames
+	// with less overhead, and shrinks while writing a frame to the
+	// underlying net.Conn is slow. Conn.FrameSize reports the current
+	// size.
+	AdaptiveFrameSize bool
+
+	// MinFrameSize is the smallest frame payload size used with
+	// AdaptiveFrameSize. It defaults to 4096 bytes.
+	MinFrameSize int
+
+	// SendRate, if positive, caps how many bytes per second are written
+	// to the underlying net.Conn, in bursts of up to SendBurst bytes,
+	// which defaults to a tenth of SendRate. Frames are paced after Write
+	// coalesced them, so bulk transfers leave room for other traffic of
+	// the host without giving up large writes.
+	SendRate  int
+	SendBurst int
+
+	// SendLimiter, if set, also paces the frames written, and may be
+	// shared with other connections to cap their combined rate.
+	SendLimiter *BandwidthLimiter
+
+	// RekeyInterval, if positive, changes the key used to send data once
+	// it was used for this long. Keys are changed lazily, before the next
+	// write, so an unused key isn't replaced until it would be used again.
+	RekeyInterval time.Duration
+
+	// RekeyAfterIdle, if positive, changes the key used to send data
+	// before writing after nothing was written for this long.
+	RekeyAfterIdle time.Duration
+
+	// RekeyAfterBytes, if positive, changes the key used to send data
+	// once this much data was sent with it.
+	//
+	// The rekey options send a control frame telling the peer to change
+	// its receiving key, so they enable HandshakeExtensions, and writes
+	// fail if the peer doesn't support control frames. See Conn.Rekey.
+	RekeyAfterBytes int64
+
+	// ReadFull makes Read block until b is filled, reading as many frames
+	// as needed, like io.ReadFull. Read returns fewer bytes only with an
+	// error, which is io.ErrUnexpectedEOF if the connection ends after
+	// part of b was filled.
+	ReadFull bool
+
+	// Extensions registers application-defined handshake extensions; see
+	// Extension. It enables HandshakeExtensions. Peers that don't know an
+	// extension ignore it, so extensions negotiate optional features: a
+	// feature is used once both peers sent and received its extension.
+	Extensions []Extension
+
+	// MaxFrameSize, if positive, is the largest transport frame payload
+	// this side accepts. It is announced to the peer during the handshake,
+	// and peers that support it keep their frames within it, so
+	// memory-constrained receivers can bound the size of the frames they
+	// buffer. Once announced, larger frames are framing errors. Handshake
+	// payloads aren't limited by it; see MaxHandshakePayload. It must be
+	// between 512 and noise.MaxMsgLen, and enables HandshakeExtensions.
+	MaxFrameSize int
+
+	// KeepaliveInterval, if positive, sends a keepalive control frame
+	// once nothing was sent for this long. Keepalives are also sent, as
+	// often as needed, to peers that announced an IdleTimeout. See
+	// Conn.Keepalive for the negotiated durations.
+	KeepaliveInterval time.Duration
+
+	// IdleTimeout, if positive, closes the connection once a read waited
+	// this long without receiving anything, and the read fails with
+	// ErrIdleTimeout. It is announced during the handshake, so that the
+	// peer sends keepalives often enough, and only enforced if the peer
+	// announced that it supports keepalives, so peers aren't timed out for
+	// not knowing about it. It must be at least 100ms.
+	//
+	// KeepaliveInterval and IdleTimeout need control frames, so they
+	// enable HandshakeExtensions.
+	IdleTimeout time.Duration
+
+	// PingInterval, if positive, sends a ping control frame this often,
+	// which the peer answers, so that Conn.RTT estimates the round-trip
+	// time without waiting for Conn.Ping. The answers are only received
+	// while the connection is read. It enables HandshakeExtensions.
+	PingInterval time.Duration
+
+	// Linger, if positive, makes Close wait up to this long for writes in
+	// progress to finish, including ones paced by SendRate, and then send
+	// a close-notify control frame, so that reads of the peer return
+	// io.EOF after everything written, before closing the underlying
+	// net.Conn regardless. Writes fail once the close-notify was sent.
+	// The close-notify is only sent to peers that support control frames,
+	// so Linger enables HandshakeExtensions.
+	Linger time.Duration
+
+	// SendCloseReason, when the Conn tears the connection down itself
+	// because of a protocol violation, such as reaching
+	// MaxDecryptFailures, tells the peer with CloseProtocolViolation, so
+	// that its reads fail with a PeerCloseError instead of a bare
+	// connection reset. See Conn.CloseWithReason for closing with other
+	// reasons. It enables HandshakeExtensions.
+	SendCloseReason bool
+
+	// Framing selects how Noise messages are delimited on the underlying
+	// net.Conn, to interoperate with peers using another wire format. It
+	// defaults to FramingDefault, and is not supported for a
+	// MessageTransport.
+	Framing Framing
+}
+
+// ErrHandshakeRequired is returned with Options.ExplicitHandshake when data
+// is read or written before the handshake completed.
+var ErrHandshakeRequired = errors.New("handshake required")
+
+// PeerVerifier is a callback that verifies the static public key of a peer.
+type PeerVerifier func(addr net.Addr, peerStatic []byte) error
+
+// PinPeers returns a PeerVerifier that only accepts the provided static
+// public keys.
+func PinPeers(peerStatics ...[]byte) PeerVerifier {
+	return func(addr net.Addr, peerStatic []byte) error {
+		for _, key := range peerStatics {
+			if bytes.Equal(key, peerStatic) {
+				return nil
+			}
+		}
+		return errs.New("unexpected peer static key for %v", addr)
+	}
+}
+
+// MessageTransport is an underlying net.Conn that preserves message
+// boundaries, such as a WebSocket. If the net.Conn provided to NewConn
+// implements MessageTransport, every Noise message is carried in a single
+// transport message, without the stream framing, and the Read and Write
+// methods of the net.Conn are not used.
+type MessageTransport interface {
+	net.Conn
+
+	// ReadMessage returns the next message. The returned slice only needs
+	// to remain valid until the next call.
+	ReadMessage() ([]byte, error)
+
+	// WriteMessage sends b as a single message.
+	WriteMessage(b []byte) error
 }
 
 // Conn is a net.Conn that implements a framed Noise protocol on top of the
@@ -38,72 +477,546 @@ type Options struct {
 type Conn struct {
 	net.Conn
 	hsMu             sync.Mutex
+	readMu           sync.Mutex
 	readBarrier      barrier
 	hs               *noise.HandshakeState
 	hh               []byte
+	peerStatic       []byte
 	initiator        bool
 	hsResponsibility bool
 	readMsgBuf       []byte
 	writeMsgBuf      []byte
 	readBuf          []byte
-	send, recv       *noise.CipherState
-	rfmValidate       MessageInspector
+	frameHeader      [4]byte
+	frameHeaderN     int
+	frameBody        []byte
+	buffered         atomic.Int64
+	send, recv       *cipherState
+	rfmValidate      MessageInspector
+	verifyPeer       PeerVerifier
+	hsErr            error
+	mt               MessageTransport
+	msgMode          bool
+	readMsgs         [][]byte
+	keyLog           io.Writer
+	keyCapture       *keyCapture
+	capture          *CaptureWriter
+	captureID        uint64
+	transcript       *HandshakeTranscript
+	onTranscript     func(*HandshakeTranscript)
+	extensions       bool
+	extBuf           []byte
+	identity         []byte
+	identityMsg      int
+	identityRoots    []ed25519.PublicKey
+	peerIdentityMsg  int
+	peerIdentity     *Certificate
+	writeMu          sync.Mutex
+	controlBuf       []byte
+	peerControl      bool
+	nextStatic       []byte
+	nextPeerStatic   func(addr net.Addr, peerStatic, next []byte) error
+	cipherSuite      noise.CipherSuite
+	pqMode           PostQuantumMode
+	pqEnabled        bool
+	kemKey           []byte
+	kemDecapsulate   func(ciphertext []byte) ([]byte, error)
+	kemCiphertext    []byte
+	kemSecret        []byte
+	postQuantum      bool
+	hint             []byte
+	selectStatic     func(addr net.Addr, hint []byte) (noise.DHKey, error)
+	hintConfig       noise.Config
+	authToken        []byte
+	verifyToken      func(addr net.Addr, peerStatic, token []byte) error
+	authMu           sync.Mutex
+	authDone         bool
+	authErr          error
+	timestamp        []byte
+	maxTimestampAge  time.Duration
+	replayCache      ReplayCache
+	earlyTokens      EarlyDataTokenStore
+	earlyToken       []byte
+	onEarlyToken     func(token []byte)
+	hooks            *Hooks
+	logger           Logger
+	explicitHS       bool
+	writeWaitsForHS  bool
+	readFull         bool
+	fips             bool
+	framing          Framing
+	serverName       string
+	retryConfigs     []noise.Config
+	appExtensions    []Extension
+	peerExtensions   map[byte]bool
+	frameLimit       frameLimit
+	keepalive        keepalive
+	pacer            *pacer
+	rtt              rtt
+	lingerState      lingerState
+	decryptFailures  decryptFailures
+	maxHSPayload     int
+	profileLabels    profileLabels
+	registryEntry    *registryEntry
+	frameSizer       frameSizer
+	rekey            rekeyPolicy
+	hsCond           *sync.Cond
+	hsReadErr        error
+	hsClosed         bool
+	protocol         string
+	peerPreStatic    bool
+	created          time.Time
+	hsStart          time.Time
+	hsFinish         time.Time
+	earlyData        earlyData
+	misuse           misuseDetector
+	hsMessages       []HandshakeMessageStats
+	lifecycle        lifecycle
+	hsReported       bool
+	closeReported    uint32
+	exported         uint32
+	aborted          uint32
+	closeReason      bool
+	peerCloseErr     error
 }
 
 var _ net.Conn = (*Conn)(nil)
 
 // NewConn wraps an existing net.Conn with encryption provided by
-// noise.Config.
-func NewConn(conn net.Conn, config noise.Config) (*Conn, error) {
-	return NewConnWithOptions(conn, config, Options{})
+// noise.Config, configured by opts.
+func NewConn(conn net.Conn, config noise.Config, opts ...Option) (*Conn, error) {
+	return NewConnWithOptions(conn, config, applyOptions(opts))
 }
 
 // NewConn wraps an existing net.Conn with encryption provided by
 // noise.Config and options provided by Options.
 func NewConnWithOptions(conn net.Conn, config noise.Config, opts Options) (*Conn, error) {
+	if err := ValidateConfig(config, opts); err != nil {
+		return nil, err
+	}
+	if opts.Random != nil {
+		config.Random = opts.Random
+	}
+	if opts.StaticKey != nil {
+		if config.CipherSuite == nil {
+			return nil, errs.New("StaticKey needs a cipher suite")
+		}
+		suite, keypair, err := newStaticKeySuite(config.CipherSuite, opts.StaticKey)
+		if err != nil {
+			return nil, err
+		}
+		config.CipherSuite, config.StaticKeypair = suite, keypair
+	}
+	var retryKeys []noise.DHKey
+	if opts.StaticKeys != nil {
+		keys := opts.StaticKeys.keys(time.Now())
+		config.StaticKeypair = keys[0]
+		if !config.Initiator && preMessageStatic(config.Pattern, false) {
+			retryKeys = keys[1:]
+		}
+	}
+	if opts.InsecureNullCipher {
+		config.CipherSuite = insecureCipherSuite(config.CipherSuite)
+		if opts.Logger != nil {
+			opts.Logger.Log(LogWarn, "INSECURE: encryption is disabled by InsecureNullCipher", "remote", conn.RemoteAddr())
+		}
+	}
+	// the transport keys are captured for Options.KeyLog and for the
+	// transport cipher states.
+	kc := &keyCapture{CipherSuite: config.CipherSuite}
+	config.CipherSuite = kc
+	if err := validateAuth(config, opts); err != nil {
+		return nil, err
+	}
+	var timestamp []byte
+	var maxTimestampAge time.Duration
+	if config.Initiator && opts.SendTimestamp {
+		var err error
+		if timestamp, err = newTimestamp(); err != nil {
+			return nil, err
+		}
+	} else if !config.Initiator {
+		maxTimestampAge = opts.MaxTimestampAge
+	}
+	if opts.SelectStatic != nil {
+		if config.Initiator {
+			return nil, errs.New("SelectStatic is only used by responders")
+		}
+		if len(opts.Identity) > 0 {
+			return nil, errs.New("Identity can't be used with SelectStatic")
+		}
+		if opts.StaticKey != nil {
+			return nil, errs.New("StaticKey can't be used with SelectStatic")
+		}
+	}
+	extensions := opts.HandshakeExtensions || len(opts.Identity) > 0 || len(opts.IdentityRoots) > 0 ||
+		opts.NextStatic != nil || opts.NextPeerStatic != nil || opts.PostQuantum != PostQuantumDisabled ||
+		opts.SendTimestamp || opts.MaxTimestampAge > 0 || opts.EarlyDataTokens != nil ||
+		opts.EarlyDataToken != nil || opts.OnEarlyDataToken != nil ||
+		opts.RekeyInterval > 0 || opts.RekeyAfterIdle > 0 || opts.RekeyAfterBytes > 0 ||
+		len(opts.Extensions) > 0 || opts.MaxFrameSize > 0 ||
+		opts.KeepaliveInterval > 0 || opts.IdleTimeout > 0 || opts.PingInterval > 0 ||
+		opts.Linger > 0 || opts.SendCloseReason
+	if extensions {
+		config.Prologue = bindNegotiation(config.Prologue, "extensions", nil)
+	}
+	staticHint := opts.StaticHint
+	if opts.ServerName != "" {
+		if staticHint != nil {
+			return nil, errs.New("ServerName can't be used with StaticHint")
+		}
+		staticHint = []byte(opts.ServerName)
+	}
+	var hint []byte
+	if staticHint != nil {
+		if !config.Initiator {
+			return nil, errs.New("StaticHint and ServerName are only sent by initiators")
+		}
+		if len(staticHint) > maxHintLen {
+			return nil, errs.New("static key hint too long")
+		}
+		hint = append([]byte(nil), staticHint...)
+		config.Prologue = bindNegotiation(config.Prologue, "hint", hint)
+	}
+	// with SelectStatic, the handshake state is replaced once the hint is
+	// received, so the initial one uses a placeholder key.
+	var hintConfig noise.Config
+	if opts.SelectStatic != nil {
+		hintConfig = config
+		config.StaticKeypair = noise.DHKey{Private: make([]byte, 32), Public: make([]byte, 32)}
+	}
 	hs, err := noise.NewHandshakeState(config)
 	if err != nil {
 		return nil, errs.Wrap(err)
 	}
-	return &Conn{
+	var retryConfigs []noise.Config
+	for _, key := range retryKeys {
+		retry := config
+		retry.StaticKeypair = key
+		retryConfigs = append(retryConfigs, retry)
+	}
+	if len(opts.FallbackPattern.Messages) > 0 {
+		retry := config
+		retry.Pattern = opts.FallbackPattern
+		retryConfigs = append(retryConfigs, retry)
+	}
+	mt, _ := conn.(MessageTransport)
+	if mt != nil && opts.Framing != FramingDefault {
+		return nil, errs.New("Framing is not supported for message transports")
+	}
+	if opts.ProxyProtocol {
+		if mt != nil {
+			return nil, errs.New("PROXY protocol is not supported for message transports")
+		}
+		conn = &proxyConn{Conn: conn}
+	}
+	if opts.Framing != FramingDefault {
+		if conn, mt, err = applyFraming(conn, opts.Framing); err != nil {
+			return nil, err
+		}
+	}
+	var transcript *HandshakeTranscript
+	if opts.Transcript != nil {
+		transcript = newTranscript(config)
+	}
+	var identity []byte
+	identityMsg := identityMessage(config.Pattern, config.Initiator)
+	if len(opts.Identity) > 0 {
+		if identityMsg < 0 {
+			return nil, errs.New("pattern %s doesn't send a static key to carry an identity", config.Pattern.Name)
+		}
+		if !bytes.Equal(opts.Identity[0].Key, config.StaticKeypair.Public) {
+			return nil, errs.New("identity is not for the static key")
+		}
+		identity = marshalChain(opts.Identity)
+	}
+	peerIdentityMsg := identityMessage(config.Pattern, !config.Initiator)
+	if len(opts.IdentityRoots) > 0 && peerIdentityMsg < 0 {
+		return nil, errs.New("pattern %s doesn't send a peer static key to carry an identity", config.Pattern.Name)
+	}
+	pqEnabled, kemKey, kemDecapsulate, err := setupPostQuantum(opts.PostQuantum, config)
+	if err != nil {
+		return nil, err
+	}
+	maxDecryptFailures := opts.MaxDecryptFailures
+	if maxDecryptFailures == 0 {
+		maxDecryptFailures = 1
+	}
+	var captureID uint64
+	if opts.Capture != nil {
+		captureID = opts.Capture.newConn()
+	}
+	c := &Conn{
 		Conn:             conn,
+		mt:               mt,
 		hs:               hs,
 		initiator:        config.Initiator,
 		hsResponsibility: config.Initiator,
-		rfmValidate:       opts.ResponderFirstMessageValidator,
-	}, nil
+		rfmValidate:      opts.ResponderFirstMessageValidator,
+		verifyPeer:       opts.VerifyPeer,
+		keyLog:           opts.KeyLog,
+		keyCapture:       kc,
+		capture:          opts.Capture,
+		captureID:        captureID,
+		transcript:       transcript,
+		onTranscript:     opts.Transcript,
+		extensions:       extensions,
+		identity:         identity,
+		identityMsg:      identityMsg,
+		identityRoots:    opts.IdentityRoots,
+		peerIdentityMsg:  peerIdentityMsg,
+		nextStatic:       opts.NextStatic,
+		nextPeerStatic:   opts.NextPeerStatic,
+		cipherSuite:      config.CipherSuite,
+		pqMode:           opts.PostQuantum,
+		pqEnabled:        pqEnabled,
+		kemKey:           kemKey,
+		kemDecapsulate:   kemDecapsulate,
+		hint:             hint,
+		selectStatic:     opts.SelectStatic,
+		hintConfig:       hintConfig,
+		authToken:        opts.AuthToken,
+		verifyToken:      opts.VerifyToken,
+		timestamp:        timestamp,
+		maxTimestampAge:  maxTimestampAge,
+		replayCache:      opts.ReplayCache,
+		earlyTokens:      opts.EarlyDataTokens,
+		earlyToken:       opts.EarlyDataToken,
+		onEarlyToken:     opts.OnEarlyDataToken,
+		hooks:            withExpvarHooks(opts.Hooks),
+		logger:           opts.Logger,
+		explicitHS:       opts.ExplicitHandshake,
+		writeWaitsForHS:  opts.WriteWaitsForHandshake,
+		readFull:         opts.ReadFull,
+		fips:             opts.FIPS,
+		framing:          opts.Framing,
+		appExtensions:    append([]Extension(nil), opts.Extensions...),
+		frameLimit:       frameLimit{local: opts.MaxFrameSize},
+		retryConfigs:     retryConfigs,
+		keepalive:        keepalive{interval: opts.KeepaliveInterval, idleTimeout: opts.IdleTimeout, ping: opts.PingInterval},
+		decryptFailures:  decryptFailures{max: maxDecryptFailures},
+		maxHSPayload:     opts.MaxHandshakePayload,
+		profileLabels:    profileLabels{enabled: opts.ProfileLabels},
+		frameSizer:       newFrameSizer(opts),
+		pacer:            newPacer(opts),
+		lingerState:      lingerState{timeout: opts.Linger},
+		closeReason:      opts.SendCloseReason,
+		rekey:            rekeyPolicy{interval: opts.RekeyInterval, idle: opts.RekeyAfterIdle, bytes: opts.RekeyAfterBytes},
+		misuse:           misuseDetector{enabled: opts.DetectConcurrentUse},
+		protocol:         protocolName(config),
+		peerPreStatic:    preMessageStatic(config.Pattern, !config.Initiator),
+		created:          time.Now(),
+		lifecycle:        lifecycle{onConnected: opts.OnConnected, onClosed: opts.OnClosed},
+	}
+	c.hsCond = sync.NewCond(&c.hsMu)
+	if opts.Registry != nil {
+		c.registryEntry = opts.Registry.add(c)
+	}
+	return c, nil
 }
 
+// Close closes the underlying net.Conn and zeroes the plaintext and key
+// material buffered by the Conn. The handshake state and the ciphers of
+// flynn/noise keep their keys in unexported fields, which are released but
+// can't be zeroed. With Options.Linger, writes in progress may finish and
+// the close-notify is sent first; Abort closes without either.
 func (c *Conn) Close() error {
+	return c.close(true)
+}
+
+func (c *Conn) close(graceful bool) error {
+	defer c.notifyClosed()
+	if graceful {
+		c.linger()
+	}
+	c.closed()
 	c.readBarrier.Release()
-	return c.Conn.Close()
+	if c.pacer != nil {
+		c.pacer.close()
+	}
+	err := c.Conn.Close()
+
+	// closing the underlying net.Conn unblocks reads.
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	c.hsMu.Lock()
+	defer c.hsMu.Unlock()
+	c.hsClosed = true
+	c.hsCond.Broadcast()
+	c.stopKeepalive()
+	zero(c.readBuf[:cap(c.readBuf)])
+	zero(c.controlBuf[:cap(c.controlBuf)])
+	zero(c.extBuf[:cap(c.extBuf)])
+	zero(c.kemSecret)
+	c.readBuf, c.controlBuf, c.extBuf, c.kemSecret = nil, nil, nil, nil
+	c.updateBuffered()
+	return err
 }
 
-func (c *Conn) setCipherStates(cs1, cs2 *noise.CipherState) {
-	if c.initiator {
-		c.send, c.recv = cs1, cs2
-	} else {
-		c.send, c.recv = cs2, cs1
+func (c *Conn) setCipherStates(cs1, cs2 *noise.CipherState) (err error) {
+	if cs1 != nil && c.kemSecret != nil {
+		cs1, cs2, err = hybridCipherStates(c.cipherSuite, c.hs.ChannelBinding(), cs1, cs2, c.kemSecret)
+		if err != nil {
+			return err
+		}
+		zero(c.kemSecret)
+		c.postQuantum, c.kemSecret = true, nil
 	}
-	if c.send != nil {
+	if cs1 != nil {
+		initiator := newCipherState(c.keyCapture.CipherSuite, c.keyCapture.keys[0], 0)
+		responder := newCipherState(c.keyCapture.CipherSuite, c.keyCapture.keys[1], 0)
+		if c.initiator {
+			c.send, c.recv = initiator, responder
+		} else {
+			c.send, c.recv = responder, initiator
+		}
 		c.readBarrier.Release()
 		c.hh = c.hs.ChannelBinding()
+		c.peerStatic = c.hs.PeerStatic()
+		if c.profileLabels.enabled && len(c.peerStatic) > 0 {
+			c.profileLabels.peer = Fingerprint(c.peerStatic)
+		}
+		c.completeFrameLimit()
+		c.negotiateKeepalive()
 		c.hs = nil
+		c.hsFinish = time.Now()
+		c.rekey.reset(c.hsFinish)
+		c.setConnected()
+		zero(c.extBuf[:cap(c.extBuf)])
+		c.extBuf = nil
+		c.finishTranscript(nil)
+		c.handshakeDone(nil)
+		if c.keyLog != nil {
+			// failing to log keys must not fail the connection.
+			_ = writeKeyLog(c.keyLog, c.hh, c.keyCapture)
+		}
+		c.bindMultipath()
+		c.keyCapture.keys = [2][32]byte{}
 	}
+	return nil
+}
+
+// verify calls the PeerVerifier once the static key of the peer is known.
+// Failures are permanent.
+func (c *Conn) verify() error {
+	if c.verifyPeer == nil || len(c.hs.PeerStatic()) == 0 {
+		return nil
+	}
+	verifyPeer := c.verifyPeer
+	c.verifyPeer = nil
+	if err := verifyPeer(c.Conn.RemoteAddr(), c.hs.PeerStatic()); err != nil {
+		c.log(LogWarn, "peer verification failed", "peer", Fingerprint(c.hs.PeerStatic()), "error", err)
+		c.hsErr = errs.Wrap(err)
+		c.finishTranscript(c.hsErr)
+		return c.hsErr
+	}
+	return nil
 }
 
 func (c *Conn) hsRead() (err error) {
-	c.readMsgBuf, err = c.readMsg(c.readMsgBuf[:0])
+	defer c.updateBuffered()
+	defer func() {
+		c.hsReadErr = err
+		c.hsCond.Broadcast()
+	}()
+	defer func() {
+		if err != nil {
+			c.handshakeDone(err)
+		}
+	}()
+	if c.hsErr != nil {
+		return c.hsErr
+	}
+	if c.selectStatic != nil {
+		if err := c.readHint(); err != nil {
+			return err
+		}
+	}
+	var control bool
+	c.readMsgBuf, control, err = c.readMsg(c.readMsgBuf[:0])
 	if err != nil {
 		return err
 	}
+	if control {
+		return errs.New("unexpected control frame during handshake")
+	}
+	c.hsMessage()
+	c.hsMessageDone(false, len(c.readMsgBuf))
+	c.transcribe(false, c.readMsgBuf)
+	readBuf, readBufLen := c.readBuf, len(c.readBuf)
+	var payload []byte
 	var cs1, cs2 *noise.CipherState
-	c.readBuf, cs1, cs2, err = c.hs.ReadMessage(c.readBuf, c.readMsgBuf)
+	endProfile, endRegion := c.profile("handshake"), c.region("handshake")
+	for {
+		if c.msgMode {
+			payload, cs1, cs2, err = c.hs.ReadMessage(nil, c.readMsgBuf)
+		} else {
+			c.readBuf, cs1, cs2, err = c.hs.ReadMessage(readBuf, c.readMsgBuf)
+			payload = c.readBuf[readBufLen:]
+		}
+		if err == nil {
+			break
+		}
+		retry, retryErr := c.retryHandshake()
+		if retryErr != nil {
+			err = retryErr
+		}
+		if !retry || retryErr != nil {
+			break
+		}
+		c.readBuf = readBuf
+		c.transcribe(false, c.readMsgBuf)
+	}
+	c.retryConfigs = nil
+	endRegion()
+	endProfile()
 	if err != nil {
+		c.finishTranscript(err)
 		return errs.Wrap(err)
 	}
-	c.setCipherStates(cs1, cs2)
+	if err := c.verify(); err != nil {
+		c.readBuf = c.readBuf[:readBufLen]
+		return err
+	}
+	if c.extensions {
+		payload, err = c.readExtensions(payload)
+		if err != nil {
+			c.readBuf = c.readBuf[:readBufLen]
+			return err
+		}
+		if !c.msgMode {
+			c.readBuf = append(c.readBuf[:readBufLen], payload...)
+		}
+	}
+	if c.msgMode {
+		err = c.capHandshakePayload(0, payload)
+	} else {
+		err = c.capHandshakePayload(readBufLen, payload)
+	}
+	if err != nil {
+		c.readBuf = c.readBuf[:readBufLen]
+		return err
+	}
+	if c.msgMode && len(payload) > 0 {
+		c.readMsgs = append(c.readMsgs, payload)
+	}
+	c.bufferEarlyData(payload)
+	if err := c.setCipherStates(cs1, cs2); err != nil {
+		return err
+	}
 	c.hsResponsibility = true
+	if c.hs == nil {
+		// the handshake completed with the peer's message, so control
+		// frames for completion are sent on their own.
+		buf, err := c.appendCompletionControl(nil)
+		if err == nil && len(buf) > 0 {
+			err = c.writeFrames(buf)
+		}
+		if err != nil {
+			return err
+		}
+		c.spawnKeepalive()
+	}
 	if c.rfmValidate != nil {
 		err = c.rfmValidate(c.Conn.RemoteAddr(), c.readMsgBuf)
 		c.rfmValidate = nil
@@ -112,10 +1025,47 @@ func (c *Conn) hsRead() (err error) {
 	return nil
 }
 
+// Read reads plaintext, driving the handshake if needed. Buffered
+// plaintext is returned without reading from the underlying net.Conn, so
+// it is returned even if the read deadline passed. If the deadline passes
+// while a frame is partially received, the next Read resumes it. With
+// Options.ReadFull, Read fills b completely.
 func (c *Conn) Read(b []byte) (n int, err error) {
+	if !c.readFull {
+		return c.read(b)
+	}
+	for n < len(b) && err == nil {
+		var nn int
+		nn, err = c.read(b[n:])
+		n += nn
+	}
+	if n > 0 && errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (c *Conn) read(b []byte) (n int, err error) {
+	defer c.afterIO(&err)
+	defer c.beginRead("Read")()
+	if c.capture != nil {
+		defer func() {
+			if n > 0 {
+				c.capture.record(c.captureID, CaptureReceivedPlaintext, b[:n])
+			}
+		}()
+	}
+	if err := c.requireHandshake(); err != nil {
+		return 0, err
+	}
+	if err := c.authenticate(); err != nil {
+		return 0, err
+	}
 	if c.initiator {
 		c.readBarrier.Wait()
 	}
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
 	c.hsMu.Lock()
 	locked := true
 	unlocker := func() {
@@ -134,8 +1084,7 @@ func (c *Conn) Read(b []byte) (n int, err error) {
 			return false
 		}
 		n = copy(b, c.readBuf)
-		copy(c.readBuf, c.readBuf[n:])
-		c.readBuf = c.readBuf[:len(c.readBuf)-n]
+		c.consumeReadBuf(n)
 		return true
 	}
 
@@ -143,42 +1092,34 @@ func (c *Conn) Read(b []byte) (n int, err error) {
 		return n, nil
 	}
 
-	for c.hs != nil {
-		if c.hsResponsibility {
-			c.writeMsgBuf, err = c.hsCreate(c.writeMsgBuf[:0], nil)
-			if err != nil {
-				return 0, err
-			}
-			_, err = c.Conn.Write(c.writeMsgBuf)
-			if err != nil {
-				return 0, errs.Wrap(err)
-			}
-			if c.hs == nil {
-				break
-			}
-		}
-		err = c.hsRead()
-		if err != nil {
-			return 0, err
-		}
-		if handleBuffered() {
-			return n, nil
-		}
+	err = c.hsReadUntil(func() bool { return len(c.readBuf) > 0 })
+	if err != nil {
+		return 0, err
+	}
+	if handleBuffered() {
+		return n, nil
 	}
 	unlocker()
 
 	for {
-		c.readMsgBuf, err = c.readMsg(c.readMsgBuf[:0])
+		var control bool
+		c.readMsgBuf, control, err = c.readMsg(c.readMsgBuf[:0])
 		if err != nil {
 			return 0, err
 		}
+		if control {
+			if err := c.readControl(c.readMsgBuf); err != nil {
+				return 0, err
+			}
+			continue
+		}
 		if len(b) >= 65535 {
 			// read directly into b, since b has enough room for a noise
 			// payload.
 			// TODO(jt): is this the best way to determine if we can read into
 			// b? we should be able to know without this worst case. i kind of
 			// hate this code.
-			out, err := c.recv.Decrypt(b[:0], nil, c.readMsgBuf)
+			out, err := c.decrypt(b[:0], c.readMsgBuf)
 			if err != nil {
 				return 0, errs.Wrap(err)
 			}
@@ -190,10 +1131,11 @@ func (c *Conn) Read(b []byte) (n int, err error) {
 			}
 			continue
 		}
-		c.readBuf, err = c.recv.Decrypt(c.readBuf, nil, c.readMsgBuf)
+		c.readBuf, err = c.decrypt(c.readBuf, c.readMsgBuf)
 		if err != nil {
 			return 0, errs.Wrap(err)
 		}
+		c.updateBuffered()
 		if handleBuffered() {
 			return n, nil
 		}
@@ -201,30 +1143,276 @@ func (c *Conn) Read(b []byte) (n int, err error) {
 	}
 }
 
-// readMsg appends a message to b.
-func (c *Conn) readMsg(b []byte) ([]byte, error) {
+// consumeReadBuf removes n bytes from the front of c.readBuf. c.readMu or
+// c.hsMu must be held.
+func (c *Conn) consumeReadBuf(n int) {
+	copy(c.readBuf, c.readBuf[n:])
+	// the plaintext moved out of the tail isn't left behind.
+	zero(c.readBuf[len(c.readBuf)-n:])
+	c.readBuf = c.readBuf[:len(c.readBuf)-n]
+	c.updateBuffered()
+	c.consumeEarlyData(n)
+}
+
+// ReadBuffers is like Read, but fills bufs in order, with a single call.
+// It blocks until some plaintext is available, and returns the plaintext
+// that is then buffered, without waiting for more to fill every buffer.
+func (c *Conn) ReadBuffers(bufs [][]byte) (n int, err error) {
+	defer c.afterIO(&err)
+	defer c.beginRead("ReadBuffers")()
+	if err := c.requireHandshake(); err != nil {
+		return 0, err
+	}
+	if err := c.authenticate(); err != nil {
+		return 0, err
+	}
+	if c.initiator {
+		c.readBarrier.Wait()
+	}
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	empty := true
+	for _, b := range bufs {
+		empty = empty && len(b) == 0
+	}
+	if empty {
+		return 0, nil
+	}
+	if err := c.fillReadBuf(); err != nil {
+		return 0, err
+	}
+	for _, b := range bufs {
+		n += copy(b, c.readBuf[n:])
+		if n == len(c.readBuf) {
+			break
+		}
+	}
+	if c.capture != nil {
+		c.capture.record(c.captureID, CaptureReceivedPlaintext, c.readBuf[:n])
+	}
+	c.consumeReadBuf(n)
+	return n, nil
+}
+
+// Peek returns up to n bytes of the plaintext that was received but not
+// read yet, without consuming it. If none is buffered, it blocks until a
+// frame with data is received, driving the handshake if needed. It may
+// return fewer than n bytes even if more are in flight. The returned slice
+// is only valid until the next call to Read or Close.
+func (c *Conn) Peek(n int) (_ []byte, err error) {
+	defer c.afterIO(&err)
+	defer c.beginRead("Peek")()
+	if err := c.requireHandshake(); err != nil {
+		return nil, err
+	}
+	if err := c.authenticate(); err != nil {
+		return nil, err
+	}
+	if c.initiator {
+		c.readBarrier.Wait()
+	}
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if err := c.fillReadBuf(); err != nil {
+		return nil, err
+	}
+	return c.readBuf[:min(n, len(c.readBuf))], nil
+}
+
+// fillReadBuf reads until c.readBuf isn't empty. c.readMu must be held.
+func (c *Conn) fillReadBuf() error {
+	c.hsMu.Lock()
+	if len(c.readBuf) == 0 {
+		if err := c.hsReadUntil(func() bool { return len(c.readBuf) > 0 }); err != nil {
+			c.hsMu.Unlock()
+			return err
+		}
+	}
+	c.hsMu.Unlock()
+	for len(c.readBuf) == 0 {
+		var control bool
+		var err error
+		c.readMsgBuf, control, err = c.readMsg(c.readMsgBuf[:0])
+		if err != nil {
+			return err
+		}
+		if control {
+			if err := c.readControl(c.readMsgBuf); err != nil {
+				return err
+			}
+			continue
+		}
+		c.readBuf, err = c.decrypt(c.readBuf, c.readMsgBuf)
+		if err != nil {
+			return errs.Wrap(err)
+		}
+		c.updateBuffered()
+	}
+	return nil
+}
+
+// Buffered returns how many bytes of received plaintext are held by the
+// Conn and not read yet. If it is nonzero, the next Read doesn't block. It
+// doesn't block itself, so it may be called concurrently with Read.
+func (c *Conn) Buffered() int {
+	return int(c.buffered.Load())
+}
+
+// updateBuffered publishes the length of c.readBuf for Buffered. c.readMu
+// or c.hsMu must be held.
+func (c *Conn) updateBuffered() {
+	c.buffered.Store(int64(len(c.readBuf)))
+}
+
+// hsReadUntil drives the handshake from the read side until it is complete
+// or until done returns true after a handshake message was read. c.hsMu must
+// be held.
+func (c *Conn) hsReadUntil(done func() bool) (err error) {
+	for c.hs != nil {
+		if c.hsResponsibility {
+			c.writeMsgBuf, err = c.hsCreate(c.writeMsgBuf[:0], nil)
+			if err != nil {
+				return err
+			}
+			err = c.writeHandshake(c.writeMsgBuf)
+			if err != nil {
+				return errs.Wrap(err)
+			}
+			if c.hs == nil {
+				break
+			}
+		}
+		err = c.hsRead()
+		if err != nil {
+			return err
+		}
+		if done() {
+			return nil
+		}
+	}
+	return nil
+}
+
+// readMsg appends a message to b. It also reports whether the message is
+// a control frame.
+func (c *Conn) readMsg(b []byte) (_ []byte, control bool, err error) {
+	if c.decryptFailures.err != nil {
+		return nil, false, c.decryptFailures.err
+	}
+	if c.peerCloseErr != nil {
+		return nil, false, c.peerCloseErr
+	}
+	endRead := c.beginFrameRead()
+	b, control, err = c.readFrame(b)
+	endRead()
+	if err != nil {
+		err = c.idleTimeoutErr(err)
+	}
+	if err == nil && c.capture != nil {
+		c.capture.record(c.captureID, CaptureReceivedFrame, b)
+	}
+	if err == nil {
+		c.frameReceived(len(b))
+	}
+	return b, control, err
+}
+
+func (c *Conn) readFrame(b []byte) (_ []byte, control bool, err error) {
+	if c.mt != nil {
+		msg, err := c.mt.ReadMessage()
+		if err != nil {
+			return nil, false, errs.Wrap(err)
+		}
+		if err := c.checkFrameSize(len(msg)); err != nil {
+			return nil, false, err
+		}
+		return append(b, msg...), false, nil
+	}
 	// TODO(jt): make sure these reads are through bufio somewhere in the stack
 	// appropriate.
-	var msgHeader [4]byte
-	_, err := io.ReadFull(c.Conn, msgHeader[:])
-	if err != nil {
-		return nil, errs.Wrap(err)
+	// a frame interrupted by an error, such as a read deadline, is resumed
+	// by the next call.
+	for c.frameHeaderN < len(c.frameHeader) {
+		n, err := c.Conn.Read(c.frameHeader[c.frameHeaderN:])
+		c.frameHeaderN += n
+		if err != nil && c.frameHeaderN < len(c.frameHeader) {
+			if errors.Is(err, io.EOF) && c.frameHeaderN > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, false, errs.Wrap(err)
+		}
 	}
-	if msgHeader[0] != HeaderByte {
-		// TODO(jt): close conn?
-		return nil, errs.New("unknown message header")
+	msgHeader := c.frameHeader
+	switch msgHeader[0] {
+	case HeaderByte:
+	case controlHeaderByte:
+		control = true
+	default:
+		c.log(LogWarn, "framing error", "header", msgHeader[0])
+		return nil, false, c.decryptFailed(errs.New("unknown message header"), true)
 	}
 	msgHeader[0] = 0
 	msgSize := int(binary.BigEndian.Uint32(msgHeader[:]))
-	b = append(b[len(b):], make([]byte, msgSize)...)
-	_, err = io.ReadFull(c.Conn, b)
+	if err := c.checkFrameSize(msgSize); err != nil {
+		return nil, false, err
+	}
+	have := len(c.frameBody)
+	b = append(append(b[len(b):], c.frameBody...), make([]byte, msgSize-have)...)
+	c.frameBody = c.frameBody[:0]
+	n, err := io.ReadFull(c.Conn, b[have:])
 	if err != nil {
+		c.frameBody = append(c.frameBody, b[:have+n]...)
 		if errors.Is(err, io.EOF) {
-			return nil, errs.Wrap(io.ErrUnexpectedEOF)
+			return nil, false, errs.Wrap(io.ErrUnexpectedEOF)
 		}
-		return nil, errs.Wrap(err)
+		return nil, false, errs.Wrap(err)
 	}
-	return b, nil
+	c.frameHeaderN = 0
+	return b, control, nil
+}
+
+// writeFrames writes a buffer of one or more framed messages to the
+// underlying net.Conn. For a MessageTransport, the frame headers are
+// stripped and every message is written separately.
+func (c *Conn) writeFrames(buf []byte) error {
+	if c.capture != nil {
+		c.capture.recordFrames(c.captureID, buf)
+	}
+	c.framesSent(buf)
+	if c.pacer != nil {
+		return c.writePaced(buf)
+	}
+	return c.writeFramesNow(buf)
+}
+
+// writeHandshake writes a handshake message created by hsCreate, and starts
+// the keepalive goroutine once the message completed the handshake. c.hsMu
+// must be held.
+func (c *Conn) writeHandshake(buf []byte) error {
+	if err := c.writeFrames(buf); err != nil {
+		return err
+	}
+	if c.hs == nil {
+		c.spawnKeepalive()
+	}
+	return nil
+}
+
+// writeFramesNow writes a buffer of framed messages to the underlying
+// net.Conn or MessageTransport.
+func (c *Conn) writeFramesNow(buf []byte) error {
+	if c.mt == nil {
+		_, err := c.Conn.Write(buf)
+		return errs.Wrap(err)
+	}
+	for len(buf) > 0 {
+		size := int(binary.BigEndian.Uint32(buf[:4]) & 0xffffff)
+		if err := c.mt.WriteMessage(buf[4 : 4+size]); err != nil {
+			return errs.Wrap(err)
+		}
+		buf = buf[4+size:]
+	}
+	return nil
 }
 
 func (c *Conn) frame(header, b []byte) error {
@@ -237,20 +1425,53 @@ func (c *Conn) frame(header, b []byte) error {
 }
 
 func (c *Conn) hsCreate(out, payload []byte) (_ []byte, err error) {
+	defer func() {
+		if err != nil {
+			c.handshakeDone(err)
+		}
+	}()
+	if c.hsErr != nil {
+		return nil, c.hsErr
+	}
+	if err := c.verify(); err != nil {
+		return nil, err
+	}
+	if c.hint != nil {
+		out, c.hint = appendHint(out, c.hint), nil
+	}
+	c.hsMessage()
+	payload, err = c.hsPayload(payload)
+	if err != nil {
+		return nil, err
+	}
 	var cs1, cs2 *noise.CipherState
 	outlen := len(out)
+	endProfile, endRegion := c.profile("handshake"), c.region("handshake")
 	out, cs1, cs2, err = c.hs.WriteMessage(append(out, make([]byte, 4)...), payload)
+	endRegion()
+	endProfile()
+	zero(c.extBuf)
 	if err != nil {
 		return nil, errs.Wrap(err)
 	}
+	c.transcribe(true, out[outlen+4:])
+	c.hsMessageDone(true, len(out)-outlen-4)
 	if c.rfmValidate != nil {
 		// only applies to responders, not initiators.
 		c.rfmValidate = nil
 	}
-	c.setCipherStates(cs1, cs2)
+	if err := c.setCipherStates(cs1, cs2); err != nil {
+		return nil, err
+	}
 	c.hsResponsibility = false
 	c.readBarrier.Release()
-	return out, c.frame(out[outlen:], out[outlen+4:])
+	if err := c.frame(out[outlen:], out[outlen+4:]); err != nil {
+		return nil, err
+	}
+	if c.hs == nil {
+		return c.appendCompletionControl(out)
+	}
+	return out, nil
 }
 
 // If a Noise handshake is still occurring (or has yet to occur), the
@@ -258,6 +1479,21 @@ func (c *Conn) hsCreate(out, payload []byte) (_ []byte, err error) {
 // even if the Noise configuration allows for 0-RTT, the request will only be
 // 0-RTT if the request is 65535 bytes or smaller.
 func (c *Conn) Write(b []byte) (n int, err error) {
+	defer c.afterIO(&err)
+	defer c.beginWrite("Write")()
+	if c.capture != nil {
+		defer func(b []byte) {
+			if n > 0 {
+				c.capture.record(c.captureID, CaptureSentPlaintext, b[:n])
+			}
+		}(b)
+	}
+	if err := c.requireHandshake(); err != nil {
+		return 0, err
+	}
+	if err := c.authenticate(); err != nil {
+		return 0, err
+	}
 	c.hsMu.Lock()
 	locked := true
 	unlocker := func() {
@@ -272,6 +1508,12 @@ func (c *Conn) Write(b []byte) (n int, err error) {
 		defer unlocker()
 	}
 	for c.hs != nil && len(b) > 0 {
+		if !c.hsResponsibility && c.writeWaitsForHS {
+			if err := c.waitHandshakeTurn(); err != nil {
+				return n, err
+			}
+			continue
+		}
 		if !c.hsResponsibility {
 			err = c.hsRead()
 			if err != nil {
@@ -279,12 +1521,12 @@ func (c *Conn) Write(b []byte) (n int, err error) {
 			}
 		}
 		if c.hs != nil {
-			l := min(noise.MaxMsgLen, len(b))
+			l := min(c.hsPayloadLimit(), len(b))
 			c.writeMsgBuf, err = c.hsCreate(c.writeMsgBuf[:0], b[:l])
 			if err != nil {
 				return n, err
 			}
-			_, err = c.Conn.Write(c.writeMsgBuf)
+			err = c.writeHandshake(c.writeMsgBuf)
 			if err != nil {
 				return n, errs.Wrap(err)
 			}
@@ -294,10 +1536,22 @@ func (c *Conn) Write(b []byte) (n int, err error) {
 	}
 	unlocker()
 
-	c.writeMsgBuf = c.writeMsgBuf[:0]
+	// control frames may be written concurrently.
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.checkCloseNotified(); err != nil {
+		return n, err
+	}
+	defer c.profile("encrypt")()
+	c.writeMsgBuf, err = c.appendPolicyRekey(c.writeMsgBuf[:0], len(b))
+	if err != nil {
+		return n, err
+	}
+	transport, frames := len(b), 0
+	var flush time.Duration
 	for len(b) > 0 {
 		outlen := len(c.writeMsgBuf)
-		l := min(noise.MaxMsgLen, len(b))
+		l := min(min(c.frameSizer.frameSize(), c.maxPayload(false)), len(b))
 		c.writeMsgBuf, err = c.send.Encrypt(append(c.writeMsgBuf, make([]byte, 4)...), nil, b[:l])
 		if err != nil {
 			return n, errs.Wrap(err)
@@ -308,8 +1562,9 @@ func (c *Conn) Write(b []byte) (n int, err error) {
 		}
 		n += l
 		b = b[l:]
+		frames++
 		if len(c.writeMsgBuf) > flushLimit {
-			_, err = c.Conn.Write(c.writeMsgBuf)
+			err = c.flushFrames(c.writeMsgBuf, &flush)
 			if err != nil {
 				return n, err
 			}
@@ -318,15 +1573,120 @@ func (c *Conn) Write(b []byte) (n int, err error) {
 	}
 
 	if len(c.writeMsgBuf) > 0 {
-		_, err = c.Conn.Write(c.writeMsgBuf)
+		err = c.flushFrames(c.writeMsgBuf, &flush)
 		if err != nil {
 			return n, err
 		}
 		c.writeMsgBuf = c.writeMsgBuf[:0]
 	}
+	c.frameSizer.observe(transport, frames, flush)
 	return n, nil
 }
 
+// Handshake runs the Noise handshake, if it hasn't completed yet, without
+// sending any handshake payloads. Read and Write drive the handshake
+// automatically, so calling Handshake is only necessary to learn about
+// handshake failures or the peer's identity before exchanging data.
+func (c *Conn) Handshake() (err error) {
+	defer c.afterIO(&err)
+	if err := c.handshake(); err != nil {
+		return err
+	}
+	return c.authenticate()
+}
+
+// waitHandshakeTurn waits until it is our turn to send a handshake message
+// or the handshake completed, while another call reads the handshake
+// messages of the peer. c.hsMu must be held.
+func (c *Conn) waitHandshakeTurn() error {
+	for c.hs != nil && !c.hsResponsibility {
+		if c.hsErr != nil {
+			return c.hsErr
+		}
+		if c.hsClosed {
+			return errs.Wrap(net.ErrClosed)
+		}
+		c.hsCond.Wait()
+		if c.hsReadErr != nil {
+			return c.hsReadErr
+		}
+	}
+	return nil
+}
+
+// requireHandshake fails with Options.ExplicitHandshake if the handshake
+// didn't complete yet, and after ExportState.
+func (c *Conn) requireHandshake() error {
+	if atomic.LoadUint32(&c.exported) != 0 {
+		return ErrStateExported
+	}
+	if c.explicitHS && !c.HandshakeComplete() {
+		return fmt.Errorf("%w: call Handshake before reading or writing", ErrHandshakeRequired)
+	}
+	return nil
+}
+
+func (c *Conn) handshake() error {
+	c.hsMu.Lock()
+	defer c.hsMu.Unlock()
+	return c.hsReadUntil(func() bool { return false })
+}
+
+// HandshakeContext is like Handshake, but the handshake is interrupted if
+// ctx is done, and is bounded by the deadline of ctx, if any. It overwrites
+// any deadlines set on the underlying net.Conn.
+func (c *Conn) HandshakeContext(ctx context.Context) (err error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := c.Conn.SetDeadline(deadline); err != nil {
+			return errs.Wrap(err)
+		}
+		defer func() { _ = c.Conn.SetDeadline(time.Time{}) }()
+	}
+	if ctx.Done() != nil {
+		done := make(chan struct{})
+		interrupted := make(chan struct{})
+		go func() {
+			defer close(interrupted)
+			select {
+			case <-ctx.Done():
+				_ = c.Conn.SetDeadline(time.Unix(1, 0))
+			case <-done:
+			}
+		}()
+		defer func() {
+			close(done)
+			<-interrupted
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				err = errs.Wrap(ctxErr)
+			}
+		}()
+	}
+	return c.Handshake()
+}
+
+// PeerStatic returns the static public key of the peer, if the handshake
+// pattern provided one. With patterns where the key is known beforehand,
+// such as IK for initiators, this is Config.PeerStatic until the handshake
+// proved that the peer has it. Otherwise, this returns nil if the
+// handshake hasn't progressed far enough for the key to be known.
+func (c *Conn) PeerStatic() []byte {
+	c.hsMu.Lock()
+	defer c.hsMu.Unlock()
+	if c.hs != nil && (c.hs.MessageIndex() > 0 || c.peerPreStatic) {
+		return c.hs.PeerStatic()
+	}
+	return c.peerStatic
+}
+
+// PeerIdentity returns the verified leaf certificate of the peer, if
+// Options.IdentityRoots is set. This returns nil until the certificate has
+// been received.
+func (c *Conn) PeerIdentity() *Certificate {
+	c.hsMu.Lock()
+	defer c.hsMu.Unlock()
+	return c.peerIdentity
+}
+
 // HandshakeComplete returns whether a handshake is complete.
 func (c *Conn) HandshakeComplete() bool {
 	c.hsMu.Lock()
@@ -343,6 +1703,13 @@ func (c *Conn) HandshakeHash() []byte {
 	return c.hh
 }
 
+// zero overwrites b with zeros.
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
 func min(a, b int) int {
 	if a <= b {
 		return a
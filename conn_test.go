@@ -3,8 +3,11 @@ package noiseconn
 import (
 	"bytes"
 	"crypto/rand"
+	"errors"
+	"io"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/flynn/noise"
 	"golang.org/x/sync/errgroup"
@@ -75,3 +78,496 @@ func TestConn(t *testing.T) {
 		panic(err)
 	}
 }
+
+func TestConnHandshake(t *testing.T) {
+	p1, p2 := net.Pipe()
+
+	clientKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	serverKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	client, err := NewConn(p1, noise.Config{
+		CipherSuite:   noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+		Pattern:       noise.HandshakeXX,
+		Initiator:     true,
+		StaticKeypair: clientKey,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer client.Close()
+
+	server, err := NewConn(p2, noise.Config{
+		CipherSuite:   noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+		Pattern:       noise.HandshakeXX,
+		Initiator:     false,
+		StaticKeypair: serverKey,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer server.Close()
+
+	var eg errgroup.Group
+	eg.Go(client.Handshake)
+	eg.Go(server.Handshake)
+	err = eg.Wait()
+	if err != nil {
+		panic(err)
+	}
+
+	if !bytes.Equal(client.PeerStatic(), serverKey.Public) ||
+		!bytes.Equal(server.PeerStatic(), clientKey.Public) ||
+		!bytes.Equal(client.HandshakeHash(), server.HandshakeHash()) {
+		panic("failure")
+	}
+}
+
+func TestConnPeerStaticBeforeHandshake(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer func() { _ = p1.Close() }()
+	defer func() { _ = p2.Close() }()
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	serverKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	clientKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	// an IK initiator knows the key of the responder beforehand, but the
+	// responder doesn't know the key of the initiator.
+	client, err := NewConn(p1, noise.Config{
+		CipherSuite:   cs,
+		Pattern:       noise.HandshakeIK,
+		Initiator:     true,
+		StaticKeypair: clientKey,
+		PeerStatic:    serverKey.Public,
+	})
+	if err != nil {
+		panic(err)
+	}
+	server, err := NewConn(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeIK, StaticKeypair: serverKey})
+	if err != nil {
+		panic(err)
+	}
+	if !bytes.Equal(client.PeerStatic(), serverKey.Public) {
+		t.Fatalf("unexpected client peer static %x", client.PeerStatic())
+	}
+	if server.PeerStatic() != nil {
+		t.Fatalf("unexpected server peer static %x", server.PeerStatic())
+	}
+}
+
+func TestConnZeroize(t *testing.T) {
+	p1, p2 := net.Pipe()
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	client, err := NewConn(p1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	server, err := NewConn(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN})
+	if err != nil {
+		panic(err)
+	}
+
+	data := bytes.Repeat([]byte{0xaa}, 100)
+	var eg errgroup.Group
+	eg.Go(func() error {
+		_, err := client.Write(data)
+		return err
+	})
+	b := make([]byte, 10)
+	if _, err := server.Read(b); err != nil {
+		panic(err)
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+
+	// plaintext that was read isn't left behind the buffered data.
+	buffered := server.readBuf
+	if len(buffered) != 90 {
+		t.Fatalf("unexpected buffered length %d", len(buffered))
+	}
+	for _, x := range buffered[len(buffered):cap(buffered)] {
+		if x != 0 {
+			t.Fatal("read plaintext left in the buffer")
+		}
+	}
+
+	if err := server.Close(); err != nil {
+		panic(err)
+	}
+	if server.readBuf != nil {
+		t.Fatal("buffer not released on close")
+	}
+	for _, x := range buffered[:cap(buffered)] {
+		if x != 0 {
+			t.Fatal("plaintext left in the buffer on close")
+		}
+	}
+}
+
+func TestConnPeek(t *testing.T) {
+	p1, p2 := net.Pipe()
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	client, err := NewConn(p1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	server, err := NewConn(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		_, err := client.Write([]byte("hello world"))
+		return err
+	})
+	// the first peek drives the handshake.
+	b, err := server.Peek(5)
+	if err != nil {
+		panic(err)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("unexpected peek %q", b)
+	}
+	if b, err = server.Peek(100); err != nil || string(b) != "hello world" {
+		t.Fatalf("unexpected peek %q %v", b, err)
+	}
+	buf := make([]byte, 100)
+	n, err := server.Read(buf)
+	if err != nil {
+		panic(err)
+	}
+	if string(buf[:n]) != "hello world" {
+		t.Fatalf("unexpected read %q", buf[:n])
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+
+	// later peeks wait for a frame.
+	eg.Go(func() error {
+		_, err := client.Write([]byte("again"))
+		return err
+	})
+	if b, err = server.Peek(3); err != nil || string(b) != "aga" {
+		t.Fatalf("unexpected peek %q %v", b, err)
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+}
+
+func TestConnBuffered(t *testing.T) {
+	p1, p2 := net.Pipe()
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	client, err := NewConn(p1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	server, err := NewConn(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+
+	if server.Buffered() != 0 {
+		t.Fatal("expected nothing buffered")
+	}
+	var eg errgroup.Group
+	eg.Go(func() error {
+		_, err := client.Write([]byte("hello world"))
+		return err
+	})
+	b := make([]byte, 5)
+	if _, err := server.Read(b); err != nil {
+		panic(err)
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	if n := server.Buffered(); n != 6 {
+		t.Fatalf("expected 6 bytes buffered, got %d", n)
+	}
+	if _, err := server.Read(make([]byte, 10)); err != nil {
+		panic(err)
+	}
+	if n := server.Buffered(); n != 0 {
+		t.Fatalf("expected nothing buffered, got %d", n)
+	}
+}
+
+func TestConnExplicitHandshake(t *testing.T) {
+	p1, p2 := net.Pipe()
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	client, err := NewConn(p1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true}, WithExplicitHandshake())
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	server, err := NewConn(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, WithExplicitHandshake())
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+
+	if _, err := client.Write([]byte("hello")); !errors.Is(err, ErrHandshakeRequired) {
+		t.Fatal("expected ErrHandshakeRequired, got", err)
+	}
+	if _, err := server.Read(make([]byte, 5)); !errors.Is(err, ErrHandshakeRequired) {
+		t.Fatal("expected ErrHandshakeRequired, got", err)
+	}
+
+	var eg errgroup.Group
+	eg.Go(client.Handshake)
+	eg.Go(server.Handshake)
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	eg.Go(func() error {
+		_, err := client.Write([]byte("hello"))
+		return err
+	})
+	b := make([]byte, 5)
+	if _, err := server.Read(b); err != nil || string(b) != "hello" {
+		t.Fatal("unexpected read", string(b), err)
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+}
+
+func TestConnWriteWaitsForHandshake(t *testing.T) {
+	p1, p2 := net.Pipe()
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	client, err := NewConn(p1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	server, err := NewConn(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, WithWriteWaitsForHandshake())
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+
+	greeted := make(chan error, 1)
+	go func() {
+		_, err := server.Write([]byte("greeting"))
+		greeted <- err
+	}()
+	// net.Pipe is synchronous, so the first message is only delivered to
+	// a read.
+	var eg errgroup.Group
+	eg.Go(func() error {
+		_, err := client.Write([]byte("hello"))
+		return err
+	})
+	select {
+	case err := <-greeted:
+		t.Fatal("expected Write to wait for Read, got", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the read of the server lets the greeting go out.
+	b := make([]byte, 5)
+	if _, err := server.Read(b); err != nil || string(b) != "hello" {
+		t.Fatal("unexpected read", string(b), err)
+	}
+	b = make([]byte, 8)
+	if _, err := client.Read(b); err != nil || string(b) != "greeting" {
+		t.Fatal("unexpected read", string(b), err)
+	}
+	if err := <-greeted; err != nil {
+		t.Fatal(err)
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+}
+
+func TestConnWriteWaitsForHandshakeClose(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer func() { _ = p1.Close() }()
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	server, err := NewConn(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, WithWriteWaitsForHandshake())
+	if err != nil {
+		panic(err)
+	}
+	greeted := make(chan error, 1)
+	go func() {
+		_, err := server.Write([]byte("greeting"))
+		greeted <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	_ = server.Close()
+	if err := <-greeted; !errors.Is(err, net.ErrClosed) {
+		t.Fatal("expected net.ErrClosed, got", err)
+	}
+}
+
+// splitConn writes the next Write in two halves, waiting for release in
+// between, once split is set.
+type splitConn struct {
+	net.Conn
+	split   chan struct{}
+	release chan struct{}
+}
+
+func (c *splitConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.split:
+	default:
+		return c.Conn.Write(b)
+	}
+	n, err := c.Conn.Write(b[:len(b)/2])
+	if err != nil {
+		return n, err
+	}
+	<-c.release
+	m, err := c.Conn.Write(b[len(b)/2:])
+	return n + m, err
+}
+
+func TestConnReadDeadlineResumesFrame(t *testing.T) {
+	p1, p2 := net.Pipe()
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	split := &splitConn{Conn: p1, split: make(chan struct{}), release: make(chan struct{})}
+	client, err := NewConn(split, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	server, err := NewConn(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+	var eg errgroup.Group
+	eg.Go(client.Handshake)
+	eg.Go(server.Handshake)
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+
+	close(split.split)
+	eg.Go(func() error {
+		_, err := client.Write([]byte("hello world"))
+		return err
+	})
+	// the deadline passes with half of the frame received.
+	_ = server.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	var netErr net.Error
+	if _, err := server.Read(make([]byte, 5)); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatal("expected a timeout, got", err)
+	}
+	close(split.release)
+	_ = server.SetReadDeadline(time.Time{})
+	b := make([]byte, 5)
+	if _, err := server.Read(b); err != nil || string(b) != "hello" {
+		t.Fatal("unexpected read", string(b), err)
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+
+	// buffered plaintext is returned even after the deadline.
+	_ = server.SetReadDeadline(time.Now().Add(-time.Second))
+	b = make([]byte, 10)
+	n, err := server.Read(b)
+	if err != nil || string(b[:n]) != " world" {
+		t.Fatal("unexpected read", string(b[:n]), err)
+	}
+}
+
+func TestConnReadBuffers(t *testing.T) {
+	p1, p2 := net.Pipe()
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	client, err := NewConn(p1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	server, err := NewConn(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		_, err := client.Write([]byte("headbody!"))
+		return err
+	})
+	header, body := make([]byte, 4), make([]byte, 4)
+	n, err := server.ReadBuffers([][]byte{header, body})
+	if err != nil {
+		panic(err)
+	}
+	if n != 8 || string(header) != "head" || string(body) != "body" {
+		t.Fatalf("unexpected read %d %q %q", n, header, body)
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+
+	// only the buffered plaintext is returned.
+	n, err = server.ReadBuffers([][]byte{nil, header, body})
+	if err != nil || n != 1 || header[0] != '!' {
+		t.Fatalf("unexpected read %d %q %v", n, header, err)
+	}
+}
+
+func TestConnReadFull(t *testing.T) {
+	p1, p2 := net.Pipe()
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	client, err := NewConn(p1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true})
+	if err != nil {
+		panic(err)
+	}
+	server, err := NewConn(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, WithReadFull())
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		for _, part := range []string{"fixed", "-size", "-record", "tail"} {
+			if _, err := client.Write([]byte(part)); err != nil {
+				return err
+			}
+		}
+		return client.Close()
+	})
+	record := make([]byte, 17)
+	n, err := server.Read(record)
+	if err != nil {
+		panic(err)
+	}
+	if n != len(record) || string(record) != "fixed-size-record" {
+		t.Fatalf("unexpected read %d %q", n, record)
+	}
+	n, err = server.Read(record)
+	if !errors.Is(err, io.ErrUnexpectedEOF) || string(record[:n]) != "tail" {
+		t.Fatalf("unexpected read %d %q %v", n, record[:n], err)
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+}
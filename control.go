@@ -0,0 +1,135 @@
+package noiseconn
+
+import (
+	"io"
+
+	"github.com/zeebo/errs"
+)
+
+// Control frames are transport messages framed with controlHeaderByte
+// instead of HeaderByte. They are encrypted like data, and their plaintext
+// is a type byte followed by the payload. Control frames of unknown types
+// are ignored.
+const (
+	controlNextStatic  = 1
+	controlEarlyToken  = 2
+	controlRekey       = 3
+	controlKeepalive   = 4
+	controlPing        = 5
+	controlPong        = 6
+	controlClose       = 7
+	controlCloseReason = 8
+)
+
+// supportsControl returns whether this side can receive control frames.
+func (c *Conn) supportsControl() bool {
+	return c.extensions && c.mt == nil
+}
+
+// appendControl appends an encrypted control frame to out. The caller must
+// hold c.writeMu, or c.hsMu if the handshake has just completed.
+func (c *Conn) appendControl(out []byte, typ byte, payload []byte) ([]byte, error) {
+	outlen := len(out)
+	out, err := c.send.Encrypt(append(out, make([]byte, 4)...), nil, append([]byte{typ}, payload...))
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	if err := c.frame(out[outlen:], out[outlen+4:]); err != nil {
+		return nil, err
+	}
+	out[outlen] = controlHeaderByte
+	return out, nil
+}
+
+// appendCompletionControl appends the control frames that are sent as soon
+// as the handshake completes. c.hsMu must be held.
+func (c *Conn) appendCompletionControl(out []byte) (_ []byte, err error) {
+	if c.nextStatic != nil && c.peerControl {
+		if out, err = c.appendControl(out, controlNextStatic, c.nextStatic); err != nil {
+			return nil, err
+		}
+	}
+	if c.earlyTokens != nil && c.peerControl {
+		token, err := c.earlyTokens.Issue()
+		if err != nil {
+			return nil, errs.Wrap(err)
+		}
+		if out, err = c.appendControl(out, controlEarlyToken, token); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// writeControl sends a control frame once the handshake is complete.
+func (c *Conn) writeControl(typ byte, payload []byte) error {
+	c.hsMu.Lock()
+	complete, peerControl := c.hs == nil, c.peerControl
+	c.hsMu.Unlock()
+	if !complete {
+		return errs.New("handshake not complete")
+	}
+	if !peerControl {
+		return errs.New("peer doesn't support control frames")
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	buf, err := c.appendControl(c.writeMsgBuf[:0], typ, payload)
+	if err != nil {
+		return err
+	}
+	c.writeMsgBuf = buf
+	return c.writeFrames(buf)
+}
+
+// readControl decrypts and handles a received control frame.
+func (c *Conn) readControl(frame []byte) (err error) {
+	c.controlBuf, err = c.decrypt(c.controlBuf[:0], frame)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	defer zero(c.controlBuf)
+	if len(c.controlBuf) == 0 {
+		return errs.New("empty control frame")
+	}
+	typ, payload := c.controlBuf[0], c.controlBuf[1:]
+	switch typ {
+	case controlNextStatic:
+		if c.nextPeerStatic != nil {
+			return errs.Wrap(c.nextPeerStatic(c.Conn.RemoteAddr(), c.peerStatic, append([]byte(nil), payload...)))
+		}
+	case controlEarlyToken:
+		if c.onEarlyToken != nil {
+			c.onEarlyToken(append([]byte(nil), payload...))
+		}
+	case controlRekey:
+		endRegion := c.region("rekey")
+		c.recv.Rekey()
+		endRegion()
+		c.rekeyed(false)
+	case controlKeepalive:
+		// receiving it was the point.
+	case controlPing:
+		c.readPing(payload)
+	case controlPong:
+		return c.readPong(payload)
+	case controlClose:
+		// the peer closed the connection after everything it wrote, so
+		// every later read ends there too.
+		c.peerCloseErr = io.EOF
+		return io.EOF
+	case controlCloseReason:
+		return c.readCloseReason(payload)
+	}
+	return nil
+}
+
+// AdvertiseNextStatic tells the peer about the static public key this side
+// will switch to, in an authenticated control frame, so that the peer can
+// update pinned keys before the switch (see Options.NextPeerStatic). It
+// fails if the handshake isn't complete or the peer doesn't support
+// control frames. It may be called concurrently with Read and Write.
+func (c *Conn) AdvertiseNextStatic(next []byte) error {
+	return c.writeControl(controlNextStatic, next)
+}
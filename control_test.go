@@ -0,0 +1,129 @@
+package noiseconn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestNextStatic(t *testing.T) {
+	p1, p2 := net.Pipe()
+
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	clientKey, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	serverKey, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	next1, next2 := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+
+	tofu := &TOFU{
+		Store: NewKnownHostsFile(filepath.Join(t.TempDir(), "known_hosts")),
+		Host:  func(net.Addr) string { return "server" },
+	}
+	var mu sync.Mutex
+	var advertised [][]byte
+	client, err := NewConnWithOptions(p1, noise.Config{
+		CipherSuite:   cs,
+		Pattern:       noise.HandshakeXX,
+		Initiator:     true,
+		StaticKeypair: clientKey,
+	}, Options{
+		VerifyPeer: tofu.Verify,
+		NextPeerStatic: func(addr net.Addr, peerStatic, next []byte) error {
+			mu.Lock()
+			advertised = append(advertised, next)
+			mu.Unlock()
+			return tofu.Rotate(addr, peerStatic, next)
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	server, err := NewConnWithOptions(p2, noise.Config{
+		CipherSuite:   cs,
+		Pattern:       noise.HandshakeXX,
+		StaticKeypair: serverKey,
+	}, Options{NextStatic: next1})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		if _, err := client.Write([]byte("ping")); err != nil {
+			return err
+		}
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(client, buf); err != nil {
+			return err
+		}
+		// completes the handshake.
+		if _, err := client.Write([]byte("ping")); err != nil {
+			return err
+		}
+		buf = make([]byte, 12)
+		if _, err := io.ReadFull(client, buf); err != nil {
+			return err
+		}
+		if string(buf) != "pongpongpong" {
+			t.Errorf("unexpected data %q", buf)
+		}
+		return nil
+	})
+	eg.Go(func() error {
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(server, buf); err != nil {
+			return err
+		}
+		if _, err := server.Write([]byte("pong")); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(server, buf); err != nil {
+			return err
+		}
+		if _, err := server.Write([]byte("pong")); err != nil {
+			return err
+		}
+		// control frames may be interleaved with concurrent writes.
+		var writes errgroup.Group
+		writes.Go(func() error {
+			_, err := server.Write([]byte("pong"))
+			return err
+		})
+		writes.Go(func() error { return server.AdvertiseNextStatic(next2) })
+		if err := writes.Wait(); err != nil {
+			return err
+		}
+		_, err := server.Write([]byte("pong"))
+		return err
+	})
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+
+	if len(advertised) != 2 || !bytes.Equal(advertised[0], next1) || !bytes.Equal(advertised[1], next2) {
+		t.Fatalf("unexpected advertised keys %x", advertised)
+	}
+	for _, key := range [][]byte{serverKey.Public, next1, next2} {
+		if err := tofu.Verify(nil, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tofu.Rotate(nil, bytes.Repeat([]byte{3}, 32), next1); err == nil {
+		t.Fatal("expected error rotating from an unknown key")
+	}
+}
@@ -0,0 +1,84 @@
+package noiseconn
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	cookieLen            = 16
+	cookieSecretLifetime = 2 * time.Minute
+)
+
+// CookieChecker protects datagram responders from handshake floods with
+// spoofed source addresses. When it demands a cookie, a responder answers
+// an initial handshake message with a stateless cookie derived from the
+// sender's address, and only allocates handshake state (and performs DH
+// operations) once the initiator echoes the cookie back. A CookieChecker
+// may be shared between many DatagramConns.
+type CookieChecker struct {
+	// UnderLoad reports whether cookies should currently be demanded. If
+	// nil, cookies are always demanded.
+	UnderLoad func() bool
+
+	mu      sync.Mutex
+	secret  [32]byte
+	prev    [32]byte
+	rotated time.Time
+}
+
+// NewCookieChecker returns a new CookieChecker that demands cookies when
+// underLoad returns true. underLoad may be nil.
+func NewCookieChecker(underLoad func() bool) *CookieChecker {
+	return &CookieChecker{UnderLoad: underLoad}
+}
+
+func (c *CookieChecker) required() bool {
+	return c.UnderLoad == nil || c.UnderLoad()
+}
+
+func (c *CookieChecker) rotate() {
+	if time.Since(c.rotated) < cookieSecretLifetime {
+		return
+	}
+	if c.rotated.IsZero() {
+		mustRead(c.secret[:])
+	}
+	c.prev = c.secret
+	mustRead(c.secret[:])
+	c.rotated = time.Now()
+}
+
+func (c *CookieChecker) cookie(addr net.Addr) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rotate()
+	return makeCookie(&c.secret, addr)
+}
+
+func (c *CookieChecker) valid(addr net.Addr, cookie []byte) bool {
+	if len(cookie) != cookieLen {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rotate()
+	return hmac.Equal(cookie, makeCookie(&c.secret, addr)) ||
+		hmac.Equal(cookie, makeCookie(&c.prev, addr))
+}
+
+func makeCookie(secret *[32]byte, addr net.Addr) []byte {
+	mac := hmac.New(sha256.New, secret[:])
+	_, _ = mac.Write([]byte(addr.String()))
+	return mac.Sum(nil)[:cookieLen]
+}
+
+func mustRead(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,537 @@
+package noiseconn
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/flynn/noise"
+	"github.com/zeebo/errs"
+)
+
+const (
+	// DefaultMTU is the default largest datagram DatagramConn will hand to
+	// the underlying net.Conn. It is small enough to avoid IP fragmentation
+	// on practically every path.
+	DefaultMTU = 1280
+
+	// DefaultMaxMessageSize is the default largest application message a
+	// DatagramConn will reassemble.
+	DefaultMaxMessageSize = 1 << 20
+
+	// DefaultMaxSessions is the default DatagramOptions.MaxSessions.
+	DefaultMaxSessions = 4096
+
+	// DefaultSessionHandshakeTimeout is the default
+	// DatagramOptions.SessionHandshakeTimeout.
+	DefaultSessionHandshakeTimeout = 10 * time.Second
+
+	dgHandshake       = 0x01
+	dgTransport       = 0x02
+	dgCookieReply     = 0x03
+	dgHandshakeCookie = 0x04
+
+	// datagram header (type + nonce), fragment header (message id, index,
+	// count) and the AEAD tag.
+	dgHeaderLen   = 1 + 8
+	fragHeaderLen = 4 + 2 + 2
+	dgOverhead    = dgHeaderLen + fragHeaderLen + 16
+
+	minMTU             = dgOverhead + 1
+	maxDatagram        = 65535
+	maxReassemblies    = 16
+	replayWindowLength = 64
+)
+
+// DatagramOptions configures a DatagramConn.
+type DatagramOptions struct {
+	// MTU is the largest datagram, excluding IP and UDP headers, that will
+	// be written to the underlying net.Conn. Messages that don't fit are
+	// fragmented. Defaults to DefaultMTU.
+	MTU int
+
+	// MaxMessageSize is the largest message that will be sent or
+	// reassembled. Defaults to DefaultMaxMessageSize.
+	MaxMessageSize int
+
+	// DontFragment sets the don't-fragment bit on outgoing datagrams, if
+	// the underlying net.Conn supports it. When the kernel reports that a
+	// datagram exceeds the path MTU, the MTU is lowered to the discovered
+	// value and the message is resent.
+	//
+	// A PacketListener sets it once on its net.PacketConn instead, and
+	// its sessions don't lower their MTU, as the path MTU of an
+	// unconnected socket isn't known, so their writes beyond the path MTU
+	// fail.
+	DontFragment bool
+
+	// Cookies, if set, makes a responder demand a stateless cookie round
+	// trip before allocating handshake state, whenever the CookieChecker
+	// says so. It is not considered for initiators.
+	Cookies *CookieChecker
+
+	// Random, if set, is the source of randomness for the ephemeral keys
+	// of the handshake, overriding noise.Config.Random. A deterministic
+	// source makes handshakes reproducible, which is only ever appropriate
+	// in tests.
+	Random io.Reader
+
+	// MaxSessions limits how many remote addresses a PacketListener keeps
+	// sessions for, so that initiation packets from spoofed addresses
+	// can't grow its state without bound. Initiations from new addresses
+	// are dropped while the limit is reached. Defaults to
+	// DefaultMaxSessions. It is not considered by DatagramConn.
+	MaxSessions int
+
+	// SessionHandshakeTimeout is how long a PacketListener keeps a session
+	// whose handshake didn't complete, and defaults to
+	// DefaultSessionHandshakeTimeout. SessionIdleTimeout, if positive, is
+	// how long it keeps a session that received nothing. Expired sessions
+	// are closed. They are not considered by DatagramConn.
+	SessionHandshakeTimeout time.Duration
+	SessionIdleTimeout      time.Duration
+}
+
+// socket is what socket options need of a net.Conn or net.PacketConn.
+type socket interface {
+	LocalAddr() net.Addr
+}
+
+// DatagramConn is a net.Conn that implements the Noise protocol on top of an
+// unreliable, datagram-oriented net.Conn (such as a connected *net.UDPConn).
+// Every Write is delivered as a single message to a single Read, or not at
+// all. Messages larger than the MTU are fragmented and reassembled.
+// Transport messages carry explicit nonces, so loss and reordering are
+// tolerated, and replayed messages are dropped.
+//
+// Handshake messages are not retransmitted. If a handshake message is lost,
+// Handshake will block until the deadline of the underlying net.Conn.
+type DatagramConn struct {
+	net.Conn
+	opts DatagramOptions
+
+	hsMu      sync.Mutex
+	hs        *noise.HandshakeState
+	config    *noise.Config
+	hh        []byte
+	initiator bool
+	hsWrite   bool
+	hsLast    []byte
+	hsBuf     []byte
+	// hsDone is set once the handshake completed, for checks that can't
+	// wait for hsMu.
+	hsDone uint32
+
+	writeMu  sync.Mutex
+	send     noise.Cipher
+	sendN    uint64
+	msgID    uint32
+	mtu      int
+	writeBuf []byte
+
+	readMu  sync.Mutex
+	recv    noise.Cipher
+	replay  replayWindow
+	readBuf []byte
+	ptBuf   []byte
+	pending []*reassembly
+}
+
+var _ net.Conn = (*DatagramConn)(nil)
+
+// NewDatagramConn wraps an existing datagram-oriented net.Conn with
+// encryption provided by noise.Config.
+func NewDatagramConn(conn net.Conn, config noise.Config) (*DatagramConn, error) {
+	return NewDatagramConnWithOptions(conn, config, DatagramOptions{})
+}
+
+// NewDatagramConnWithOptions wraps an existing datagram-oriented net.Conn
+// with encryption provided by noise.Config and options provided by
+// DatagramOptions.
+func NewDatagramConnWithOptions(conn net.Conn, config noise.Config, opts DatagramOptions) (*DatagramConn, error) {
+	if opts.MTU == 0 {
+		opts.MTU = DefaultMTU
+	}
+	if opts.MTU < minMTU || opts.MTU > maxDatagram {
+		return nil, errs.New("invalid mtu: %d", opts.MTU)
+	}
+	if opts.MaxMessageSize <= 0 {
+		opts.MaxMessageSize = DefaultMaxMessageSize
+	}
+	if opts.Random != nil {
+		config.Random = opts.Random
+	}
+	if opts.DontFragment {
+		if err := setDontFragment(conn); err != nil {
+			return nil, errs.Wrap(err)
+		}
+	}
+	c := &DatagramConn{
+		Conn:      conn,
+		opts:      opts,
+		initiator: config.Initiator,
+		hsWrite:   config.Initiator,
+		mtu:       opts.MTU,
+	}
+	if config.Initiator {
+		hs, err := noise.NewHandshakeState(config)
+		if err != nil {
+			return nil, errs.Wrap(err)
+		}
+		c.hs = hs
+	} else {
+		// responders allocate handshake state once a first message (and
+		// maybe a cookie) arrives.
+		c.config = &config
+	}
+	return c, nil
+}
+
+// MTU returns the current largest datagram size. It may be lower than the
+// configured MTU if path MTU discovery lowered it.
+func (c *DatagramConn) MTU() int {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.mtu
+}
+
+// Handshake runs the Noise handshake, if it hasn't completed yet. Read and
+// Write call Handshake automatically.
+func (c *DatagramConn) Handshake() (err error) {
+	if atomic.LoadUint32(&c.hsDone) != 0 {
+		return nil
+	}
+	c.hsMu.Lock()
+	defer c.hsMu.Unlock()
+	for c.hs != nil || c.config != nil {
+		if c.hsBuf == nil {
+			c.hsBuf = make([]byte, maxDatagram)
+		}
+		buf := c.hsBuf
+		var cs1, cs2 *noise.CipherState
+		if c.hsWrite {
+			var msg []byte
+			msg, cs1, cs2, err = c.hs.WriteMessage(append(buf[:0], dgHandshake), nil)
+			if err != nil {
+				return errs.Wrap(err)
+			}
+			if _, err = c.Conn.Write(msg); err != nil {
+				return errs.Wrap(err)
+			}
+			c.hsLast = append(c.hsLast[:0], msg...)
+		} else {
+			n, err := c.Conn.Read(buf)
+			if err != nil {
+				return errs.Wrap(err)
+			}
+			if n == 0 {
+				continue
+			}
+			msg, ok, err := c.hsReceive(buf[:n])
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			_, cs1, cs2, err = c.hs.ReadMessage(nil, msg)
+			if err != nil {
+				// datagrams can be spoofed, so a bad handshake message
+				// shouldn't tear down the handshake.
+				if !c.initiator && c.hs.MessageIndex() == 0 {
+					c.hs = nil
+				}
+				continue
+			}
+		}
+		c.hsWrite = !c.hsWrite
+		if cs1 != nil {
+			if !c.initiator {
+				cs1, cs2 = cs2, cs1
+			}
+			c.hh = c.hs.ChannelBinding()
+			c.hs, c.config, c.hsLast, c.hsBuf = nil, nil, nil, nil
+			c.writeMu.Lock()
+			c.send = cs1.Cipher()
+			c.writeMu.Unlock()
+			c.readMu.Lock()
+			c.recv = cs2.Cipher()
+			c.readMu.Unlock()
+			// set last, so the fast path in Handshake finds the ciphers.
+			atomic.StoreUint32(&c.hsDone, 1)
+		}
+	}
+	return nil
+}
+
+// hsReceive inspects a datagram received during the handshake, returning
+// the handshake message it carries, if any. It handles the cookie round
+// trip on both sides.
+func (c *DatagramConn) hsReceive(dg []byte) (msg []byte, ok bool, err error) {
+	typ, msg := dg[0], dg[1:]
+	if c.initiator {
+		if typ == dgCookieReply && len(msg) == cookieLen && c.hsLast != nil && c.hs.MessageIndex() == 1 {
+			// cookie replies are unauthenticated, but all they can cause is
+			// a resend of the first message.
+			resend := append(append([]byte{dgHandshakeCookie}, msg...), c.hsLast[1:]...)
+			if _, err := c.Conn.Write(resend); err != nil {
+				return nil, false, errs.Wrap(err)
+			}
+			return nil, false, nil
+		}
+		return msg, typ == dgHandshake, nil
+	}
+
+	var cookie []byte
+	switch typ {
+	case dgHandshake:
+	case dgHandshakeCookie:
+		if len(msg) < cookieLen {
+			return nil, false, nil
+		}
+		cookie, msg = msg[:cookieLen], msg[cookieLen:]
+	default:
+		return nil, false, nil
+	}
+	if c.hs != nil {
+		return msg, true, nil
+	}
+	if cc := c.opts.Cookies; cc != nil && cc.required() {
+		addr := c.Conn.RemoteAddr()
+		if !cc.valid(addr, cookie) {
+			reply := append([]byte{dgCookieReply}, cc.cookie(addr)...)
+			if _, err := c.Conn.Write(reply); err != nil {
+				return nil, false, errs.Wrap(err)
+			}
+			return nil, false, nil
+		}
+	}
+	c.hs, err = noise.NewHandshakeState(*c.config)
+	if err != nil {
+		return nil, false, errs.Wrap(err)
+	}
+	return msg, true, nil
+}
+
+// HandshakeComplete returns whether a handshake is complete.
+func (c *DatagramConn) HandshakeComplete() bool {
+	c.hsMu.Lock()
+	defer c.hsMu.Unlock()
+	return c.hs == nil && c.config == nil
+}
+
+// HandshakeHash returns the hash generated by the handshake which can be
+// used for channel identification and channel binding. This returns nil
+// until the handshake is completed.
+func (c *DatagramConn) HandshakeHash() []byte {
+	c.hsMu.Lock()
+	defer c.hsMu.Unlock()
+	return c.hh
+}
+
+// Write sends b as a single message, fragmenting it if it doesn't fit into
+// one datagram.
+func (c *DatagramConn) Write(b []byte) (n int, err error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	if len(b) > c.opts.MaxMessageSize {
+		return 0, errs.New("message too large: %d", len(b))
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	for {
+		err = c.writeFragments(b)
+		if err == nil {
+			return len(b), nil
+		}
+		if !c.opts.DontFragment || !isMsgSize(err) {
+			return 0, errs.Wrap(err)
+		}
+		mtu, perr := pathMTU(c.Conn)
+		if perr != nil || mtu >= c.mtu || mtu < minMTU {
+			return 0, errs.Wrap(err)
+		}
+		c.mtu = mtu
+	}
+}
+
+func (c *DatagramConn) writeFragments(b []byte) error {
+	per := c.mtu - dgOverhead
+	count := (len(b) + per - 1) / per
+	if count == 0 {
+		count = 1
+	}
+	if count > 0xffff {
+		return errs.New("message too large: %d", len(b))
+	}
+	c.msgID++
+	for i := 0; i < count; i++ {
+		frag := b[min(i*per, len(b)):min((i+1)*per, len(b))]
+
+		var hdr [dgHeaderLen + fragHeaderLen]byte
+		hdr[0] = dgTransport
+		binary.BigEndian.PutUint64(hdr[1:9], c.sendN)
+		binary.BigEndian.PutUint32(hdr[9:13], c.msgID)
+		binary.BigEndian.PutUint16(hdr[13:15], uint16(i))
+		binary.BigEndian.PutUint16(hdr[15:17], uint16(count))
+
+		c.writeBuf = append(append(c.writeBuf[:0], hdr[dgHeaderLen:]...), frag...)
+		pt := len(c.writeBuf)
+		c.writeBuf = c.send.Encrypt(append(c.writeBuf, hdr[:dgHeaderLen]...), c.sendN, nil, c.writeBuf[:pt])
+		c.sendN++
+		if _, err := c.Conn.Write(c.writeBuf[pt:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read reads a single message into b. If b is too small to hold the message,
+// the message is truncated and io.ErrShortBuffer is returned.
+func (c *DatagramConn) Read(b []byte) (n int, err error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if c.readBuf == nil {
+		c.readBuf = make([]byte, maxDatagram)
+	}
+	for {
+		n, err := c.Conn.Read(c.readBuf)
+		if err != nil {
+			return 0, errs.Wrap(err)
+		}
+		msg, ok := c.handleDatagram(c.readBuf[:n])
+		if !ok {
+			continue
+		}
+		n = copy(b, msg)
+		if n < len(msg) {
+			return n, errs.Wrap(io.ErrShortBuffer)
+		}
+		return n, nil
+	}
+}
+
+// handleDatagram processes a received datagram, returning a message if
+// one was completed. Invalid datagrams are dropped.
+func (c *DatagramConn) handleDatagram(dg []byte) (msg []byte, ok bool) {
+	if len(dg) < dgOverhead || dg[0] != dgTransport {
+		return nil, false
+	}
+	nonce := binary.BigEndian.Uint64(dg[1:9])
+	if !c.replay.check(nonce) {
+		return nil, false
+	}
+	pt, err := c.recv.Decrypt(c.ptBuf[:0], nonce, nil, dg[dgHeaderLen:])
+	if err != nil {
+		return nil, false
+	}
+	c.ptBuf = pt
+	c.replay.mark(nonce)
+
+	id := binary.BigEndian.Uint32(pt[0:4])
+	index := int(binary.BigEndian.Uint16(pt[4:6]))
+	count := int(binary.BigEndian.Uint16(pt[6:8]))
+	data := pt[fragHeaderLen:]
+	if count == 0 || index >= count {
+		return nil, false
+	}
+	if count == 1 {
+		return data, true
+	}
+	return c.reassemble(id, index, count, data)
+}
+
+type reassembly struct {
+	id    uint32
+	frags [][]byte
+	have  int
+	size  int
+}
+
+func (c *DatagramConn) reassemble(id uint32, index, count int, data []byte) ([]byte, bool) {
+	var r *reassembly
+	for _, p := range c.pending {
+		if p.id == id {
+			r = p
+			break
+		}
+	}
+	if r == nil {
+		if len(c.pending) >= maxReassemblies {
+			// evict the oldest partial message.
+			copy(c.pending, c.pending[1:])
+			c.pending = c.pending[:len(c.pending)-1]
+		}
+		r = &reassembly{id: id, frags: make([][]byte, count)}
+		c.pending = append(c.pending, r)
+	}
+	if len(r.frags) != count || r.frags[index] != nil {
+		return nil, false
+	}
+	if r.size+len(data) > c.opts.MaxMessageSize {
+		c.dropReassembly(r)
+		return nil, false
+	}
+	r.frags[index] = append([]byte{}, data...)
+	r.have++
+	r.size += len(data)
+	if r.have < count {
+		return nil, false
+	}
+	c.dropReassembly(r)
+	msg := make([]byte, 0, r.size)
+	for _, frag := range r.frags {
+		msg = append(msg, frag...)
+	}
+	return msg, true
+}
+
+func (c *DatagramConn) dropReassembly(r *reassembly) {
+	for i, p := range c.pending {
+		if p == r {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// replayWindow is a sliding window of recently seen nonces.
+type replayWindow struct {
+	max  uint64
+	bits uint64
+	seen bool
+}
+
+func (w *replayWindow) check(n uint64) bool {
+	if !w.seen || n > w.max {
+		return true
+	}
+	if w.max-n >= replayWindowLength {
+		return false
+	}
+	return w.bits&(1<<(w.max-n)) == 0
+}
+
+func (w *replayWindow) mark(n uint64) {
+	switch {
+	case !w.seen:
+		w.seen, w.max, w.bits = true, n, 1
+	case n > w.max:
+		if shift := n - w.max; shift < replayWindowLength {
+			w.bits <<= shift
+		} else {
+			w.bits = 0
+		}
+		w.max = n
+		w.bits |= 1
+	default:
+		w.bits |= 1 << (w.max - n)
+	}
+}
@@ -0,0 +1,74 @@
+//go:build linux
+
+package noiseconn
+
+import (
+	"errors"
+	"net"
+	"syscall"
+
+	"github.com/zeebo/errs"
+)
+
+func setDontFragment(conn socket) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return errs.New("don't-fragment unsupported for %T", conn)
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		if isIPv6(conn) {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_DO)
+		} else {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+func pathMTU(conn net.Conn) (mtu int, err error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return 0, errs.New("path mtu unsupported for %T", conn)
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	ipv6 := isIPv6(conn)
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		if ipv6 {
+			mtu, serr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU)
+		} else {
+			mtu, serr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU)
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	if serr != nil {
+		return 0, serr
+	}
+	// the kernel reports the IP MTU, so remove the IP and UDP headers.
+	if ipv6 {
+		return mtu - 48, nil
+	}
+	return mtu - 28, nil
+}
+
+func isMsgSize(err error) bool {
+	return errors.Is(err, syscall.EMSGSIZE)
+}
+
+func isIPv6(conn socket) bool {
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	return ok && addr.IP.To4() == nil
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package noiseconn
+
+import (
+	"net"
+
+	"github.com/zeebo/errs"
+)
+
+func setDontFragment(conn socket) error {
+	return errs.New("don't-fragment unsupported on this platform")
+}
+
+func pathMTU(conn net.Conn) (int, error) {
+	return 0, errs.New("path mtu unsupported on this platform")
+}
+
+func isMsgSize(err error) bool { return false }
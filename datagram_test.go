@@ -0,0 +1,149 @@
+package noiseconn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestDatagramConnFragmentation(t *testing.T) {
+	p1, p2 := net.Pipe()
+
+	clientKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	serverKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	client, err := NewDatagramConnWithOptions(p1, noise.Config{
+		CipherSuite:   noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+		Pattern:       noise.HandshakeIK,
+		Initiator:     true,
+		StaticKeypair: clientKey,
+		PeerStatic:    serverKey.Public,
+	}, DatagramOptions{MTU: 200})
+	if err != nil {
+		panic(err)
+	}
+	defer client.Close()
+
+	server, err := NewDatagramConnWithOptions(p2, noise.Config{
+		CipherSuite:   noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+		Pattern:       noise.HandshakeIK,
+		Initiator:     false,
+		StaticKeypair: serverKey,
+	}, DatagramOptions{MTU: 200})
+	if err != nil {
+		panic(err)
+	}
+	defer server.Close()
+
+	small := []byte("hello")
+	large := make([]byte, 10000)
+	for i := range large {
+		large[i] = byte(i % 256)
+	}
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		if _, err := client.Write(small); err != nil {
+			return err
+		}
+		_, err := client.Write(large)
+		return err
+	})
+	eg.Go(func() error {
+		b := make([]byte, 65536)
+		for _, expected := range [][]byte{small, large} {
+			n, err := server.Read(b)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(b[:n], expected) {
+				panic("failure")
+			}
+		}
+		return nil
+	})
+	err = eg.Wait()
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	var w replayWindow
+	for _, n := range []uint64{5, 3, 70, 10} {
+		if !w.check(n) {
+			t.Fatalf("expected %d to be accepted", n)
+		}
+		w.mark(n)
+	}
+	// replays, and nonces that fell out of the window, are rejected.
+	for _, n := range []uint64{70, 10, 5, 6} {
+		if w.check(n) {
+			t.Fatalf("expected %d to be rejected", n)
+		}
+	}
+	if !w.check(69) {
+		t.Fatal("expected 69 to be accepted")
+	}
+}
+
+func TestDatagramConnCookie(t *testing.T) {
+	p1, p2 := net.Pipe()
+
+	serverKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	client, err := NewDatagramConn(p1, noise.Config{
+		CipherSuite: noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+		Pattern:     noise.HandshakeNK,
+		Initiator:   true,
+		PeerStatic:  serverKey.Public,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer client.Close()
+
+	server, err := NewDatagramConnWithOptions(p2, noise.Config{
+		CipherSuite:   noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+		Pattern:       noise.HandshakeNK,
+		StaticKeypair: serverKey,
+	}, DatagramOptions{Cookies: NewCookieChecker(nil)})
+	if err != nil {
+		panic(err)
+	}
+	defer server.Close()
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		_, err := client.Write([]byte("hello"))
+		return err
+	})
+	eg.Go(func() error {
+		b := make([]byte, 1024)
+		n, err := server.Read(b)
+		if err != nil {
+			return err
+		}
+		if string(b[:n]) != "hello" {
+			panic("failure")
+		}
+		return nil
+	})
+	err = eg.Wait()
+	if err != nil {
+		panic(err)
+	}
+}
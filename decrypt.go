@@ -0,0 +1,58 @@
+package noiseconn
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrDecryptFailed is returned when a received transport frame fails
+// authentication, or is malformed. Once Options.MaxDecryptFailures is
+// reached, the connection is torn down and every later read returns it.
+var ErrDecryptFailed = errors.New("decryption failed")
+
+// decryptFailures is the state behind Options.MaxDecryptFailures. It is
+// protected by c.readMu.
+type decryptFailures struct {
+	max   int
+	count int
+	err   error
+}
+
+// decrypt decrypts a received transport frame, accounting for failures.
+// c.readMu must be held.
+func (c *Conn) decrypt(out, frame []byte) ([]byte, error) {
+	defer c.profile("decrypt")()
+	out, err := c.recv.Decrypt(out, nil, frame)
+	if err != nil {
+		if errors.Is(err, ErrStateExported) {
+			return nil, err
+		}
+		return nil, c.decryptFailed(err, false)
+	}
+	return out, nil
+}
+
+// decryptFailed counts a frame that failed to decrypt, or a framing error,
+// and tears the connection down once Options.MaxDecryptFailures is
+// reached. Framing errors always tear it down, as the stream can't be
+// resynchronized. Otherwise, the frame is skipped. It returns the error to
+// report. c.readMu must be held.
+func (c *Conn) decryptFailed(err error, framing bool) error {
+	f := &c.decryptFailures
+	f.count++
+	c.log(LogWarn, "decryption failed", "error", err, "failures", f.count)
+	if c.hooks != nil && c.hooks.DecryptFailed != nil {
+		c.hooks.DecryptFailed(c, err)
+	}
+	if !framing && f.count < f.max {
+		// the peer used the nonce of the frame, so skip it.
+		c.recv.n++
+		return fmt.Errorf("%w: %v", ErrDecryptFailed, err)
+	}
+	f.err = fmt.Errorf("%w: connection closed after %d failures: %v", ErrDecryptFailed, f.count, err)
+	if c.closeReason {
+		c.sendCloseReason(CloseProtocolViolation, fmt.Sprintf("%d received frames failed authentication or were malformed", f.count))
+	}
+	_ = c.Conn.Close()
+	return f.err
+}
@@ -0,0 +1,63 @@
+package noiseconn
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestConnDecryptFailures(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	for _, max := range []int{0, 2} {
+		p1, p2 := tcpPair()
+		tamper := &tamperConn{Conn: p1, offset: -1}
+		client, err := NewConn(tamper, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true})
+		if err != nil {
+			panic(err)
+		}
+		var failures int64
+		server, err := NewConnWithOptions(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, Options{
+			MaxDecryptFailures: max,
+			Hooks:              &Hooks{DecryptFailed: func(*Conn, error) { atomic.AddInt64(&failures, 1) }},
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		var eg errgroup.Group
+		eg.Go(client.Handshake)
+		eg.Go(server.Handshake)
+		if err := eg.Wait(); err != nil {
+			panic(err)
+		}
+
+		// corrupt the ciphertext of the next frame.
+		tamper.offset = 5
+		if _, err := client.Write([]byte("corrupt")); err != nil {
+			panic(err)
+		}
+		if _, err := client.Write([]byte("intact")); err != nil {
+			panic(err)
+		}
+		b := make([]byte, 6)
+		if _, err := server.Read(b); !errors.Is(err, ErrDecryptFailed) {
+			t.Fatalf("max %d: expected ErrDecryptFailed, got %v", max, err)
+		}
+		if atomic.LoadInt64(&failures) != 1 {
+			t.Fatalf("max %d: expected a reported failure, got %d", max, failures)
+		}
+		_, err = io.ReadFull(server, b)
+		switch {
+		case max == 0 && !errors.Is(err, ErrDecryptFailed):
+			t.Fatalf("expected the connection to be torn down, got %v", err)
+		case max == 2 && (err != nil || string(b) != "intact"):
+			t.Fatalf("expected the corrupt frame to be skipped, got %q %v", b, err)
+		}
+		_ = client.Close()
+		_ = server.Close()
+	}
+}
@@ -0,0 +1,335 @@
+package noiseconn
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/flynn/noise"
+	"github.com/zeebo/errs"
+)
+
+// Dialer establishes Noise connections. Unlike NewConn, the connections
+// returned by a Dialer have already completed the handshake (and peer
+// verification), bounded by the context and HandshakeTimeout, except for
+// the ones resumed with SessionCache.
+type Dialer struct {
+	// Config is the Noise configuration for dialed connections. The
+	// Initiator field is ignored.
+	Config noise.Config
+
+	// Options are the options for dialed connections.
+	Options Options
+
+	// NetDialer dials the underlying connections. If nil, a zero
+	// net.Dialer is used.
+	NetDialer *net.Dialer
+
+	// HandshakeTimeout, if nonzero, bounds how long the handshake may
+	// take, independently of the timeout of NetDialer.
+	HandshakeTimeout time.Duration
+
+	// LookupPeerStatic, if set, returns the expected static public key of
+	// the peer at address, overriding Config.PeerStatic. This allows a
+	// single Dialer to pin different keys for different destinations.
+	LookupPeerStatic func(ctx context.Context, network, address string) ([]byte, error)
+
+	// Proxy, if set, is the proxy the underlying connection is established
+	// through, before the handshake starts. The scheme must be http (for
+	// HTTP CONNECT), socks5, which resolves host names locally, or socks5h,
+	// which leaves that to the proxy. Without a port, 80 and 1080 are
+	// used. Credentials in the URL's user info are sent to the proxy.
+	Proxy *url.URL
+
+	// ProxyHeader, if set, is called for every dialed connection and the
+	// returned header is sent as a PROXY protocol v2 header before the
+	// handshake. A nil header is sent as a LOCAL header. This is useful
+	// when relaying connections on behalf of other clients to a server
+	// with Options.ProxyProtocol set.
+	ProxyHeader func(ctx context.Context, raw net.Conn) (*ProxyHeader, error)
+
+	// FallbackDelay is how long DialAddrs waits for an attempt before
+	// starting the next one. If zero, 300ms is used.
+	FallbackDelay time.Duration
+
+	// SessionCache, if set, remembers the static keys of the responders
+	// by address. When the key of address is known, the handshake uses
+	// ResumePattern with it, which takes fewer messages than Config.Pattern
+	// and lets the first message carry encrypted data. The connection is
+	// then returned with the handshake pending, so that the first Write is
+	// sent in the first handshake message. If that handshake fails, such
+	// as because the responder changed its key, the key is forgotten and
+	// the error is returned by the Read or Write that drove the
+	// handshake; dialing again, as ReconnectingConn does, falls back to
+	// Config. If the connection can't be set up at all, it is dialed again
+	// with Config right away. The responders must accept both patterns,
+	// for example with Options.FallbackPattern. It isn't used with
+	// LookupPeerStatic.
+	SessionCache SessionCache
+
+	// ResumePattern is the pattern used with the static keys of
+	// SessionCache. If unset, noise.HandshakeIK is used.
+	ResumePattern noise.HandshakePattern
+}
+
+// Dial connects to address and completes a Noise handshake.
+func (d *Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to address and completes a Noise handshake. The
+// returned net.Conn is a *Conn.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	config := d.Config
+	config.Initiator = true
+	if d.LookupPeerStatic != nil {
+		peerStatic, err := d.LookupPeerStatic(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		config.PeerStatic = peerStatic
+	} else if d.SessionCache != nil {
+		return d.dialCached(ctx, network, address, config)
+	}
+	conn, err := d.dial(ctx, network, address, config)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// dialCached dials address with the static key remembered by
+// d.SessionCache, if any, and with config otherwise.
+func (d *Dialer) dialCached(ctx context.Context, network, address string, config noise.Config) (net.Conn, error) {
+	if peerStatic, ok := d.SessionCache.Get(address); ok {
+		resume := config
+		resume.Pattern, resume.PeerStatic = d.ResumePattern, peerStatic
+		if len(resume.Pattern.Messages) == 0 {
+			resume.Pattern = noise.HandshakeIK
+		}
+		conn, err := d.connect(ctx, network, address, resume, d.resumeOptions(address, peerStatic))
+		if err == nil {
+			return conn, nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, err
+		}
+		d.SessionCache.Delete(address)
+		if d.Options.Logger != nil {
+			d.Options.Logger.Log(LogInfo, "dialing with the cached static key failed, retrying", "address", address, "error", err)
+		}
+	}
+	conn, err := d.dial(ctx, network, address, config)
+	if err != nil {
+		return nil, err
+	}
+	if peerStatic := conn.PeerStatic(); len(peerStatic) > 0 {
+		d.SessionCache.Put(address, peerStatic)
+	}
+	return conn, nil
+}
+
+// resumeOptions returns d.Options with a HandshakeDone hook that refreshes
+// peerStatic in d.SessionCache once the resumed handshake completes, and
+// forgets it if the handshake fails.
+func (d *Dialer) resumeOptions(address string, peerStatic []byte) Options {
+	opts := d.Options
+	var hooks Hooks
+	if opts.Hooks != nil {
+		hooks = *opts.Hooks
+	}
+	handshakeDone := hooks.HandshakeDone
+	hooks.HandshakeDone = func(c *Conn, dur time.Duration, received []byte, err error) {
+		if err == nil {
+			d.SessionCache.Put(address, peerStatic)
+		} else {
+			d.SessionCache.Delete(address)
+		}
+		if handshakeDone != nil {
+			handshakeDone(c, dur, received, err)
+		}
+	}
+	opts.Hooks = &hooks
+	return opts
+}
+
+// dial connects to address and completes a Noise handshake with config.
+func (d *Dialer) dial(ctx context.Context, network, address string, config noise.Config) (*Conn, error) {
+	conn, err := d.connect(ctx, network, address, config, d.Options)
+	if err != nil {
+		return nil, err
+	}
+	if d.HandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.HandshakeTimeout)
+		defer cancel()
+	}
+	if err := conn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// connect connects to address and sets up a Conn with config and opts,
+// without starting the handshake.
+func (d *Dialer) connect(ctx context.Context, network, address string, config noise.Config, opts Options) (*Conn, error) {
+	netDialer := d.NetDialer
+	if netDialer == nil {
+		netDialer = &net.Dialer{}
+	}
+	var raw net.Conn
+	var err error
+	if d.Proxy != nil {
+		raw, err = dialProxy(ctx, netDialer, d.Proxy, network, address)
+	} else {
+		raw, err = netDialer.DialContext(ctx, network, address)
+		err = errs.Wrap(err)
+	}
+	if err != nil {
+		if d.Options.Logger != nil {
+			d.Options.Logger.Log(LogWarn, "dial failed", "address", address, "error", err)
+		}
+		return nil, err
+	}
+	if d.ProxyHeader != nil {
+		header, err := d.ProxyHeader(ctx, raw)
+		if err != nil {
+			_ = raw.Close()
+			return nil, err
+		}
+		if _, err := raw.Write(appendProxyHeader(nil, header)); err != nil {
+			_ = raw.Close()
+			return nil, errs.Wrap(err)
+		}
+	}
+	conn, err := NewConnWithOptions(raw, config, opts)
+	if err != nil {
+		_ = raw.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// DialAddrs connects to the first of addresses that completes a Noise
+// handshake (including peer verification). Attempts are staggered in the
+// style of Happy Eyeballs: the next attempt starts when the previous one
+// fails or after FallbackDelay, whichever comes first, and IPv4 and IPv6
+// addresses are alternated. The remaining attempts are canceled once one
+// succeeds. An endpoint that accepts the TCP connection but fails the
+// handshake counts as a failed attempt.
+func (d *Dialer) DialAddrs(ctx context.Context, network string, addresses []string) (net.Conn, error) {
+	if len(addresses) == 0 {
+		return nil, errs.New("no addresses to dial")
+	}
+	addresses = interleaveFamilies(addresses)
+	delay := d.FallbackDelay
+	if delay <= 0 {
+		delay = 300 * time.Millisecond
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(addresses))
+	next, pending := 0, 0
+	start := func() {
+		address := addresses[next]
+		next++
+		pending++
+		go func() {
+			conn, err := d.DialContext(ctx, network, address)
+			results <- result{conn: conn, err: err}
+		}()
+	}
+
+	var group errs.Group
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	start()
+	for pending > 0 {
+		select {
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				cancel()
+				// close any other attempt that completed concurrently.
+				go func(n int) {
+					for ; n > 0; n-- {
+						if res := <-results; res.conn != nil {
+							_ = res.conn.Close()
+						}
+					}
+				}(pending)
+				return res.conn, nil
+			}
+			group.Add(res.err)
+			if next < len(addresses) {
+				start()
+				timer.Reset(delay)
+			}
+		case <-timer.C:
+			if next < len(addresses) {
+				start()
+				timer.Reset(delay)
+			}
+		}
+	}
+	return nil, group.Err()
+}
+
+// interleaveFamilies reorders addresses so IPv6 and IPv4 addresses
+// alternate, starting with the family of the first IP literal and
+// otherwise preserving the order. Addresses that aren't IP literals keep
+// their positions.
+func interleaveFamilies(addresses []string) []string {
+	family := func(address string) int {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		switch ip := net.ParseIP(host); {
+		case ip == nil:
+			return 0
+		case ip.To4() != nil:
+			return 4
+		default:
+			return 6
+		}
+	}
+	var first, second []string
+	firstFamily := 0
+	for _, address := range addresses {
+		switch f := family(address); {
+		case f == 0:
+		case firstFamily == 0 || f == firstFamily:
+			firstFamily = f
+			first = append(first, address)
+		default:
+			second = append(second, address)
+		}
+	}
+	ips := make([]string, 0, len(first)+len(second))
+	for len(first) > 0 || len(second) > 0 {
+		if len(first) > 0 {
+			ips, first = append(ips, first[0]), first[1:]
+		}
+		if len(second) > 0 {
+			ips, second = append(ips, second[0]), second[1:]
+		}
+	}
+	out := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		if family(address) == 0 {
+			out = append(out, address)
+		} else {
+			out, ips = append(out, ips[0]), ips[1:]
+		}
+	}
+	return out
+}
@@ -0,0 +1,180 @@
+package noiseconn
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+)
+
+func TestDialAddrs(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	listen := func() (string, noise.DHKey) {
+		key, err := noise.DH25519.GenerateKeypair(rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+		inner, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			panic(err)
+		}
+		t.Cleanup(func() { _ = inner.Close() })
+		lis := NewListener(inner, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNK, StaticKeypair: key})
+		go func() {
+			for {
+				conn, err := lis.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					_ = conn.(*Conn).Handshake()
+				}()
+			}
+		}()
+		return inner.Addr().String(), key
+	}
+
+	// an endpoint that accepts connections but has the wrong key.
+	impostor, _ := listen()
+	good, key := listen()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	refused := closed.Addr().String()
+	_ = closed.Close()
+
+	d := &Dialer{Config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNK, PeerStatic: key.Public}}
+	conn, err := d.DialAddrs(context.Background(), "tcp", []string{refused, impostor, good})
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != good {
+		t.Fatalf("connected to %v instead of %v", conn.RemoteAddr(), good)
+	}
+
+	if _, err := d.DialAddrs(context.Background(), "tcp", []string{refused, impostor}); err == nil {
+		t.Fatal("expected dialing without the right endpoint to fail")
+	}
+}
+
+func TestInterleaveFamilies(t *testing.T) {
+	for _, test := range []struct{ in, want []string }{
+		{
+			in:   []string{"[::1]:1", "[::2]:1", "example.com:1", "1.1.1.1:1", "2.2.2.2:1"},
+			want: []string{"[::1]:1", "1.1.1.1:1", "example.com:1", "[::2]:1", "2.2.2.2:1"},
+		},
+		{
+			in:   []string{"example.com:1", "1.1.1.1:1", "2.2.2.2:1", "[::1]:1", "example.org:1"},
+			want: []string{"example.com:1", "1.1.1.1:1", "[::1]:1", "2.2.2.2:1", "example.org:1"},
+		},
+	} {
+		if got := interleaveFamilies(test.in); !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("got %v, want %v", got, test.want)
+		}
+	}
+}
+
+func TestDialerSessionCache(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	key, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = inner.Close() }()
+	lis := NewListener(inner, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeIK, StaticKeypair: key},
+		WithFallbackPattern(noise.HandshakeXX))
+	early := make(chan bool, 1)
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var b [4]byte
+				if _, err := io.ReadFull(conn, b[:]); err != nil {
+					return
+				}
+				early <- conn.(*Conn).EarlyDataRead()
+				_, _ = io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	address := inner.Addr().String()
+
+	clientKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	cache := NewMemorySessionCache(time.Hour)
+	d := &Dialer{
+		Config:       noise.Config{CipherSuite: cs, Pattern: noise.HandshakeXX, StaticKeypair: clientKey},
+		SessionCache: cache,
+	}
+	dial := func(protocol string) error {
+		conn, err := d.Dial("tcp", address)
+		if err != nil {
+			panic(err)
+		}
+		defer func() { _ = conn.Close() }()
+		if got := conn.(*Conn).ConnectionState().Protocol; got != protocol {
+			t.Fatalf("got protocol %s, want %s", got, protocol)
+		}
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			return err
+		}
+		if err := conn.(*Conn).Handshake(); err != nil {
+			return err
+		}
+		// the first write is sent in the first handshake message only
+		// when resuming.
+		if got := <-early; got != (protocol == "Noise_IK_25519_ChaChaPoly_BLAKE2b") {
+			t.Fatalf("unexpected early data %v with %s", got, protocol)
+		}
+		if cached, ok := cache.Get(address); !ok || !bytes.Equal(cached, key.Public) {
+			t.Fatalf("unexpected cached key %x", cached)
+		}
+		return nil
+	}
+
+	// the first connection learns the key, and the next one uses it.
+	if err := dial("Noise_XX_25519_ChaChaPoly_BLAKE2b"); err != nil {
+		panic(err)
+	}
+	if err := dial("Noise_IK_25519_ChaChaPoly_BLAKE2b"); err != nil {
+		panic(err)
+	}
+
+	// a stale key fails the resumed handshake and is forgotten, so the
+	// next connection falls back to the full handshake.
+	stale, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	cache.Put(address, stale.Public)
+	if err := dial("Noise_IK_25519_ChaChaPoly_BLAKE2b"); err == nil {
+		t.Fatal("expected the handshake with the stale key to fail")
+	}
+	// the responder read the first message with the fallback pattern.
+	<-early
+	if _, ok := cache.Get(address); ok {
+		t.Fatal("expected the stale key to be forgotten")
+	}
+	if err := dial("Noise_XX_25519_ChaChaPoly_BLAKE2b"); err != nil {
+		panic(err)
+	}
+}
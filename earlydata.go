@@ -0,0 +1,70 @@
+package noiseconn
+
+import (
+	"sync/atomic"
+
+	"github.com/zeebo/errs"
+)
+
+// earlyData tracks the plaintext of the first handshake message received
+// by a responder, which is 0-RTT data: unlike later payloads, it can be
+// replayed by an attacker to a responder that doesn't use MaxTimestampAge
+// and a ReplayCache.
+type earlyData struct {
+	// buffered is how many bytes at the front of c.readBuf, or messages
+	// at the front of c.readMsgs, are early data.
+	buffered int
+	read     uint32
+}
+
+// bufferEarlyData notes that the payload of a handshake message was
+// buffered, if it is early data. c.hsMu must be held.
+func (c *Conn) bufferEarlyData(payload []byte) {
+	if c.initiator || len(c.hsMessages) != 1 || len(payload) == 0 {
+		return
+	}
+	if c.msgMode {
+		c.earlyData.buffered = 1
+	} else {
+		c.earlyData.buffered = len(payload)
+	}
+}
+
+// consumeEarlyData notes that n bytes, or messages, were consumed from the
+// front of the buffered plaintext.
+func (c *Conn) consumeEarlyData(n int) {
+	if c.earlyData.buffered == 0 || n == 0 {
+		return
+	}
+	c.earlyData.buffered -= min(n, c.earlyData.buffered)
+	atomic.StoreUint32(&c.earlyData.read, 1)
+}
+
+// EarlyDataRead reports whether Read or MessageConn.ReadMsg returned 0-RTT
+// data, that is, plaintext of the first handshake message received by a
+// responder, such as with IK. Such data can be replayed by an attacker, so
+// replay-sensitive requests shouldn't be handled before the handshake
+// completed, unless replays are rejected with MaxTimestampAge and a
+// ReplayCache.
+func (c *Conn) EarlyDataRead() bool {
+	return atomic.LoadUint32(&c.earlyData.read) != 0
+}
+
+// capHandshakePayload fails the handshake if buffering payload would make
+// the unread handshake payloads exceed Options.MaxHandshakePayload.
+// buffered is how much is buffered without payload. Failures are
+// permanent. c.hsMu must be held.
+func (c *Conn) capHandshakePayload(buffered int, payload []byte) error {
+	if c.maxHSPayload <= 0 {
+		return nil
+	}
+	for _, msg := range c.readMsgs {
+		buffered += len(msg)
+	}
+	if buffered+len(payload) > c.maxHSPayload {
+		c.hsErr = errs.New("unread handshake payloads exceed %d bytes", c.maxHSPayload)
+		c.finishTranscript(c.hsErr)
+		return c.hsErr
+	}
+	return nil
+}
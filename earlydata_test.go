@@ -0,0 +1,102 @@
+package noiseconn
+
+import (
+	"crypto/rand"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestEarlyDataRead(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	clientKey, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	serverKey, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	for _, handshakeFirst := range []bool{false, true} {
+		p1, p2 := net.Pipe()
+		client, err := NewConn(p1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeIK, Initiator: true,
+			StaticKeypair: clientKey, PeerStatic: serverKey.Public})
+		if err != nil {
+			panic(err)
+		}
+		server, err := NewConn(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeIK, StaticKeypair: serverKey})
+		if err != nil {
+			panic(err)
+		}
+		if handshakeFirst {
+			var eg errgroup.Group
+			eg.Go(client.Handshake)
+			eg.Go(server.Handshake)
+			if err := eg.Wait(); err != nil {
+				panic(err)
+			}
+		}
+
+		var eg errgroup.Group
+		eg.Go(func() error {
+			_, err := client.Write([]byte("hello"))
+			return err
+		})
+		b := make([]byte, 2)
+		if _, err := server.Read(b); err != nil {
+			panic(err)
+		}
+		if server.EarlyDataRead() == handshakeFirst || server.ConnectionState().EarlyDataRead == handshakeFirst {
+			t.Fatalf("handshake first %v: unexpected early data flag", handshakeFirst)
+		}
+		if err := eg.Wait(); err != nil {
+			panic(err)
+		}
+		if client.EarlyDataRead() {
+			t.Fatal("the initiator doesn't receive early data")
+		}
+		_ = client.Close()
+		_ = server.Close()
+	}
+}
+
+func TestMaxHandshakePayload(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	serverKey, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	for _, max := range []int{1000, 4096} {
+		p1, p2 := net.Pipe()
+		client, err := NewConn(p1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNK, Initiator: true,
+			PeerStatic: serverKey.Public})
+		if err != nil {
+			panic(err)
+		}
+		server, err := NewConn(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNK, StaticKeypair: serverKey},
+			WithMaxHandshakePayload(max))
+		if err != nil {
+			panic(err)
+		}
+
+		var eg errgroup.Group
+		eg.Go(func() error {
+			_, err := client.Write(make([]byte, 2000))
+			return err
+		})
+		_, err = server.Read(make([]byte, 1))
+		if (max == 1000) != (err != nil) {
+			t.Fatalf("max %d: unexpected error %v", max, err)
+		}
+		if err != nil && server.Handshake() == nil {
+			t.Fatal("expected the handshake to fail permanently")
+		}
+		if err := eg.Wait(); err != nil {
+			panic(err)
+		}
+		_ = client.Close()
+		_ = server.Close()
+	}
+}
@@ -0,0 +1,85 @@
+package noiseconn
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+// earlyTokenLen is the length of the tokens of MemoryEarlyDataTokenStore.
+const earlyTokenLen = 16
+
+// EarlyDataTokenStore issues single-use tokens that allow initiators to
+// send 0-RTT data, for Options.EarlyDataTokens. It should be shared by all
+// the connections of a responder.
+type EarlyDataTokenStore interface {
+	// Issue returns a new token.
+	Issue() ([]byte, error)
+	// Redeem reports whether token was issued and not redeemed yet, and
+	// makes sure it isn't accepted again.
+	Redeem(token []byte) bool
+}
+
+// MemoryEarlyDataTokenStore is an in-memory EarlyDataTokenStore, whose
+// tokens expire after a TTL.
+type MemoryEarlyDataTokenStore struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	issued  map[string]time.Time
+	nextGC  time.Time
+	timeNow func() time.Time
+}
+
+// NewMemoryEarlyDataTokenStore returns a MemoryEarlyDataTokenStore whose
+// tokens expire after ttl.
+func NewMemoryEarlyDataTokenStore(ttl time.Duration) *MemoryEarlyDataTokenStore {
+	return &MemoryEarlyDataTokenStore{ttl: ttl, issued: make(map[string]time.Time), timeNow: time.Now}
+}
+
+// Issue implements EarlyDataTokenStore.
+func (s *MemoryEarlyDataTokenStore) Issue() ([]byte, error) {
+	token := make([]byte, earlyTokenLen)
+	if _, err := rand.Read(token); err != nil {
+		return nil, errs.Wrap(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.timeNow()
+	if now.After(s.nextGC) {
+		for k, exp := range s.issued {
+			if now.After(exp) {
+				delete(s.issued, k)
+			}
+		}
+		s.nextGC = now.Add(time.Minute)
+	}
+	s.issued[string(token)] = now.Add(s.ttl)
+	return token, nil
+}
+
+// Redeem implements EarlyDataTokenStore.
+func (s *MemoryEarlyDataTokenStore) Redeem(token []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exp, ok := s.issued[string(token)]
+	if !ok {
+		return false
+	}
+	delete(s.issued, string(token))
+	return !s.timeNow().After(exp)
+}
+
+// checkEarlyToken checks the early data token of a first handshake message
+// that carries data. A nil value means the initiator didn't send one.
+func (c *Conn) checkEarlyToken(value []byte) error {
+	if value == nil {
+		return fmt.Errorf("%w: early data without a token", ErrReplayedHandshake)
+	}
+	if !c.earlyTokens.Redeem(value) {
+		return fmt.Errorf("%w: early data token is unknown or was already used", ErrReplayedHandshake)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package noiseconn
+
+import (
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestEarlyDataTokens(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	clientKey, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	serverKey, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	store := NewMemoryEarlyDataTokenStore(time.Minute)
+	tokens := make(chan []byte, 1)
+
+	// connect writes hello from the client, as 0-RTT data unless
+	// handshakeFirst, and returns the error of the server.
+	connect := func(token []byte, handshakeFirst bool) error {
+		// unlike net.Pipe, TCP buffers the token sent after the handshake.
+		p1, p2 := tcpPair()
+		client, err := NewConn(p1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeIK, Initiator: true,
+			StaticKeypair: clientKey, PeerStatic: serverKey.Public},
+			WithEarlyDataToken(token), WithOnEarlyDataToken(func(token []byte) { tokens <- token }))
+		if err != nil {
+			panic(err)
+		}
+		defer func() { _ = client.Close() }()
+		server, err := NewConn(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeIK, StaticKeypair: serverKey},
+			WithEarlyDataTokens(store))
+		if err != nil {
+			panic(err)
+		}
+		defer func() { _ = server.Close() }()
+
+		var eg errgroup.Group
+		eg.Go(func() error {
+			if handshakeFirst {
+				if err := client.Handshake(); err != nil {
+					return err
+				}
+			}
+			if _, err := client.Write([]byte("hello")); err != nil {
+				return err
+			}
+			_, err := client.Read(make([]byte, 2))
+			return err
+		})
+		b := make([]byte, 5)
+		if _, err := server.Read(b); err != nil {
+			_ = server.Close()
+			_ = client.Close()
+			_ = eg.Wait()
+			return err
+		}
+		// the token follows the last handshake message, ahead of the reply.
+		if err := server.Handshake(); err != nil {
+			return err
+		}
+		if _, err := server.Write([]byte("ok")); err != nil {
+			return err
+		}
+		return eg.Wait()
+	}
+
+	if err := connect(nil, false); !errors.Is(err, ErrReplayedHandshake) {
+		t.Fatal("expected early data without a token to be rejected, got", err)
+	}
+	if err := connect(nil, true); err != nil {
+		t.Fatal(err)
+	}
+	token := <-tokens
+	if err := connect(token, false); err != nil {
+		t.Fatal(err)
+	}
+	<-tokens
+	if err := connect(token, false); !errors.Is(err, ErrReplayedHandshake) {
+		t.Fatal("expected a reused token to be rejected, got", err)
+	}
+}
+
+func tcpPair() (net.Conn, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = l.Close() }()
+	c1, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+	c2, err := l.Accept()
+	if err != nil {
+		panic(err)
+	}
+	return c1, c2
+}
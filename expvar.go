@@ -0,0 +1,72 @@
+package noiseconn
+
+import (
+	"expvar"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ExpvarName is the name under which PublishExpvar publishes the counters.
+const ExpvarName = "noiseconn"
+
+var expvarStats struct {
+	once      sync.Once
+	published uint32
+	vars      *expvar.Map
+
+	handshakes, failures, bytesSent, bytesReceived, active expvar.Int
+
+	established sync.Map
+	hooks       Hooks
+}
+
+// PublishExpvar publishes package-level counters of the connections
+// created afterwards as the expvar map "noiseconn", so they are served by
+// the /debug/vars handler of the expvar package. The counters are
+// "handshakes" and "handshake_failures", "bytes_sent" and "bytes_received"
+// of the Noise messages, and "active_conns" with a completed handshake that
+// weren't closed yet. It may be called more than once.
+func PublishExpvar() {
+	s := &expvarStats
+	s.once.Do(func() {
+		s.vars = new(expvar.Map)
+		s.vars.Set("handshakes", &s.handshakes)
+		s.vars.Set("handshake_failures", &s.failures)
+		s.vars.Set("bytes_sent", &s.bytesSent)
+		s.vars.Set("bytes_received", &s.bytesReceived)
+		s.vars.Set("active_conns", &s.active)
+		expvar.Publish(ExpvarName, s.vars)
+
+		s.hooks = Hooks{
+			HandshakeDone: func(c *Conn, _ time.Duration, _ []byte, err error) {
+				if err != nil {
+					s.failures.Add(1)
+					return
+				}
+				s.handshakes.Add(1)
+				s.established.Store(c, struct{}{})
+				s.active.Add(1)
+			},
+			FrameSent:     func(_ *Conn, size int) { s.bytesSent.Add(int64(size)) },
+			FrameReceived: func(_ *Conn, size int) { s.bytesReceived.Add(int64(size)) },
+			Closed: func(c *Conn) {
+				if _, ok := s.established.LoadAndDelete(c); ok {
+					s.active.Add(-1)
+				}
+			},
+		}
+		atomic.StoreUint32(&s.published, 1)
+	})
+}
+
+// withExpvarHooks adds the hooks of PublishExpvar to hooks, if published.
+func withExpvarHooks(hooks *Hooks) *Hooks {
+	if atomic.LoadUint32(&expvarStats.published) == 0 {
+		return hooks
+	}
+	if hooks == nil {
+		return &expvarStats.hooks
+	}
+	return CombineHooks(hooks, &expvarStats.hooks)
+}
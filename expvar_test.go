@@ -0,0 +1,63 @@
+package noiseconn
+
+import (
+	"expvar"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestPublishExpvar(t *testing.T) {
+	PublishExpvar()
+	PublishExpvar()
+	vars := expvar.Get(ExpvarName).(*expvar.Map)
+	get := func(name string) int64 { return vars.Get(name).(*expvar.Int).Value() }
+	handshakes, active, sent := get("handshakes"), get("active_conns"), get("bytes_sent")
+
+	p1, p2 := net.Pipe()
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	client, err := NewConn(p1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true})
+	if err != nil {
+		panic(err)
+	}
+	server, err := NewConn(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN})
+	if err != nil {
+		panic(err)
+	}
+	var eg errgroup.Group
+	eg.Go(func() error {
+		if _, err := client.Write([]byte("hello")); err != nil {
+			return err
+		}
+		_, err := io.ReadFull(client, make([]byte, 5))
+		return err
+	})
+	eg.Go(func() error {
+		if _, err := io.ReadFull(server, make([]byte, 5)); err != nil {
+			return err
+		}
+		_, err := server.Write([]byte("world"))
+		return err
+	})
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+
+	if got := get("handshakes") - handshakes; got != 2 {
+		t.Fatalf("expected 2 handshakes, got %d", got)
+	}
+	if got := get("active_conns") - active; got != 2 {
+		t.Fatalf("expected 2 active connections, got %d", got)
+	}
+	if get("bytes_sent") == sent {
+		t.Fatal("expected bytes to be counted")
+	}
+	_ = client.Close()
+	_ = server.Close()
+	if got := get("active_conns") - active; got != 0 {
+		t.Fatalf("expected no active connections, got %d", got)
+	}
+}
@@ -0,0 +1,261 @@
+package noiseconn
+
+import (
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+// When handshake extensions are enabled, every handshake payload starts
+// with an extension block: a uint16 length, followed by that many bytes of
+// extensions, each a type byte and a uint16 length-prefixed value.
+// Extensions of unknown types are ignored. Types from 128 on are left to
+// Options.Extensions.
+const (
+	extIdentity   = 1
+	extControl    = 2
+	extKEM        = 3
+	extTimestamp  = 4
+	extEarlyToken = 5
+	extMaxFrame   = 6
+	extKeepalive  = 7
+)
+
+// minExtensionType is the smallest type of an Extension.
+const minExtensionType = 128
+
+// Extension is an application-defined handshake extension, registered with
+// Options.Extensions, to negotiate features such as padding or compression
+// in the handshake payloads instead of with extra round trips. Values are
+// carried in the extension block of the handshake payloads, so they are as
+// confidential as the payload of their handshake message, and the
+// handshake only completes if they were received unmodified. Handlers are
+// called by the Conn while it performs the handshake, so they must not call
+// its methods.
+type Extension struct {
+	// Type identifies the extension. It must be at least 128, as smaller
+	// types are reserved for this package.
+	Type byte
+
+	// Send, if set, returns the value to send in the handshake message
+	// with the given index, counting messages of both peers from 0, and
+	// whether to send one. It may be called more than once per message,
+	// and must return the same value each time.
+	Send func(message int) (value []byte, ok bool)
+
+	// Receive, if set, is called with the value received from the peer in
+	// the handshake message with the given index. An error fails the
+	// handshake.
+	Receive func(message int, value []byte) error
+}
+
+type extension struct {
+	typ   byte
+	value []byte
+}
+
+// appendExtensions appends the extension block of exts. The values and the
+// block have 16-bit lengths, so larger ones are an error.
+func appendExtensions(b []byte, exts []extension) ([]byte, error) {
+	size := 0
+	for _, ext := range exts {
+		if len(ext.value) > 0xffff {
+			return nil, errs.New("handshake extension %d too large: %d bytes", ext.typ, len(ext.value))
+		}
+		size += 3 + len(ext.value)
+	}
+	if size > 0xffff {
+		return nil, errs.New("handshake extensions too large: %d bytes", size)
+	}
+	b = append(b, byte(size>>8), byte(size))
+	for _, ext := range exts {
+		b = appendUint16Bytes(append(b, ext.typ), ext.value)
+	}
+	return b, nil
+}
+
+func cutExtensions(payload []byte) (exts []extension, rest []byte, err error) {
+	block, rest, ok := cutUint16Bytes(payload)
+	if !ok {
+		return nil, nil, errs.New("truncated handshake extensions")
+	}
+	for len(block) > 0 {
+		typ := block[0]
+		var value []byte
+		if value, block, ok = cutUint16Bytes(block[1:]); !ok {
+			return nil, nil, errs.New("truncated handshake extension")
+		}
+		exts = append(exts, extension{typ: typ, value: value})
+	}
+	return exts, rest, nil
+}
+
+// hsExtensions returns the extensions to send in the next handshake
+// message. c.hsMu must be held.
+func (c *Conn) hsExtensions() []extension {
+	var exts []extension
+	if c.supportsControl() && c.hs.MessageIndex() < 2 {
+		// the first message written by either side.
+		exts = append(exts, extension{typ: extControl})
+	}
+	switch {
+	case c.kemKey != nil && c.hs.MessageIndex() == 0:
+		exts = append(exts, extension{typ: extKEM, value: c.kemKey})
+	case c.kemCiphertext != nil && c.hs.MessageIndex() == 1:
+		exts = append(exts, extension{typ: extKEM, value: c.kemCiphertext})
+	}
+	if c.timestamp != nil && c.hs.MessageIndex() == 0 {
+		exts = append(exts, extension{typ: extTimestamp, value: setTimestamp(c.timestamp, time.Now())})
+	}
+	if c.earlyToken != nil && c.hs.MessageIndex() == 0 {
+		exts = append(exts, extension{typ: extEarlyToken, value: c.earlyToken})
+	}
+	if ext, ok := c.maxFrameExtension(); ok {
+		exts = append(exts, ext)
+	}
+	if ext, ok := c.keepaliveExtension(); ok {
+		exts = append(exts, ext)
+	}
+	if c.identity != nil && c.hs.MessageIndex() == c.identityMsg {
+		exts = append(exts, extension{typ: extIdentity, value: c.identity})
+	}
+	for _, ext := range c.appExtensions {
+		if ext.Send == nil {
+			continue
+		}
+		if value, ok := ext.Send(c.hs.MessageIndex()); ok {
+			exts = append(exts, extension{typ: ext.Type, value: value})
+		}
+	}
+	return exts
+}
+
+// hsPayloadLimit returns how much data fits in the payload of the next
+// handshake message. If the extension block can't be encoded, it returns
+// zero, and hsPayload the error. c.hsMu must be held.
+func (c *Conn) hsPayloadLimit() int {
+	if !c.extensions {
+		return c.maxPayload(true)
+	}
+	block, err := appendExtensions(nil, c.hsExtensions())
+	if err != nil {
+		return 0
+	}
+	return c.maxPayload(true) - len(block)
+}
+
+// hsPayload returns the handshake payload for data, including the
+// extension block if enabled. c.hsMu must be held.
+func (c *Conn) hsPayload(data []byte) ([]byte, error) {
+	if !c.extensions {
+		return data, nil
+	}
+	block, err := appendExtensions(c.extBuf[:0], c.hsExtensions())
+	if err != nil {
+		return nil, err
+	}
+	c.extBuf = append(block, data...)
+	return c.extBuf, nil
+}
+
+// readExtensions processes the extension block at the start of a received
+// handshake payload and returns the rest of the payload. Failures are
+// permanent. c.hsMu must be held.
+func (c *Conn) readExtensions(payload []byte) ([]byte, error) {
+	exts, rest, err := cutExtensions(payload)
+	if err != nil {
+		return nil, c.failExtensions(err)
+	}
+	var timestamp, earlyToken []byte
+	for _, ext := range exts {
+		switch ext.typ {
+		case extIdentity:
+			err = c.readIdentity(ext.value)
+		case extControl:
+			c.peerControl = true
+		case extKEM:
+			err = c.readKEM(ext.value)
+		case extTimestamp:
+			timestamp = append([]byte{}, ext.value...)
+		case extEarlyToken:
+			earlyToken = append([]byte{}, ext.value...)
+		case extMaxFrame:
+			err = c.readMaxFrameSize(ext.value)
+		case extKeepalive:
+			err = c.readKeepalive(ext.value)
+		default:
+			err = c.readAppExtension(ext)
+		}
+		if err != nil {
+			return nil, c.failExtensions(err)
+		}
+	}
+	if c.maxTimestampAge > 0 && c.hs.MessageIndex() == 1 {
+		if err := c.checkTimestamp(timestamp); err != nil {
+			return nil, c.failExtensions(err)
+		}
+	}
+	if c.earlyTokens != nil && c.hs.MessageIndex() == 1 && len(rest) > 0 {
+		if err := c.checkEarlyToken(earlyToken); err != nil {
+			return nil, c.failExtensions(err)
+		}
+	}
+	if err := c.checkKEM(); err != nil {
+		return nil, c.failExtensions(err)
+	}
+	// the message that was just read.
+	index := c.hs.MessageIndex() - 1
+	if len(c.identityRoots) > 0 && index >= c.peerIdentityMsg && c.peerIdentity == nil {
+		return nil, c.failExtensions(errs.New("peer did not present an identity"))
+	}
+	return rest, nil
+}
+
+func (c *Conn) failExtensions(err error) error {
+	c.hsErr = errs.Wrap(err)
+	c.finishTranscript(c.hsErr)
+	return c.hsErr
+}
+
+func (c *Conn) readIdentity(value []byte) error {
+	if len(c.identityRoots) == 0 {
+		return nil
+	}
+	chain, err := parseChain(value)
+	if err != nil {
+		return err
+	}
+	c.peerIdentity, err = VerifyCertificateChain(chain, c.hs.PeerStatic(), c.identityRoots, time.Now())
+	return err
+}
+
+// readAppExtension passes a received extension to the handler registered
+// for its type, if any. c.hsMu must be held.
+func (c *Conn) readAppExtension(ext extension) error {
+	if ext.typ < minExtensionType {
+		return nil
+	}
+	for _, registered := range c.appExtensions {
+		if registered.Type != ext.typ {
+			continue
+		}
+		if c.peerExtensions == nil {
+			c.peerExtensions = make(map[byte]bool)
+		}
+		c.peerExtensions[ext.typ] = true
+		if registered.Receive == nil {
+			return nil
+		}
+		// the message that was just read.
+		return registered.Receive(c.hs.MessageIndex()-1, ext.value)
+	}
+	return nil
+}
+
+// PeerExtension reports whether the peer sent the Extension of type typ,
+// registered with Options.Extensions, in a handshake message so far.
+func (c *Conn) PeerExtension(typ byte) bool {
+	c.hsMu.Lock()
+	defer c.hsMu.Unlock()
+	return c.peerExtensions[typ]
+}
@@ -0,0 +1,133 @@
+package noiseconn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestExtensions(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	clientKey, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	serverKey, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	// handshake returns the error of the server, if any, or of the client.
+	handshake := func(clientOpts, serverOpts Options) (client, server *Conn, err error) {
+		p1, p2 := net.Pipe()
+		client, err = NewConnWithOptions(p1, noise.Config{
+			CipherSuite: cs, Pattern: noise.HandshakeXX, Initiator: true, StaticKeypair: clientKey,
+		}, clientOpts)
+		if err != nil {
+			panic(err)
+		}
+		server, err = NewConnWithOptions(p2, noise.Config{
+			CipherSuite: cs, Pattern: noise.HandshakeXX, StaticKeypair: serverKey,
+		}, serverOpts)
+		if err != nil {
+			panic(err)
+		}
+		var eg errgroup.Group
+		eg.Go(func() error {
+			err := client.Handshake()
+			_ = client.Close()
+			return err
+		})
+		err = server.Handshake()
+		_ = server.Close()
+		if clientErr := eg.Wait(); err == nil {
+			err = clientErr
+		}
+		return client, server, err
+	}
+
+	// an extension sent by the initiator in the first message, and
+	// answered by the responder in the second.
+	type received struct {
+		message int
+		value   []byte
+	}
+	extension := func(value string, got *[]received) Extension {
+		return Extension{
+			Type: 200,
+			Send: func(message int) ([]byte, bool) {
+				return []byte(value), message < 2
+			},
+			Receive: func(message int, value []byte) error {
+				*got = append(*got, received{message, append([]byte(nil), value...)})
+				return nil
+			},
+		}
+	}
+
+	var clientGot, serverGot []received
+	client, server, err := handshake(
+		Options{Extensions: []Extension{extension("client", &clientGot)}},
+		Options{Extensions: []Extension{extension("server", &serverGot)}})
+	if err != nil {
+		panic(err)
+	}
+	if !client.PeerExtension(200) || !server.PeerExtension(200) || client.PeerExtension(201) {
+		t.Fatal("unexpected negotiated extensions")
+	}
+	if len(serverGot) != 1 || serverGot[0].message != 0 || !bytes.Equal(serverGot[0].value, []byte("client")) {
+		t.Fatalf("unexpected values received by the server: %v", serverGot)
+	}
+	if len(clientGot) != 1 || clientGot[0].message != 1 || !bytes.Equal(clientGot[0].value, []byte("server")) {
+		t.Fatalf("unexpected values received by the client: %v", clientGot)
+	}
+
+	// peers without the extension ignore it.
+	clientGot = nil
+	client, _, err = handshake(
+		Options{Extensions: []Extension{extension("client", &clientGot)}},
+		Options{HandshakeExtensions: true})
+	if err != nil {
+		panic(err)
+	}
+	if client.PeerExtension(200) || len(clientGot) != 0 {
+		t.Fatal("unexpected negotiated extension")
+	}
+
+	// errors of Receive fail the handshake.
+	rejected := errors.New("rejected")
+	_, _, err = handshake(
+		Options{Extensions: []Extension{extension("client", &clientGot)}},
+		Options{Extensions: []Extension{{Type: 200, Receive: func(int, []byte) error { return rejected }}}})
+	if !errors.Is(err, rejected) {
+		t.Fatalf("expected the handshake to be rejected, got %v", err)
+	}
+}
+
+func TestAppendExtensionsLimits(t *testing.T) {
+	// a single extension with the largest value that fits in the block.
+	exts := []extension{{typ: 1, value: make([]byte, 0xffff-3)}}
+	block, err := appendExtensions(nil, exts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, rest, err := cutExtensions(block)
+	if err != nil || len(rest) != 0 || len(got) != 1 || len(got[0].value) != 0xffff-3 {
+		t.Fatalf("unexpected round trip: %d extensions, %d left: %v", len(got), len(rest), err)
+	}
+
+	for _, exts := range [][]extension{
+		{{typ: 1, value: make([]byte, 0xffff-2)}},
+		{{typ: 1, value: make([]byte, 0x10000)}},
+		{{typ: 1, value: make([]byte, 0x8000)}, {typ: 2, value: make([]byte, 0x8000)}},
+	} {
+		if _, err := appendExtensions(nil, exts); err == nil {
+			t.Fatal("expected oversized extensions to fail")
+		}
+	}
+}
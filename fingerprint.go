@@ -0,0 +1,148 @@
+package noiseconn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+	"strings"
+
+	"github.com/zeebo/errs"
+)
+
+const fingerprintPrefix = "SHA256:"
+
+// Fingerprint returns a short, human-comparable identifier of a static
+// public key: the unpadded base64 SHA-256 of the key, prefixed with
+// "SHA256:".
+func Fingerprint(public []byte) string {
+	sum := sha256.Sum256(public)
+	return fingerprintPrefix + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// FingerprintHex is like Fingerprint, but with the SHA-256 in lowercase
+// hex, for tools that can't handle base64.
+func FingerprintHex(public []byte) string {
+	sum := sha256.Sum256(public)
+	return fingerprintPrefix + hex.EncodeToString(sum[:])
+}
+
+// ParseFingerprint parses a fingerprint as returned by Fingerprint or
+// FingerprintHex and returns the SHA-256 of the key. The "SHA256:" prefix
+// is optional, base64 may be padded, and hex may be in either case and
+// separated by colons.
+func ParseFingerprint(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if len(s) >= len(fingerprintPrefix) && strings.EqualFold(s[:len(fingerprintPrefix)], fingerprintPrefix) {
+		s = s[len(fingerprintPrefix):]
+	}
+	if sum, err := hex.DecodeString(strings.ReplaceAll(s, ":", "")); err == nil && len(sum) == sha256.Size {
+		return sum, nil
+	}
+	if sum, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "=")); err == nil && len(sum) == sha256.Size {
+		return sum, nil
+	}
+	return nil, errs.New("invalid fingerprint %q", s)
+}
+
+// MatchFingerprint returns whether public has the fingerprint, in any
+// format accepted by ParseFingerprint.
+func MatchFingerprint(public []byte, fingerprint string) bool {
+	want, err := ParseFingerprint(fingerprint)
+	if err != nil {
+		return false
+	}
+	sum := sha256.Sum256(public)
+	return bytes.Equal(sum[:], want)
+}
+
+// PinFingerprints is like PinPeers, but accepts the peers by the
+// fingerprints of their static public keys. Since the keys themselves
+// aren't known, it can't be used to provide noise.Config.PeerStatic for
+// patterns that need it.
+func PinFingerprints(fingerprints ...string) (PeerVerifier, error) {
+	sums := make([][]byte, 0, len(fingerprints))
+	for _, fingerprint := range fingerprints {
+		sum, err := ParseFingerprint(fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		sums = append(sums, sum)
+	}
+	return func(addr net.Addr, peerStatic []byte) error {
+		sum := sha256.Sum256(peerStatic)
+		for _, want := range sums {
+			if bytes.Equal(sum[:], want) {
+				return nil
+			}
+		}
+		return errs.New("unexpected peer static key %s for %v", Fingerprint(peerStatic), addr)
+	}, nil
+}
+
+// Randomart returns the OpenSSH-style "drunken bishop" visualization of
+// the SHA-256 fingerprint of public, which makes it easier to notice when
+// a key changes. The result has multiple lines, without a trailing
+// newline.
+func Randomart(public []byte) string {
+	const (
+		width, height = 17, 9
+		symbols       = " .o+=*BOX@%&#/^SE"
+		start, end    = len(symbols) - 2, len(symbols) - 1
+	)
+	sum := sha256.Sum256(public)
+
+	var field [width][height]int
+	x, y := width/2, height/2
+	for _, b := range sum {
+		for i := 0; i < 4; i++ {
+			if b&1 != 0 {
+				x++
+			} else {
+				x--
+			}
+			if b&2 != 0 {
+				y++
+			} else {
+				y--
+			}
+			x, y = clamp(x, 0, width-1), clamp(y, 0, height-1)
+			if field[x][y] < start-1 {
+				field[x][y]++
+			}
+			b >>= 2
+		}
+	}
+	field[width/2][height/2] = start
+	field[x][y] = end
+
+	var out strings.Builder
+	out.WriteString(randomartBorder("[25519 256]", width))
+	for row := 0; row < height; row++ {
+		out.WriteString("\n|")
+		for col := 0; col < width; col++ {
+			out.WriteByte(symbols[field[col][row]])
+		}
+		out.WriteString("|")
+	}
+	out.WriteString("\n")
+	out.WriteString(randomartBorder("[SHA256]", width))
+	return out.String()
+}
+
+// randomartBorder returns a border line with label centered in it.
+func randomartBorder(label string, width int) string {
+	left := (width - len(label)) / 2
+	return "+" + strings.Repeat("-", left) + label + strings.Repeat("-", width-len(label)-left) + "+"
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
@@ -0,0 +1,80 @@
+package noiseconn
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	public := bytes.Repeat([]byte{1}, 32)
+	sum, err := ParseFingerprint(FingerprintHex(public))
+	if err != nil {
+		panic(err)
+	}
+
+	for _, fingerprint := range []string{
+		Fingerprint(public),
+		FingerprintHex(public),
+		strings.TrimPrefix(Fingerprint(public), "SHA256:") + "=",
+		strings.ToUpper(FingerprintHex(public)),
+		"SHA256:" + colons(strings.TrimPrefix(FingerprintHex(public), "SHA256:")),
+	} {
+		got, err := ParseFingerprint(fingerprint)
+		if err != nil {
+			panic(err)
+		}
+		if !bytes.Equal(got, sum) {
+			t.Fatalf("%q: digest mismatch", fingerprint)
+		}
+		if !MatchFingerprint(public, fingerprint) || MatchFingerprint(sum, fingerprint) {
+			t.Fatalf("%q: match mismatch", fingerprint)
+		}
+	}
+	for _, invalid := range []string{"", "SHA256:", "SHA256:abcd", Fingerprint(public)[:20]} {
+		if _, err := ParseFingerprint(invalid); err == nil {
+			t.Fatalf("%q: expected error", invalid)
+		}
+	}
+
+	verify, err := PinFingerprints(Fingerprint(public))
+	if err != nil {
+		panic(err)
+	}
+	if err := verify(nil, public); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(nil, sum); err == nil {
+		t.Fatal("expected error for an unpinned key")
+	}
+	if _, err := PinFingerprints("bogus"); err == nil {
+		t.Fatal("expected error for an invalid fingerprint")
+	}
+}
+
+func TestRandomart(t *testing.T) {
+	art := Randomart(bytes.Repeat([]byte{1}, 32))
+	lines := strings.Split(art, "\n")
+	if len(lines) != 11 {
+		t.Fatalf("got %d lines:\n%s", len(lines), art)
+	}
+	for _, line := range lines {
+		if len(line) != 19 {
+			t.Fatalf("got line of length %d:\n%s", len(line), art)
+		}
+	}
+	if strings.Count(art, "E") != 1 {
+		t.Fatalf("missing end:\n%s", art)
+	}
+	if art == Randomart(bytes.Repeat([]byte{2}, 32)) {
+		t.Fatal("different keys have the same randomart")
+	}
+}
+
+func colons(s string) string {
+	var parts []string
+	for ; len(s) > 0; s = s[2:] {
+		parts = append(parts, s[:2])
+	}
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,29 @@
+package noiseconn
+
+import (
+	"github.com/flynn/noise"
+)
+
+// fipsCiphers and fipsHashes are the primitives allowed by Options.FIPS,
+// by their Noise names.
+var (
+	fipsCiphers = map[string]bool{"AESGCM": true}
+	fipsHashes  = map[string]bool{"SHA256": true, "SHA512": true}
+)
+
+// checkFIPS returns a description of the first primitive of suite that
+// isn't allowed by Options.FIPS, or "" if there is none.
+func checkFIPS(suite noise.CipherSuite) string {
+	if name := suite.CipherName(); !fipsCiphers[name] {
+		return "cipher " + name
+	}
+	if name := suite.HashName(); !fipsHashes[name] {
+		return "hash " + name
+	}
+	return ""
+}
+
+// FIPS returns whether the Conn was created with Options.FIPS.
+func (c *Conn) FIPS() bool {
+	return c.fips
+}
@@ -0,0 +1,39 @@
+package noiseconn
+
+import (
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestConnFIPS(t *testing.T) {
+	p1, p2 := net.Pipe()
+	if _, err := NewConn(p1, ProtocolNN25519ChaChaPolyBLAKE2s(), WithFIPS()); err == nil {
+		t.Fatal("expected ChaChaPoly to be refused")
+	}
+
+	config := ProtocolNN25519AESGCMSHA256()
+	config.Initiator = true
+	client, err := NewConn(p1, config, WithFIPS())
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	server, err := NewConn(p2, noise.Config{CipherSuite: config.CipherSuite, Pattern: noise.HandshakeNN})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+
+	var eg errgroup.Group
+	eg.Go(client.Handshake)
+	eg.Go(server.Handshake)
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	if !client.FIPS() || !client.ConnectionState().FIPS || server.ConnectionState().FIPS {
+		t.Fatal("unexpected FIPS state")
+	}
+}
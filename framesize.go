@@ -0,0 +1,106 @@
+package noiseconn
+
+import (
+	"time"
+
+	"github.com/flynn/noise"
+)
+
+const (
+	// defaultMinFrameSize is the default Options.MinFrameSize.
+	defaultMinFrameSize = 4096
+
+	// slowFlush is how long writing frames to the underlying net.Conn may
+	// take before the frame size is reduced.
+	slowFlush = 10 * time.Millisecond
+)
+
+// frameSizer picks the payload size of outgoing transport frames for
+// Options.AdaptiveFrameSize. The size grows towards the power of two
+// covering the average write size, and is halved when frames are slow to
+// flush, as the peer then waits longer for every frame. It is protected by
+// c.writeMu.
+type frameSizer struct {
+	enabled bool
+	min     int
+	size    int
+	// avg is an exponentially weighted moving average of the write sizes.
+	avg int
+}
+
+func newFrameSizer(opts Options) frameSizer {
+	if !opts.AdaptiveFrameSize {
+		return frameSizer{}
+	}
+	min := opts.MinFrameSize
+	if min == 0 {
+		min = defaultMinFrameSize
+	}
+	return frameSizer{enabled: true, min: min, size: min, avg: min}
+}
+
+// frameSize returns the maximum payload size of the next transport frame.
+func (s *frameSizer) frameSize() int {
+	if !s.enabled {
+		return noise.MaxMsgLen
+	}
+	return s.size
+}
+
+// observe adjusts the frame size after a write of n bytes, whose frames
+// took flush to write.
+func (s *frameSizer) observe(n, frames int, flush time.Duration) {
+	if !s.enabled || frames == 0 {
+		return
+	}
+	s.avg += (min(n, noise.MaxMsgLen) - s.avg) / 8
+	target := s.min
+	for target < s.avg && target < noise.MaxMsgLen {
+		target *= 2
+	}
+	target = min(target, noise.MaxMsgLen)
+
+	switch {
+	case flush/time.Duration(frames) > slowFlush:
+		s.size = max(s.min, s.size/2)
+	case s.size < target:
+		s.size = min(s.size*2, target)
+	default:
+		s.size = target
+	}
+}
+
+// flushFrames writes buf like writeFrames, adding how long it took to
+// flush. c.writeMu must be held.
+func (c *Conn) flushFrames(buf []byte, flush *time.Duration) error {
+	if !c.frameSizer.enabled {
+		return c.writeFrames(buf)
+	}
+	start := time.Now()
+	var waited int64
+	if c.pacer != nil {
+		waited = c.pacer.waited.Load()
+	}
+	err := c.writeFrames(buf)
+	*flush += time.Since(start)
+	if c.pacer != nil {
+		// pacing doesn't mean the peer is slow.
+		*flush -= time.Duration(c.pacer.waited.Load() - waited)
+	}
+	return err
+}
+
+// FrameSize returns the maximum payload size of the transport frames that
+// Write currently sends, which only changes with Options.AdaptiveFrameSize.
+func (c *Conn) FrameSize() int {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.frameSizer.frameSize()
+}
+
+func max(a, b int) int {
+	if a >= b {
+		return a
+	}
+	return b
+}
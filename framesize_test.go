@@ -0,0 +1,96 @@
+package noiseconn
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestFrameSizer(t *testing.T) {
+	s := newFrameSizer(Options{AdaptiveFrameSize: true})
+	if s.frameSize() != defaultMinFrameSize {
+		t.Fatalf("unexpected initial frame size %d", s.frameSize())
+	}
+	for i := 0; i < 100; i++ {
+		s.observe(1<<20, 16, time.Millisecond)
+	}
+	if s.frameSize() != noise.MaxMsgLen {
+		t.Fatalf("bulk writes should use the largest frames, got %d", s.frameSize())
+	}
+	s.observe(1<<20, 16, time.Second)
+	if s.frameSize() != noise.MaxMsgLen/2 {
+		t.Fatalf("slow flushes should halve the frame size, got %d", s.frameSize())
+	}
+	for i := 0; i < 100; i++ {
+		s.observe(100, 1, 0)
+	}
+	if s.frameSize() != defaultMinFrameSize {
+		t.Fatalf("small writes should use the smallest frames, got %d", s.frameSize())
+	}
+
+	disabled := newFrameSizer(Options{})
+	disabled.observe(100, 1, time.Second)
+	if disabled.frameSize() != noise.MaxMsgLen {
+		t.Fatalf("unexpected frame size %d", disabled.frameSize())
+	}
+}
+
+func TestConnAdaptiveFrameSize(t *testing.T) {
+	p1, p2 := tcpPair()
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	client, err := NewConn(p1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true},
+		WithAdaptiveFrameSize(1024))
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	server, err := NewConn(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+
+	var eg errgroup.Group
+	eg.Go(client.Handshake)
+	eg.Go(server.Handshake)
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	if client.FrameSize() != 1024 || server.FrameSize() != noise.MaxMsgLen {
+		t.Fatalf("unexpected frame sizes %d %d", client.FrameSize(), server.FrameSize())
+	}
+
+	data := bytes.Repeat([]byte("bulk"), 64<<10)
+	eg.Go(func() error {
+		for i := 0; i < 20; i++ {
+			if _, err := client.Write(data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	received := make([]byte, len(data))
+	for i := 0; i < 20; i++ {
+		if _, err := io.ReadFull(server, received); err != nil {
+			panic(err)
+		}
+		if !bytes.Equal(received, data) {
+			t.Fatal("unexpected data")
+		}
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	if client.FrameSize() <= 1024 {
+		t.Fatalf("bulk writes should grow the frame size, got %d", client.FrameSize())
+	}
+
+	if _, err := NewConn(p1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true},
+		WithAdaptiveFrameSize(noise.MaxMsgLen+1)); err == nil {
+		t.Fatal("expected an error for an out of range MinFrameSize")
+	}
+}
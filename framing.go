@@ -0,0 +1,140 @@
+package noiseconn
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/flynn/noise"
+	"github.com/zeebo/errs"
+)
+
+// Framing selects how Noise messages are delimited on a stream, so a Conn
+// can interoperate with peers that don't use the format of this package.
+// Both peers must use the same Framing.
+type Framing int
+
+const (
+	// FramingDefault prefixes every Noise message with a 4 byte header:
+	// HeaderByte, or another header byte for control frames and the
+	// static key hint, followed by the 24 bit big-endian length of the
+	// message. It is the format of earlier releases of this package.
+	FramingDefault Framing = iota
+	// FramingLengthPrefixed prefixes every Noise message with its 16 bit
+	// big-endian length, which is the framing of the transport messages
+	// of NoiseSocket and of libp2p's Noise handshake. The negotiation data
+	// and payload padding of NoiseSocket are not supported. There are no
+	// header bytes to tell control frames apart from data, so the Conn
+	// behaves as over a MessageTransport: control frames aren't sent, and
+	// the static key hint is the first message.
+	FramingLengthPrefixed
+)
+
+// String returns the name of the Framing.
+func (f Framing) String() string {
+	switch f {
+	case FramingDefault:
+		return "default"
+	case FramingLengthPrefixed:
+		return "length-prefixed"
+	default:
+		return "unknown"
+	}
+}
+
+// maxPayload returns the largest payload of a transport message, or of a
+// handshake message, that fits the framing and the limit announced by the
+// peer with Options.MaxFrameSize. FramingDefault carries larger
+// messages than the Noise specification allows, so only the payload is
+// limited to noise.MaxMsgLen.
+func (c *Conn) maxPayload(handshake bool) int {
+	if !handshake && c.frameLimit.send > 0 {
+		return min(c.frameLimit.send, c.maxFramedPayload(handshake))
+	}
+	return c.maxFramedPayload(handshake)
+}
+
+func (c *Conn) maxFramedPayload(handshake bool) int {
+	if c.framing == FramingDefault {
+		return noise.MaxMsgLen
+	}
+	// an upper bound of the authentication tags and keys sent along with
+	// the payload.
+	overhead := 16
+	if handshake {
+		overhead += 2*c.cipherSuite.DHLen() + 16
+	}
+	return noise.MaxMsgLen - overhead
+}
+
+// lengthPrefixedConn implements FramingLengthPrefixed as a
+// MessageTransport over a stream.
+type lengthPrefixedConn struct {
+	net.Conn
+
+	// a message interrupted by an error, such as a read deadline, is
+	// resumed by the next call.
+	header  [2]byte
+	headerN int
+	body    []byte
+	bodyN   int
+
+	outMu sync.Mutex
+	out   []byte
+}
+
+// applyFraming wraps conn in the MessageTransport implementing framing, if
+// it isn't FramingDefault.
+func applyFraming(conn net.Conn, framing Framing) (net.Conn, MessageTransport, error) {
+	switch framing {
+	case FramingDefault:
+		return conn, nil, nil
+	case FramingLengthPrefixed:
+		lc := &lengthPrefixedConn{Conn: conn}
+		return lc, lc, nil
+	default:
+		return nil, nil, errs.New("unknown framing: %d", framing)
+	}
+}
+
+func (c *lengthPrefixedConn) ReadMessage() ([]byte, error) {
+	for c.headerN < len(c.header) {
+		n, err := c.Conn.Read(c.header[c.headerN:])
+		c.headerN += n
+		if err != nil && c.headerN < len(c.header) {
+			if errors.Is(err, io.EOF) && c.headerN > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	size := int(binary.BigEndian.Uint16(c.header[:]))
+	if cap(c.body) < size {
+		c.body = make([]byte, size)
+	}
+	c.body = c.body[:size]
+	n, err := io.ReadFull(c.Conn, c.body[c.bodyN:])
+	c.bodyN += n
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	c.headerN, c.bodyN = 0, 0
+	return c.body, nil
+}
+
+func (c *lengthPrefixedConn) WriteMessage(b []byte) error {
+	if len(b) > noise.MaxMsgLen {
+		return errs.New("message too large: %d", len(b))
+	}
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	c.out = binary.BigEndian.AppendUint16(c.out[:0], uint16(len(b)))
+	c.out = append(c.out, b...)
+	_, err := c.Conn.Write(c.out)
+	return err
+}
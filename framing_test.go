@@ -0,0 +1,115 @@
+package noiseconn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestFramingLengthPrefixed(t *testing.T) {
+	c1, c2 := tcpPair()
+	rec := &recordingConn{Conn: c1}
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+
+	client, err := NewConnWithOptions(rec, noise.Config{
+		CipherSuite: cs,
+		Pattern:     noise.HandshakeNN,
+		Initiator:   true,
+	}, Options{Framing: FramingLengthPrefixed})
+	if err != nil {
+		panic(err)
+	}
+	defer client.Close()
+	server, err := NewConnWithOptions(c2, noise.Config{
+		CipherSuite: cs,
+		Pattern:     noise.HandshakeNN,
+	}, Options{Framing: FramingLengthPrefixed})
+	if err != nil {
+		panic(err)
+	}
+	defer server.Close()
+
+	data := bytes.Repeat([]byte("framing"), 30000)
+	var eg errgroup.Group
+	eg.Go(func() error {
+		_, err := client.Write(data)
+		return err
+	})
+	eg.Go(func() error {
+		got := make([]byte, len(data))
+		if _, err := io.ReadFull(server, got); err != nil {
+			return err
+		}
+		if !bytes.Equal(got, data) {
+			panic("unexpected data")
+		}
+		_, err := server.Write([]byte("done"))
+		return err
+	})
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	var done [4]byte
+	if _, err := io.ReadFull(client, done[:]); err != nil {
+		panic(err)
+	}
+
+	// the client wrote nothing but 16 bit length-prefixed messages.
+	rec.mu.Lock()
+	written := rec.written
+	rec.mu.Unlock()
+	var messages int
+	for len(written) > 0 {
+		if len(written) < 2 {
+			t.Fatal("truncated length prefix")
+		}
+		size := int(binary.BigEndian.Uint16(written))
+		if len(written) < 2+size {
+			t.Fatalf("message %d is truncated", messages)
+		}
+		written = written[2+size:]
+		messages++
+	}
+	if messages < 4 {
+		t.Fatalf("expected at least 4 messages, got %d", messages)
+	}
+}
+
+func TestFramingMismatch(t *testing.T) {
+	c1, c2 := tcpPair()
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+
+	client, err := NewConnWithOptions(c1, noise.Config{
+		CipherSuite: cs,
+		Pattern:     noise.HandshakeNN,
+		Initiator:   true,
+	}, Options{Framing: FramingLengthPrefixed})
+	if err != nil {
+		panic(err)
+	}
+	defer client.Close()
+	server, err := NewConn(c2, noise.Config{
+		CipherSuite: cs,
+		Pattern:     noise.HandshakeNN,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer server.Close()
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		_ = client.Handshake()
+		return nil
+	})
+	if err := server.Handshake(); err == nil {
+		t.Fatal("expected the handshake to fail")
+	}
+	_ = server.Close()
+	_ = client.Close()
+	_ = eg.Wait()
+}
@@ -0,0 +1,80 @@
+package noiseconn
+
+import (
+	"net"
+	"time"
+
+	"github.com/flynn/noise"
+	"github.com/zeebo/errs"
+)
+
+// NewConnFromCipherStates returns a Conn in the transport phase, which
+// skips the handshake and encrypts with send and decrypts with recv, such
+// as cipher states derived by another protocol or by a handshake run
+// elsewhere. The Conn takes the cipher states over, so they must not be
+// used afterwards.
+//
+// config must have the cipher suite of the cipher states and the role of
+// this side. Its pattern, if any, only names the protocol, and PeerStatic,
+// if set, is reported as the static key of the peer. The handshake related
+// options have no effect, and since the capabilities of the peer are
+// unknown, no control frames are sent, so features such as Rekey aren't
+// available. ExportState only works once both cipher states were rekeyed,
+// as their keys are unknown until then.
+func NewConnFromCipherStates(conn net.Conn, config noise.Config, send, recv *noise.CipherState, opts ...Option) (*Conn, error) {
+	return NewConnFromCipherStatesWithOptions(conn, config, send, recv, applyOptions(opts))
+}
+
+// NewConnFromCipherStatesWithOptions is like NewConnFromCipherStates, with
+// options provided by Options.
+func NewConnFromCipherStatesWithOptions(conn net.Conn, config noise.Config, send, recv *noise.CipherState, opts Options) (*Conn, error) {
+	peerStatic := config.PeerStatic
+	named := len(config.Pattern.Messages) > 0
+	if !named {
+		// the handshake state is never used, but needs a pattern.
+		config.Pattern = noise.HandshakeNN
+	}
+	if !preMessageStatic(config.Pattern, !config.Initiator) {
+		config.PeerStatic = nil
+	}
+	c, err := newConnFromCipherStates(conn, config, send, recv, peerStatic, nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !named {
+		c.hsMu.Lock()
+		c.protocol = ""
+		c.hsMu.Unlock()
+	}
+	return c, nil
+}
+
+// newConnFromCipherStates returns a Conn in the transport phase with the
+// cipher states, static key of the peer and handshake hash of a handshake
+// run elsewhere.
+func newConnFromCipherStates(conn net.Conn, config noise.Config, send, recv *noise.CipherState, peerStatic, hh []byte, opts Options) (*Conn, error) {
+	if send == nil || recv == nil {
+		return nil, errs.New("both cipher states are needed")
+	}
+	c, err := NewConnWithOptions(conn, config, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	c.hsMu.Lock()
+	defer c.hsMu.Unlock()
+	c.send = takeCipherState(c.keyCapture.CipherSuite, send)
+	c.recv = takeCipherState(c.keyCapture.CipherSuite, recv)
+	c.hh = append([]byte(nil), hh...)
+	c.peerStatic = append([]byte(nil), peerStatic...)
+	if c.profileLabels.enabled && len(c.peerStatic) > 0 {
+		c.profileLabels.peer = Fingerprint(c.peerStatic)
+	}
+	c.authDone = true
+	c.hs = nil
+	c.hsFinish = time.Now()
+	c.rekey.reset(c.hsFinish)
+	c.readBarrier.Release()
+	c.setConnected()
+	return c, nil
+}
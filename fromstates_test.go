@@ -0,0 +1,94 @@
+package noiseconn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestNewConnFromCipherStates(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+
+	// run the handshake elsewhere.
+	initiator, err := noise.NewHandshakeState(noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true, Random: rand.Reader})
+	if err != nil {
+		panic(err)
+	}
+	responder, err := noise.NewHandshakeState(noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Random: rand.Reader})
+	if err != nil {
+		panic(err)
+	}
+	msg, _, _, err := initiator.WriteMessage(nil, nil)
+	if err != nil {
+		panic(err)
+	}
+	if _, _, _, err := responder.ReadMessage(nil, msg); err != nil {
+		panic(err)
+	}
+	msg, rsend, rrecv, err := responder.WriteMessage(nil, nil)
+	if err != nil {
+		panic(err)
+	}
+	_, irecv, isend, err := initiator.ReadMessage(nil, msg)
+	if err != nil {
+		panic(err)
+	}
+	// the Conns continue at the nonces of the cipher states.
+	msg, err = isend.Encrypt(nil, nil, nil)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := rrecv.Decrypt(nil, nil, msg); err != nil {
+		panic(err)
+	}
+
+	c1, c2 := net.Pipe()
+	client, err := NewConnFromCipherStates(c1, noise.Config{CipherSuite: cs, Initiator: true}, isend, irecv)
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	server, err := NewConnFromCipherStates(c2, noise.Config{CipherSuite: cs}, rsend, rrecv)
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		_, err := client.Write([]byte("hello"))
+		return err
+	})
+	var b [5]byte
+	if _, err := io.ReadFull(server, b[:]); err != nil {
+		panic(err)
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	if !bytes.Equal(b[:], []byte("hello")) {
+		t.Fatalf("unexpected data %q", b[:])
+	}
+	eg.Go(func() error {
+		_, err := server.Write([]byte("world"))
+		return err
+	})
+	if _, err := io.ReadFull(client, b[:]); err != nil {
+		panic(err)
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	if !bytes.Equal(b[:], []byte("world")) {
+		t.Fatalf("unexpected data %q", b[:])
+	}
+
+	if _, err := client.ExportState(); err == nil {
+		t.Fatal("expected exporting unknown keys to fail")
+	}
+}
@@ -0,0 +1,236 @@
+package noiseconn
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/flynn/noise"
+	"github.com/zeebo/errs"
+)
+
+// ErrStateExported is returned by the I/O methods of a Conn after its state
+// was exported with ExportState.
+var ErrStateExported = errors.New("connection state exported")
+
+const handoffVersion = 3
+
+// ExportState serializes the transport state of the connection: the cipher
+// states with their nonces, the handshake results and any data that was
+// received but not read yet. Together with the underlying connection, such
+// as a file descriptor passed with SCM_RIGHTS, the state lets another
+// process continue the connection with ImportConn, for instance during a hot
+// upgrade.
+//
+// The handshake, and the token exchange if enabled, must be complete. Reads
+// and writes must not be in progress, and data must not be in flight from
+// this side, as writes aren't resumable. Afterwards, the I/O methods of c
+// return ErrStateExported, and c should only be closed.
+//
+// The state contains the traffic keys of the connection, and must be kept
+// as secret as the static keys.
+func (c *Conn) ExportState() ([]byte, error) {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	c.hsMu.Lock()
+	defer c.hsMu.Unlock()
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.hs != nil {
+		return nil, errs.New("handshake not complete")
+	}
+	if atomic.LoadUint32(&c.exported) != 0 {
+		return nil, ErrStateExported
+	}
+	if (c.authToken != nil || c.verifyToken != nil) && !c.authDone {
+		return nil, errs.New("token exchange not complete")
+	}
+	if c.authErr != nil {
+		return nil, c.authErr
+	}
+	if c.send.opaque || c.recv.opaque {
+		return nil, errs.New("keys of the cipher states are unknown")
+	}
+
+	var flags byte
+	if c.initiator {
+		flags |= 1
+	}
+	if c.peerControl {
+		flags |= 2
+	}
+	if c.postQuantum {
+		flags |= 4
+	}
+	b := []byte{handoffVersion, flags}
+	b = appendUint16Bytes(b, []byte(c.protocol))
+	b = appendUint16Bytes(b, c.hh)
+	b = appendUint16Bytes(b, c.peerStatic)
+	for _, cs := range []*cipherState{c.send, c.recv} {
+		b = append(b, cs.k[:]...)
+		b = binary.BigEndian.AppendUint64(b, cs.n)
+	}
+	b = appendUint32Bytes(b, c.readBuf)
+	b = appendUint32Bytes(b, c.frameHeader[:c.frameHeaderN])
+	b = appendUint32Bytes(b, c.frameBody)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(c.readMsgs)))
+	for _, msg := range c.readMsgs {
+		b = appendUint32Bytes(b, msg)
+	}
+	b = binary.BigEndian.AppendUint32(b, uint32(c.frameLimit.send))
+	b = binary.BigEndian.AppendUint32(b, uint32(c.frameLimit.recv))
+	b = binary.BigEndian.AppendUint64(b, uint64(c.keepalive.send))
+	b = binary.BigEndian.AppendUint64(b, uint64(c.keepalive.timeout))
+
+	// the state must only be used once, so this Conn is done.
+	atomic.StoreUint32(&c.exported, 1)
+	c.stopKeepalive()
+	c.send.invalid, c.recv.invalid = true, true
+	zero(c.readBuf[:cap(c.readBuf)])
+	c.readBuf, c.frameHeaderN, c.frameBody, c.readMsgs = nil, 0, nil, nil
+	c.updateBuffered()
+	return b, nil
+}
+
+// ImportConn continues a connection with the state returned by
+// Conn.ExportState. conn is the underlying connection of the exported Conn,
+// and config and opts should match the ones it was created with. The
+// handshake related options have no effect.
+func ImportConn(conn net.Conn, config noise.Config, state []byte, opts ...Option) (*Conn, error) {
+	return ImportConnWithOptions(conn, config, state, applyOptions(opts))
+}
+
+// ImportConnWithOptions is like ImportConn, with options provided by
+// Options.
+func ImportConnWithOptions(conn net.Conn, config noise.Config, state []byte, opts Options) (*Conn, error) {
+	c, err := NewConnWithOptions(conn, config, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.importState(state); err != nil {
+		if e := c.registryEntry; e != nil {
+			e.registry.remove(e.id)
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Conn) importState(state []byte) error {
+	if len(state) < 2 || state[0] != handoffVersion {
+		return errs.New("unsupported connection state")
+	}
+	flags, b := state[1], state[2:]
+	var hh, peerStatic, readBuf, frameHeader, frameBody []byte
+	var keys [2][32]byte
+	var nonces [2]uint64
+	protocol, b, ok := cutUint16Bytes(b)
+	if ok {
+		hh, b, ok = cutUint16Bytes(b)
+	}
+	if ok {
+		peerStatic, b, ok = cutUint16Bytes(b)
+	}
+	for i := range keys {
+		if !ok || len(b) < 40 {
+			ok = false
+			break
+		}
+		copy(keys[i][:], b)
+		nonces[i] = binary.BigEndian.Uint64(b[32:])
+		b = b[40:]
+	}
+	if ok {
+		readBuf, b, ok = cutUint32Bytes(b)
+	}
+	if ok {
+		frameHeader, b, ok = cutUint32Bytes(b)
+	}
+	if ok {
+		frameBody, b, ok = cutUint32Bytes(b)
+	}
+	var readMsgs [][]byte
+	if ok && len(b) >= 4 {
+		count := binary.BigEndian.Uint32(b)
+		b = b[4:]
+		for i := uint32(0); ok && i < count; i++ {
+			var msg []byte
+			msg, b, ok = cutUint32Bytes(b)
+			readMsgs = append(readMsgs, append([]byte{}, msg...))
+		}
+	} else {
+		ok = false
+	}
+	var limits [2]int
+	for i := range limits {
+		if !ok || len(b) < 4 {
+			ok = false
+			break
+		}
+		limits[i] = int(binary.BigEndian.Uint32(b))
+		b = b[4:]
+	}
+	var keepalive [2]time.Duration
+	for i := range keepalive {
+		if !ok || len(b) < 8 {
+			ok = false
+			break
+		}
+		keepalive[i] = time.Duration(binary.BigEndian.Uint64(b))
+		b = b[8:]
+	}
+	if !ok || len(b) > 0 || len(frameHeader) > len(c.frameHeader) {
+		return errs.New("malformed connection state")
+	}
+	if string(protocol) != c.protocol || (flags&1 != 0) != c.initiator {
+		return errs.New("connection state is for %s as initiator=%t", protocol, flags&1 != 0)
+	}
+
+	c.hsMu.Lock()
+	defer c.hsMu.Unlock()
+	c.send = newCipherState(c.keyCapture.CipherSuite, keys[0], nonces[0])
+	c.recv = newCipherState(c.keyCapture.CipherSuite, keys[1], nonces[1])
+	c.hh = append([]byte{}, hh...)
+	c.peerStatic = append([]byte{}, peerStatic...)
+	if c.profileLabels.enabled && len(c.peerStatic) > 0 {
+		c.profileLabels.peer = Fingerprint(c.peerStatic)
+	}
+	c.peerControl, c.postQuantum = flags&2 != 0, flags&4 != 0
+	c.readBuf = append([]byte{}, readBuf...)
+	c.frameHeaderN = copy(c.frameHeader[:], frameHeader)
+	if len(frameBody) > 0 {
+		c.frameBody = append([]byte{}, frameBody...)
+	}
+	c.readMsgs = readMsgs
+	c.frameLimit.send, c.frameLimit.recv = limits[0], limits[1]
+	c.keepalive.send, c.keepalive.timeout = keepalive[0], keepalive[1]
+	c.spawnKeepalive()
+	c.authDone = true
+	c.hs = nil
+	c.hsFinish = time.Now()
+	c.rekey.reset(c.hsFinish)
+	c.readBarrier.Release()
+	c.setConnected()
+	c.updateBuffered()
+	return nil
+}
+
+func appendUint32Bytes(b, value []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(b, uint32(len(value))), value...)
+}
+
+func cutUint32Bytes(b []byte) (value, rest []byte, ok bool) {
+	if len(b) < 4 {
+		return nil, nil, false
+	}
+	size := binary.BigEndian.Uint32(b)
+	if uint64(len(b)-4) < uint64(size) {
+		return nil, nil, false
+	}
+	return b[4 : 4+size], b[4+size:], true
+}
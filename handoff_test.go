@@ -0,0 +1,102 @@
+package noiseconn
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestConnExportState(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	serverKey, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	clientConfig := noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNK, Initiator: true, PeerStatic: serverKey.Public}
+	serverConfig := noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNK, StaticKeypair: serverKey}
+
+	p1, p2 := tcpPair()
+	client, err := NewConn(p1, clientConfig)
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	server, err := NewConn(p2, serverConfig)
+	if err != nil {
+		panic(err)
+	}
+
+	var eg errgroup.Group
+	eg.Go(client.Handshake)
+	eg.Go(server.Handshake)
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	if _, err := client.Write([]byte("hello world")); err != nil {
+		panic(err)
+	}
+	b := make([]byte, 6)
+	if _, err := io.ReadFull(server, b); err != nil {
+		panic(err)
+	}
+	state, err := server.ExportState()
+	if err != nil {
+		panic(err)
+	}
+	if _, err := server.Read(b); !errors.Is(err, ErrStateExported) {
+		t.Fatalf("expected ErrStateExported, got %v", err)
+	}
+	if _, err := server.Write(b); !errors.Is(err, ErrStateExported) {
+		t.Fatalf("expected ErrStateExported, got %v", err)
+	}
+	if _, err := server.ExportState(); !errors.Is(err, ErrStateExported) {
+		t.Fatalf("expected ErrStateExported, got %v", err)
+	}
+	if _, err := ImportConn(p2, clientConfig, state); err == nil {
+		t.Fatal("expected an error importing with the wrong role")
+	}
+
+	imported, err := ImportConn(p2, serverConfig, state)
+	if err != nil {
+		panic(err)
+	}
+	if !imported.HandshakeComplete() || imported.Buffered() != 5 {
+		t.Fatalf("unexpected imported state: complete %v, buffered %d", imported.HandshakeComplete(), imported.Buffered())
+	}
+	b = make([]byte, 5)
+	if _, err := io.ReadFull(imported, b); err != nil {
+		panic(err)
+	}
+	if string(b) != "world" {
+		t.Fatalf("unexpected buffered data %q", b)
+	}
+	for _, msg := range []string{"ping", "pong"} {
+		from, to := client, imported
+		if msg == "pong" {
+			from, to = imported, client
+		}
+		if _, err := from.Write([]byte(msg)); err != nil {
+			panic(err)
+		}
+		b := make([]byte, len(msg))
+		if _, err := io.ReadFull(to, b); err != nil {
+			panic(err)
+		}
+		if string(b) != msg {
+			t.Fatalf("expected %q, got %q", msg, b)
+		}
+	}
+	_ = imported.Close()
+
+	unfinished, err := NewConn(p2, serverConfig)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := unfinished.ExportState(); err == nil {
+		t.Fatal("expected an error exporting an incomplete handshake")
+	}
+}
@@ -0,0 +1,140 @@
+package noiseconn
+
+import (
+	"net"
+
+	"github.com/flynn/noise"
+	"github.com/zeebo/errs"
+)
+
+// HandshakeDriver runs a handshake one message at a time, independently of
+// any connection, so that the handshake messages can be carried over a
+// different channel than the data, such as an HTTP exchange, QR codes or a
+// message bus. Once it is complete, NewConn applies the resulting cipher
+// states to a data connection.
+//
+// The messages are the plain Noise handshake messages, without the
+// negotiation, extensions and framing of a Conn handshake, so both sides
+// must use a HandshakeDriver. It must not be used concurrently.
+type HandshakeDriver struct {
+	config     noise.Config
+	hs         *noise.HandshakeState
+	send, recv *noise.CipherState
+	peerStatic []byte
+	hh         []byte
+	err        error
+}
+
+// NewHandshakeDriver returns a HandshakeDriver running the handshake of
+// config.
+func NewHandshakeDriver(config noise.Config) (*HandshakeDriver, error) {
+	if err := ValidateConfig(config, Options{}); err != nil {
+		return nil, err
+	}
+	hs, err := noise.NewHandshakeState(config)
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	return &HandshakeDriver{config: config, hs: hs}, nil
+}
+
+// NextHandshakeMessage returns the next handshake message to deliver to the
+// peer, carrying payload. It fails if a message from the peer is expected
+// next, or the handshake is complete.
+func (d *HandshakeDriver) NextHandshakeMessage(payload []byte) ([]byte, error) {
+	if err := d.usable(); err != nil {
+		return nil, err
+	}
+	msg, cs1, cs2, err := d.hs.WriteMessage(nil, payload)
+	if err != nil {
+		d.err = errs.Wrap(err)
+		return nil, d.err
+	}
+	d.step(cs1, cs2)
+	return msg, nil
+}
+
+// ConsumeHandshakeMessage processes a handshake message received from the
+// peer and returns its payload. It fails if a message to the peer is
+// expected next, or the handshake is complete. The handshake can't continue
+// after a message failed to be processed.
+func (d *HandshakeDriver) ConsumeHandshakeMessage(msg []byte) ([]byte, error) {
+	if err := d.usable(); err != nil {
+		return nil, err
+	}
+	payload, cs1, cs2, err := d.hs.ReadMessage(nil, msg)
+	if err != nil {
+		d.err = errs.Wrap(err)
+		return nil, d.err
+	}
+	d.step(cs1, cs2)
+	return payload, nil
+}
+
+func (d *HandshakeDriver) usable() error {
+	switch {
+	case d.err != nil:
+		return d.err
+	case d.hs == nil:
+		return errs.New("handshake already complete")
+	}
+	return nil
+}
+
+// step notes the cipher states returned by the last handshake message, if
+// it was the final one.
+func (d *HandshakeDriver) step(cs1, cs2 *noise.CipherState) {
+	if cs1 == nil {
+		return
+	}
+	d.send, d.recv = cs1, cs2
+	if !d.config.Initiator {
+		d.send, d.recv = cs2, cs1
+	}
+	d.peerStatic = d.hs.PeerStatic()
+	d.hh = d.hs.ChannelBinding()
+	d.hs = nil
+}
+
+// Complete returns whether the handshake is complete.
+func (d *HandshakeDriver) Complete() bool {
+	return d.hs == nil
+}
+
+// PeerStatic returns the static public key of the peer, if the handshake
+// pattern provided one and it is known.
+func (d *HandshakeDriver) PeerStatic() []byte {
+	if d.hs != nil {
+		return d.hs.PeerStatic()
+	}
+	return d.peerStatic
+}
+
+// HandshakeHash returns the hash generated by the handshake, once it is
+// complete.
+func (d *HandshakeDriver) HandshakeHash() []byte {
+	return d.hh
+}
+
+// NewConn returns a Conn in the transport phase over conn, using the cipher
+// states of the complete handshake, as with NewConnFromCipherStates. The
+// cipher states are handed over, so NewConn can only be called once.
+func (d *HandshakeDriver) NewConn(conn net.Conn, opts ...Option) (*Conn, error) {
+	return d.NewConnWithOptions(conn, applyOptions(opts))
+}
+
+// NewConnWithOptions is like NewConn, with options provided by Options.
+func (d *HandshakeDriver) NewConnWithOptions(conn net.Conn, opts Options) (*Conn, error) {
+	switch {
+	case d.hs != nil:
+		return nil, errs.New("handshake not complete")
+	case d.send == nil:
+		return nil, errs.New("cipher states already handed over")
+	}
+	c, err := newConnFromCipherStates(conn, d.config, d.send, d.recv, d.peerStatic, d.hh, opts)
+	if err != nil {
+		return nil, err
+	}
+	d.send, d.recv = nil, nil
+	return c, nil
+}
@@ -0,0 +1,100 @@
+package noiseconn
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestHandshakeDriver(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	clientKey, err := cs.GenerateKeypair(nil)
+	if err != nil {
+		panic(err)
+	}
+	serverKey, err := cs.GenerateKeypair(nil)
+	if err != nil {
+		panic(err)
+	}
+	client, err := NewHandshakeDriver(noise.Config{
+		CipherSuite:   cs,
+		Pattern:       noise.HandshakeXX,
+		Initiator:     true,
+		StaticKeypair: clientKey,
+	})
+	if err != nil {
+		panic(err)
+	}
+	server, err := NewHandshakeDriver(noise.Config{
+		CipherSuite:   cs,
+		Pattern:       noise.HandshakeXX,
+		StaticKeypair: serverKey,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	// carry the handshake out of band.
+	from, to := client, server
+	for i := 0; !client.Complete() || !server.Complete(); i++ {
+		msg, err := from.NextHandshakeMessage([]byte{byte(i)})
+		if err != nil {
+			panic(err)
+		}
+		payload, err := to.ConsumeHandshakeMessage(msg)
+		if err != nil {
+			panic(err)
+		}
+		if !bytes.Equal(payload, []byte{byte(i)}) {
+			t.Fatalf("unexpected payload %x", payload)
+		}
+		from, to = to, from
+	}
+	if _, err := client.NextHandshakeMessage(nil); err == nil {
+		t.Fatal("expected the complete handshake to fail")
+	}
+	if !bytes.Equal(client.PeerStatic(), serverKey.Public) || !bytes.Equal(server.PeerStatic(), clientKey.Public) {
+		t.Fatal("unexpected peer static keys")
+	}
+
+	c1, c2 := net.Pipe()
+	clientConn, err := client.NewConn(c1)
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = clientConn.Close() }()
+	serverConn, err := server.NewConn(c2)
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = serverConn.Close() }()
+	if _, err := client.NewConn(c1); err == nil {
+		t.Fatal("expected handing the cipher states over twice to fail")
+	}
+	if !bytes.Equal(clientConn.HandshakeHash(), serverConn.HandshakeHash()) || len(clientConn.HandshakeHash()) == 0 {
+		t.Fatal("unexpected handshake hashes")
+	}
+	if !bytes.Equal(serverConn.PeerStatic(), clientKey.Public) {
+		t.Fatal("unexpected peer static key")
+	}
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		_, err := clientConn.Write([]byte("hello"))
+		return err
+	})
+	var b [5]byte
+	if _, err := io.ReadFull(serverConn, b[:]); err != nil {
+		panic(err)
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	if !bytes.Equal(b[:], []byte("hello")) {
+		t.Fatalf("unexpected data %q", b[:])
+	}
+}
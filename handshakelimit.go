@@ -0,0 +1,67 @@
+package noiseconn
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrHandshakeShed is reported when a connection is closed because a
+// HandshakeLimiter was full.
+var ErrHandshakeShed = errors.New("handshake shed")
+
+// HandshakeLimiter caps how many handshakes are in flight at once. It may
+// be shared by many Listeners to cap handshakes server-wide, so that bursts
+// of DH operations don't starve established connections. The zero value
+// doesn't limit handshakes; use NewHandshakeLimiter.
+type HandshakeLimiter struct {
+	// Shed makes listeners close connections whose handshake can't start
+	// immediately, instead of queueing them until a handshake completes.
+	// It must not be changed once the limiter is in use.
+	Shed bool
+
+	slots chan struct{}
+}
+
+// NewHandshakeLimiter returns a HandshakeLimiter that allows max handshakes
+// in flight at once. A max below 1 allows a single handshake.
+func NewHandshakeLimiter(max int) *HandshakeLimiter {
+	if max < 1 {
+		max = 1
+	}
+	return &HandshakeLimiter{slots: make(chan struct{}, max)}
+}
+
+// InFlight returns the number of handshakes in flight. It is always zero
+// for the zero value, which doesn't track them.
+func (h *HandshakeLimiter) InFlight() int {
+	return len(h.slots)
+}
+
+// acquire waits for a handshake slot, or returns ErrHandshakeShed if Shed
+// is set and none is free.
+func (h *HandshakeLimiter) acquire(ctx context.Context) error {
+	if h.slots == nil {
+		return nil
+	}
+	if h.Shed {
+		select {
+		case h.slots <- struct{}{}:
+			return nil
+		default:
+			return ErrHandshakeShed
+		}
+	}
+	select {
+	case h.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *HandshakeLimiter) release() {
+	if h.slots == nil {
+		return
+	}
+	<-h.slots
+}
@@ -0,0 +1,125 @@
+package noiseconn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+)
+
+func TestHandshakeLimiter(t *testing.T) {
+	h := NewHandshakeLimiter(1)
+	if err := h.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := h.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected the second handshake to wait, got", err)
+	}
+	h.Shed = true
+	if err := h.acquire(context.Background()); !errors.Is(err, ErrHandshakeShed) {
+		t.Fatal("expected the second handshake to be shed, got", err)
+	}
+	if h.InFlight() != 1 {
+		t.Fatal("unexpected in flight handshakes", h.InFlight())
+	}
+	h.release()
+	if err := h.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// a max below 1 still allows one handshake, and the zero value doesn't
+	// limit them.
+	h = NewHandshakeLimiter(0)
+	h.Shed = true
+	if err := h.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.acquire(context.Background()); !errors.Is(err, ErrHandshakeShed) {
+		t.Fatal("expected the second handshake to be shed, got", err)
+	}
+	h = &HandshakeLimiter{Shed: true}
+	for i := 0; i < 3; i++ {
+		if err := h.acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.release()
+}
+
+func TestListenerHandshakeLimit(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	for _, shed := range []bool{false, true} {
+		inner, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			panic(err)
+		}
+		limit := NewHandshakeLimiter(1)
+		limit.Shed = shed
+		l := NewListener(inner, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN})
+		l.HandshakeLimit = limit
+		shedErrs := make(chan error, 1)
+		l.OnHandshakeFailure = func(addr net.Addr, stage HandshakeStage, err error) {
+			if stage == HandshakeStageLimit {
+				shedErrs <- err
+			}
+		}
+		defer func() { _ = l.Close() }()
+		accepted := make(chan net.Conn)
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					close(accepted)
+					return
+				}
+				accepted <- conn
+			}
+		}()
+
+		dial := func() net.Conn {
+			raw, err := net.Dial("tcp", inner.Addr().String())
+			if err != nil {
+				panic(err)
+			}
+			return raw
+		}
+
+		// a stalled handshake takes the only slot.
+		stalled := dial()
+		defer func() { _ = stalled.Close() }()
+		for limit.InFlight() != 1 {
+			time.Sleep(time.Millisecond)
+		}
+
+		client, err := NewConn(dial(), noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true})
+		if err != nil {
+			panic(err)
+		}
+		defer func() { _ = client.Close() }()
+		go func() { _ = client.Handshake() }()
+
+		if shed {
+			if err := <-shedErrs; !errors.Is(err, ErrHandshakeShed) {
+				t.Fatal("unexpected error", err)
+			}
+			continue
+		}
+		// queued until the stalled handshake fails.
+		select {
+		case conn := <-accepted:
+			t.Fatalf("unexpected accepted connection from %v", conn.RemoteAddr())
+		case <-time.After(50 * time.Millisecond):
+		}
+		_ = stalled.Close()
+		conn := <-accepted
+		if conn == nil {
+			t.Fatal("listener closed")
+		}
+		_ = conn.Close()
+	}
+}
@@ -0,0 +1,103 @@
+package noiseconn
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/flynn/noise"
+	"github.com/zeebo/errs"
+)
+
+// hintHeaderByte replaces HeaderByte in the frame header of the static key
+// hint, which an initiator with Options.StaticHint sends before the first
+// handshake message. Over a MessageTransport, the hint is the first
+// message.
+const hintHeaderByte = 0x82
+
+const maxHintLen = 1024
+
+// appendHint appends the hint frame to out.
+func appendHint(out, hint []byte) []byte {
+	out = binary.BigEndian.AppendUint32(out, uint32(len(hint)))
+	out[len(out)-4] = hintHeaderByte
+	return append(out, hint...)
+}
+
+// readHint reads the hint of the initiator and starts the handshake with
+// the static key selected for it. Failures are permanent. c.hsMu must be
+// held.
+func (c *Conn) readHint() error {
+	hint, err := c.readHintFrame()
+	if err == nil {
+		err = c.selectHandshake(hint)
+	}
+	if err != nil {
+		c.hsErr = errs.Wrap(err)
+		c.finishTranscript(c.hsErr)
+		return c.hsErr
+	}
+	return nil
+}
+
+func (c *Conn) readHintFrame() ([]byte, error) {
+	return readHintFrame(c.mt, c.Conn)
+}
+
+// readHintFrame reads a hint frame from conn, or the first message of mt
+// if set.
+func readHintFrame(mt MessageTransport, conn net.Conn) ([]byte, error) {
+	if mt != nil {
+		msg, err := mt.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		if len(msg) > maxHintLen {
+			return nil, errs.New("static key hint too long")
+		}
+		return append([]byte(nil), msg...), nil
+	}
+	var header [4]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != hintHeaderByte {
+		return nil, errs.New("expected a static key hint")
+	}
+	header[0] = 0
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxHintLen {
+		return nil, errs.New("static key hint too long")
+	}
+	hint := make([]byte, size)
+	if _, err := io.ReadFull(conn, hint); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return hint, nil
+}
+
+// selectHandshake replaces the handshake state with one using the static
+// key selected for hint.
+func (c *Conn) selectHandshake(hint []byte) error {
+	key, err := c.selectStatic(c.Conn.RemoteAddr(), hint)
+	if err != nil {
+		return err
+	}
+	config := c.hintConfig
+	config.StaticKeypair = key
+	config.Prologue = bindNegotiation(config.Prologue, "hint", hint)
+	hs, err := noise.NewHandshakeState(config)
+	if err != nil {
+		return err
+	}
+	c.hs, c.selectStatic, c.hintConfig = hs, nil, noise.Config{}
+	c.serverName = string(hint)
+	if c.transcript != nil {
+		c.transcript = newTranscript(config)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package noiseconn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+	"github.com/zeebo/errs"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestStaticHint(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	keys := map[string]noise.DHKey{}
+	clientKey, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		key, err := cs.GenerateKeypair(rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+		keys[name] = key
+	}
+	selectStatic := func(addr net.Addr, hint []byte) (noise.DHKey, error) {
+		key, ok := keys[string(hint)]
+		if !ok {
+			return noise.DHKey{}, errs.New("unknown identity %q", hint)
+		}
+		return key, nil
+	}
+
+	handshake := func(pattern noise.HandshakePattern, hint string, peerStatic []byte) (*Conn, error) {
+		p1, p2 := net.Pipe()
+		client, err := NewConnWithOptions(p1, noise.Config{
+			CipherSuite: cs, Pattern: pattern, Initiator: true,
+			StaticKeypair: clientKey, PeerStatic: peerStatic,
+		}, Options{StaticHint: []byte(hint)})
+		if err != nil {
+			panic(err)
+		}
+		t.Cleanup(func() { _ = client.Close() })
+		server, err := NewConnWithOptions(p2, noise.Config{
+			CipherSuite: cs, Pattern: pattern,
+		}, Options{SelectStatic: selectStatic})
+		if err != nil {
+			panic(err)
+		}
+		t.Cleanup(func() { _ = server.Close() })
+
+		var eg errgroup.Group
+		eg.Go(func() error {
+			defer func() { _ = client.Close() }()
+			if _, err := client.Write([]byte("hello")); err != nil {
+				return err
+			}
+			_, err := client.Read(make([]byte, 1))
+			return err
+		})
+		eg.Go(func() error {
+			defer func() { _ = server.Close() }()
+			buf := make([]byte, 5)
+			if _, err := io.ReadFull(server, buf); err != nil {
+				return err
+			}
+			_, err := server.Write([]byte("!"))
+			return err
+		})
+		return client, eg.Wait()
+	}
+
+	for _, name := range []string{"a", "b"} {
+		client, err := handshake(noise.HandshakeXX, name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(client.PeerStatic(), keys[name].Public) {
+			t.Fatal("unexpected static key selected")
+		}
+		if _, err := handshake(noise.HandshakeIK, name, keys[name].Public); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := handshake(noise.HandshakeIK, "a", keys["b"].Public); err == nil {
+		t.Fatal("expected the handshake to fail with the wrong key")
+	}
+	if _, err := handshake(noise.HandshakeXX, "c", nil); err == nil {
+		t.Fatal("expected the handshake to fail for an unknown hint")
+	}
+}
@@ -0,0 +1,204 @@
+package noiseconn
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+)
+
+// Hooks observe the connections they are set as Options.Hooks for, such as
+// to collect metrics. Every field is optional. A Hooks may be shared by
+// many connections, so the callbacks may be called concurrently. They are
+// called while the Conn holds internal locks, so they must not block or
+// call methods of the Conn.
+type Hooks struct {
+	// HandshakeMessage is called with the size of every handshake
+	// message, without the stream framing, once it is created to be sent
+	// or was received.
+	HandshakeMessage func(c *Conn, sent bool, size int)
+
+	// HandshakeDone is called once the handshake completes, with a nil
+	// error, or fails. d is measured from the first handshake message
+	// that was sent or received, and peerStatic is the static public key
+	// of the peer, if it was received.
+	HandshakeDone func(c *Conn, d time.Duration, peerStatic []byte, err error)
+
+	// FrameSent and FrameReceived are called with the size of every Noise
+	// message that is sent or received, without the stream framing.
+	FrameSent     func(c *Conn, size int)
+	FrameReceived func(c *Conn, size int)
+
+	// DecryptFailed is called when a received transport frame fails
+	// authentication or is malformed (see Options.MaxDecryptFailures).
+	DecryptFailed func(c *Conn, err error)
+
+	// Rekey is called when the key used to send data, or to receive it,
+	// changed (see Conn.Rekey).
+	Rekey func(c *Conn, sent bool)
+
+	// Closed is called the first time the Conn is closed.
+	Closed func(c *Conn)
+}
+
+// hsMessage notes that a handshake message is being sent, or was received,
+// to time the handshake. c.hsMu must be held.
+func (c *Conn) hsMessage() {
+	if c.hsStart.IsZero() {
+		c.hsStart = time.Now()
+		c.log(LogDebug, "handshake started")
+	}
+}
+
+// hsMessageDone records and reports a handshake message that was sent or
+// received. c.hsMu must be held.
+func (c *Conn) hsMessageDone(sent bool, size int) {
+	c.hsMessages = append(c.hsMessages, HandshakeMessageStats{Sent: sent, Size: size, Time: time.Now()})
+	if c.hooks != nil && c.hooks.HandshakeMessage != nil {
+		c.hooks.HandshakeMessage(c, sent, size)
+	}
+}
+
+// handshakeDone reports the outcome of the handshake once. c.hsMu must be
+// held.
+func (c *Conn) handshakeDone(err error) {
+	if c.hsReported || (c.logger == nil && (c.hooks == nil || c.hooks.HandshakeDone == nil)) {
+		return
+	}
+	c.hsReported = true
+	var d time.Duration
+	if !c.hsStart.IsZero() {
+		d = time.Since(c.hsStart)
+	}
+	peerStatic := c.peerStatic
+	if c.hs != nil {
+		peerStatic = c.hs.PeerStatic()
+	}
+	if err != nil {
+		c.log(LogWarn, "handshake failed", "duration", d, "error", err)
+	} else if len(peerStatic) > 0 {
+		c.log(LogInfo, "handshake completed", "duration", d, "peer", Fingerprint(peerStatic))
+	} else {
+		c.log(LogInfo, "handshake completed", "duration", d)
+	}
+	if c.hooks != nil && c.hooks.HandshakeDone != nil {
+		c.hooks.HandshakeDone(c, d, peerStatic, err)
+	}
+}
+
+// framesSent reports every message of a buffer of framed messages.
+func (c *Conn) framesSent(buf []byte) {
+	if c.keepalive.send > 0 {
+		c.keepalive.lastSent.Store(time.Now().UnixNano())
+	}
+	hook := c.hooks != nil && c.hooks.FrameSent != nil
+	if !hook && c.registryEntry == nil {
+		return
+	}
+	for len(buf) >= 4 {
+		size := int(binary.BigEndian.Uint32(buf[:4]) & 0xffffff)
+		if c.registryEntry != nil {
+			c.registryActivity(true, size)
+		}
+		if hook {
+			c.hooks.FrameSent(c, size)
+		}
+		buf = buf[4+size:]
+	}
+}
+
+func (c *Conn) frameReceived(size int) {
+	if c.registryEntry != nil {
+		c.registryActivity(false, size)
+	}
+	if c.hooks != nil && c.hooks.FrameReceived != nil {
+		c.hooks.FrameReceived(c, size)
+	}
+}
+
+func (c *Conn) closed() {
+	if !atomic.CompareAndSwapUint32(&c.closeReported, 0, 1) {
+		return
+	}
+	if e := c.registryEntry; e != nil {
+		e.registry.remove(e.id)
+	}
+	if c.hooks != nil && c.hooks.Closed != nil {
+		c.hooks.Closed(c)
+	}
+}
+
+// CombineHooks returns Hooks that call every callback of hooks, in order.
+// Nil Hooks are skipped.
+func CombineHooks(hooks ...*Hooks) *Hooks {
+	var combined Hooks
+	for _, h := range hooks {
+		if h == nil {
+			continue
+		}
+		h := h
+		if h.HandshakeMessage != nil {
+			prev := combined.HandshakeMessage
+			combined.HandshakeMessage = func(c *Conn, sent bool, size int) {
+				if prev != nil {
+					prev(c, sent, size)
+				}
+				h.HandshakeMessage(c, sent, size)
+			}
+		}
+		if h.HandshakeDone != nil {
+			prev := combined.HandshakeDone
+			combined.HandshakeDone = func(c *Conn, d time.Duration, peerStatic []byte, err error) {
+				if prev != nil {
+					prev(c, d, peerStatic, err)
+				}
+				h.HandshakeDone(c, d, peerStatic, err)
+			}
+		}
+		if h.FrameSent != nil {
+			prev := combined.FrameSent
+			combined.FrameSent = func(c *Conn, size int) {
+				if prev != nil {
+					prev(c, size)
+				}
+				h.FrameSent(c, size)
+			}
+		}
+		if h.FrameReceived != nil {
+			prev := combined.FrameReceived
+			combined.FrameReceived = func(c *Conn, size int) {
+				if prev != nil {
+					prev(c, size)
+				}
+				h.FrameReceived(c, size)
+			}
+		}
+		if h.DecryptFailed != nil {
+			prev := combined.DecryptFailed
+			combined.DecryptFailed = func(c *Conn, err error) {
+				if prev != nil {
+					prev(c, err)
+				}
+				h.DecryptFailed(c, err)
+			}
+		}
+		if h.Rekey != nil {
+			prev := combined.Rekey
+			combined.Rekey = func(c *Conn, sent bool) {
+				if prev != nil {
+					prev(c, sent)
+				}
+				h.Rekey(c, sent)
+			}
+		}
+		if h.Closed != nil {
+			prev := combined.Closed
+			combined.Closed = func(c *Conn) {
+				if prev != nil {
+					prev(c)
+				}
+				h.Closed(c)
+			}
+		}
+	}
+	return &combined
+}
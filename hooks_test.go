@@ -0,0 +1,123 @@
+package noiseconn
+
+import (
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestHooks(t *testing.T) {
+	var mu sync.Mutex
+	var done []error
+	var sent, received, closed int
+	hooks := &Hooks{
+		HandshakeDone: func(c *Conn, d time.Duration, peerStatic []byte, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			done = append(done, err)
+		},
+		FrameSent: func(c *Conn, size int) {
+			mu.Lock()
+			defer mu.Unlock()
+			sent += size
+		},
+		FrameReceived: func(c *Conn, size int) {
+			mu.Lock()
+			defer mu.Unlock()
+			received += size
+		},
+		Closed: func(c *Conn) {
+			mu.Lock()
+			defer mu.Unlock()
+			closed++
+		},
+	}
+
+	p1, p2 := net.Pipe()
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	client, err := NewConnWithOptions(p1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true}, Options{Hooks: hooks})
+	if err != nil {
+		panic(err)
+	}
+	server, err := NewConnWithOptions(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, Options{Hooks: hooks})
+	if err != nil {
+		panic(err)
+	}
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		if _, err := client.Write([]byte("hello")); err != nil {
+			return err
+		}
+		_, err := io.ReadFull(client, make([]byte, 5))
+		return err
+	})
+	eg.Go(func() error {
+		if _, err := io.ReadFull(server, make([]byte, 5)); err != nil {
+			return err
+		}
+		_, err := server.Write([]byte("world"))
+		return err
+	})
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	_ = client.Close()
+	_ = client.Close()
+	_ = server.Close()
+
+	mu.Lock()
+	if len(done) != 2 || done[0] != nil || done[1] != nil {
+		t.Fatalf("unexpected handshake results %v", done)
+	}
+	if sent == 0 || sent != received {
+		t.Fatalf("unexpected frame sizes: sent %d, received %d", sent, received)
+	}
+	if closed != 2 {
+		t.Fatalf("expected 2 closes, got %d", closed)
+	}
+	mu.Unlock()
+
+	// failed handshakes are reported too.
+	p1, p2 = net.Pipe()
+	server, err = NewConnWithOptions(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, Options{Hooks: hooks})
+	if err != nil {
+		panic(err)
+	}
+	go func() {
+		_, _ = p1.Write([]byte{HeaderByte, 0, 0, 1, 0})
+		_ = p1.Close()
+	}()
+	if err := server.Handshake(); err == nil {
+		t.Fatal("expected the handshake to fail")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(done) != 3 || done[2] == nil {
+		t.Fatalf("expected a failed handshake, got %v", done)
+	}
+}
+
+func TestCombineHooks(t *testing.T) {
+	var calls []string
+	hooks := CombineHooks(
+		&Hooks{Closed: func(*Conn) { calls = append(calls, "a") }},
+		nil,
+		&Hooks{FrameSent: func(*Conn, int) { calls = append(calls, "frame") }},
+		&Hooks{Closed: func(*Conn) { calls = append(calls, "b") }},
+	)
+	if hooks.HandshakeDone != nil || hooks.FrameReceived != nil {
+		t.Fatal("expected unset hooks to stay nil")
+	}
+	hooks.Closed(nil)
+	hooks.FrameSent(nil, 1)
+	if strings.Join(calls, ",") != "a,b,frame" {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+}
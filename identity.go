@@ -0,0 +1,213 @@
+package noiseconn
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"time"
+
+	"github.com/flynn/noise"
+	"github.com/zeebo/errs"
+)
+
+const (
+	certificateVersion = 1
+	certificateContext = "noiseconn certificate v1"
+	maxChainLength     = 8
+)
+
+// Certificate binds a key to a name, signed by an issuer. Leaf
+// certificates certify the static public key of a peer; CA certificates
+// certify an Ed25519 key that may issue further certificates, so
+// organizations can delegate issuing to intermediate keys while peers only
+// trust a few root keys.
+type Certificate struct {
+	// Name identifies the holder of the key, such as a host name. It isn't
+	// interpreted by this package.
+	Name string
+
+	// Key is the certified key: a static public key for leaf certificates,
+	// and an Ed25519 public key for CA certificates.
+	Key []byte
+
+	// CA is whether Key may issue certificates.
+	CA bool
+
+	// NotBefore and NotAfter bound when the certificate is valid.
+	NotBefore, NotAfter time.Time
+
+	// Issuer is the Ed25519 public key that signed the certificate. It is
+	// set by SignCertificate.
+	Issuer ed25519.PublicKey
+
+	// Signature is set by SignCertificate.
+	Signature []byte
+}
+
+// SignCertificate returns cert signed by signer.
+func SignCertificate(cert Certificate, signer ed25519.PrivateKey) Certificate {
+	cert.Issuer = signer.Public().(ed25519.PublicKey)
+	cert.Signature = ed25519.Sign(signer, cert.signed())
+	return cert
+}
+
+// signed returns the encoding of the signed fields of c.
+func (c *Certificate) signed() []byte {
+	var flags byte
+	if c.CA {
+		flags |= 1
+	}
+	b := append([]byte(certificateContext), certificateVersion, flags)
+	b = appendUint16Bytes(b, []byte(c.Name))
+	b = appendUint16Bytes(b, c.Key)
+	b = binary.BigEndian.AppendUint64(b, uint64(c.NotBefore.Unix()))
+	b = binary.BigEndian.AppendUint64(b, uint64(c.NotAfter.Unix()))
+	return appendUint16Bytes(b, c.Issuer)
+}
+
+// Marshal encodes c, which must have been signed.
+func (c *Certificate) Marshal() []byte {
+	b := c.signed()[len(certificateContext):]
+	return appendUint16Bytes(b, c.Signature)
+}
+
+// ParseCertificate decodes a certificate encoded with Marshal. It doesn't
+// verify the certificate.
+func ParseCertificate(b []byte) (*Certificate, error) {
+	if len(b) < 2 || b[0] != certificateVersion {
+		return nil, errs.New("unsupported certificate version")
+	}
+	c := &Certificate{CA: b[1]&1 != 0}
+	b = b[2:]
+	var name []byte
+	var ok bool
+	if name, b, ok = cutUint16Bytes(b); !ok {
+		return nil, errs.New("truncated certificate")
+	}
+	c.Name = string(name)
+	if c.Key, b, ok = cutUint16Bytes(b); !ok || len(b) < 16 {
+		return nil, errs.New("truncated certificate")
+	}
+	c.NotBefore = time.Unix(int64(binary.BigEndian.Uint64(b)), 0)
+	c.NotAfter = time.Unix(int64(binary.BigEndian.Uint64(b[8:])), 0)
+	b = b[16:]
+	var issuer []byte
+	if issuer, b, ok = cutUint16Bytes(b); !ok {
+		return nil, errs.New("truncated certificate")
+	}
+	c.Issuer = issuer
+	if c.Signature, b, ok = cutUint16Bytes(b); !ok || len(b) != 0 {
+		return nil, errs.New("malformed certificate")
+	}
+	return c, nil
+}
+
+// VerifyCertificateChain verifies that chain, leaf first, certifies
+// peerStatic at time now, and that the last certificate was issued by one
+// of roots. It returns the leaf certificate.
+func VerifyCertificateChain(chain []*Certificate, peerStatic []byte, roots []ed25519.PublicKey, now time.Time) (*Certificate, error) {
+	if len(chain) == 0 {
+		return nil, errs.New("empty certificate chain")
+	}
+	if len(chain) > maxChainLength {
+		return nil, errs.New("certificate chain too long")
+	}
+	leaf := chain[0]
+	if leaf.CA || !bytes.Equal(leaf.Key, peerStatic) {
+		return nil, errs.New("certificate %q is not for the peer static key", leaf.Name)
+	}
+	for i, cert := range chain {
+		if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+			return nil, errs.New("certificate %q is not valid at %v", cert.Name, now.UTC())
+		}
+		if len(cert.Issuer) != ed25519.PublicKeySize || !ed25519.Verify(cert.Issuer, cert.signed(), cert.Signature) {
+			return nil, errs.New("invalid signature on certificate %q", cert.Name)
+		}
+		if i+1 < len(chain) {
+			issuer := chain[i+1]
+			if !issuer.CA || !bytes.Equal(issuer.Key, cert.Issuer) {
+				return nil, errs.New("certificate %q was not issued by %q", cert.Name, issuer.Name)
+			}
+		}
+	}
+	last := chain[len(chain)-1]
+	for _, root := range roots {
+		if bytes.Equal(root, last.Issuer) {
+			return leaf, nil
+		}
+	}
+	return nil, errs.New("certificate %q was not issued by a trusted root", last.Name)
+}
+
+// identityMessage returns the index of the handshake message that carries
+// the identity of the local peer: the first message it writes once its
+// static key has been transmitted. It returns -1 if there's none.
+func identityMessage(pattern noise.HandshakePattern, initiator bool) int {
+	ours := pattern.InitiatorPreMessages
+	if !initiator {
+		ours = pattern.ResponderPreMessages
+	}
+	sent := false
+	for _, token := range ours {
+		sent = sent || token == noise.MessagePatternS
+	}
+	for i, msg := range pattern.Messages {
+		if (i%2 == 0) != initiator {
+			continue
+		}
+		for _, token := range msg {
+			sent = sent || token == noise.MessagePatternS
+		}
+		if sent {
+			return i
+		}
+	}
+	return -1
+}
+
+func marshalChain(chain []Certificate) []byte {
+	b := []byte{byte(len(chain))}
+	for i := range chain {
+		b = appendUint16Bytes(b, chain[i].Marshal())
+	}
+	return b
+}
+
+func parseChain(b []byte) ([]*Certificate, error) {
+	if len(b) == 0 || int(b[0]) > maxChainLength {
+		return nil, errs.New("invalid certificate chain")
+	}
+	chain := make([]*Certificate, b[0])
+	b = b[1:]
+	for i := range chain {
+		var enc []byte
+		var ok bool
+		if enc, b, ok = cutUint16Bytes(b); !ok {
+			return nil, errs.New("truncated certificate chain")
+		}
+		cert, err := ParseCertificate(enc)
+		if err != nil {
+			return nil, err
+		}
+		chain[i] = cert
+	}
+	if len(b) != 0 {
+		return nil, errs.New("invalid certificate chain")
+	}
+	return chain, nil
+}
+
+func appendUint16Bytes(b, data []byte) []byte {
+	return append(binary.BigEndian.AppendUint16(b, uint16(len(data))), data...)
+}
+
+func cutUint16Bytes(b []byte) (data, rest []byte, ok bool) {
+	if len(b) < 2 {
+		return nil, nil, false
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return nil, nil, false
+	}
+	return b[2 : 2+n], b[2+n:], true
+}
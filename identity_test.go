@@ -0,0 +1,166 @@
+package noiseconn
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestIdentity(t *testing.T) {
+	rootPublic, root, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	intermediatePublic, intermediate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	now := time.Now()
+	intermediateCert := SignCertificate(Certificate{
+		Name: "intermediate", Key: intermediatePublic, CA: true,
+		NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour),
+	}, root)
+
+	newPeer := func(name string, signer ed25519.PrivateKey, chain ...Certificate) (noise.DHKey, []Certificate) {
+		key, err := noise.DH25519.GenerateKeypair(rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+		leaf := SignCertificate(Certificate{
+			Name: name, Key: key.Public,
+			NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour),
+		}, signer)
+		return key, append([]Certificate{leaf}, chain...)
+	}
+
+	handshake := func(pattern noise.HandshakePattern, clientKey, serverKey noise.DHKey, clientOpts, serverOpts Options) (client, server *Conn, clientErr, serverErr error) {
+		p1, p2 := net.Pipe()
+		cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+		var peerStatic []byte
+		if len(pattern.ResponderPreMessages) > 0 {
+			peerStatic = serverKey.Public
+		}
+		client, err := NewConnWithOptions(p1, noise.Config{
+			CipherSuite: cs, Pattern: pattern, Initiator: true,
+			StaticKeypair: clientKey, PeerStatic: peerStatic,
+		}, clientOpts)
+		if err != nil {
+			panic(err)
+		}
+		t.Cleanup(func() { _ = client.Close() })
+		server, err = NewConnWithOptions(p2, noise.Config{
+			CipherSuite: cs, Pattern: pattern, StaticKeypair: serverKey,
+		}, serverOpts)
+		if err != nil {
+			panic(err)
+		}
+		t.Cleanup(func() { _ = server.Close() })
+
+		var eg errgroup.Group
+		eg.Go(func() error {
+			if _, clientErr = client.Write([]byte("hello")); clientErr == nil {
+				clientErr = client.Handshake()
+			}
+			if clientErr != nil {
+				_ = client.Close()
+			}
+			return nil
+		})
+		eg.Go(func() error {
+			buf := make([]byte, 5)
+			if _, serverErr = io.ReadFull(server, buf); serverErr == nil && string(buf) != "hello" {
+				t.Error("unexpected data")
+			}
+			if serverErr == nil {
+				serverErr = server.Handshake()
+			}
+			if serverErr != nil {
+				_ = server.Close()
+			}
+			return nil
+		})
+		_ = eg.Wait()
+		return client, server, clientErr, serverErr
+	}
+
+	// mutual identities over XX, with an intermediate for the server.
+	clientKey, clientChain := newPeer("client", root)
+	serverKey, serverChain := newPeer("server", intermediate, intermediateCert)
+	roots := []ed25519.PublicKey{rootPublic}
+	client, server, clientErr, serverErr := handshake(noise.HandshakeXX, clientKey, serverKey,
+		Options{Identity: clientChain, IdentityRoots: roots},
+		Options{Identity: serverChain, IdentityRoots: roots})
+	if clientErr != nil || serverErr != nil {
+		t.Fatal(clientErr, serverErr)
+	}
+	if client.PeerIdentity().Name != "server" || server.PeerIdentity().Name != "client" {
+		t.Fatal("unexpected peer identities")
+	}
+
+	// server-only identity over IK, with 0-RTT data from the client.
+	_, _, clientErr, serverErr = handshake(noise.HandshakeIK, clientKey, serverKey,
+		Options{IdentityRoots: roots},
+		Options{Identity: serverChain})
+	if clientErr != nil || serverErr != nil {
+		t.Fatal(clientErr, serverErr)
+	}
+
+	// the server requires a client identity, and the 0-RTT data of a
+	// client without one is not returned.
+	_, _, _, serverErr = handshake(noise.HandshakeIK, clientKey, serverKey,
+		Options{IdentityRoots: roots},
+		Options{Identity: serverChain, IdentityRoots: roots})
+	if serverErr == nil {
+		t.Fatal("expected error for a missing client identity")
+	}
+
+	// untrusted roots.
+	_, otherRoot, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	untrustedKey, untrustedChain := newPeer("server", otherRoot)
+	_, _, clientErr, _ = handshake(noise.HandshakeXX, clientKey, untrustedKey,
+		Options{IdentityRoots: roots},
+		Options{Identity: untrustedChain})
+	if clientErr == nil {
+		t.Fatal("expected error for an untrusted identity")
+	}
+}
+
+func TestCertificateChain(t *testing.T) {
+	rootPublic, root, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	now := time.Unix(1700000000, 0)
+	leaf := SignCertificate(Certificate{
+		Name: "leaf", Key: make([]byte, 32),
+		NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour),
+	}, root)
+
+	parsed, err := ParseCertificate(leaf.Marshal())
+	if err != nil {
+		panic(err)
+	}
+	roots := []ed25519.PublicKey{rootPublic}
+	if _, err := VerifyCertificateChain([]*Certificate{parsed}, leaf.Key, roots, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyCertificateChain([]*Certificate{parsed}, leaf.Key, roots, now.Add(2*time.Hour)); err == nil {
+		t.Fatal("expected error for an expired certificate")
+	}
+	parsed.Name = "forged"
+	if _, err := VerifyCertificateChain([]*Certificate{parsed}, leaf.Key, roots, now); err == nil {
+		t.Fatal("expected error for a modified certificate")
+	}
+	if _, err := ParseCertificate(leaf.Marshal()[:20]); err == nil {
+		t.Fatal("expected error for a truncated certificate")
+	}
+}
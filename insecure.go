@@ -0,0 +1,17 @@
+package noiseconn
+
+import "github.com/flynn/noise"
+
+// InsecureBuildTag is the build tag that must be set for
+// Options.InsecureNullCipher to be usable.
+const InsecureBuildTag = "noiseconn_insecure"
+
+// nullCipher is the cipher of Options.InsecureNullCipher. It is nil unless
+// the package is built with InsecureBuildTag.
+var nullCipher noise.CipherFunc
+
+// insecureCipherSuite returns cs with its cipher replaced by the null
+// cipher, for Options.InsecureNullCipher.
+func insecureCipherSuite(cs noise.CipherSuite) noise.CipherSuite {
+	return noise.NewCipherSuite(cs, nullCipher, cs)
+}
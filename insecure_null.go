@@ -0,0 +1,48 @@
+//go:build noiseconn_insecure
+
+package noiseconn
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/flynn/noise"
+)
+
+func init() {
+	nullCipher = nullCipherFunc{}
+	fmt.Fprintln(os.Stderr, "noiseconn: WARNING: built with the "+InsecureBuildTag+
+		" build tag; connections with InsecureNullCipher are NOT encrypted")
+}
+
+// nullTagSize keeps the overhead of the null cipher equal to that of the
+// AEADs, so framing and message sizes are unchanged.
+const nullTagSize = 16
+
+var errNullTag = errors.New("noiseconn: invalid null cipher tag")
+
+// nullCipherFunc is a noise.CipherFunc that doesn't encrypt. It appends a
+// zero tag, to keep the message sizes of real ciphers.
+type nullCipherFunc struct{}
+
+func (nullCipherFunc) Cipher(k [32]byte) noise.Cipher { return nullCipherFunc{} }
+
+func (nullCipherFunc) CipherName() string { return "INSECURENULL" }
+
+func (nullCipherFunc) Encrypt(out []byte, n uint64, ad, plaintext []byte) []byte {
+	out = append(out, plaintext...)
+	return append(out, make([]byte, nullTagSize)...)
+}
+
+func (nullCipherFunc) Decrypt(out []byte, n uint64, ad, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < nullTagSize {
+		return nil, errNullTag
+	}
+	plaintext, tag := ciphertext[:len(ciphertext)-nullTagSize], ciphertext[len(ciphertext)-nullTagSize:]
+	if subtle.ConstantTimeCompare(tag, make([]byte, nullTagSize)) != 1 {
+		return nil, errNullTag
+	}
+	return append(out, plaintext...), nil
+}
@@ -0,0 +1,21 @@
+//go:build !noiseconn_insecure
+
+package noiseconn
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+)
+
+func TestInsecureNullCipherNeedsBuildTag(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	p1, p2 := net.Pipe()
+	defer func() { _ = p2.Close() }()
+	_, err := NewConn(p1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, WithInsecureNullCipher())
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatal("expected ErrInvalidConfig, got", err)
+	}
+}
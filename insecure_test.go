@@ -0,0 +1,75 @@
+//go:build noiseconn_insecure
+
+package noiseconn
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestInsecureNullCipher(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	p1, p2 := net.Pipe()
+	rec := &recordingConn{Conn: p1}
+	client, err := NewConn(rec, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true}, WithInsecureNullCipher())
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	server, err := NewConn(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, WithInsecureNullCipher())
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		_, err := client.Write([]byte("readable secret"))
+		return err
+	})
+	b := make([]byte, 15)
+	if _, err := server.Read(b); err != nil || string(b) != "readable secret" {
+		t.Fatal("unexpected read", string(b), err)
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if !bytes.Contains(rec.written, []byte("readable secret")) {
+		t.Fatal("expected the plaintext on the wire")
+	}
+	if state := client.ConnectionState(); state.Protocol != "Noise_NN_25519_INSECURENULL_BLAKE2b" {
+		t.Fatal("unexpected protocol", state.Protocol)
+	}
+}
+
+func TestInsecureNullCipherMismatch(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	p1, p2 := net.Pipe()
+	client, err := NewConn(p1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true}, WithInsecureNullCipher())
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	server, err := NewConn(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		err := server.Handshake()
+		_ = server.Close()
+		return err
+	})
+	if err := client.Handshake(); err == nil {
+		t.Fatal("expected a peer with encryption to fail the handshake")
+	}
+	_ = eg.Wait()
+}
@@ -0,0 +1,192 @@
+// Package cmdutil contains helpers shared by the commands.
+package cmdutil
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/flynn/noise"
+	"github.com/jtolio/noiseconn"
+	"github.com/zeebo/errs"
+	"golang.org/x/crypto/curve25519"
+)
+
+// ParseProtocol parses a Noise protocol name such as
+// Noise_XX_25519_ChaChaPoly_BLAKE2b.
+func ParseProtocol(name string) (noise.HandshakePattern, noise.CipherSuite, error) {
+	config, err := noiseconn.ParseProtocol(name)
+	return config.Pattern, config.CipherSuite, err
+}
+
+// ParsePrivateKey parses a base64-encoded Curve25519 private key and
+// derives its public key.
+func ParsePrivateKey(s string) (noise.DHKey, error) {
+	private, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return noise.DHKey{}, errs.New("invalid private key: %v", err)
+	}
+	if len(private) != 32 {
+		return noise.DHKey{}, errs.New("invalid private key length %d", len(private))
+	}
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		return noise.DHKey{}, errs.Wrap(err)
+	}
+	return noise.DHKey{Private: private, Public: public}, nil
+}
+
+// ParsePublicKey parses a base64-encoded Curve25519 public key.
+func ParsePublicKey(s string) ([]byte, error) {
+	public, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errs.New("invalid public key: %v", err)
+	}
+	if len(public) != 32 {
+		return nil, errs.New("invalid public key length %d", len(public))
+	}
+	return public, nil
+}
+
+// Endpoint holds the flags describing one side of a connection.
+type Endpoint struct {
+	Protocol string
+	Key      string
+	KeyFile  string
+	Peer     string
+	PeerFile string
+
+	KeyPassphraseFile string
+	PeerFingerprint   string
+	KnownHosts        string
+	PostQuantum       string
+}
+
+// Config builds the Noise configuration and options for the endpoint. If
+// no key was provided, an ephemeral static key is generated. The static
+// public key is printed to stderr, so the peer can pin it.
+func (e *Endpoint) Config(initiator bool) (noise.Config, noiseconn.Options, error) {
+	pattern, cs, err := ParseProtocol(e.Protocol)
+	if err != nil {
+		return noise.Config{}, noiseconn.Options{}, err
+	}
+	var key noise.DHKey
+	if e.KeyFile != "" {
+		var opts noiseconn.KeyFileOptions
+		if e.KeyPassphraseFile != "" {
+			opts.Passphrase, err = ReadPassphrase(e.KeyPassphraseFile)
+		}
+		if err == nil {
+			key, err = noiseconn.LoadKeypair(e.KeyFile, opts)
+		}
+	} else if e.Key != "" {
+		key, err = ParsePrivateKey(e.Key)
+	} else {
+		key, err = noise.DH25519.GenerateKeypair(rand.Reader)
+	}
+	if err != nil {
+		return noise.Config{}, noiseconn.Options{}, err
+	}
+	fmt.Fprintf(os.Stderr, "static public key %s (%s)\n", FormatPublicKey(key.Public), noiseconn.Fingerprint(key.Public))
+	config := noise.Config{
+		CipherSuite:   cs,
+		Pattern:       pattern,
+		Initiator:     initiator,
+		StaticKeypair: key,
+	}
+
+	var opts noiseconn.Options
+	if e.Peer != "" || e.PeerFile != "" {
+		var peer []byte
+		if e.PeerFile != "" {
+			var data []byte
+			data, err = os.ReadFile(e.PeerFile)
+			if err == nil {
+				peer, err = noiseconn.DecodePublicKey(data)
+			}
+		} else {
+			peer, err = ParsePublicKey(e.Peer)
+		}
+		if err != nil {
+			return noise.Config{}, noiseconn.Options{}, err
+		}
+		if needsPeerStatic(pattern, initiator) {
+			config.PeerStatic = peer
+		}
+		opts.VerifyPeer = noiseconn.PinPeers(peer)
+	} else if e.PeerFingerprint != "" {
+		if needsPeerStatic(pattern, initiator) {
+			return noise.Config{}, noiseconn.Options{}, errs.New("pattern %s needs the peer's public key, not a fingerprint", pattern.Name)
+		}
+		opts.VerifyPeer, err = noiseconn.PinFingerprints(e.PeerFingerprint)
+		if err != nil {
+			return noise.Config{}, noiseconn.Options{}, err
+		}
+	} else if e.KnownHosts != "" {
+		if needsPeerStatic(pattern, initiator) {
+			return noise.Config{}, noiseconn.Options{}, errs.New("pattern %s needs the peer's public key", pattern.Name)
+		}
+		tofu := &noiseconn.TOFU{Store: noiseconn.NewKnownHostsFile(e.KnownHosts)}
+		opts.VerifyPeer = tofu.Verify
+	}
+	switch e.PostQuantum {
+	case "", "off":
+	case "preferred":
+		opts.PostQuantum = noiseconn.PostQuantumPreferred
+	case "required":
+		opts.PostQuantum = noiseconn.PostQuantumRequired
+	default:
+		return noise.Config{}, noiseconn.Options{}, errs.New("invalid post-quantum mode %q", e.PostQuantum)
+	}
+	return config, opts, nil
+}
+
+// needsPeerStatic returns whether the peer's static key is a pre-message
+// of pattern, so it must be known before the handshake.
+func needsPeerStatic(pattern noise.HandshakePattern, initiator bool) bool {
+	peerPre := pattern.ResponderPreMessages
+	if !initiator {
+		peerPre = pattern.InitiatorPreMessages
+	}
+	for _, token := range peerPre {
+		if token == noise.MessagePatternS {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultProtocol is the protocol used when none is specified.
+const DefaultProtocol = "Noise_XX_25519_ChaChaPoly_BLAKE2b"
+
+// RegisterFlags registers flags for the endpoint on fs, with names
+// prefixed by prefix.
+func (e *Endpoint) RegisterFlags(fs *flag.FlagSet, prefix string) {
+	fs.StringVar(&e.Protocol, prefix+"protocol", DefaultProtocol, "Noise protocol name")
+	fs.StringVar(&e.Key, prefix+"key", "", "base64 static private key (generated if empty)")
+	fs.StringVar(&e.KeyFile, prefix+"key-file", "", "static private key file, as written by noisekeygen")
+	fs.StringVar(&e.KeyPassphraseFile, prefix+"key-passphrase-file", "", "file with the passphrase of an encrypted key file")
+	fs.StringVar(&e.Peer, prefix+"peer", "", "base64 static public key the peer must have")
+	fs.StringVar(&e.PeerFile, prefix+"peer-file", "", "file with the static public key the peer must have")
+	fs.StringVar(&e.PeerFingerprint, prefix+"peer-fingerprint", "", "fingerprint of the static public key the peer must have")
+	fs.StringVar(&e.KnownHosts, prefix+"known-hosts", "", "file recording peer static public keys on first use, required on later connections")
+	fs.StringVar(&e.PostQuantum, prefix+"post-quantum", "off", "hybrid post-quantum handshakes: off, preferred or required")
+}
+
+// ReadPassphrase reads a passphrase from the first line of the file name.
+func ReadPassphrase(name string) ([]byte, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	return []byte(strings.TrimSuffix(line, "\r")), nil
+}
+
+// FormatPublicKey formats a public key the way ParsePublicKey expects it.
+func FormatPublicKey(public []byte) string {
+	return base64.StdEncoding.EncodeToString(public)
+}
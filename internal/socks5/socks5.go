@@ -0,0 +1,276 @@
+// Package socks5 implements the parts of the SOCKS5 protocol (RFC 1928 and
+// RFC 1929) shared by the proxy-related packages.
+package socks5
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/zeebo/errs"
+)
+
+const (
+	version = 0x05
+
+	methodNoAuth       = 0x00
+	methodUserPass     = 0x02
+	methodNoAcceptable = 0xff
+
+	userPassVersion = 0x01
+
+	// CmdConnect is the CONNECT command.
+	CmdConnect = 0x01
+
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+)
+
+// Reply codes.
+const (
+	ReplySucceeded           = 0x00
+	ReplyGeneralFailure      = 0x01
+	ReplyNotAllowed          = 0x02
+	ReplyNetworkUnreachable  = 0x03
+	ReplyHostUnreachable     = 0x04
+	ReplyConnectionRefused   = 0x05
+	ReplyCommandNotSupported = 0x07
+	ReplyAddressNotSupported = 0x08
+)
+
+// Auth holds username/password credentials.
+type Auth struct {
+	Username string
+	Password string
+}
+
+// Connect asks the SOCKS5 server on the other end of rw to connect to
+// address, authenticating with auth if it is not nil.
+func Connect(rw io.ReadWriter, address string, auth *Auth) error {
+	host, port, err := splitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	method := byte(methodNoAuth)
+	if auth != nil {
+		method = methodUserPass
+	}
+	if _, err := rw.Write([]byte{version, 1, method}); err != nil {
+		return errs.Wrap(err)
+	}
+	var resp [2]byte
+	if _, err := io.ReadFull(rw, resp[:]); err != nil {
+		return errs.Wrap(err)
+	}
+	if resp[0] != version {
+		return errs.New("unexpected socks version %d", resp[0])
+	}
+	if resp[1] != method {
+		return errs.New("socks server rejected authentication method")
+	}
+	if auth != nil {
+		if len(auth.Username) > 255 || len(auth.Password) > 255 {
+			return errs.New("socks credentials too long")
+		}
+		req := []byte{userPassVersion, byte(len(auth.Username))}
+		req = append(req, auth.Username...)
+		req = append(req, byte(len(auth.Password)))
+		req = append(req, auth.Password...)
+		if _, err := rw.Write(req); err != nil {
+			return errs.Wrap(err)
+		}
+		if _, err := io.ReadFull(rw, resp[:]); err != nil {
+			return errs.Wrap(err)
+		}
+		if resp[1] != 0 {
+			return errs.New("socks authentication failed")
+		}
+	}
+
+	req := []byte{version, CmdConnect, 0}
+	req = appendAddr(req, host, port)
+	if _, err := rw.Write(req); err != nil {
+		return errs.Wrap(err)
+	}
+
+	var hdr [3]byte
+	if _, err := io.ReadFull(rw, hdr[:]); err != nil {
+		return errs.Wrap(err)
+	}
+	if hdr[0] != version {
+		return errs.New("unexpected socks version %d", hdr[0])
+	}
+	if hdr[1] != ReplySucceeded {
+		return errs.New("socks connect failed: reply %d", hdr[1])
+	}
+	// the bound address isn't useful to callers.
+	_, err = readAddr(rw)
+	return err
+}
+
+// Request is a client request read by a server.
+type Request struct {
+	// Command is the requested command, such as CmdConnect.
+	Command byte
+	// Address is the requested destination as host:port.
+	Address string
+}
+
+// ReadRequest performs the server side of method negotiation and reads the
+// client's request. If authenticate is nil, only clients offering no
+// authentication are accepted; otherwise username/password authentication
+// is required and checked with authenticate.
+func ReadRequest(rw io.ReadWriter, authenticate func(Auth) bool) (*Request, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(rw, hdr[:]); err != nil {
+		return nil, errs.Wrap(err)
+	}
+	if hdr[0] != version {
+		return nil, errs.New("unexpected socks version %d", hdr[0])
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(rw, methods); err != nil {
+		return nil, errs.Wrap(err)
+	}
+	want := byte(methodNoAuth)
+	if authenticate != nil {
+		want = methodUserPass
+	}
+	offered := false
+	for _, m := range methods {
+		offered = offered || m == want
+	}
+	if !offered {
+		_, _ = rw.Write([]byte{version, methodNoAcceptable})
+		return nil, errs.New("no acceptable socks authentication method")
+	}
+	if _, err := rw.Write([]byte{version, want}); err != nil {
+		return nil, errs.Wrap(err)
+	}
+	if authenticate != nil {
+		auth, err := readUserPass(rw)
+		if err != nil {
+			return nil, err
+		}
+		if !authenticate(auth) {
+			_, _ = rw.Write([]byte{userPassVersion, 1})
+			return nil, errs.New("socks authentication failed")
+		}
+		if _, err := rw.Write([]byte{userPassVersion, 0}); err != nil {
+			return nil, errs.Wrap(err)
+		}
+	}
+
+	var req [3]byte
+	if _, err := io.ReadFull(rw, req[:]); err != nil {
+		return nil, errs.Wrap(err)
+	}
+	if req[0] != version {
+		return nil, errs.New("unexpected socks version %d", req[0])
+	}
+	address, err := readAddr(rw)
+	if err != nil {
+		return nil, err
+	}
+	return &Request{Command: req[1], Address: address}, nil
+}
+
+// WriteReply sends a reply to a request with the given reply code and
+// bound address, which may be nil.
+func WriteReply(w io.Writer, reply byte, bound net.Addr) error {
+	host, port := "0.0.0.0", 0
+	if tcp, ok := bound.(*net.TCPAddr); ok {
+		host, port = tcp.IP.String(), tcp.Port
+	}
+	_, err := w.Write(appendAddr([]byte{version, reply, 0}, host, port))
+	return errs.Wrap(err)
+}
+
+func readUserPass(r io.Reader) (auth Auth, err error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return auth, errs.Wrap(err)
+	}
+	if hdr[0] != userPassVersion {
+		return auth, errs.New("unexpected socks auth version %d", hdr[0])
+	}
+	user := make([]byte, hdr[1])
+	if _, err := io.ReadFull(r, user); err != nil {
+		return auth, errs.Wrap(err)
+	}
+	if _, err := io.ReadFull(r, hdr[:1]); err != nil {
+		return auth, errs.Wrap(err)
+	}
+	pass := make([]byte, hdr[0])
+	if _, err := io.ReadFull(r, pass); err != nil {
+		return auth, errs.Wrap(err)
+	}
+	return Auth{Username: string(user), Password: string(pass)}, nil
+}
+
+func splitHostPort(address string) (host string, port int, err error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, errs.Wrap(err)
+	}
+	port, err = strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 0xffff {
+		return "", 0, errs.New("invalid port %q", portStr)
+	}
+	if len(host) > 255 {
+		return "", 0, errs.New("host name too long")
+	}
+	return host, port, nil
+}
+
+func appendAddr(b []byte, host string, port int) []byte {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(append(b, atypIPv4), ip4...)
+		} else {
+			b = append(append(b, atypIPv6), ip.To16()...)
+		}
+	} else {
+		b = append(append(b, atypDomain, byte(len(host))), host...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port))
+}
+
+func readAddr(r io.Reader) (string, error) {
+	var atyp [1]byte
+	if _, err := io.ReadFull(r, atyp[:]); err != nil {
+		return "", errs.Wrap(err)
+	}
+	var host string
+	switch atyp[0] {
+	case atypIPv4, atypIPv6:
+		ip := make(net.IP, 4)
+		if atyp[0] == atypIPv6 {
+			ip = make(net.IP, 16)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", errs.Wrap(err)
+		}
+		host = ip.String()
+	case atypDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return "", errs.Wrap(err)
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", errs.Wrap(err)
+		}
+		host = string(name)
+	default:
+		return "", errs.New("unknown socks address type %d", atyp[0])
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", errs.Wrap(err)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
@@ -0,0 +1,224 @@
+package noiseconn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+// ErrIdleTimeout is returned by reads once nothing was received from the
+// peer for Options.IdleTimeout. The connection is closed.
+var ErrIdleTimeout = errors.New("peer idle timeout")
+
+// minIdleTimeout is the smallest Options.IdleTimeout, and the smallest
+// idle timeout a peer may announce.
+const minIdleTimeout = 100 * time.Millisecond
+
+// keepalive is the state behind Options.KeepaliveInterval and IdleTimeout,
+// whose goroutine also sends the pings of Options.PingInterval.
+// Peers that can receive control frames announce both durations in the
+// extension block of their first handshake message. Once the handshake
+// completes, each side sends keepalive control frames often enough for the
+// idle timeout of the peer, and only enforces its own idle timeout if the
+// peer announced that it does so.
+type keepalive struct {
+	interval    time.Duration
+	idleTimeout time.Duration
+	ping        time.Duration
+
+	// announced is whether the peer announced its durations, protected
+	// by c.hsMu.
+	announced   bool
+	peerTimeout time.Duration
+
+	// send and timeout are the negotiated durations, set when the
+	// handshake completes and protected by c.hsMu.
+	send    time.Duration
+	timeout time.Duration
+
+	// lastSent is when a frame was last sent, and readStart when a read of
+	// the next frame started, in Unix nanoseconds. reading is set while
+	// the read is in progress.
+	lastSent  atomic.Int64
+	readStart atomic.Int64
+	reading   uint32
+	expired   uint32
+
+	// stop stops the keepalive goroutine. It is protected by c.hsMu.
+	stop    chan struct{}
+	stopped bool
+}
+
+func appendDurationMillis(b []byte, d time.Duration) []byte {
+	return binary.BigEndian.AppendUint32(b, uint32(d/time.Millisecond))
+}
+
+// keepaliveExtension returns the extension announcing the keepalive
+// durations, if it is sent in the next handshake message. c.hsMu must be
+// held.
+func (c *Conn) keepaliveExtension() (extension, bool) {
+	if !c.supportsControl() || c.hs.MessageIndex() >= 2 {
+		return extension{}, false
+	}
+	// the first message written by either side.
+	value := appendDurationMillis(nil, c.keepalive.interval)
+	value = appendDurationMillis(value, c.keepalive.idleTimeout)
+	return extension{typ: extKeepalive, value: value}, true
+}
+
+// readKeepalive reads the durations announced by the peer. c.hsMu must be
+// held.
+func (c *Conn) readKeepalive(value []byte) error {
+	if len(value) != 8 {
+		return errs.New("malformed keepalive durations")
+	}
+	k := &c.keepalive
+	// the keepalive interval of the peer is only informational.
+	k.peerTimeout = time.Duration(binary.BigEndian.Uint32(value[4:])) * time.Millisecond
+	if k.peerTimeout != 0 && k.peerTimeout < minIdleTimeout {
+		return errs.New("peer idle timeout %v is too short", k.peerTimeout)
+	}
+	k.announced = true
+	return nil
+}
+
+// negotiateKeepalive negotiates the keepalive durations once the handshake
+// completes. The goroutine using them is spawned once the last handshake
+// message and the completion control frames were written, so that
+// keepalives and pings can't be interleaved with them. c.hsMu must be held.
+func (c *Conn) negotiateKeepalive() {
+	k := &c.keepalive
+	k.send = k.interval
+	if k.announced && k.peerTimeout > 0 {
+		// keepalives are checked every half interval, so the peer hears
+		// from this side at least every half of its idle timeout.
+		if limit := k.peerTimeout / 3; k.send == 0 || k.send > limit {
+			k.send = limit
+		}
+	}
+	if !c.peerControl {
+		k.send = 0
+	}
+	if k.idleTimeout > 0 {
+		if k.announced && c.supportsControl() {
+			k.timeout = k.idleTimeout
+		} else {
+			c.log(LogWarn, "peer doesn't support keepalives, idle timeout disabled")
+		}
+	}
+}
+
+// spawnKeepalive starts the goroutine sending keepalives and pings and
+// enforcing the idle timeout with the negotiated durations, if any. c.hsMu
+// must be held.
+func (c *Conn) spawnKeepalive() {
+	k := &c.keepalive
+	ping := k.ping
+	if !c.peerControl {
+		ping = 0
+	}
+	if (k.send == 0 && k.timeout == 0 && ping == 0) || k.stopped {
+		return
+	}
+	tick := k.send / 2
+	if k.timeout > 0 && (tick == 0 || tick > k.timeout/4) {
+		tick = k.timeout / 4
+	}
+	if ping > 0 && (tick == 0 || tick > ping/2) {
+		tick = ping / 2
+	}
+	now := time.Now().UnixNano()
+	k.lastSent.Store(now)
+	k.readStart.Store(now)
+	c.rtt.lastPing.Store(now)
+	k.stop = make(chan struct{})
+	go c.runKeepalive(k.send, k.timeout, ping, tick, k.stop)
+}
+
+func (c *Conn) runKeepalive(send, timeout, ping, tick time.Duration, stop chan struct{}) {
+	k := &c.keepalive
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		if timeout > 0 && atomic.LoadUint32(&k.reading) != 0 &&
+			now.Sub(time.Unix(0, k.readStart.Load())) >= timeout {
+			atomic.StoreUint32(&k.expired, 1)
+			c.log(LogWarn, "peer idle timeout", "timeout", timeout)
+			_ = c.Conn.Close()
+			return
+		}
+		if ping > 0 && now.Sub(time.Unix(0, c.rtt.lastPing.Load())) >= ping {
+			// a ping is as good as a keepalive.
+			c.sendPing()
+		} else if send > 0 && now.Sub(time.Unix(0, k.lastSent.Load())) >= send {
+			c.sendKeepalive()
+		}
+	}
+}
+
+// sendKeepalive sends a keepalive control frame, unless a write is in
+// progress anyway.
+func (c *Conn) sendKeepalive() {
+	if !c.writeMu.TryLock() {
+		return
+	}
+	defer c.writeMu.Unlock()
+	buf, err := c.appendControl(c.writeMsgBuf[:0], controlKeepalive, nil)
+	if err != nil {
+		return
+	}
+	c.writeMsgBuf = buf
+	if err := c.writeFrames(buf); err != nil {
+		c.log(LogDebug, "sending keepalive failed", "error", err)
+	}
+}
+
+// stopKeepalive stops sending keepalives and enforcing the idle timeout.
+// c.hsMu must be held.
+func (c *Conn) stopKeepalive() {
+	k := &c.keepalive
+	if k.stop != nil && !k.stopped {
+		close(k.stop)
+	}
+	k.stopped = true
+}
+
+// beginFrameRead notes that a read of the next frame started, for the idle
+// timeout, and returns a function noting that it ended.
+func (c *Conn) beginFrameRead() func() {
+	k := &c.keepalive
+	if k.idleTimeout == 0 {
+		return noop
+	}
+	k.readStart.Store(time.Now().UnixNano())
+	atomic.StoreUint32(&k.reading, 1)
+	return func() { atomic.StoreUint32(&k.reading, 0) }
+}
+
+// idleTimeoutErr returns ErrIdleTimeout in place of err if the idle timeout
+// closed the connection.
+func (c *Conn) idleTimeoutErr(err error) error {
+	if err == nil || atomic.LoadUint32(&c.keepalive.expired) == 0 {
+		return err
+	}
+	return fmt.Errorf("%w: nothing received for %v", ErrIdleTimeout, c.keepalive.idleTimeout)
+}
+
+// Keepalive returns the negotiated interval after which a keepalive is sent
+// if nothing else was, and the idle timeout enforced on the peer, or zero
+// durations if not used. They are known once the handshake completes.
+func (c *Conn) Keepalive() (interval, idleTimeout time.Duration) {
+	c.hsMu.Lock()
+	defer c.hsMu.Unlock()
+	return c.keepalive.send, c.keepalive.timeout
+}
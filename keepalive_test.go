@@ -0,0 +1,134 @@
+package noiseconn
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestKeepalive(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	pair := func(clientOpts Options) (*Conn, *Conn) {
+		c1, c2 := tcpPair()
+		client, err := NewConnWithOptions(c1, noise.Config{
+			CipherSuite: cs,
+			Pattern:     noise.HandshakeNN,
+			Initiator:   true,
+		}, clientOpts)
+		if err != nil {
+			panic(err)
+		}
+		server, err := NewConnWithOptions(c2, noise.Config{
+			CipherSuite: cs,
+			Pattern:     noise.HandshakeNN,
+		}, Options{IdleTimeout: 300 * time.Millisecond})
+		if err != nil {
+			panic(err)
+		}
+		var eg errgroup.Group
+		eg.Go(client.Handshake)
+		eg.Go(server.Handshake)
+		if err := eg.Wait(); err != nil {
+			panic(err)
+		}
+		return client, server
+	}
+
+	// the client keeps the server from timing out.
+	client, server := pair(Options{HandshakeExtensions: true})
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+	if interval, timeout := client.Keepalive(); interval != 100*time.Millisecond || timeout != 0 {
+		t.Fatalf("unexpected client keepalive: %v %v", interval, timeout)
+	}
+	if interval, timeout := server.Keepalive(); interval != 0 || timeout != 300*time.Millisecond {
+		t.Fatalf("unexpected server keepalive: %v %v", interval, timeout)
+	}
+	if err := server.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		panic(err)
+	}
+	var b [1]byte
+	if _, err := server.Read(b[:]); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the read deadline to expire, got %v", err)
+	}
+
+	// without keepalives, the server times the client out.
+	client, server = pair(Options{HandshakeExtensions: true})
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+	client.hsMu.Lock()
+	client.stopKeepalive()
+	client.hsMu.Unlock()
+	start := time.Now()
+	if _, err := server.Read(b[:]); !errors.Is(err, ErrIdleTimeout) {
+		t.Fatalf("expected an idle timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Fatalf("timed out after %v", elapsed)
+	}
+}
+
+// overlapConn delays the first write, and records whether another write
+// started while one was in progress.
+type overlapConn struct {
+	net.Conn
+	writes  int32
+	writing int32
+	overlap int32
+}
+
+func (c *overlapConn) Write(b []byte) (int, error) {
+	if !atomic.CompareAndSwapInt32(&c.writing, 0, 1) {
+		atomic.StoreInt32(&c.overlap, 1)
+		return c.Conn.Write(b)
+	}
+	defer atomic.StoreInt32(&c.writing, 0)
+	if atomic.AddInt32(&c.writes, 1) == 1 {
+		time.Sleep(100 * time.Millisecond)
+	}
+	return c.Conn.Write(b)
+}
+
+func TestKeepaliveAfterHandshake(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	c1, c2 := tcpPair()
+	client, err := NewConnWithOptions(c1, noise.Config{
+		CipherSuite: cs,
+		Pattern:     noise.HandshakeNN,
+		Initiator:   true,
+	}, Options{HandshakeExtensions: true})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	// the last handshake message of the server is written slowly, and no
+	// keepalive may be sent before it.
+	slow := &overlapConn{Conn: c2}
+	server, err := NewConnWithOptions(slow, noise.Config{
+		CipherSuite: cs,
+		Pattern:     noise.HandshakeNN,
+	}, Options{KeepaliveInterval: 10 * time.Millisecond})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+	var eg errgroup.Group
+	eg.Go(client.Handshake)
+	eg.Go(server.Handshake)
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(&slow.overlap) != 0 {
+		t.Fatal("a keepalive was written during the handshake")
+	}
+	if atomic.LoadInt32(&slow.writes) < 2 {
+		t.Fatal("expected keepalives after the handshake")
+	}
+}
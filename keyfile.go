@@ -0,0 +1,303 @@
+package noiseconn
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/flynn/noise"
+	"github.com/zeebo/errs"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Key files are PEM encoded. The DH header names the DH function, and is
+// assumed to be 25519 when missing. Encrypted private keys additionally
+// have KDF and Salt headers, and the key is sealed with ChaChaPoly under
+// the key derived from the passphrase.
+const (
+	privateKeyBlock          = "NOISE PRIVATE KEY"
+	encryptedPrivateKeyBlock = "NOISE ENCRYPTED PRIVATE KEY"
+	publicKeyBlock           = "NOISE PUBLIC KEY"
+	dhHeader                 = "DH"
+	dh25519                  = "25519"
+	kdfHeader                = "KDF"
+	saltHeader               = "Salt"
+
+	scryptN, scryptR, scryptP = 1 << 15, 8, 1
+	// maxScryptN bounds the work an untrusted key file can demand.
+	maxScryptN = 1 << 20
+)
+
+// ErrPassphraseRequired is returned when decoding or loading an encrypted
+// private key without a passphrase.
+var ErrPassphraseRequired = errs.New("private key is encrypted")
+
+// EncodeKeypair encodes the Curve25519 keypair key in the key-file format.
+func EncodeKeypair(key noise.DHKey) []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type:    privateKeyBlock,
+		Headers: map[string]string{dhHeader: dh25519},
+		Bytes:   key.Private,
+	})
+}
+
+// DecodeKeypair decodes a Curve25519 keypair encoded with EncodeKeypair.
+func DecodeKeypair(data []byte) (noise.DHKey, error) {
+	if block, _ := pem.Decode(data); block != nil && block.Type == encryptedPrivateKeyBlock {
+		return noise.DHKey{}, ErrPassphraseRequired
+	}
+	private, err := decodeKeyBlock(data, privateKeyBlock)
+	if err != nil {
+		return noise.DHKey{}, err
+	}
+	return keypairFromPrivate(private)
+}
+
+// EncodeEncryptedKeypair encodes the Curve25519 keypair key in the
+// key-file format, encrypted with a key derived from passphrase with
+// scrypt.
+func EncodeEncryptedKeypair(key noise.DHKey, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errs.New("empty passphrase")
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, errs.Wrap(err)
+	}
+	kdf := fmt.Sprintf("scrypt N=%d r=%d p=%d", scryptN, scryptR, scryptP)
+	aead, err := keyFileAEAD(passphrase, salt, scryptN, scryptR, scryptP)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type: encryptedPrivateKeyBlock,
+		Headers: map[string]string{
+			dhHeader:   dh25519,
+			kdfHeader:  kdf,
+			saltHeader: base64.RawStdEncoding.EncodeToString(salt),
+		},
+		Bytes: aead.Seal(nil, make([]byte, aead.NonceSize()), key.Private, []byte(encryptedPrivateKeyBlock)),
+	}), nil
+}
+
+// DecodeEncryptedKeypair decodes a Curve25519 keypair encoded with
+// EncodeEncryptedKeypair or EncodeKeypair. passphrase is only used for
+// encrypted keys.
+func DecodeEncryptedKeypair(data, passphrase []byte) (noise.DHKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != encryptedPrivateKeyBlock {
+		return DecodeKeypair(data)
+	}
+	if len(passphrase) == 0 {
+		return noise.DHKey{}, ErrPassphraseRequired
+	}
+	if dh, ok := block.Headers[dhHeader]; ok && dh != dh25519 {
+		return noise.DHKey{}, errs.New("unsupported DH function %q", dh)
+	}
+	var n, r, p int
+	if _, err := fmt.Sscanf(block.Headers[kdfHeader], "scrypt N=%d r=%d p=%d", &n, &r, &p); err != nil {
+		return noise.DHKey{}, errs.New("unsupported KDF %q", block.Headers[kdfHeader])
+	}
+	if n > maxScryptN || r <= 0 || p <= 0 || r*p >= 1<<10 {
+		return noise.DHKey{}, errs.New("KDF parameters out of range")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(block.Headers[saltHeader])
+	if err != nil || len(salt) == 0 {
+		return noise.DHKey{}, errs.New("invalid salt")
+	}
+	aead, err := keyFileAEAD(passphrase, salt, n, r, p)
+	if err != nil {
+		return noise.DHKey{}, err
+	}
+	private, err := aead.Open(nil, make([]byte, aead.NonceSize()), block.Bytes, []byte(encryptedPrivateKeyBlock))
+	if err != nil {
+		return noise.DHKey{}, errs.New("wrong passphrase or corrupt key")
+	}
+	if len(private) != 32 {
+		return noise.DHKey{}, errs.New("invalid key length %d", len(private))
+	}
+	return keypairFromPrivate(private)
+}
+
+func keyFileAEAD(passphrase, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, n, r, p, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	aead, err := chacha20poly1305.New(key)
+	return aead, errs.Wrap(err)
+}
+
+func keypairFromPrivate(private []byte) (noise.DHKey, error) {
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		return noise.DHKey{}, errs.Wrap(err)
+	}
+	return noise.DHKey{Private: private, Public: public}, nil
+}
+
+// EncodePublicKey encodes the Curve25519 public key in the key-file
+// format.
+func EncodePublicKey(public []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type:    publicKeyBlock,
+		Headers: map[string]string{dhHeader: dh25519},
+		Bytes:   public,
+	})
+}
+
+// DecodePublicKey decodes a Curve25519 public key encoded with
+// EncodePublicKey. If data contains a keypair instead, its public key is
+// returned.
+func DecodePublicKey(data []byte) ([]byte, error) {
+	block, _ := pem.Decode(data)
+	if block != nil && block.Type == privateKeyBlock {
+		key, err := DecodeKeypair(data)
+		return key.Public, err
+	}
+	return decodeKeyBlock(data, publicKeyBlock)
+}
+
+// KeyFormat is the format of a private key file.
+type KeyFormat int
+
+const (
+	// KeyFormatPEM is the PEM key-file format of EncodeKeypair.
+	KeyFormatPEM KeyFormat = iota
+	// KeyFormatRaw is the 32 bytes of the private key.
+	KeyFormatRaw
+	// KeyFormatEncrypted is the passphrase-encrypted PEM key-file format of
+	// EncodeEncryptedKeypair.
+	KeyFormatEncrypted
+)
+
+// KeyFileOptions are options for SaveKeypair and LoadKeypair.
+type KeyFileOptions struct {
+	// Format is the format SaveKeypair writes. LoadKeypair detects the
+	// format.
+	Format KeyFormat
+
+	// Passphrase is the passphrase for KeyFormatEncrypted.
+	Passphrase []byte
+
+	// Overwrite allows SaveKeypair to replace an existing file.
+	Overwrite bool
+
+	// InsecurePermissions disables the permission checks of LoadKeypair.
+	InsecurePermissions bool
+}
+
+// SaveKeypair writes the Curve25519 keypair key to the file name, which is
+// only accessible by its owner. The file is replaced atomically if
+// opts.Overwrite is set, and otherwise must not exist.
+func SaveKeypair(name string, key noise.DHKey, opts KeyFileOptions) (err error) {
+	var data []byte
+	switch opts.Format {
+	case KeyFormatPEM:
+		data = EncodeKeypair(key)
+	case KeyFormatRaw:
+		data = append([]byte(nil), key.Private...)
+	case KeyFormatEncrypted:
+		data, err = EncodeEncryptedKeypair(key, opts.Passphrase)
+		if err != nil {
+			return err
+		}
+	default:
+		return errs.New("unknown key format %d", opts.Format)
+	}
+
+	if !opts.Overwrite {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return errs.Wrap(err)
+		}
+		return writeKeyFile(f, data)
+	}
+	// os.CreateTemp creates the file with mode 0600.
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".tmp*")
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	if err := writeKeyFile(f, data); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		_ = os.Remove(f.Name())
+		return errs.Wrap(err)
+	}
+	return nil
+}
+
+// writeKeyFile writes data to f and closes it, removing f on failure.
+func writeKeyFile(f *os.File, data []byte) error {
+	_, err := f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return errs.Wrap(err)
+	}
+	return nil
+}
+
+// LoadKeypair reads a Curve25519 keypair from the file name, in any of the
+// KeyFormats. Unless opts.InsecurePermissions is set, it refuses files
+// that aren't regular files or that are accessible by other users (on
+// systems with Unix permissions), as the key could have been read or
+// replaced by them.
+func LoadKeypair(name string, opts KeyFileOptions) (noise.DHKey, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return noise.DHKey{}, errs.Wrap(err)
+	}
+	defer func() { _ = f.Close() }()
+	if !opts.InsecurePermissions {
+		info, err := f.Stat()
+		if err != nil {
+			return noise.DHKey{}, errs.Wrap(err)
+		}
+		if !info.Mode().IsRegular() {
+			return noise.DHKey{}, errs.New("%s: not a regular file", filepath.Base(name))
+		}
+		if err := checkKeyFilePermissions(info); err != nil {
+			return noise.DHKey{}, errs.New("%s: %v", filepath.Base(name), err)
+		}
+	}
+	data, err := io.ReadAll(io.LimitReader(f, 64<<10))
+	if err != nil {
+		return noise.DHKey{}, errs.Wrap(err)
+	}
+	if len(data) == 32 && !bytes.HasPrefix(data, []byte("-----")) {
+		return keypairFromPrivate(data)
+	}
+	return DecodeEncryptedKeypair(data, opts.Passphrase)
+}
+
+func decodeKeyBlock(data []byte, typ string) ([]byte, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errs.New("no key found")
+	}
+	if block.Type != typ {
+		return nil, errs.New("unexpected key type %q", block.Type)
+	}
+	if dh, ok := block.Headers[dhHeader]; ok && dh != dh25519 {
+		return nil, errs.New("unsupported DH function %q", dh)
+	}
+	if len(block.Bytes) != 32 {
+		return nil, errs.New("invalid key length %d", len(block.Bytes))
+	}
+	return block.Bytes, nil
+}
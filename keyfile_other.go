@@ -0,0 +1,11 @@
+//go:build !unix
+
+package noiseconn
+
+import "os"
+
+// checkKeyFilePermissions does nothing on systems without Unix
+// permissions.
+func checkKeyFilePermissions(info os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,121 @@
+package noiseconn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/flynn/noise"
+)
+
+func TestKeyFile(t *testing.T) {
+	key, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	decoded, err := DecodeKeypair(EncodeKeypair(key))
+	if err != nil {
+		panic(err)
+	}
+	if !bytes.Equal(decoded.Private, key.Private) || !bytes.Equal(decoded.Public, key.Public) {
+		t.Fatal("keypair mismatch")
+	}
+
+	for _, data := range [][]byte{EncodePublicKey(key.Public), EncodeKeypair(key)} {
+		public, err := DecodePublicKey(data)
+		if err != nil {
+			panic(err)
+		}
+		if !bytes.Equal(public, key.Public) {
+			t.Fatal("public key mismatch")
+		}
+	}
+
+	if _, err := DecodeKeypair(EncodePublicKey(key.Public)); err == nil {
+		t.Fatal("expected error decoding a public key as keypair")
+	}
+	if Fingerprint(key.Public) == Fingerprint(decoded.Private) {
+		t.Fatal("fingerprints of different keys match")
+	}
+}
+
+func TestKeyFileEncrypted(t *testing.T) {
+	key, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	data, err := EncodeEncryptedKeypair(key, []byte("hunter2"))
+	if err != nil {
+		panic(err)
+	}
+	if bytes.Contains(data, EncodeKeypair(key)) {
+		t.Fatal("private key not encrypted")
+	}
+
+	decoded, err := DecodeEncryptedKeypair(data, []byte("hunter2"))
+	if err != nil {
+		panic(err)
+	}
+	if !bytes.Equal(decoded.Private, key.Private) || !bytes.Equal(decoded.Public, key.Public) {
+		t.Fatal("keypair mismatch")
+	}
+	if _, err := DecodeEncryptedKeypair(data, []byte("hunter3")); err == nil {
+		t.Fatal("expected error with the wrong passphrase")
+	}
+	if _, err := DecodeKeypair(data); !errors.Is(err, ErrPassphraseRequired) {
+		t.Fatalf("expected ErrPassphraseRequired, got %v", err)
+	}
+}
+
+func TestSaveLoadKeypair(t *testing.T) {
+	key, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	dir := t.TempDir()
+
+	for _, opts := range []KeyFileOptions{
+		{Format: KeyFormatPEM},
+		{Format: KeyFormatRaw},
+		{Format: KeyFormatEncrypted, Passphrase: []byte("hunter2")},
+	} {
+		name := filepath.Join(dir, fmt.Sprint("key", opts.Format))
+		if err := SaveKeypair(name, key, opts); err != nil {
+			panic(err)
+		}
+		if err := SaveKeypair(name, key, opts); err == nil {
+			t.Fatal("expected error overwriting a key without Overwrite")
+		}
+		opts.Overwrite = true
+		if err := SaveKeypair(name, key, opts); err != nil {
+			panic(err)
+		}
+		loaded, err := LoadKeypair(name, opts)
+		if err != nil {
+			panic(err)
+		}
+		if !bytes.Equal(loaded.Private, key.Private) || !bytes.Equal(loaded.Public, key.Public) {
+			t.Fatal("keypair mismatch")
+		}
+
+		if runtime.GOOS == "windows" {
+			continue
+		}
+		if err := os.Chmod(name, 0o644); err != nil {
+			panic(err)
+		}
+		if _, err := LoadKeypair(name, opts); err == nil {
+			t.Fatal("expected error loading a world-readable key")
+		}
+		opts.InsecurePermissions = true
+		if _, err := LoadKeypair(name, opts); err != nil {
+			panic(err)
+		}
+	}
+}
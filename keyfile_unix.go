@@ -0,0 +1,22 @@
+//go:build unix
+
+package noiseconn
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/zeebo/errs"
+)
+
+// checkKeyFilePermissions returns an error if a private key file is
+// accessible by anyone but its owner, or isn't owned by the current user.
+func checkKeyFilePermissions(info os.FileInfo) error {
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		return errs.New("permissions %#o are too open; the key must only be accessible by its owner", perm)
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Geteuid() {
+		return errs.New("owned by uid %d instead of the current user", st.Uid)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package noiseconn
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/flynn/noise"
+)
+
+// keyLogMu serializes writes to key logs, which are commonly shared by many
+// connections.
+var keyLogMu sync.Mutex
+
+// keyCapture is a noise.CipherSuite that remembers the last two keys it
+// created ciphers for. After the handshake, these are the keys derived by
+// Split for the initiator and the responder, in that order.
+type keyCapture struct {
+	noise.CipherSuite
+	keys [2][32]byte
+}
+
+func (k *keyCapture) Cipher(key [32]byte) noise.Cipher {
+	k.keys[0], k.keys[1] = k.keys[1], key
+	return k.CipherSuite.Cipher(key)
+}
+
+// writeKeyLog writes the traffic secrets of a completed handshake.
+func writeKeyLog(w io.Writer, handshakeHash []byte, kc *keyCapture) error {
+	keyLogMu.Lock()
+	defer keyLogMu.Unlock()
+	_, err := fmt.Fprintf(w, "NOISE_INITIATOR_TRAFFIC_SECRET %x %x %s\nNOISE_RESPONDER_TRAFFIC_SECRET %x %x %s\n",
+		handshakeHash, kc.keys[0], kc.Name(),
+		handshakeHash, kc.keys[1], kc.Name())
+	return err
+}
@@ -0,0 +1,98 @@
+package noiseconn
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+type recordingConn struct {
+	net.Conn
+	mu      sync.Mutex
+	written []byte
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	c.written = append(c.written, b...)
+	c.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+func TestKeyLog(t *testing.T) {
+	p1, p2 := net.Pipe()
+	rec := &recordingConn{Conn: p1}
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+
+	var clientLog, serverLog bytes.Buffer
+	client, err := NewConnWithOptions(rec, noise.Config{
+		CipherSuite: cs,
+		Pattern:     noise.HandshakeNN,
+		Initiator:   true,
+	}, Options{KeyLog: &clientLog})
+	if err != nil {
+		panic(err)
+	}
+	defer client.Close()
+	server, err := NewConnWithOptions(p2, noise.Config{
+		CipherSuite: cs,
+		Pattern:     noise.HandshakeNN,
+	}, Options{KeyLog: &serverLog})
+	if err != nil {
+		panic(err)
+	}
+	defer server.Close()
+
+	var eg errgroup.Group
+	eg.Go(client.Handshake)
+	eg.Go(server.Handshake)
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	if clientLog.String() != serverLog.String() {
+		t.Fatalf("key logs differ:\n%s\n%s", clientLog.String(), serverLog.String())
+	}
+
+	rec.mu.Lock()
+	rec.written = nil
+	rec.mu.Unlock()
+	eg.Go(func() error {
+		_, err := client.Write([]byte("secret"))
+		return err
+	})
+	buf := make([]byte, 6)
+	if _, err := server.Read(buf); err != nil {
+		panic(err)
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+
+	var label, hh, key, protocol string
+	line := strings.SplitN(clientLog.String(), "\n", 2)[0]
+	if _, err := fmt.Sscan(line, &label, &hh, &key, &protocol); err != nil {
+		panic(err)
+	}
+	if label != "NOISE_INITIATOR_TRAFFIC_SECRET" || hh != hex.EncodeToString(client.HandshakeHash()) ||
+		protocol != "25519_ChaChaPoly_BLAKE2b" {
+		t.Fatalf("unexpected key log line %q", line)
+	}
+	var k [32]byte
+	if _, err := hex.Decode(k[:], []byte(key)); err != nil {
+		panic(err)
+	}
+	plaintext, err := cs.Cipher(k).Decrypt(nil, 0, nil, rec.written[4:])
+	if err != nil {
+		panic(err)
+	}
+	if string(plaintext) != "secret" {
+		t.Fatalf("decrypted %q", plaintext)
+	}
+}
@@ -0,0 +1,197 @@
+package noiseconn
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/zeebo/errs"
+)
+
+// KnownHostsStore records the static public keys of peers, by host.
+type KnownHostsStore interface {
+	// Lookup returns the keys recorded for host. It returns no keys and no
+	// error for unknown hosts.
+	Lookup(host string) ([][]byte, error)
+
+	// Add records key for host.
+	Add(host string, key []byte) error
+}
+
+// HostKeyMismatchError is returned when a peer presents a static public
+// key other than the ones recorded for it.
+type HostKeyMismatchError struct {
+	Host  string
+	Known [][]byte
+	Got   []byte
+}
+
+// Error implements error.
+func (e *HostKeyMismatchError) Error() string {
+	known := make([]string, 0, len(e.Known))
+	for _, key := range e.Known {
+		known = append(known, Fingerprint(key))
+	}
+	return fmt.Sprintf("host key mismatch for %s: got %s, known %s",
+		e.Host, Fingerprint(e.Got), strings.Join(known, ", "))
+}
+
+// TOFU verifies peers on a trust-on-first-use basis, like SSH: the first
+// static public key a host presents is recorded in Store and accepted, and
+// later handshakes with the host fail with a *HostKeyMismatchError unless
+// the peer presents a recorded key. Use Verify as Options.VerifyPeer.
+type TOFU struct {
+	// Store records the keys.
+	Store KnownHostsStore
+
+	// Host returns the name keys are recorded under for the peer at addr.
+	// If nil, addr.String() is used.
+	Host func(addr net.Addr) string
+
+	mu sync.Mutex
+}
+
+// Verify is a PeerVerifier that verifies the peer at addr.
+func (t *TOFU) Verify(addr net.Addr, peerStatic []byte) error {
+	return t.verify(t.host(addr), peerStatic)
+}
+
+// Rotate records next as a key of the peer at addr, which presented
+// peerStatic, so that the peer can switch to it later. It can be used as
+// Options.NextPeerStatic.
+func (t *TOFU) Rotate(addr net.Addr, peerStatic, next []byte) error {
+	host := t.host(addr)
+	// the peer must currently be trusted.
+	if err := t.verify(host, peerStatic); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	known, err := t.Store.Lookup(host)
+	if err != nil {
+		return err
+	}
+	for _, key := range known {
+		if bytes.Equal(key, next) {
+			return nil
+		}
+	}
+	return t.Store.Add(host, next)
+}
+
+func (t *TOFU) host(addr net.Addr) string {
+	if t.Host != nil {
+		return t.Host(addr)
+	}
+	if addr != nil {
+		return addr.String()
+	}
+	return ""
+}
+
+// VerifierFor returns a PeerVerifier that verifies peers as host,
+// regardless of their address. This allows recording keys under the
+// address that was dialed instead of the one it resolved to.
+func (t *TOFU) VerifierFor(host string) PeerVerifier {
+	return func(addr net.Addr, peerStatic []byte) error {
+		return t.verify(host, peerStatic)
+	}
+}
+
+func (t *TOFU) verify(host string, peerStatic []byte) error {
+	if host == "" {
+		return errs.New("no host to verify the peer static key for")
+	}
+	// looking up and adding is serialized, so concurrent first handshakes
+	// with a host can't record different keys.
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	known, err := t.Store.Lookup(host)
+	if err != nil {
+		return err
+	}
+	if len(known) == 0 {
+		return t.Store.Add(host, peerStatic)
+	}
+	for _, key := range known {
+		if bytes.Equal(key, peerStatic) {
+			return nil
+		}
+	}
+	return &HostKeyMismatchError{Host: host, Known: known, Got: peerStatic}
+}
+
+// KnownHostsFile is a KnownHostsStore backed by a file. Every line of the
+// file is a host and a base64 static public key, separated by whitespace.
+// Empty lines and lines starting with # are ignored, and a host may have
+// multiple lines. The file is read on every lookup, so it can be edited
+// while in use, for example to remove a key that changed legitimately.
+type KnownHostsFile struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewKnownHostsFile returns a KnownHostsFile for the file at path, which
+// is created when the first key is added.
+func NewKnownHostsFile(path string) *KnownHostsFile {
+	return &KnownHostsFile{path: path}
+}
+
+// Lookup implements KnownHostsStore.
+func (f *KnownHostsFile) Lookup(host string) (keys [][]byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fh, err := os.Open(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	defer func() { _ = fh.Close() }()
+
+	scanner := bufio.NewScanner(fh)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, errs.New("%s:%d: malformed line", f.path, line)
+		}
+		if fields[0] != host {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, errs.New("%s:%d: invalid key: %v", f.path, line, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, errs.Wrap(scanner.Err())
+}
+
+// Add implements KnownHostsStore.
+func (f *KnownHostsFile) Add(host string, key []byte) error {
+	if host == "" || strings.ContainsAny(host, " \t\r\n") || strings.HasPrefix(host, "#") {
+		return errs.New("invalid host %q", host)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fh, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	_, err = fmt.Fprintf(fh, "%s %s\n", host, base64.StdEncoding.EncodeToString(key))
+	return errs.Combine(errs.Wrap(err), errs.Wrap(fh.Close()))
+}
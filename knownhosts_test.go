@@ -0,0 +1,57 @@
+package noiseconn
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTOFU(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	tofu := &TOFU{Store: NewKnownHostsFile(path)}
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}
+	key1, key2 := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+
+	// the first key is recorded, and then required.
+	if err := tofu.Verify(addr, key1); err != nil {
+		panic(err)
+	}
+	if err := tofu.Verify(addr, key1); err != nil {
+		panic(err)
+	}
+	var mismatch *HostKeyMismatchError
+	if err := tofu.Verify(addr, key2); !errors.As(err, &mismatch) || mismatch.Host != "127.0.0.1:1234" {
+		t.Fatalf("expected mismatch, got %v", err)
+	}
+
+	// other hosts are independent.
+	if err := tofu.VerifierFor("example.com:1234")(addr, key2); err != nil {
+		panic(err)
+	}
+
+	// the file can be edited, for example to add keys.
+	fh, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := fh.WriteString("\n# rotated\n127.0.0.1:1234 AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=\n"); err != nil {
+		panic(err)
+	}
+	if err := fh.Close(); err != nil {
+		panic(err)
+	}
+	if err := tofu.Verify(addr, key2); err != nil {
+		panic(err)
+	}
+
+	keys, err := tofu.Store.Lookup("127.0.0.1:1234")
+	if err != nil {
+		panic(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(keys))
+	}
+}
@@ -0,0 +1,69 @@
+package noiseconn
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// lifecycle tracks the state behind Options.OnConnected and
+// Options.OnClosed.
+type lifecycle struct {
+	onConnected func(c *Conn)
+	onClosed    func(c *Conn, reason error)
+
+	// connected is 1 once the handshake completed and 2 once onConnected
+	// was called.
+	connected uint32
+	closed    uint32
+
+	mu     sync.Mutex
+	reason error
+}
+
+// setConnected notes that the handshake completed. c.hsMu must be held.
+func (c *Conn) setConnected() {
+	atomic.CompareAndSwapUint32(&c.lifecycle.connected, 0, 1)
+}
+
+// afterIO is deferred by the methods that perform I/O, without any locks
+// held. It calls OnConnected once the handshake completed and records the
+// first failure of the connection as the reason for OnClosed.
+func (c *Conn) afterIO(err *error) {
+	*err = c.abortedErr(*err)
+	l := &c.lifecycle
+	if *err != nil && l.onClosed != nil && !isTransient(*err) {
+		l.mu.Lock()
+		if l.reason == nil {
+			l.reason = *err
+		}
+		l.mu.Unlock()
+	}
+	if l.onConnected != nil && atomic.CompareAndSwapUint32(&l.connected, 1, 2) {
+		l.onConnected(c)
+	}
+}
+
+// notifyClosed calls OnClosed once, without any locks held.
+func (c *Conn) notifyClosed() {
+	l := &c.lifecycle
+	if l.onClosed == nil || !atomic.CompareAndSwapUint32(&l.closed, 0, 1) {
+		return
+	}
+	l.mu.Lock()
+	reason := l.reason
+	l.mu.Unlock()
+	l.onClosed(c, reason)
+}
+
+// isTransient reports whether err doesn't describe why a connection ended:
+// timeouts can be retried, closed connection errors are caused by Close
+// itself, and ErrHandshakeRequired leaves the connection usable.
+func isTransient(err error) bool {
+	var netErr net.Error
+	return (errors.As(err, &netErr) && netErr.Timeout()) ||
+		errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe) ||
+		errors.Is(err, ErrHandshakeRequired)
+}
@@ -0,0 +1,104 @@
+package noiseconn
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestLifecycleCallbacks(t *testing.T) {
+	var mu sync.Mutex
+	connected := map[*Conn]int{}
+	reasons := map[*Conn][]error{}
+	opts := Options{
+		OnConnected: func(c *Conn) {
+			// the Conn may be used from the callback.
+			if !c.HandshakeComplete() {
+				t.Error("expected a completed handshake")
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			connected[c]++
+		},
+		OnClosed: func(c *Conn, reason error) {
+			mu.Lock()
+			defer mu.Unlock()
+			reasons[c] = append(reasons[c], reason)
+		},
+	}
+
+	p1, p2 := net.Pipe()
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	clientKey, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	serverKey, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	client, err := NewConnWithOptions(p1, noise.Config{
+		CipherSuite: cs, Pattern: noise.HandshakeXX, Initiator: true, StaticKeypair: clientKey,
+	}, opts)
+	if err != nil {
+		panic(err)
+	}
+	server, err := NewConnWithOptions(p2, noise.Config{
+		CipherSuite: cs, Pattern: noise.HandshakeXX, StaticKeypair: serverKey,
+	}, opts)
+	if err != nil {
+		panic(err)
+	}
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		if _, err := client.Write([]byte("hello")); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(client, make([]byte, 5)); err != nil {
+			return err
+		}
+		if _, err := client.Write([]byte("!")); err != nil {
+			return err
+		}
+		return client.Close()
+	})
+	eg.Go(func() error {
+		if _, err := io.ReadFull(server, make([]byte, 5)); err != nil {
+			return err
+		}
+		if _, err := server.Write([]byte("world")); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(server, make([]byte, 1)); err != nil {
+			return err
+		}
+		// the client closed the connection.
+		if _, err := server.Read(make([]byte, 1)); err == nil {
+			return errors.New("expected an error")
+		}
+		_ = server.Close()
+		return server.Close()
+	})
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if connected[client] != 1 || connected[server] != 1 {
+		t.Fatalf("expected OnConnected once per side, got %d and %d", connected[client], connected[server])
+	}
+	if len(reasons[client]) != 1 || reasons[client][0] != nil {
+		t.Fatalf("unexpected client close reasons %v", reasons[client])
+	}
+	if len(reasons[server]) != 1 || !errors.Is(reasons[server][0], io.ErrUnexpectedEOF) && !errors.Is(reasons[server][0], io.EOF) {
+		t.Fatalf("unexpected server close reasons %v", reasons[server])
+	}
+}
@@ -0,0 +1,61 @@
+package noiseconn
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+// lingerState is the state behind Options.Linger.
+type lingerState struct {
+	timeout time.Duration
+	// closeNotified is set once the close-notify, or the close reason,
+	// was sent, after which writes fail. It is protected by c.writeMu.
+	closeNotified bool
+}
+
+// linger lets the writes in progress finish and sends the close-notify,
+// for Options.Linger, before Close closes the underlying net.Conn.
+func (c *Conn) linger() {
+	if c.lingerState.timeout > 0 {
+		c.sendFinal(controlClose, nil, c.lingerState.timeout)
+	}
+}
+
+// sendFinal sends the last control frame of the connection, once the writes
+// in progress finished. Both are bounded by timeout, with a write deadline.
+func (c *Conn) sendFinal(typ byte, payload []byte, timeout time.Duration) {
+	// c.hsMu may be held by a handshake blocked on reading, so whether the
+	// handshake completed is learned without it.
+	if atomic.LoadUint32(&c.exported) != 0 || atomic.LoadUint32(&c.lifecycle.connected) == 0 {
+		return
+	}
+
+	// the deadline also fails writes waiting for Options.SendRate.
+	_ = c.SetWriteDeadline(time.Now().Add(timeout))
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.lingerState.closeNotified || !c.peerControl {
+		return
+	}
+	c.lingerState.closeNotified = true
+	buf, err := c.appendControl(c.writeMsgBuf[:0], typ, payload)
+	if err != nil {
+		return
+	}
+	c.writeMsgBuf = buf
+	if err := c.writeFrames(buf); err != nil {
+		c.log(LogDebug, "sending final control frame failed", "error", err)
+	}
+}
+
+// checkCloseNotified fails writes after the close-notify was sent. c.writeMu
+// must be held.
+func (c *Conn) checkCloseNotified() error {
+	if c.lingerState.closeNotified {
+		return errs.Wrap(net.ErrClosed)
+	}
+	return nil
+}
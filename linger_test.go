@@ -0,0 +1,89 @@
+package noiseconn
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestLinger(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	pair := func(linger time.Duration) (*Conn, *Conn) {
+		c1, c2 := tcpPair()
+		client, err := NewConn(c1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true},
+			WithSendRate(256<<10, 16<<10), WithLinger(linger))
+		if err != nil {
+			panic(err)
+		}
+		server, err := NewConn(c2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN},
+			WithHandshakeExtensions())
+		if err != nil {
+			panic(err)
+		}
+		var eg errgroup.Group
+		eg.Go(client.Handshake)
+		eg.Go(server.Handshake)
+		if err := eg.Wait(); err != nil {
+			panic(err)
+		}
+		return client, server
+	}
+
+	// the paced write finishes, and the server reads up to the
+	// close-notify.
+	client, server := pair(5 * time.Second)
+	defer func() { _ = server.Close() }()
+	var eg errgroup.Group
+	eg.Go(func() error {
+		_, err := client.Write(make([]byte, 64<<10))
+		return err
+	})
+	var received []byte
+	eg.Go(func() (err error) {
+		received, err = io.ReadAll(server)
+		return err
+	})
+	time.Sleep(50 * time.Millisecond)
+	if err := client.Close(); err != nil {
+		panic(err)
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	if len(received) != 64<<10 {
+		t.Fatalf("received %d bytes", len(received))
+	}
+	// reads keep ending at the close-notify.
+	for i := 0; i < 2; i++ {
+		if _, err := server.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("expected EOF, got %v", err)
+		}
+	}
+	if _, err := client.Write([]byte("late")); err == nil {
+		t.Fatal("write after close succeeded")
+	}
+
+	// the write that doesn't finish in time fails.
+	client, server = pair(100 * time.Millisecond)
+	defer func() { _ = server.Close() }()
+	go func() { _, _ = io.Copy(io.Discard, server) }()
+	written := make(chan error, 1)
+	go func() {
+		_, err := client.Write(make([]byte, 1<<20))
+		written <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	if err := client.Close(); err != nil {
+		panic(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("close lingered for %v", elapsed)
+	}
+	if err := <-written; err == nil {
+		t.Fatal("write didn't fail")
+	}
+}
@@ -1,35 +1,400 @@
 package noiseconn
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/flynn/noise"
 )
 
+// defaultHandshakeWorkers is the number of handshakes a Listener runs
+// concurrently if HandshakeWorkers is zero.
+const defaultHandshakeWorkers = 16
+
 type Listener struct {
 	net.Listener
 	config noise.Config
 	opts   Options
+
+	// CompleteHandshakes makes Accept only return connections whose
+	// handshake, including peer verification, succeeded. Connections that
+	// fail the handshake are closed without being returned. Handshakes are
+	// run in the background by HandshakeWorkers goroutines, so slow peers
+	// don't hold up the others, and no more connections are accepted from
+	// the underlying listener while all workers are busy. It must be set
+	// before the first call to Accept.
+	CompleteHandshakes bool
+
+	// HandshakeWorkers is how many handshakes are run concurrently with
+	// CompleteHandshakes. If zero, 16 are.
+	HandshakeWorkers int
+
+	// Policy, if set, decides which peers may connect, and implies
+	// CompleteHandshakes. Connections whose peer doesn't present a static
+	// key, or that Policy rejects, are closed without being returned. The
+	// policy is applied as soon as the static key of the peer is received,
+	// before any further handshake messages are sent.
+	Policy PeerPolicy
+
+	// HandshakeTimeout, if nonzero, bounds how long the handshakes run by
+	// the listener may take.
+	HandshakeTimeout time.Duration
+
+	// RateLimit, if set, limits how often connections are accepted.
+	// Connections it refuses are closed as soon as they are accepted from
+	// the underlying listener, before any handshake state is allocated,
+	// and aren't returned by Accept. With Options.ProxyProtocol, it is
+	// applied to the original client's address once the header was read
+	// by the handshake workers instead, and implies CompleteHandshakes.
+	RateLimit RateLimiter
+
+	// OnHandshakeFailure, if set, is called with the remote address, the
+	// stage and the error of every handshake that fails, and implies
+	// CompleteHandshakes. Failed handshakes aren't returned by Accept. It
+	// may be called concurrently.
+	OnHandshakeFailure func(addr net.Addr, stage HandshakeStage, err error)
+
+	// HandshakeLimit, if set, caps how many handshakes are in flight at
+	// once, and implies CompleteHandshakes. Connections wait for their
+	// handshake to start in the workers, or are closed if the limiter
+	// sheds them.
+	HandshakeLimit *HandshakeLimiter
+
+	// Fallback, if set, enables opportunistic encryption: connections
+	// whose first byte isn't the start of a Noise handshake, or of a TLS
+	// handshake handled by TLS, are passed to Fallback in a new goroutine,
+	// with the bytes read so far preserved, instead of being returned by
+	// Accept. It implies CompleteHandshakes, and is called after the PROXY
+	// protocol header, if any, was consumed. The Listener doesn't close
+	// the connections passed to Fallback.
+	Fallback func(conn net.Conn)
+
+	// TLS, if set, is passed the connections that start with a TLS
+	// handshake, wrapped with tls.Server and TLSConfig, in a new
+	// goroutine, so TLS and Noise can share a port. Like Fallback, it
+	// implies CompleteHandshakes, and the Listener doesn't close the
+	// connections passed to it.
+	TLS       func(conn *tls.Conn)
+	TLSConfig *tls.Config
+
+	// VirtualHosts, if set, routes connections by the server name that
+	// initiators request with Options.ServerName: the handshake of a
+	// connection uses the config and options of the VirtualHost for the
+	// name instead of the ones of the Listener, so several services with
+	// their own keys, verification and protocols can share an address.
+	// Connections without a known server name fail the handshake. It
+	// implies CompleteHandshakes.
+	VirtualHosts map[string]VirtualHost
+
+	startOnce  sync.Once
+	ctx        context.Context
+	cancel     func()
+	ready      chan *Conn
+	acceptErr  error
+	acceptDone chan struct{}
+	handshakes sync.WaitGroup
 }
 
 var _ net.Listener = (*Listener)(nil)
 
-func NewListener(inner net.Listener, config noise.Config) *Listener {
-	return NewListenerWithOptions(inner, config, Options{})
+// HandshakeStage is the stage at which a handshake run by a Listener failed.
+type HandshakeStage int
+
+const (
+	// HandshakeStageSetup is a failure to set up the handshake state.
+	HandshakeStageSetup HandshakeStage = iota
+	// HandshakeStageHandshake is a failure to exchange the handshake
+	// messages, including failed peer verification, closed connections
+	// and timeouts.
+	HandshakeStageHandshake
+	// HandshakeStagePolicy is a rejection by Listener.Policy.
+	HandshakeStagePolicy
+	// HandshakeStageAuth is a failed token exchange.
+	HandshakeStageAuth
+	// HandshakeStageLimit is a connection shed by Listener.HandshakeLimit.
+	HandshakeStageLimit
+)
+
+func (s HandshakeStage) String() string {
+	switch s {
+	case HandshakeStageSetup:
+		return "setup"
+	case HandshakeStageHandshake:
+		return "handshake"
+	case HandshakeStagePolicy:
+		return "policy"
+	case HandshakeStageAuth:
+		return "auth"
+	case HandshakeStageLimit:
+		return "limit"
+	}
+	return fmt.Sprintf("HandshakeStage(%d)", int(s))
+}
+
+func NewListener(inner net.Listener, config noise.Config, opts ...Option) *Listener {
+	return NewListenerWithOptions(inner, config, applyOptions(opts))
 }
 
 func (l *Listener) Accept() (net.Conn, error) {
-	conn, err := l.Listener.Accept()
+	if !l.completeHandshakes() {
+		conn, err := l.accept()
+		if err != nil {
+			return nil, err
+		}
+		return NewConnWithOptions(conn, l.config, l.opts)
+	}
+	l.startOnce.Do(l.start)
+	conn, ok := <-l.ready
+	if !ok {
+		return nil, l.acceptErr
+	}
+	return conn, nil
+}
+
+// Close closes the underlying listener and the connections whose handshake
+// is in progress or that weren't returned by Accept yet.
+func (l *Listener) Close() error {
+	l.cancel()
+	return l.Listener.Close()
+}
+
+// Shutdown stops accepting connections and waits for the handshakes in
+// progress to finish. If ctx is done first, the remaining handshakes are
+// interrupted and ctx.Err() is returned. Connections whose handshake
+// completed can still be returned by Accept, which returns an error once
+// they are drained. Close closes the ones that aren't.
+func (l *Listener) Shutdown(ctx context.Context) error {
+	err := l.Listener.Close()
+	if !l.completeHandshakes() {
+		return err
+	}
+	// the accept loop notices the closed listener and stops.
+	l.startOnce.Do(l.start)
+
+	drained := make(chan struct{})
+	go func() {
+		<-l.acceptDone
+		l.handshakes.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return err
+	case <-ctx.Done():
+		l.cancel()
+		<-drained
+		return ctx.Err()
+	}
+}
+
+func (l *Listener) log(level LogLevel, msg string, keyvals ...interface{}) {
+	if l.opts.Logger != nil {
+		l.opts.Logger.Log(level, msg, keyvals...)
+	}
+}
+
+// completeHandshakes returns whether the handshakes are run by the
+// listener before Accept returns.
+func (l *Listener) completeHandshakes() bool {
+	return l.CompleteHandshakes || l.Policy != nil || l.OnHandshakeFailure != nil || l.HandshakeLimit != nil ||
+		l.Fallback != nil || l.TLS != nil || l.VirtualHosts != nil || (l.RateLimit != nil && l.opts.ProxyProtocol)
+}
+
+// accept returns the next connection of the underlying listener that
+// l.RateLimit allows. With a PROXY protocol header, the limit is applied
+// by handshake instead.
+func (l *Listener) accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.RateLimit == nil || l.opts.ProxyProtocol || l.RateLimit.Allow(conn.RemoteAddr()) {
+			return conn, nil
+		}
+		l.log(LogDebug, "connection rate limited", "remote", conn.RemoteAddr())
+		_ = conn.Close()
+	}
+}
+
+// start starts accepting connections and running handshakes in the
+// background.
+func (l *Listener) start() {
+	workers := l.HandshakeWorkers
+	if workers <= 0 {
+		workers = defaultHandshakeWorkers
+	}
+	pending := make(chan net.Conn)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for conn := range pending {
+				l.serveHandshake(conn)
+			}
+		}()
+	}
+	go func() {
+		for {
+			conn, err := l.accept()
+			if err != nil {
+				l.acceptErr = err
+				break
+			}
+			l.handshakes.Add(1)
+			pending <- conn
+		}
+		close(l.acceptDone)
+		close(pending)
+		wg.Wait()
+		close(l.ready)
+	}()
+}
+
+// serveHandshake completes the handshake of conn and hands it to Accept.
+func (l *Listener) serveHandshake(conn net.Conn) {
+	if l.HandshakeLimit != nil {
+		if err := l.HandshakeLimit.acquire(l.ctx); err != nil {
+			l.handshakes.Done()
+			_ = conn.Close()
+			if !errors.Is(err, ErrHandshakeShed) {
+				return
+			}
+			l.log(LogWarn, "handshake shed", "remote", conn.RemoteAddr())
+			if l.OnHandshakeFailure != nil {
+				l.OnHandshakeFailure(conn.RemoteAddr(), HandshakeStageLimit, err)
+			}
+			return
+		}
+	}
+	nc, stage, err := l.handshake(conn)
+	if l.HandshakeLimit != nil {
+		l.HandshakeLimit.release()
+	}
+	l.handshakes.Done()
 	if err != nil {
-		return nil, err
+		_ = conn.Close()
+		if stage == HandshakeStageSetup {
+			// the other stages are logged by the Conn.
+			l.log(LogWarn, "handshake setup failed", "remote", conn.RemoteAddr(), "error", err)
+		}
+		if l.OnHandshakeFailure != nil {
+			l.OnHandshakeFailure(conn.RemoteAddr(), stage, err)
+		}
+		return
 	}
-	return NewConnWithOptions(conn, l.config, l.opts)
+	if nc == nil {
+		return
+	}
+	select {
+	case l.ready <- nc:
+	case <-l.ctx.Done():
+		_ = nc.Close()
+	}
+}
+
+// handshake completes the handshake of conn, applying l.Policy. On failure,
+// it returns the stage that failed, after closing the Conn, if one was
+// created, so that its registration and background work end with it. If
+// conn was passed to l.Fallback, or closed by l.RateLimit, it returns
+// neither a Conn nor an error.
+func (l *Listener) handshake(conn net.Conn) (*Conn, HandshakeStage, error) {
+	ctx := l.ctx
+	if l.HandshakeTimeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, l.HandshakeTimeout)
+		defer cancel()
+	}
+	opts := l.opts
+	if l.RateLimit != nil && opts.ProxyProtocol {
+		conn = &proxyConn{Conn: conn}
+		opts.ProxyProtocol = false
+		if !l.RateLimit.Allow(conn.RemoteAddr()) {
+			l.log(LogDebug, "connection rate limited", "remote", conn.RemoteAddr())
+			_ = conn.Close()
+			return nil, 0, nil
+		}
+	}
+	if l.Fallback != nil || l.TLS != nil {
+		if opts.ProxyProtocol {
+			conn = &proxyConn{Conn: conn}
+			opts.ProxyProtocol = false
+		}
+		var err error
+		conn, err = l.dispatch(ctx, conn)
+		if err != nil {
+			return nil, HandshakeStageHandshake, err
+		}
+		if conn == nil {
+			return nil, 0, nil
+		}
+	}
+	config := l.config
+	var serverName string
+	if l.VirtualHosts != nil {
+		if opts.ProxyProtocol {
+			conn = &proxyConn{Conn: conn}
+			opts.ProxyProtocol = false
+		}
+		var err error
+		serverName, config, opts, err = l.virtualHost(ctx, conn, opts)
+		if err != nil {
+			return nil, HandshakeStageHandshake, err
+		}
+	}
+	var rejected bool
+	if l.Policy != nil {
+		verifyPeer := opts.VerifyPeer
+		opts.VerifyPeer = func(addr net.Addr, peerStatic []byte) error {
+			if err := l.Policy.AllowPeer(addr, peerStatic); err != nil {
+				rejected = true
+				return err
+			}
+			if verifyPeer != nil {
+				return verifyPeer(addr, peerStatic)
+			}
+			return nil
+		}
+	}
+	nc, err := NewConnWithOptions(conn, config, opts)
+	if err != nil {
+		return nil, HandshakeStageSetup, err
+	}
+	nc.serverName = serverName
+	if err := nc.HandshakeContext(ctx); err != nil {
+		_ = nc.Close()
+		switch {
+		case rejected:
+			return nil, HandshakeStagePolicy, err
+		case nc.HandshakeComplete():
+			return nil, HandshakeStageAuth, err
+		}
+		return nil, HandshakeStageHandshake, err
+	}
+	if l.Policy != nil && len(nc.PeerStatic()) == 0 {
+		l.log(LogWarn, "peer did not present a static key", "remote", conn.RemoteAddr())
+		_ = nc.Close()
+		return nil, HandshakeStagePolicy, fmt.Errorf("%w: peer did not present a static key", ErrPeerRejected)
+	}
+	return nc, 0, nil
 }
 
 func NewListenerWithOptions(inner net.Listener, config noise.Config, opts Options) *Listener {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Listener{
 		Listener: inner,
 		config:   config,
 		opts:     opts,
+		ctx:      ctx,
+		cancel:   cancel,
+		ready:    make(chan *Conn),
+
+		acceptDone: make(chan struct{}),
 	}
 }
@@ -0,0 +1,461 @@
+package noiseconn
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+)
+
+func TestListenerCompleteHandshakes(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	l := NewListener(inner, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN})
+	l.CompleteHandshakes = true
+	l.HandshakeWorkers = 2
+	defer func() { _ = l.Close() }()
+
+	// a peer that never sends its handshake doesn't hold up others.
+	stalled, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = stalled.Close() }()
+
+	raw, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+	client, err := NewConn(raw, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	go func() { _, _ = client.Write([]byte("hello")) }()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !conn.(*Conn).HandshakeComplete() {
+		t.Fatal("expected a completed handshake")
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Fatal("unexpected data", err)
+	}
+	_ = conn.Close()
+
+	// closing the listener interrupts the stalled handshake and Accept.
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Accept(); err == nil {
+		t.Fatal("expected an error after Close")
+	}
+	_ = stalled.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := stalled.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal("expected the stalled connection to be closed, got", err)
+	}
+}
+
+func TestListenerHandshakeFailures(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	serverKey, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	denied, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	l := NewListener(inner, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeXX, StaticKeypair: serverKey})
+	l.Policy = NewPeerList()
+	l.Policy.(*PeerList).Deny(denied.Public)
+	type failure struct {
+		addr  net.Addr
+		stage HandshakeStage
+		err   error
+	}
+	failures := make(chan failure, 2)
+	l.OnHandshakeFailure = func(addr net.Addr, stage HandshakeStage, err error) {
+		failures <- failure{addr, stage, err}
+	}
+	defer func() { _ = l.Close() }()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Errorf("unexpected accepted connection from %v", conn.RemoteAddr())
+			_ = conn.Close()
+		}
+	}()
+
+	// a peer that disconnects without completing the handshake.
+	raw, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+	_ = raw.Close()
+	f := <-failures
+	if f.stage != HandshakeStageHandshake || f.err == nil || f.addr.String() != raw.LocalAddr().String() {
+		t.Fatalf("unexpected failure %v %v %v", f.addr, f.stage, f.err)
+	}
+
+	raw, err = net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+	client, err := NewConn(raw, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeXX, Initiator: true, StaticKeypair: denied})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	go func() { _ = client.Handshake() }()
+	f = <-failures
+	if f.stage != HandshakeStagePolicy || !errors.Is(f.err, ErrPeerRejected) {
+		t.Fatalf("unexpected failure %v %v", f.stage, f.err)
+	}
+}
+
+func TestListenerAuthFailureCloses(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	registry := NewRegistry()
+	l := NewListener(inner, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN},
+		WithRegistry(registry), WithVerifyToken(func(addr net.Addr, peerStatic, token []byte) error {
+			return errors.New("bad token")
+		}))
+	failures := make(chan HandshakeStage, 1)
+	l.OnHandshakeFailure = func(addr net.Addr, stage HandshakeStage, err error) { failures <- stage }
+	defer func() { _ = l.Close() }()
+	go func() { _, _ = l.Accept() }()
+
+	raw, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+	client, err := NewConn(raw, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true},
+		WithAuthToken([]byte("wrong")))
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	if err := client.Handshake(); !errors.Is(err, ErrTokenRejected) {
+		t.Fatalf("expected ErrTokenRejected, got %v", err)
+	}
+	if stage := <-failures; stage != HandshakeStageAuth {
+		t.Fatalf("unexpected failure stage %v", stage)
+	}
+	if n := registry.Len(); n != 0 {
+		t.Fatalf("%d connections still registered", n)
+	}
+}
+
+func TestListenerShutdown(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	limit := NewHandshakeLimiter(2)
+	l := NewListener(inner, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN})
+	l.HandshakeLimit = limit
+	defer func() { _ = l.Close() }()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+
+	dial := func() net.Conn {
+		raw, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			panic(err)
+		}
+		return raw
+	}
+	slow, stalled := dial(), dial()
+	defer func() { _ = stalled.Close() }()
+	for limit.InFlight() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- l.Shutdown(ctx) }()
+
+	// in-flight handshakes may still complete.
+	client, err := NewConn(slow, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	conn := <-accepted
+	if conn == nil {
+		t.Fatal("expected the completed handshake to be accepted")
+	}
+	_ = conn.Close()
+
+	if err := <-shutdown; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected the stalled handshake to time out, got", err)
+	}
+	_ = stalled.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := stalled.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal("expected the stalled connection to be closed, got", err)
+	}
+	if _, err := l.Accept(); err == nil {
+		t.Fatal("expected an error after Shutdown")
+	}
+}
+
+func TestListenerFallback(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	l := NewListener(inner, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN})
+	fallback := make(chan net.Conn, 1)
+	l.Fallback = func(conn net.Conn) { fallback <- conn }
+	defer func() { _ = l.Close() }()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+
+	plain, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = plain.Close() }()
+	if _, err := plain.Write([]byte("GET / HTTP/1.0\r\n")); err != nil {
+		panic(err)
+	}
+	conn := <-fallback
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "GET / HTTP/1.0\r\n" {
+		t.Fatal("unexpected fallback data", string(buf), err)
+	}
+	_ = conn.Close()
+
+	raw, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+	client, err := NewConn(raw, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	go func() { _, _ = client.Write([]byte("hello")) }()
+	nc := <-accepted
+	if nc == nil {
+		t.Fatal("expected the Noise connection to be accepted")
+	}
+	defer func() { _ = nc.Close() }()
+	buf = make([]byte, 5)
+	if _, err := io.ReadFull(nc, buf); err != nil || string(buf) != "hello" {
+		t.Fatal("unexpected data", string(buf), err)
+	}
+}
+
+func TestSniffClearsDeadline(t *testing.T) {
+	c1, c2 := tcpPair()
+	defer func() { _ = c1.Close() }()
+	defer func() { _ = c2.Close() }()
+	if _, err := c1.Write([]byte("ab")); err != nil {
+		panic(err)
+	}
+
+	// a done context without a deadline interrupts the read with a deadline
+	// in the past, which must not outlive sniff.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, _ = sniff(ctx, c2, 1)
+	buf := make([]byte, 1)
+	if _, err := c2.Read(buf); err != nil {
+		t.Fatal("read deadline left behind:", err)
+	}
+}
+
+func TestListenerTLS(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	l := NewListener(inner, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN})
+	l.TLSConfig = &tls.Config{Certificates: []tls.Certificate{selfSignedCert()}}
+	l.TLS = func(conn *tls.Conn) {
+		defer func() { _ = conn.Close() }()
+		_, _ = io.Copy(conn, conn)
+	}
+	fallback := make(chan net.Conn, 1)
+	l.Fallback = func(conn net.Conn) { fallback <- conn }
+	defer func() { _ = l.Close() }()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Errorf("unexpected accepted connection from %v", conn.RemoteAddr())
+			_ = conn.Close()
+		}
+	}()
+
+	conn, err := tls.Dial("tcp", inner.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Fatal("unexpected echo", string(buf), err)
+	}
+
+	plain, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = plain.Close() }()
+	if _, err := plain.Write([]byte("plain")); err != nil {
+		panic(err)
+	}
+	fb := <-fallback
+	defer func() { _ = fb.Close() }()
+	if _, err := io.ReadFull(fb, buf); err != nil || string(buf) != "plain" {
+		t.Fatal("unexpected fallback data", string(buf), err)
+	}
+}
+
+func selfSignedCert() tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestListenerVirtualHosts(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	keyA, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	keyB, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	l := NewListener(inner, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN})
+	l.VirtualHosts = map[string]VirtualHost{
+		"a": {Config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNK, StaticKeypair: keyA}},
+		"b": {Config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeXX, StaticKeypair: keyB}},
+	}
+	failures := make(chan error, 1)
+	l.OnHandshakeFailure = func(addr net.Addr, stage HandshakeStage, err error) { failures <- err }
+	defer func() { _ = l.Close() }()
+
+	dial := func(name string, config noise.Config) *Conn {
+		raw, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			panic(err)
+		}
+		config.CipherSuite, config.Initiator = cs, true
+		client, err := NewConnWithOptions(raw, config, Options{ServerName: name})
+		if err != nil {
+			panic(err)
+		}
+		return client
+	}
+
+	clientKey, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	for _, test := range []struct {
+		name   string
+		config noise.Config
+		key    []byte
+	}{
+		{name: "a", config: noise.Config{Pattern: noise.HandshakeNK, PeerStatic: keyA.Public}},
+		{name: "b", config: noise.Config{Pattern: noise.HandshakeXX, StaticKeypair: clientKey}, key: keyB.Public},
+	} {
+		client := dial(test.name, test.config)
+		defer func() { _ = client.Close() }()
+		go func() {
+			if _, err := client.Write([]byte("hello")); err == nil {
+				_ = client.Handshake()
+			}
+		}()
+
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name := conn.(*Conn).ServerName(); name != test.name {
+			t.Fatalf("expected server name %q, got %q", test.name, name)
+		}
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+			t.Fatal("unexpected data", err)
+		}
+		if test.key != nil && string(client.PeerStatic()) != string(test.key) {
+			t.Fatal("unexpected server key")
+		}
+		_ = conn.Close()
+	}
+
+	// unknown server names fail the handshake.
+	client := dial("c", noise.Config{Pattern: noise.HandshakeNN})
+	defer func() { _ = client.Close() }()
+	go func() { _, _ = client.Write([]byte("hello")) }()
+	if err := <-failures; err == nil {
+		t.Fatal("expected the handshake to fail")
+	}
+}
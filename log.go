@@ -0,0 +1,53 @@
+package noiseconn
+
+// LogLevel is the severity of a logged event.
+type LogLevel int
+
+const (
+	// LogDebug is for routine events, such as handshakes starting.
+	LogDebug LogLevel = iota
+	// LogInfo is for notable events, such as completed handshakes.
+	LogInfo
+	// LogWarn is for failures, such as failed handshakes or peer
+	// verification.
+	LogWarn
+	// LogError is for failures that stop a listener.
+	LogError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "DEBUG"
+	case LogInfo:
+		return "INFO"
+	case LogWarn:
+		return "WARN"
+	case LogError:
+		return "ERROR"
+	}
+	return "UNKNOWN"
+}
+
+// Logger receives notable events of connections, listeners and dialers
+// with Options.Logger set. keyvals are alternating keys and values, as
+// with log/slog. Like Hooks, a Logger may be called concurrently and while
+// the Conn holds internal locks, so it must not block or call methods of
+// the Conn.
+type Logger interface {
+	Log(level LogLevel, msg string, keyvals ...interface{})
+}
+
+// LoggerFunc adapts a function to a Logger.
+type LoggerFunc func(level LogLevel, msg string, keyvals ...interface{})
+
+// Log implements Logger.
+func (f LoggerFunc) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	f(level, msg, keyvals...)
+}
+
+func (c *Conn) log(level LogLevel, msg string, keyvals ...interface{}) {
+	if c.logger != nil {
+		c.logger.Log(level, msg, append([]interface{}{"remote", c.Conn.RemoteAddr()}, keyvals...)...)
+	}
+}
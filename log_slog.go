@@ -0,0 +1,26 @@
+//go:build go1.21
+
+package noiseconn
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SlogLogger returns a Logger that logs to l.
+func SlogLogger(l *slog.Logger) Logger {
+	return LoggerFunc(func(level LogLevel, msg string, keyvals ...interface{}) {
+		var slevel slog.Level
+		switch level {
+		case LogDebug:
+			slevel = slog.LevelDebug
+		case LogInfo:
+			slevel = slog.LevelInfo
+		case LogWarn:
+			slevel = slog.LevelWarn
+		default:
+			slevel = slog.LevelError
+		}
+		l.Log(context.Background(), slevel, msg, keyvals...)
+	})
+}
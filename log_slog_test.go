@@ -0,0 +1,20 @@
+//go:build go1.21
+
+package noiseconn
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := SlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	logger.Log(LogDebug, "hidden")
+	logger.Log(LogWarn, "handshake failed", "error", "boom")
+	if got := buf.String(); strings.Contains(got, "hidden") || !strings.Contains(got, `level=WARN msg="handshake failed" error=boom`) {
+		t.Fatalf("unexpected log %q", got)
+	}
+}
@@ -0,0 +1,76 @@
+package noiseconn
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestLogger(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	logger := LoggerFunc(func(level LogLevel, msg string, keyvals ...interface{}) {
+		if len(keyvals)%2 != 0 {
+			t.Errorf("odd keyvals for %q", msg)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, fmt.Sprintf("%v %s", level, msg))
+	})
+
+	p1, p2 := net.Pipe()
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	clientKey, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	client, err := NewConnWithOptions(p1, noise.Config{
+		CipherSuite: cs, Pattern: noise.HandshakeXN, Initiator: true, StaticKeypair: clientKey,
+	}, Options{Logger: logger})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	server, err := NewConnWithOptions(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeXN}, Options{
+		Logger:     logger,
+		VerifyPeer: func(net.Addr, []byte) error { return errors.New("unknown peer") },
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		defer func() { _ = client.Close() }()
+		return client.Handshake()
+	})
+	eg.Go(func() error {
+		defer func() { _ = server.Close() }()
+		return server.Handshake()
+	})
+	if err := eg.Wait(); err == nil {
+		t.Fatal("expected the handshake to fail")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	got := strings.Join(events, "\n")
+	for _, want := range []string{
+		"DEBUG handshake started",
+		"INFO handshake completed",
+		"WARN peer verification failed",
+		"WARN handshake failed",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package noiseconn
+
+import (
+	"encoding/binary"
+
+	"github.com/flynn/noise"
+	"github.com/zeebo/errs"
+)
+
+// minMaxFrameSize is the smallest Options.MaxFrameSize, and the smallest
+// limit a peer may announce.
+const minMaxFrameSize = 512
+
+// frameLimit is the state behind Options.MaxFrameSize. With handshake
+// extensions, each side announces the largest transport frame payload it
+// accepts, noise.MaxMsgLen without MaxFrameSize, in the extension block of
+// its first handshake message, so peers also know that the limit of the
+// other side is respected. It is protected by c.hsMu until the
+// handshake completes, and read-only afterwards.
+type frameLimit struct {
+	local     int
+	announced bool
+	peer      int
+
+	// send is the payload limit of outgoing transport frames, and recv the
+	// size limit of incoming ones, or 0 if unlimited.
+	send int
+	recv int
+}
+
+// maxFrameExtension returns the extension announcing Options.MaxFrameSize,
+// if it is sent in the next handshake message. c.hsMu must be held.
+func (c *Conn) maxFrameExtension() (extension, bool) {
+	if c.hs.MessageIndex() >= 2 {
+		return extension{}, false
+	}
+	// the first message written by either side.
+	c.frameLimit.announced = true
+	size := c.frameLimit.local
+	if size == 0 {
+		size = noise.MaxMsgLen
+	}
+	return extension{typ: extMaxFrame, value: binary.BigEndian.AppendUint16(nil, uint16(size))}, true
+}
+
+// readMaxFrameSize reads the limit announced by the peer. c.hsMu must be
+// held.
+func (c *Conn) readMaxFrameSize(value []byte) error {
+	if len(value) != 2 {
+		return errs.New("malformed max frame size")
+	}
+	size := int(binary.BigEndian.Uint16(value))
+	if size < minMaxFrameSize {
+		return errs.New("peer max frame size %d is too small", size)
+	}
+	c.frameLimit.peer = size
+	return nil
+}
+
+// completeFrameLimit applies the limits once the handshake completes.
+// Incoming frames are only limited if the peer supports the extension and
+// received the announcement before sending transport frames. c.hsMu must
+// be held.
+func (c *Conn) completeFrameLimit() {
+	l := &c.frameLimit
+	if l.peer < noise.MaxMsgLen {
+		l.send = l.peer
+	}
+	if l.local > 0 && l.announced && l.peer > 0 {
+		// room for the authentication tag.
+		l.recv = l.local + 16
+	}
+}
+
+// checkFrameSize fails incoming transport frames larger than the
+// announced limit before they are buffered. c.readMu must be held.
+func (c *Conn) checkFrameSize(size int) error {
+	if c.frameLimit.recv == 0 || size <= c.frameLimit.recv {
+		return nil
+	}
+	return c.decryptFailed(errs.New("frame of %d bytes exceeds the limit of %d", size, c.frameLimit.recv), true)
+}
@@ -0,0 +1,88 @@
+package noiseconn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestMaxFrameSize(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	pair := func() (*Conn, *Conn, *recordingConn) {
+		p1, p2 := net.Pipe()
+		rec := &recordingConn{Conn: p1}
+		client, err := NewConnWithOptions(rec, noise.Config{
+			CipherSuite: cs,
+			Pattern:     noise.HandshakeNN,
+			Initiator:   true,
+		}, Options{HandshakeExtensions: true})
+		if err != nil {
+			panic(err)
+		}
+		server, err := NewConnWithOptions(p2, noise.Config{
+			CipherSuite: cs,
+			Pattern:     noise.HandshakeNN,
+		}, Options{MaxFrameSize: 1024})
+		if err != nil {
+			panic(err)
+		}
+		var eg errgroup.Group
+		eg.Go(client.Handshake)
+		eg.Go(server.Handshake)
+		if err := eg.Wait(); err != nil {
+			panic(err)
+		}
+		return client, server, rec
+	}
+
+	client, server, rec := pair()
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+	data := bytes.Repeat([]byte("x"), 10000)
+	var eg errgroup.Group
+	eg.Go(func() error {
+		_, err := client.Write(data)
+		return err
+	})
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(server, got); err != nil {
+		panic(err)
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("unexpected data")
+	}
+	rec.mu.Lock()
+	written := rec.written
+	rec.mu.Unlock()
+	for len(written) > 0 {
+		size := int(binary.BigEndian.Uint32(written) & 0xffffff)
+		if size > 1024+16 {
+			t.Fatalf("frame of %d bytes exceeds the limit", size)
+		}
+		written = written[4+size:]
+	}
+
+	// frames over the limit tear the connection down.
+	client, server, _ = pair()
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+	client.frameLimit.send = 0
+	eg.Go(func() error {
+		_, _ = client.Write(data)
+		return nil
+	})
+	if _, err := server.Read(got); !errors.Is(err, ErrDecryptFailed) {
+		t.Fatalf("expected a framing error, got %v", err)
+	}
+	_ = client.Close()
+	_ = eg.Wait()
+}
@@ -0,0 +1,197 @@
+package noiseconn
+
+import (
+	"github.com/zeebo/errs"
+)
+
+// MessageConn wraps a Conn to preserve message boundaries. Every WriteMsg
+// results in exactly one Noise message, and every ReadMsg returns the
+// payload of exactly one Noise message.
+//
+// Handshake messages without a payload (such as the ones generated when
+// ReadMsg has to drive the handshake forward) are not returned by ReadMsg,
+// so an empty message written before the handshake completes is not
+// delivered. Empty messages after the handshake are delivered.
+//
+// The Read and Write methods of the wrapped Conn must not be used once the
+// Conn is wrapped.
+type MessageConn struct {
+	*Conn
+}
+
+// NewMessageConn wraps conn, which must not have been read from or written
+// to yet.
+func NewMessageConn(conn *Conn) *MessageConn {
+	conn.msgMode = true
+	return &MessageConn{Conn: conn}
+}
+
+// WriteMsg sends b as a single Noise message. b must not be larger than
+// noise.MaxMsgLen, or less the Noise overhead with FramingLengthPrefixed.
+func (m *MessageConn) WriteMsg(b []byte) (err error) {
+	c := m.Conn
+	defer c.afterIO(&err)
+	defer c.beginWrite("WriteMsg")()
+	if c.capture != nil {
+		defer func() {
+			if err == nil {
+				c.capture.record(c.captureID, CaptureSentPlaintext, b)
+			}
+		}()
+	}
+	if len(b) > c.maxPayload(false) {
+		return errs.New("message too large: %d", len(b))
+	}
+	if err := c.requireHandshake(); err != nil {
+		return err
+	}
+	if err := c.authenticate(); err != nil {
+		return err
+	}
+
+	c.hsMu.Lock()
+	locked := true
+	unlocker := func() {
+		if locked {
+			locked = false
+			c.hsMu.Unlock()
+		}
+	}
+	if c.hs == nil {
+		unlocker()
+	} else {
+		defer unlocker()
+	}
+	if c.hs != nil && !c.hsResponsibility && c.writeWaitsForHS {
+		if err := c.waitHandshakeTurn(); err != nil {
+			return err
+		}
+	}
+	if c.hs != nil && !c.hsResponsibility {
+		err = c.hsRead()
+		if err != nil {
+			return err
+		}
+	}
+	if c.hs != nil {
+		if c.framing != FramingDefault && len(b) > c.hsPayloadLimit() {
+			return errs.New("message too large for a handshake message: %d", len(b))
+		}
+		c.writeMsgBuf, err = c.hsCreate(c.writeMsgBuf[:0], b)
+		if err != nil {
+			return err
+		}
+		err = c.writeHandshake(c.writeMsgBuf)
+		return errs.Wrap(err)
+	}
+	unlocker()
+
+	if len(b) > c.maxPayload(false) {
+		return errs.New("message too large for the peer: %d", len(b))
+	}
+
+	// control frames may be written concurrently.
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.checkCloseNotified(); err != nil {
+		return err
+	}
+	defer c.profile("encrypt")()
+	c.writeMsgBuf, err = c.appendPolicyRekey(c.writeMsgBuf[:0], len(b))
+	if err != nil {
+		return err
+	}
+	outlen := len(c.writeMsgBuf)
+	c.writeMsgBuf, err = c.send.Encrypt(append(c.writeMsgBuf, make([]byte, 4)...), nil, b)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	err = c.frame(c.writeMsgBuf[outlen:], c.writeMsgBuf[outlen+4:])
+	if err != nil {
+		return err
+	}
+	err = c.writeFrames(c.writeMsgBuf)
+	return errs.Wrap(err)
+}
+
+// ReadMsg returns the payload of the next Noise message. The returned slice
+// is owned by the caller.
+func (m *MessageConn) ReadMsg() (msg []byte, err error) {
+	c := m.Conn
+	defer c.afterIO(&err)
+	defer c.beginRead("ReadMsg")()
+	if c.capture != nil {
+		defer func() {
+			if err == nil {
+				c.capture.record(c.captureID, CaptureReceivedPlaintext, msg)
+			}
+		}()
+	}
+	if err := c.requireHandshake(); err != nil {
+		return nil, err
+	}
+	if err := c.authenticate(); err != nil {
+		return nil, err
+	}
+	if c.initiator {
+		c.readBarrier.Wait()
+	}
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	c.hsMu.Lock()
+	locked := true
+	unlocker := func() {
+		if locked {
+			locked = false
+			c.hsMu.Unlock()
+		}
+	}
+	if c.hs == nil {
+		unlocker()
+	} else {
+		defer unlocker()
+	}
+	popBuffered := func() []byte {
+		if len(c.readMsgs) == 0 {
+			return nil
+		}
+		msg := c.readMsgs[0]
+		c.readMsgs = c.readMsgs[1:]
+		c.consumeEarlyData(1)
+		return msg
+	}
+
+	if msg := popBuffered(); msg != nil {
+		return msg, nil
+	}
+	err = c.hsReadUntil(func() bool { return len(c.readMsgs) > 0 })
+	if err != nil {
+		return nil, err
+	}
+	if msg := popBuffered(); msg != nil {
+		return msg, nil
+	}
+	unlocker()
+
+	for {
+		var control bool
+		c.readMsgBuf, control, err = c.readMsg(c.readMsgBuf[:0])
+		if err != nil {
+			return nil, err
+		}
+		if !control {
+			break
+		}
+		if err := c.readControl(c.readMsgBuf); err != nil {
+			return nil, err
+		}
+	}
+	msg, err = c.decrypt(nil, c.readMsgBuf)
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	if msg == nil {
+		msg = []byte{}
+	}
+	return msg, nil
+}
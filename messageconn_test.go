@@ -0,0 +1,114 @@
+package noiseconn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestMessageConn(t *testing.T) {
+	p1, p2 := net.Pipe()
+
+	clientKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	serverKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	client, err := NewConn(p1, noise.Config{
+		CipherSuite:   noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+		Pattern:       noise.HandshakeIK,
+		Initiator:     true,
+		StaticKeypair: clientKey,
+		PeerStatic:    serverKey.Public,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer client.Close()
+
+	server, err := NewConn(p2, noise.Config{
+		CipherSuite:   noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+		Pattern:       noise.HandshakeIK,
+		Initiator:     false,
+		StaticKeypair: serverKey,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer server.Close()
+
+	mclient, mserver := NewMessageConn(client), NewMessageConn(server)
+
+	msgs := [][]byte{[]byte("early"), {}, bytes.Repeat([]byte("x"), 1000), []byte("y")}
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		for _, msg := range msgs {
+			if err := mclient.WriteMsg(msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	eg.Go(func() error {
+		for _, expected := range msgs {
+			msg, err := mserver.ReadMsg()
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(msg, expected) {
+				panic("failure")
+			}
+		}
+		return nil
+	})
+	err = eg.Wait()
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestMessageConnLinger(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	c1, c2 := tcpPair()
+	client, err := NewConn(c1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true},
+		WithLinger(time.Second))
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	server, err := NewConn(c2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, WithHandshakeExtensions())
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+	var eg errgroup.Group
+	eg.Go(client.Handshake)
+	eg.Go(server.Handshake)
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+
+	// once Close sent the close-notify, messages aren't sent anymore.
+	client.linger()
+	if err := client.SetWriteDeadline(time.Time{}); err != nil {
+		panic(err)
+	}
+	if err := NewMessageConn(client).WriteMsg([]byte("late")); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected the write to fail, got %v", err)
+	}
+	if _, err := NewMessageConn(server).ReadMsg(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
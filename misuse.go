@@ -0,0 +1,61 @@
+package noiseconn
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// misuseDetector tracks the calls in progress for
+// Options.DetectConcurrentUse.
+type misuseDetector struct {
+	enabled bool
+	reads   int32
+	writes  int32
+}
+
+// beginRead notes that op, a read side method, started, and panics if it
+// is misused concurrently. The returned func must be called once it
+// returns.
+func (c *Conn) beginRead(op string) func() {
+	d := &c.misuse
+	if !d.enabled {
+		return func() {}
+	}
+	if atomic.AddInt32(&d.reads, 1) != 1 {
+		atomic.AddInt32(&d.reads, -1)
+		panic(fmt.Sprintf("noiseconn: %s called concurrently with another read on the same Conn", op))
+	}
+	if msg := c.handshakeOverlap(op, &d.writes, "write"); msg != "" {
+		atomic.AddInt32(&d.reads, -1)
+		panic(msg)
+	}
+	return func() { atomic.AddInt32(&d.reads, -1) }
+}
+
+// beginWrite is like beginRead, for write side methods.
+func (c *Conn) beginWrite(op string) func() {
+	d := &c.misuse
+	if !d.enabled {
+		return func() {}
+	}
+	if atomic.AddInt32(&d.writes, 1) != 1 {
+		atomic.AddInt32(&d.writes, -1)
+		panic(fmt.Sprintf("noiseconn: %s called concurrently with another write on the same Conn", op))
+	}
+	if msg := c.handshakeOverlap(op, &d.reads, "read"); msg != "" {
+		atomic.AddInt32(&d.writes, -1)
+		panic(msg)
+	}
+	return func() { atomic.AddInt32(&d.writes, -1) }
+}
+
+// handshakeOverlap describes the misuse if a call of the other side is in
+// progress while the handshake is incomplete, unless Write leaves the
+// handshake to Read with Options.WriteWaitsForHandshake.
+func (c *Conn) handshakeOverlap(op string, other *int32, otherSide string) string {
+	if c.writeWaitsForHS || atomic.LoadInt32(other) == 0 || atomic.LoadUint32(&c.lifecycle.connected) != 0 {
+		return ""
+	}
+	return fmt.Sprintf("noiseconn: %s called concurrently with a %s before the handshake completed; "+
+		"call Handshake first or use Options.WriteWaitsForHandshake", op, otherSide)
+}
@@ -0,0 +1,69 @@
+package noiseconn
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestDetectConcurrentUse(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	newPair := func() (client, server *Conn) {
+		p1, p2 := net.Pipe()
+		client, err := NewConn(p1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true})
+		if err != nil {
+			panic(err)
+		}
+		server, err = NewConn(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, WithDetectConcurrentUse())
+		if err != nil {
+			panic(err)
+		}
+		return client, server
+	}
+	expectPanic := func(substr string, fn func()) {
+		defer func() {
+			r := recover()
+			if r == nil || !strings.Contains(r.(string), substr) {
+				t.Fatalf("expected a panic about %q, got %v", substr, r)
+			}
+		}()
+		fn()
+	}
+	// waitBlocked gives a call started in the background time to block.
+	waitBlocked := func() { time.Sleep(20 * time.Millisecond) }
+
+	client, server := newPair()
+	go func() { _, _ = server.Read(make([]byte, 1)) }()
+	waitBlocked()
+	expectPanic("Read called concurrently with another read", func() { _, _ = server.Read(make([]byte, 1)) })
+	expectPanic("Write called concurrently with a read before the handshake", func() { _, _ = server.Write([]byte("x")) })
+	_ = client.Close()
+	_ = server.Close()
+
+	// after the handshake, a read and a write may overlap.
+	client, server = newPair()
+	var eg errgroup.Group
+	eg.Go(client.Handshake)
+	eg.Go(server.Handshake)
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	go func() { _, _ = server.Read(make([]byte, 1)) }()
+	waitBlocked()
+	eg.Go(func() error {
+		_, err := client.Read(make([]byte, 1))
+		return err
+	})
+	if _, err := server.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := eg.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	_ = client.Close()
+	_ = server.Close()
+}
@@ -0,0 +1,750 @@
+package noiseconn
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+// Multipath bonding stripes a byte stream, such as the frames of a Conn,
+// across several underlying connections, so that a single Noise session
+// gets their aggregate throughput and survives the failure of all but one
+// of them. Every path starts with multipathMagic and the 16 byte ID of the
+// bond, followed by chunks: a type byte and a sequence number, with the
+// length and data for data chunks. Data chunks are numbered across all
+// paths, reordered by the receiver, and acknowledged cumulatively by ack
+// chunks. Unacknowledged chunks are retransmitted on the remaining paths
+// when a path fails. A graceful close is signaled by a fin chunk, which is
+// numbered and acknowledged like data.
+//
+// Paths after the first only join once the handshake of the Conn over the
+// bond completed: the listener answers their header with a random
+// challenge, and the initiator proves knowledge of a key derived from the
+// traffic keys of the session with an HMAC over the ID and the challenge,
+// so seeing the bond ID isn't enough to join it. The chunks themselves
+// aren't authenticated: the Noise session on top detects tampering, but
+// anyone who can modify a path can disrupt the connection, as with TCP.
+const (
+	multipathWindow        = 64
+	multipathMaxChunk      = 1 << 16
+	multipathMaxBuffered   = 1 << 20
+	multipathHeaderTimeout = 10 * time.Second
+	multipathFlushTimeout  = 5 * time.Second
+
+	multipathChallengeLen = 16
+)
+
+var multipathMagic = [4]byte{'N', 'M', 'P', 2}
+
+const (
+	chunkData = 0
+	chunkAck  = 1
+	chunkFin  = 2
+)
+
+// MultipathConn is an experimental net.Conn bonding several underlying
+// connections (paths), such as ones over different network interfaces,
+// into a single stream. A Conn over it performs one handshake, and its
+// frames are spread over the paths, by whichever path is ready to send
+// next. Paths that fail are dropped, and the data sent on them that the
+// peer didn't acknowledge is sent again on the others. The connection only
+// fails once all paths have failed.
+//
+// Initiators create it with NewMultipathConn and responders accept it
+// from a MultipathListener:
+//
+//	bond, err := noiseconn.NewMultipathConn(path1, path2)
+//	...
+//	conn, err := noiseconn.NewConn(bond, config)
+//
+//	lis := noiseconn.NewListener(noiseconn.NewMultipathListener(inner), config)
+type MultipathConn struct {
+	id            [16]byte
+	initiator     bool
+	local, remote net.Addr
+	onClose       func()
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	paths  []*multipathPath
+	err    error
+	closed bool
+
+	// nextSeq is the number of the next data chunk to send, and acked the
+	// number up to which the peer acknowledged them. queue holds the
+	// chunks to send, a subset of unacked.
+	nextSeq   uint64
+	acked     uint64
+	unacked   []multipathChunk
+	queue     []multipathChunk
+	finQueued bool
+
+	// next is the number of the next data chunk to receive, and
+	// advertised the one last acknowledged, which the peer may send up to
+	// a window beyond. pending holds the chunks received out of order and
+	// ready the data to read. Acks are only sent while ready is below
+	// multipathMaxBuffered, so it exceeds it by at most a window.
+	next       uint64
+	advertised uint64
+	pending    map[uint64][]byte
+	ready      []byte
+	ackDue     bool
+	finRecvd   bool
+	finSeq     uint64
+
+	// joinKey authenticates the paths joining after the first, once the
+	// Conn over the bond completed its handshake. Until then, the
+	// initiator holds them in unjoined.
+	joinKey  []byte
+	unjoined []net.Conn
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+type multipathPath struct {
+	conn net.Conn
+	dead bool
+}
+
+type multipathChunk struct {
+	seq  uint64
+	fin  bool
+	data []byte
+}
+
+var _ net.Conn = (*MultipathConn)(nil)
+
+// NewMultipathConn returns a MultipathConn over paths, which must all be
+// connected to the same MultipathListener. The paths after the first join
+// once the handshake of the Conn over the bond completed.
+func NewMultipathConn(paths ...net.Conn) (*MultipathConn, error) {
+	if len(paths) == 0 {
+		return nil, errs.New("no paths")
+	}
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, errs.Wrap(err)
+	}
+	m := newMultipathConn(id, true, paths[0])
+	if _, err := paths[0].Write(m.header()); err != nil {
+		for _, path := range paths {
+			_ = path.Close()
+		}
+		return nil, errs.Wrap(err)
+	}
+	if err := m.addPath(paths[0]); err != nil {
+		for _, path := range paths {
+			_ = path.Close()
+		}
+		return nil, err
+	}
+	m.unjoined = append(m.unjoined, paths[1:]...)
+	return m, nil
+}
+
+func newMultipathConn(id [16]byte, initiator bool, first net.Conn) *MultipathConn {
+	m := &MultipathConn{
+		id:        id,
+		initiator: initiator,
+		local:     first.LocalAddr(),
+		remote:    first.RemoteAddr(),
+		pending:   map[uint64][]byte{},
+	}
+	m.cond = sync.NewCond(&m.mu)
+	return m
+}
+
+// AddPath adds a path to a MultipathConn created with NewMultipathConn,
+// such as to replace a failed one. Before the handshake of the Conn over
+// the bond completed, the path joins once it did. The Conn must use the
+// MultipathConn directly as its underlying net.Conn.
+func (m *MultipathConn) AddPath(conn net.Conn) error {
+	if !m.initiator {
+		return errs.New("paths are added by the initiator")
+	}
+	m.mu.Lock()
+	key := m.joinKey
+	switch {
+	case m.closed || m.finQueued:
+		m.mu.Unlock()
+		return net.ErrClosed
+	case key == nil:
+		m.unjoined = append(m.unjoined, conn)
+		m.mu.Unlock()
+		return nil
+	}
+	m.mu.Unlock()
+	return m.joinPath(conn, key)
+}
+
+func (m *MultipathConn) header() []byte {
+	return append(multipathMagic[:len(multipathMagic):len(multipathMagic)], m.id[:]...)
+}
+
+// joinPath sends the header on conn, answers the challenge of the listener
+// with key and starts using it.
+func (m *MultipathConn) joinPath(conn net.Conn, key []byte) error {
+	if _, err := conn.Write(m.header()); err != nil {
+		return errs.Wrap(err)
+	}
+	var challenge [multipathChallengeLen]byte
+	_ = conn.SetReadDeadline(time.Now().Add(multipathHeaderTimeout))
+	if _, err := io.ReadFull(conn, challenge[:]); err != nil {
+		return errs.Wrap(err)
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	if _, err := conn.Write(m.joinMAC(key, challenge[:])); err != nil {
+		return errs.Wrap(err)
+	}
+	return m.addPath(conn)
+}
+
+func (m *MultipathConn) joinMAC(key, challenge []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(m.id[:])
+	_, _ = mac.Write(challenge)
+	return mac.Sum(nil)
+}
+
+// bind sets the key authenticating joining paths, and joins the paths the
+// initiator held back until then.
+func (m *MultipathConn) bind(key []byte) {
+	m.mu.Lock()
+	if m.joinKey != nil {
+		m.mu.Unlock()
+		return
+	}
+	m.joinKey = key
+	unjoined := m.unjoined
+	m.unjoined = nil
+	m.cond.Broadcast()
+	m.mu.Unlock()
+	for _, conn := range unjoined {
+		go func(conn net.Conn) {
+			if err := m.joinPath(conn, key); err != nil {
+				_ = conn.Close()
+			}
+		}(conn)
+	}
+}
+
+// waitJoinKey waits until the key authenticating joining paths is known,
+// or deadline passes.
+func (m *MultipathConn) waitJoinKey(deadline time.Time) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for m.joinKey == nil {
+		switch {
+		case m.closed || m.err != nil:
+			return nil, net.ErrClosed
+		case deadlinePassed(deadline):
+			return nil, os.ErrDeadlineExceeded
+		}
+		m.wait(deadline)
+	}
+	return m.joinKey, nil
+}
+
+// bindMultipath binds a MultipathConn under c to the session, once the
+// handshake completed. c.hsMu must be held, with the captured keys.
+func (c *Conn) bindMultipath() {
+	m, ok := c.Conn.(*MultipathConn)
+	if !ok {
+		return
+	}
+	mac := hmac.New(sha256.New, append(c.keyCapture.keys[0][:], c.keyCapture.keys[1][:]...))
+	_, _ = mac.Write([]byte("noiseconn multipath join"))
+	m.bind(mac.Sum(nil))
+}
+
+// addPath starts sending and receiving on conn, whose header was written
+// or read.
+func (m *MultipathConn) addPath(conn net.Conn) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case m.closed || m.finQueued:
+		return net.ErrClosed
+	case m.err != nil:
+		return m.err
+	}
+	p := &multipathPath{conn: conn}
+	m.paths = append(m.paths, p)
+	go m.send(p)
+	go m.receive(p)
+	return nil
+}
+
+// Paths returns the number of paths that haven't failed.
+func (m *MultipathConn) Paths() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.paths)
+}
+
+// wait waits for m.cond, or until deadline, if set. m.mu must be held.
+func (m *MultipathConn) wait(deadline time.Time) {
+	if !deadline.IsZero() {
+		t := time.AfterFunc(time.Until(deadline), func() {
+			m.mu.Lock()
+			m.cond.Broadcast()
+			m.mu.Unlock()
+		})
+		defer t.Stop()
+	}
+	m.cond.Wait()
+}
+
+func deadlinePassed(deadline time.Time) bool {
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
+// Read reads data received on any of the paths, in order.
+func (m *MultipathConn) Read(b []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for len(m.ready) == 0 {
+		switch {
+		case m.closed:
+			return 0, net.ErrClosed
+		case m.finRecvd && m.next > m.finSeq:
+			return 0, io.EOF
+		case m.err != nil:
+			return 0, m.err
+		case deadlinePassed(m.readDeadline):
+			return 0, os.ErrDeadlineExceeded
+		}
+		m.wait(m.readDeadline)
+	}
+	n := copy(b, m.ready)
+	m.ready = m.ready[n:]
+	if len(m.ready)+n >= multipathMaxBuffered && len(m.ready) < multipathMaxBuffered {
+		m.ackDue = true
+		m.cond.Broadcast()
+	}
+	return n, nil
+}
+
+// Write queues b to be sent on the paths. It blocks while too much data
+// hasn't been acknowledged by the peer.
+func (m *MultipathConn) Write(b []byte) (n int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for n < len(b) {
+		for {
+			switch {
+			case m.closed || m.finQueued:
+				return n, net.ErrClosed
+			case m.err != nil:
+				return n, m.err
+			case len(m.unacked) < multipathWindow:
+			case deadlinePassed(m.writeDeadline):
+				return n, os.ErrDeadlineExceeded
+			default:
+				m.wait(m.writeDeadline)
+				continue
+			}
+			break
+		}
+		size := min(len(b)-n, multipathMaxChunk)
+		chunk := multipathChunk{seq: m.nextSeq, data: append([]byte(nil), b[n:n+size]...)}
+		m.nextSeq++
+		m.unacked = append(m.unacked, chunk)
+		m.queue = append(m.queue, chunk)
+		m.cond.Broadcast()
+		n += size
+	}
+	return n, nil
+}
+
+// send writes acks, queued chunks and the fin chunk to p, whenever it is
+// the first path ready to.
+func (m *MultipathConn) send(p *multipathPath) {
+	var buf []byte
+	for {
+		m.mu.Lock()
+		for !p.dead && !m.ackDue && len(m.queue) == 0 {
+			m.cond.Wait()
+		}
+		if p.dead {
+			m.mu.Unlock()
+			return
+		}
+		buf = buf[:0]
+		switch {
+		case m.ackDue:
+			buf = appendChunkHeader(buf, chunkAck, m.next)
+			m.advertised = m.next
+			m.ackDue = false
+		default:
+			chunk := m.queue[0]
+			m.queue = m.queue[1:]
+			switch {
+			case chunk.seq < m.acked:
+				m.mu.Unlock()
+				continue
+			case chunk.fin:
+				buf = appendChunkHeader(buf, chunkFin, chunk.seq)
+			default:
+				buf = appendChunkHeader(buf, chunkData, chunk.seq)
+				buf = binary.BigEndian.AppendUint32(buf, uint32(len(chunk.data)))
+				buf = append(buf, chunk.data...)
+			}
+		}
+		m.mu.Unlock()
+
+		if _, err := p.conn.Write(buf); err != nil {
+			m.failPath(p, err)
+			return
+		}
+	}
+}
+
+func appendChunkHeader(b []byte, typ byte, seq uint64) []byte {
+	return binary.BigEndian.AppendUint64(append(b, typ), seq)
+}
+
+// receive reads chunks from p.
+func (m *MultipathConn) receive(p *multipathPath) {
+	var header [13]byte
+	for {
+		if _, err := io.ReadFull(p.conn, header[:9]); err != nil {
+			m.failPath(p, err)
+			return
+		}
+		var err error
+		switch typ, seq := header[0], binary.BigEndian.Uint64(header[1:9]); typ {
+		case chunkData:
+			if _, err = io.ReadFull(p.conn, header[9:13]); err != nil {
+				break
+			}
+			size := binary.BigEndian.Uint32(header[9:13])
+			if size > multipathMaxChunk {
+				err = errs.New("chunk of %d bytes is too large", size)
+				break
+			}
+			data := make([]byte, size)
+			if _, err = io.ReadFull(p.conn, data); err != nil {
+				break
+			}
+			err = m.deliver(seq, data, false)
+		case chunkAck:
+			err = m.ack(seq)
+		case chunkFin:
+			err = m.deliver(seq, nil, true)
+		default:
+			err = errs.New("unknown chunk type %d", typ)
+		}
+		if err != nil {
+			m.failPath(p, err)
+			return
+		}
+	}
+}
+
+// deliver handles a received data or fin chunk.
+func (m *MultipathConn) deliver(seq uint64, data []byte, fin bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// the fin chunk may follow a full window.
+	if seq > m.advertised+multipathWindow {
+		return errs.New("chunk %d is outside of the window", seq)
+	}
+	if fin {
+		m.finRecvd, m.finSeq = true, seq
+	}
+	if _, dup := m.pending[seq]; seq >= m.next && !dup {
+		m.pending[seq] = data
+		for {
+			data, ok := m.pending[m.next]
+			if !ok {
+				break
+			}
+			delete(m.pending, m.next)
+			m.ready = append(m.ready, data...)
+			m.next++
+		}
+	}
+	if len(m.ready) > multipathMaxBuffered+multipathWindow*multipathMaxChunk {
+		// the window prevents this, unless the peer ignores it.
+		return errs.New("peer sent more than was acknowledged")
+	}
+	// duplicates are acknowledged too, in case the ack was lost. While
+	// too much data hasn't been read, acks are held back to stop the
+	// peer once its window is full.
+	m.ackDue = len(m.ready) < multipathMaxBuffered
+	m.cond.Broadcast()
+	return nil
+}
+
+// ack handles an acknowledgement of the chunks before seq.
+func (m *MultipathConn) ack(seq uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if seq > m.nextSeq {
+		return errs.New("acknowledged chunk %d wasn't sent", seq)
+	}
+	for len(m.unacked) > 0 && m.unacked[0].seq < seq {
+		m.unacked[0] = multipathChunk{}
+		m.unacked = m.unacked[1:]
+	}
+	if seq > m.acked {
+		m.acked = seq
+	}
+	m.cond.Broadcast()
+	return nil
+}
+
+// failPath drops p after it failed with err, and sends the unacknowledged
+// chunks again on the remaining paths.
+func (m *MultipathConn) failPath(p *multipathPath, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p.dead {
+		return
+	}
+	p.dead = true
+	_ = p.conn.Close()
+	for i, other := range m.paths {
+		if other == p {
+			m.paths = append(m.paths[:i], m.paths[i+1:]...)
+			break
+		}
+	}
+	if len(m.paths) == 0 {
+		if m.err == nil {
+			m.err = errs.New("all paths failed, last with: %v", err)
+		}
+	} else {
+		m.queue = append([]multipathChunk(nil), m.unacked...)
+	}
+	m.cond.Broadcast()
+}
+
+// Close sends a fin chunk after the queued data and waits for the peer to
+// acknowledge both, for at most multipathFlushTimeout, before closing the
+// paths.
+func (m *MultipathConn) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return net.ErrClosed
+	}
+	if !m.finQueued && m.err == nil {
+		m.finQueued = true
+		fin := multipathChunk{seq: m.nextSeq, fin: true}
+		m.nextSeq++
+		m.unacked = append(m.unacked, fin)
+		m.queue = append(m.queue, fin)
+		m.cond.Broadcast()
+	}
+	deadline := time.Now().Add(multipathFlushTimeout)
+	for len(m.unacked) > 0 && len(m.paths) > 0 && !deadlinePassed(deadline) {
+		m.wait(deadline)
+	}
+	m.closed = true
+	paths := m.paths
+	m.paths = nil
+	for _, conn := range m.unjoined {
+		_ = conn.Close()
+	}
+	m.unjoined = nil
+	for _, p := range paths {
+		p.dead = true
+	}
+	m.cond.Broadcast()
+	m.mu.Unlock()
+
+	var group errs.Group
+	for _, p := range paths {
+		group.Add(p.conn.Close())
+	}
+	if m.onClose != nil {
+		m.onClose()
+	}
+	return group.Err()
+}
+
+// LocalAddr returns the local address of the first path.
+func (m *MultipathConn) LocalAddr() net.Addr { return m.local }
+
+// RemoteAddr returns the remote address of the first path.
+func (m *MultipathConn) RemoteAddr() net.Addr { return m.remote }
+
+// SetDeadline sets the read and write deadlines.
+func (m *MultipathConn) SetDeadline(t time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.readDeadline, m.writeDeadline = t, t
+	m.cond.Broadcast()
+	return nil
+}
+
+// SetReadDeadline sets the read deadline.
+func (m *MultipathConn) SetReadDeadline(t time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.readDeadline = t
+	m.cond.Broadcast()
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline, which bounds how long Write
+// waits for the peer to acknowledge data.
+func (m *MultipathConn) SetWriteDeadline(t time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.writeDeadline = t
+	m.cond.Broadcast()
+	return nil
+}
+
+// MultipathListener is a net.Listener accepting MultipathConns, whose
+// paths are the connections accepted by an inner net.Listener with the
+// same bond ID. Accept returns a MultipathConn when its first path
+// arrives, and later paths join it.
+type MultipathListener struct {
+	inner net.Listener
+
+	mu    sync.Mutex
+	bonds map[[16]byte]*MultipathConn
+
+	accept    chan *MultipathConn
+	done      chan struct{}
+	err       error
+	closeOnce sync.Once
+}
+
+var _ net.Listener = (*MultipathListener)(nil)
+
+// NewMultipathListener starts accepting paths from inner.
+func NewMultipathListener(inner net.Listener) *MultipathListener {
+	l := &MultipathListener{
+		inner:  inner,
+		bonds:  map[[16]byte]*MultipathConn{},
+		accept: make(chan *MultipathConn),
+		done:   make(chan struct{}),
+	}
+	go l.serve()
+	return l
+}
+
+func (l *MultipathListener) serve() {
+	for {
+		conn, err := l.inner.Accept()
+		if err != nil {
+			l.mu.Lock()
+			l.err = errs.Wrap(err)
+			l.mu.Unlock()
+			l.closeOnce.Do(func() { close(l.done) })
+			return
+		}
+		go l.join(conn)
+	}
+}
+
+// join reads the header of the path conn and adds it to its bond.
+func (l *MultipathListener) join(conn net.Conn) {
+	var header [len(multipathMagic) + 16]byte
+	deadline := time.Now().Add(multipathHeaderTimeout)
+	_ = conn.SetReadDeadline(deadline)
+	if _, err := io.ReadFull(conn, header[:]); err != nil || !bytes.Equal(header[:4], multipathMagic[:]) {
+		_ = conn.Close()
+		return
+	}
+	var id [16]byte
+	copy(id[:], header[4:])
+
+	l.mu.Lock()
+	if m, ok := l.bonds[id]; ok {
+		l.mu.Unlock()
+		if err := l.authenticate(m, conn, deadline); err != nil {
+			_ = conn.Close()
+			return
+		}
+		_ = conn.SetReadDeadline(time.Time{})
+		if err := m.addPath(conn); err != nil {
+			_ = conn.Close()
+		}
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	m := newMultipathConn(id, false, conn)
+	m.onClose = func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.bonds[id] == m {
+			delete(l.bonds, id)
+		}
+	}
+	l.bonds[id] = m
+	l.mu.Unlock()
+	if err := m.addPath(conn); err != nil {
+		_ = m.Close()
+		return
+	}
+	select {
+	case l.accept <- m:
+	case <-l.done:
+		_ = m.Close()
+	}
+}
+
+// authenticate checks that the path conn joining m knows its join key.
+func (l *MultipathListener) authenticate(m *MultipathConn, conn net.Conn, deadline time.Time) error {
+	key, err := m.waitJoinKey(deadline)
+	if err != nil {
+		return err
+	}
+	var challenge [multipathChallengeLen]byte
+	if _, err := rand.Read(challenge[:]); err != nil {
+		return errs.Wrap(err)
+	}
+	if _, err := conn.Write(challenge[:]); err != nil {
+		return errs.Wrap(err)
+	}
+	var answer [sha256.Size]byte
+	if _, err := io.ReadFull(conn, answer[:]); err != nil {
+		return errs.Wrap(err)
+	}
+	if !hmac.Equal(answer[:], m.joinMAC(key, challenge[:])) {
+		return errs.New("path failed to authenticate")
+	}
+	return nil
+}
+
+// Accept returns the next bond.
+func (l *MultipathListener) Accept() (net.Conn, error) {
+	select {
+	case m := <-l.accept:
+		return m, nil
+	case <-l.done:
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.err == nil {
+			return nil, net.ErrClosed
+		}
+		return nil, l.err
+	}
+}
+
+// Close closes the inner listener. Accepted MultipathConns aren't closed.
+func (l *MultipathListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return errs.Wrap(l.inner.Close())
+}
+
+// Addr returns the address of the inner listener.
+func (l *MultipathListener) Addr() net.Addr {
+	return l.inner.Addr()
+}
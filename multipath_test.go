@@ -0,0 +1,184 @@
+package noiseconn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestMultipath(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	lis := NewListener(NewMultipathListener(inner), noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN})
+	defer func() { _ = lis.Close() }()
+
+	var paths []net.Conn
+	for i := 0; i < 3; i++ {
+		path, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			panic(err)
+		}
+		paths = append(paths, path)
+	}
+	bond, err := NewMultipathConn(paths...)
+	if err != nil {
+		panic(err)
+	}
+	client, err := NewConn(bond, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	if n := bond.Paths(); n != 1 {
+		t.Fatalf("got %d paths before the handshake, want 1", n)
+	}
+
+	data := make([]byte, 4<<20)
+	if _, err := rand.Read(data); err != nil {
+		panic(err)
+	}
+	var eg errgroup.Group
+	eg.Go(func() error {
+		server, err := lis.Accept()
+		if err != nil {
+			return err
+		}
+		defer func() { _ = server.Close() }()
+		// the echo ends with the client closing.
+		if _, err := io.Copy(server, server); !errors.Is(err, io.EOF) {
+			return err
+		}
+		return nil
+	})
+	eg.Go(func() error {
+		// the other paths join once the handshake completed.
+		if err := client.Handshake(); err != nil {
+			return err
+		}
+		for bond.Paths() < 3 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		for i := 0; i < len(data); i += 64 << 10 {
+			if _, err := client.Write(data[i : i+64<<10]); err != nil {
+				return err
+			}
+			// a path fails during the transfer.
+			if i == len(data)/2 {
+				_ = paths[1].Close()
+			}
+		}
+		return nil
+	})
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(client, got); err != nil {
+		panic(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("data corrupted")
+	}
+	if n := bond.Paths(); n != 2 {
+		t.Fatalf("got %d paths, want 2", n)
+	}
+	if err := client.Close(); err != nil {
+		panic(err)
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+}
+
+func TestMultipathJoinAuthentication(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	ml := NewMultipathListener(inner)
+	lis := NewListener(ml, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN})
+	defer func() { _ = lis.Close() }()
+
+	path, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+	bond, err := NewMultipathConn(path)
+	if err != nil {
+		panic(err)
+	}
+	client, err := NewConn(bond, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	var eg errgroup.Group
+	eg.Go(func() error {
+		server, err := lis.Accept()
+		if err != nil {
+			return err
+		}
+		defer func() { _ = server.Close() }()
+		_, err = io.Copy(server, server)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	})
+	if err := client.Handshake(); err != nil {
+		panic(err)
+	}
+
+	// a path presenting the ID without the key is rejected.
+	forged, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = forged.Close() }()
+	if _, err := forged.Write(bond.header()); err != nil {
+		panic(err)
+	}
+	challenge := make([]byte, multipathChallengeLen)
+	if _, err := io.ReadFull(forged, challenge); err != nil {
+		panic(err)
+	}
+	if _, err := forged.Write(make([]byte, 32)); err != nil {
+		panic(err)
+	}
+	if _, err := forged.Read(make([]byte, 1)); err == nil {
+		t.Fatal("forged path wasn't closed")
+	}
+
+	// a path of the initiator joins.
+	path, err = net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+	if err := bond.AddPath(path); err != nil {
+		panic(err)
+	}
+	if _, err := client.Write([]byte("hello")); err != nil {
+		panic(err)
+	}
+	got := make([]byte, 5)
+	if _, err := io.ReadFull(client, got); err != nil || string(got) != "hello" {
+		t.Fatalf("unexpected echo %q: %v", got, err)
+	}
+	if n := bond.Paths(); n != 2 {
+		t.Fatalf("got %d paths, want 2", n)
+	}
+	if err := client.Close(); err != nil {
+		panic(err)
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,18 @@
+package noiseconn
+
+// Negotiation features are the choices of the peers that aren't carried in
+// encrypted or hashed handshake payloads: options that change the format
+// of the handshake, such as Options.HandshakeExtensions, and data sent in
+// cleartext before it, such as the static key hint. Each of them is bound
+// into the Noise prologue on both sides with bindNegotiation, in a fixed
+// order, so any difference between the peers, including one introduced by
+// tampering, fails the handshake before any transport data is accepted.
+// Features added later must be bound the same way. The handshake
+// extensions themselves are authenticated as part of the payloads.
+
+// bindNegotiation returns prologue with the negotiated feature label and
+// its value appended.
+func bindNegotiation(prologue []byte, label string, value []byte) []byte {
+	prologue = append(append([]byte(nil), prologue...), "noiseconn "+label...)
+	return appendUint16Bytes(prologue, value)
+}
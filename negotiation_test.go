@@ -0,0 +1,91 @@
+package noiseconn
+
+import (
+	"crypto/rand"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+// tamperConn flips the byte at offset of the data written through it.
+type tamperConn struct {
+	net.Conn
+	offset int
+}
+
+func (c *tamperConn) Write(b []byte) (int, error) {
+	if c.offset >= 0 && c.offset < len(b) {
+		b = append([]byte(nil), b...)
+		b[c.offset] ^= 1
+	}
+	c.offset -= len(b)
+	return c.Conn.Write(b)
+}
+
+func TestNegotiationBinding(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	clientKey, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	serverKey, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	selectStatic := func(net.Addr, []byte) (noise.DHKey, error) { return serverKey, nil }
+
+	handshake := func(clientConn func(net.Conn) net.Conn, clientOpts, serverOpts Options) error {
+		p1, p2 := net.Pipe()
+		client, err := NewConnWithOptions(clientConn(p1), noise.Config{
+			CipherSuite: cs, Pattern: noise.HandshakeXX, Initiator: true, StaticKeypair: clientKey,
+		}, clientOpts)
+		if err != nil {
+			panic(err)
+		}
+		defer func() { _ = client.Close() }()
+		server, err := NewConnWithOptions(p2, noise.Config{
+			CipherSuite: cs, Pattern: noise.HandshakeXX, StaticKeypair: serverKey,
+		}, serverOpts)
+		if err != nil {
+			panic(err)
+		}
+		defer func() { _ = server.Close() }()
+
+		var eg errgroup.Group
+		eg.Go(func() error {
+			err := client.Handshake()
+			_ = client.Close()
+			return err
+		})
+		eg.Go(func() error {
+			err := server.Handshake()
+			_ = server.Close()
+			return err
+		})
+		return eg.Wait()
+	}
+	plain := func(conn net.Conn) net.Conn { return conn }
+	// the first byte of the hint, after the frame header.
+	tamperHint := func(conn net.Conn) net.Conn { return &tamperConn{Conn: conn, offset: 4} }
+
+	for _, test := range []struct {
+		name       string
+		clientConn func(net.Conn) net.Conn
+		clientOpts Options
+		serverOpts Options
+		ok         bool
+	}{
+		{name: "extensions", clientConn: plain, clientOpts: Options{HandshakeExtensions: true}, serverOpts: Options{HandshakeExtensions: true}, ok: true},
+		{name: "client extensions", clientConn: plain, clientOpts: Options{HandshakeExtensions: true}},
+		{name: "server extensions", clientConn: plain, serverOpts: Options{HandshakeExtensions: true}},
+		{name: "hint", clientConn: plain, clientOpts: Options{StaticHint: []byte("a")}, serverOpts: Options{SelectStatic: selectStatic}, ok: true},
+		{name: "tampered hint", clientConn: tamperHint, clientOpts: Options{StaticHint: []byte("a")}, serverOpts: Options{SelectStatic: selectStatic}},
+	} {
+		err := handshake(test.clientConn, test.clientOpts, test.serverOpts)
+		if test.ok != (err == nil) {
+			t.Errorf("%s: unexpected handshake result %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package noiseconntest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// FaultKind is a kind of fault injected by a FaultConn.
+type FaultKind int
+
+const (
+	// FlipByte inverts the bits of the byte at the offset.
+	FlipByte FaultKind = iota
+	// Truncate delivers the bytes before the offset, drops the rest and
+	// closes the connection, as if it broke mid-frame.
+	Truncate
+	// ShortWrite makes the write containing the offset write only the
+	// bytes before it and return io.ErrShortWrite.
+	ShortWrite
+	// EOF makes the operation reaching the offset return io.EOF after
+	// the bytes before the offset.
+	EOF
+	// Delay sleeps before the operation reaching the offset.
+	Delay
+)
+
+// Fault describes a fault injected into one direction of a connection.
+type Fault struct {
+	Kind FaultKind
+	// Write selects the direction: faults apply to data written to the
+	// connection if true, and to data read from it otherwise.
+	Write bool
+	// Offset is the position in the direction's byte stream at which the
+	// fault happens.
+	Offset int64
+	// Duration is how long a Delay fault sleeps.
+	Duration time.Duration
+}
+
+// FaultConn is a net.Conn that injects faults into the data passing
+// through it. Wrap the transport of one side of a connection with it to
+// test how the other side handles a misbehaving peer or network.
+type FaultConn struct {
+	net.Conn
+
+	mu      sync.Mutex
+	faults  []Fault
+	read    int64
+	written int64
+	broken  bool
+}
+
+// NewFaultConn wraps conn, injecting faults.
+func NewFaultConn(conn net.Conn, faults ...Fault) *FaultConn {
+	return &FaultConn{Conn: conn, faults: faults}
+}
+
+// next returns the first pending fault for the direction that applies to
+// the n bytes starting at pos, removing it.
+func (c *FaultConn) next(write bool, pos int64, n int) (Fault, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, f := range c.faults {
+		if f.Write == write && f.Offset >= pos && f.Offset < pos+int64(n) {
+			c.faults = append(c.faults[:i:i], c.faults[i+1:]...)
+			return f, true
+		}
+	}
+	return Fault{}, false
+}
+
+func (c *FaultConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	broken, pos := c.broken, c.read
+	c.mu.Unlock()
+	if broken {
+		return 0, io.EOF
+	}
+	n, err := c.Conn.Read(b)
+	b = b[:n]
+	for {
+		f, ok := c.next(false, pos, len(b))
+		if !ok {
+			break
+		}
+		at := int(f.Offset - pos)
+		switch f.Kind {
+		case FlipByte:
+			b[at] ^= 0xff
+		case Truncate:
+			c.mu.Lock()
+			c.broken = true
+			c.mu.Unlock()
+			_ = c.Conn.Close()
+			b, err = b[:at], nil
+		case EOF, ShortWrite:
+			// the rest of the data is lost, as the peer could not have
+			// sent it.
+			c.mu.Lock()
+			c.broken = true
+			c.mu.Unlock()
+			b, err = b[:at], nil
+		case Delay:
+			time.Sleep(f.Duration)
+		}
+	}
+	c.mu.Lock()
+	c.read += int64(len(b))
+	c.mu.Unlock()
+	if len(b) == 0 && err == nil {
+		err = io.EOF
+	}
+	return len(b), err
+}
+
+func (c *FaultConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	broken, pos := c.broken, c.written
+	c.mu.Unlock()
+	if broken {
+		return 0, io.ErrClosedPipe
+	}
+	var faultErr error
+	if f, ok := c.next(true, pos, len(b)); ok {
+		at := int(f.Offset - pos)
+		switch f.Kind {
+		case FlipByte:
+			b = append([]byte(nil), b...)
+			b[at] ^= 0xff
+		case Truncate:
+			c.mu.Lock()
+			c.broken = true
+			c.mu.Unlock()
+			n, err := c.Conn.Write(b[:at])
+			_ = c.Conn.Close()
+			if err != nil {
+				return n, err
+			}
+			// the caller believes everything was written.
+			return len(b), nil
+		case ShortWrite:
+			b, faultErr = b[:at], io.ErrShortWrite
+		case EOF:
+			b, faultErr = b[:at], io.EOF
+		case Delay:
+			time.Sleep(f.Duration)
+		}
+	}
+	n, err := c.Conn.Write(b)
+	c.mu.Lock()
+	c.written += int64(n)
+	c.mu.Unlock()
+	if err == nil {
+		err = faultErr
+	}
+	return n, err
+}
+
+// CheckFailsClosed reads from conn until it fails, and reports a test
+// error if any data read is not a prefix of want, that is, if the
+// connection returned tampered data, or if conn doesn't fail within
+// timeout. It is meant for faults that destroy data, so receiving all of
+// want is an error too.
+func CheckFailsClosed(tb testing.TB, conn net.Conn, want []byte, timeout time.Duration) {
+	tb.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	defer func() { _ = conn.SetReadDeadline(time.Time{}) }()
+
+	var got []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		got = append(got, buf[:n]...)
+		if !bytes.HasPrefix(want, got) {
+			tb.Errorf("connection returned tampered data")
+			return
+		}
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				tb.Errorf("connection did not fail within %v", timeout)
+			}
+			return
+		}
+		if len(got) == len(want) {
+			tb.Errorf("connection returned all data despite the fault")
+			return
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package noiseconntest
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+)
+
+func TestFaults(t *testing.T) {
+	data := make([]byte, 200000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	for _, offset := range []int64{0, 3, 40, 70000, 150000} {
+		for _, kind := range []FaultKind{FlipByte, Truncate, ShortWrite, EOF} {
+			fault := Fault{Kind: kind, Write: true, Offset: offset}
+			client, server := PipeWithOptions(t, Options{
+				// the first message payload is encrypted with NK, so every
+				// byte is authenticated.
+				Pattern:       noise.HandshakeNK,
+				Buffered:      true,
+				SkipHandshake: true,
+				WrapClient: func(conn net.Conn) net.Conn {
+					return NewFaultConn(conn, fault)
+				},
+			})
+			// a fault in a frame header can leave both sides waiting for
+			// each other, until the application times out.
+			timer := time.AfterFunc(time.Second, func() { _ = client.Close() })
+			go func() {
+				_, _ = client.Write(data)
+				// like any application would after a failed write.
+				_ = client.Close()
+			}()
+			CheckFailsClosed(t, server, data, 5*time.Second)
+			timer.Stop()
+		}
+	}
+}
+
+func TestFaultDelay(t *testing.T) {
+	client, server := PipeWithOptions(t, Options{
+		Buffered:      true,
+		SkipHandshake: true,
+		WrapServer: func(conn net.Conn) net.Conn {
+			return NewFaultConn(conn, Fault{Kind: Delay, Offset: 0, Duration: 10 * time.Millisecond})
+		},
+	})
+	start := time.Now()
+	if _, err := client.Write([]byte("x")); err != nil {
+		panic(err)
+	}
+	if _, err := server.Read(make([]byte, 1)); err != nil {
+		panic(err)
+	}
+	if time.Since(start) < 10*time.Millisecond {
+		t.Fatal("expected delay")
+	}
+}
@@ -0,0 +1,193 @@
+package noiseconntest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	mathrand "math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+	"github.com/jtolio/noiseconn"
+)
+
+// The fuzz harnesses use fixed keys, so inputs found by fuzzing remain
+// valid across runs.
+var (
+	fuzzSuite     = noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	fuzzServerKey = mustKeypair(1)
+	fuzzClientKey = mustKeypair(2)
+)
+
+func mustKeypair(seed int64) noise.DHKey {
+	key, err := fuzzSuite.GenerateKeypair(mathrand.New(mathrand.NewSource(seed)))
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func fuzzConfig(initiator bool) noise.Config {
+	config := noise.Config{
+		CipherSuite:   fuzzSuite,
+		Pattern:       noise.HandshakeIK,
+		Initiator:     initiator,
+		StaticKeypair: fuzzServerKey,
+	}
+	if initiator {
+		config.StaticKeypair = fuzzClientKey
+		config.PeerStatic = fuzzServerKey.Public
+	}
+	return config
+}
+
+// fuzzConn is a transport that returns fixed data from Read and discards
+// writes.
+type fuzzConn struct {
+	*bytes.Reader
+}
+
+func (fuzzConn) Write(b []byte) (int, error)      { return len(b), nil }
+func (fuzzConn) Close() error                     { return nil }
+func (fuzzConn) LocalAddr() net.Addr              { return pipeAddr("fuzz") }
+func (fuzzConn) RemoteAddr() net.Addr             { return pipeAddr("fuzz") }
+func (fuzzConn) SetDeadline(time.Time) error      { return nil }
+func (fuzzConn) SetReadDeadline(time.Time) error  { return nil }
+func (fuzzConn) SetWriteDeadline(time.Time) error { return nil }
+
+// FuzzResponder feeds data, as sent by an unauthenticated attacker, to the
+// responder side of a Conn (using the IK pattern), and reads until the
+// Conn fails. It exercises the frame parser and the handshake. Use it from
+// a fuzz target:
+//
+//	func FuzzResponder(f *testing.F) {
+//		for _, seed := range noiseconntest.ResponderSeeds() {
+//			f.Add(seed)
+//		}
+//		f.Fuzz(func(t *testing.T, data []byte) {
+//			noiseconntest.FuzzResponder(data)
+//		})
+//	}
+//
+// Bugs show up as panics or hangs.
+func FuzzResponder(data []byte) {
+	conn, err := noiseconn.NewConn(fuzzConn{bytes.NewReader(data)}, fuzzConfig(false))
+	if err != nil {
+		panic(err)
+	}
+	buf := make([]byte, 1024)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			return
+		}
+	}
+}
+
+// ResponderSeeds returns seed inputs for FuzzResponder: valid first
+// handshake messages with and without payloads, and malformed frames.
+func ResponderSeeds() [][]byte {
+	seeds := [][]byte{
+		nil,
+		{noiseconn.HeaderByte},
+		{noiseconn.HeaderByte, 0, 0, 0},
+		{noiseconn.HeaderByte, 0xff, 0xff, 0xff},
+		{0x00, 0, 0, 1, 0},
+	}
+	for i, payload := range [][]byte{nil, []byte("hello"), make([]byte, 2000)} {
+		var out bytes.Buffer
+		config := fuzzConfig(true)
+		config.Random = mathrand.New(mathrand.NewSource(int64(i)))
+		conn, err := noiseconn.NewConn(&recordConn{w: &out}, config)
+		if err != nil {
+			panic(err)
+		}
+		if len(payload) == 0 {
+			// drive the first handshake message out without a payload.
+			_ = conn.Handshake()
+		} else {
+			_, _ = conn.Write(payload)
+		}
+		seeds = append(seeds, out.Bytes())
+	}
+	return seeds
+}
+
+// recordConn records writes and fails reads, so only the first handshake
+// messages of an initiator are produced.
+type recordConn struct {
+	fuzzConn
+	w io.Writer
+}
+
+func (c *recordConn) Read([]byte) (int, error)    { return 0, io.EOF }
+func (c *recordConn) Write(b []byte) (int, error) { return c.w.Write(b) }
+
+// FuzzTransport exercises a Conn after the handshake. data is a sequence
+// of records, each a byte selecting the kind, a 16-bit big endian length
+// and that many bytes. Even kinds send their bytes from an authenticated
+// peer in a valid Noise message; odd kinds send them unencrypted, as a
+// network attacker could. The receiving side must return exactly the
+// bytes of the valid messages sent before the first injected bytes, and
+// then fail. Bugs show up as panics, hangs, or test failures.
+func FuzzTransport(tb testing.TB, data []byte) {
+	client, server := PipeWithOptions(tb, Options{
+		Buffered: true,
+		Random:   bytes.NewReader(make([]byte, 16)),
+		WrapClient: func(conn net.Conn) net.Conn {
+			return &rawConn{Conn: conn}
+		},
+	})
+	raw := client.Conn.(*rawConn)
+
+	var want []byte
+	injected := false
+	for len(data) >= 3 {
+		kind, size := data[0], int(binary.BigEndian.Uint16(data[1:3]))
+		data = data[3:]
+		if size > len(data) {
+			size = len(data)
+		}
+		chunk := data[:size]
+		data = data[size:]
+		if kind%2 == 0 {
+			if _, err := client.Write(chunk); err != nil {
+				tb.Fatal(err)
+			}
+			if !injected {
+				want = append(want, chunk...)
+			}
+		} else {
+			if _, err := raw.Conn.Write(chunk); err != nil {
+				tb.Fatal(err)
+			}
+			injected = injected || len(chunk) > 0
+		}
+	}
+	_ = client.Close()
+
+	var got []byte
+	buf := make([]byte, 4096)
+	var err error
+	for err == nil {
+		var n int
+		n, err = server.Read(buf)
+		got = append(got, buf[:n]...)
+	}
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	if !bytes.HasPrefix(want, got) {
+		tb.Fatalf("received data that was not sent")
+	}
+	if !injected && (err != nil || len(got) != len(want)) {
+		tb.Fatalf("valid stream failed after %d of %d bytes: %v", len(got), len(want), err)
+	}
+}
+
+// rawConn gives FuzzTransport access to the transport under a Conn.
+type rawConn struct {
+	net.Conn
+}
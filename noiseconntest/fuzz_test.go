@@ -0,0 +1,26 @@
+package noiseconntest_test
+
+import (
+	"testing"
+
+	"github.com/jtolio/noiseconn/noiseconntest"
+)
+
+func FuzzResponder(f *testing.F) {
+	for _, seed := range noiseconntest.ResponderSeeds() {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		noiseconntest.FuzzResponder(data)
+	})
+}
+
+func FuzzTransport(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte("\x00\x00\x05hello"))
+	f.Add([]byte("\x00\x00\x05hello\x01\x00\x04\x80\x00\x00\x10\x00\x00\x05world"))
+	f.Add([]byte("\x00\x00\x00\x02\x00\x03abc\x01\x00\x01\x00"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		noiseconntest.FuzzTransport(t, data)
+	})
+}
@@ -0,0 +1,102 @@
+package noiseconntest
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Link describes the simulated network link of a LinkConn.
+type Link struct {
+	// Latency is the one-way delay of the link.
+	Latency time.Duration
+	// Jitter, if nonzero, adds a random delay of up to Jitter to every
+	// write. Data is still delivered in order.
+	Jitter time.Duration
+	// Bandwidth, if nonzero, limits the link to this many bytes per
+	// second. Writes block while the link is busy.
+	Bandwidth int64
+}
+
+type linkPacket struct {
+	data []byte
+	at   time.Time
+}
+
+// linkConn delays and throttles the data written to it according to a
+// Link.
+type linkConn struct {
+	net.Conn
+	link Link
+
+	mu          sync.Mutex
+	free        time.Time
+	lastArrival time.Time
+	closed      bool
+	queue       chan linkPacket
+}
+
+// NewLinkConn wraps conn, so that data written to it reaches the peer as
+// if it was sent over link. Reads are unaffected, so wrap both sides of a
+// connection to simulate a symmetric link. Close returns immediately, but
+// data in flight is still delivered before the underlying conn is closed.
+func NewLinkConn(conn net.Conn, link Link) net.Conn {
+	c := &linkConn{Conn: conn, link: link, queue: make(chan linkPacket, 1024)}
+	go c.deliver()
+	return c
+}
+
+func (c *linkConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return 0, net.ErrClosed
+	}
+	now := time.Now()
+	if c.free.Before(now) {
+		c.free = now
+	}
+	if c.link.Bandwidth > 0 {
+		c.free = c.free.Add(time.Duration(int64(len(b)) * int64(time.Second) / c.link.Bandwidth))
+	}
+	arrival := c.free.Add(c.link.Latency)
+	if c.link.Jitter > 0 {
+		arrival = arrival.Add(time.Duration(rand.Int63n(int64(c.link.Jitter))))
+	}
+	if arrival.Before(c.lastArrival) {
+		arrival = c.lastArrival
+	}
+	c.lastArrival = arrival
+	free := c.free
+	c.queue <- linkPacket{data: append([]byte(nil), b...), at: arrival}
+	c.mu.Unlock()
+
+	// the writer is blocked while the link is busy transmitting.
+	time.Sleep(time.Until(free))
+	return len(b), nil
+}
+
+func (c *linkConn) deliver() {
+	failed := false
+	for p := range c.queue {
+		if failed {
+			continue
+		}
+		time.Sleep(time.Until(p.at))
+		if _, err := c.Conn.Write(p.data); err != nil {
+			failed = true
+		}
+	}
+	_ = c.Conn.Close()
+}
+
+func (c *linkConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.queue)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package noiseconntest
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+func TestLink(t *testing.T) {
+	const latency = 20 * time.Millisecond
+	client, server := PipeWithOptions(t, Options{
+		Buffered: true,
+		Link:     &Link{Latency: latency, Bandwidth: 1 << 20},
+	})
+
+	// a round trip takes two one-way latencies.
+	start := time.Now()
+	var eg errgroup.Group
+	eg.Go(func() error {
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(server, buf); err != nil {
+			return err
+		}
+		_, err := server.Write(buf)
+		return err
+	})
+	if _, err := client.Write([]byte("ping")); err != nil {
+		panic(err)
+	}
+	if _, err := io.ReadFull(client, make([]byte, 4)); err != nil {
+		panic(err)
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	if rtt := time.Since(start); rtt < 2*latency {
+		t.Fatalf("round trip took %v, expected at least %v", rtt, 2*latency)
+	}
+
+	// 256KiB at 1MiB/s takes at least 250ms.
+	data := make([]byte, 256<<10)
+	start = time.Now()
+	eg.Go(func() error {
+		_, err := client.Write(data)
+		return err
+	})
+	if _, err := io.ReadFull(server, data); err != nil {
+		panic(err)
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Fatalf("transfer took %v, expected at least 250ms", elapsed)
+	}
+}
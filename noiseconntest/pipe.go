@@ -0,0 +1,152 @@
+// Package noiseconntest contains helpers for testing code that uses
+// noiseconn.
+package noiseconntest
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	mathrand "math/rand"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+	"github.com/jtolio/noiseconn"
+	"golang.org/x/sync/errgroup"
+)
+
+// Options configure Pipe.
+type Options struct {
+	// Pattern is the handshake pattern. If zero, noise.HandshakeXX is
+	// used. Static keys are generated for both sides, and provided to
+	// the other side when the pattern requires it.
+	Pattern noise.HandshakePattern
+
+	// CipherSuite is the cipher suite. If nil, 25519, ChaChaPoly and
+	// BLAKE2b are used.
+	CipherSuite noise.CipherSuite
+
+	// Buffered selects a buffered transport, where writes never block,
+	// instead of the synchronous net.Pipe.
+	Buffered bool
+
+	// SkipHandshake returns the connections before the handshake, so that
+	// handshake payloads can be tested.
+	SkipHandshake bool
+
+	// Random, if set, makes the static and ephemeral keys of both sides
+	// deterministic: it seeds a source of randomness for each side, so
+	// that the same Random produces the same handshakes. It overrides the
+	// Random field of Client and Server.
+	Random io.Reader
+
+	// Client and Server are the options of the connections.
+	Client, Server noiseconn.Options
+
+	// Link, if set, simulates the latency and bandwidth of a network link
+	// in both directions, as with NewLinkConn.
+	Link *Link
+
+	// WrapClient and WrapServer, if set, wrap the transports of the
+	// connections, such as with NewFaultConn. They are applied after
+	// Link.
+	WrapClient, WrapServer func(net.Conn) net.Conn
+}
+
+// Pipe returns a connected client and server, with completed handshakes.
+// They are closed when the test finishes.
+func Pipe(tb testing.TB) (client, server *noiseconn.Conn) {
+	return PipeWithOptions(tb, Options{})
+}
+
+// PipeWithOptions is like Pipe, but configured by opts.
+func PipeWithOptions(tb testing.TB, opts Options) (client, server *noiseconn.Conn) {
+	tb.Helper()
+	if opts.Pattern.Name == "" {
+		opts.Pattern = noise.HandshakeXX
+	}
+	if opts.CipherSuite == nil {
+		opts.CipherSuite = noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	}
+
+	clientRandom, serverRandom := rand.Reader, rand.Reader
+	if opts.Random != nil {
+		// the sides handshake concurrently, so they need separate sources
+		// to read from them in a deterministic order.
+		var seeds [16]byte
+		if _, err := io.ReadFull(opts.Random, seeds[:]); err != nil {
+			tb.Fatal(err)
+		}
+		clientRandom = mathrand.New(mathrand.NewSource(int64(binary.BigEndian.Uint64(seeds[:8]))))
+		serverRandom = mathrand.New(mathrand.NewSource(int64(binary.BigEndian.Uint64(seeds[8:]))))
+		opts.Client.Random, opts.Server.Random = clientRandom, serverRandom
+	}
+
+	clientKey, err := opts.CipherSuite.GenerateKeypair(clientRandom)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	serverKey, err := opts.CipherSuite.GenerateKeypair(serverRandom)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	clientConfig := noise.Config{
+		CipherSuite:   opts.CipherSuite,
+		Pattern:       opts.Pattern,
+		Initiator:     true,
+		StaticKeypair: clientKey,
+	}
+	serverConfig := noise.Config{
+		CipherSuite:   opts.CipherSuite,
+		Pattern:       opts.Pattern,
+		StaticKeypair: serverKey,
+	}
+	if hasStatic(opts.Pattern.ResponderPreMessages) {
+		clientConfig.PeerStatic = serverKey.Public
+	}
+	if hasStatic(opts.Pattern.InitiatorPreMessages) {
+		serverConfig.PeerStatic = clientKey.Public
+	}
+
+	p1, p2 := NewTransport(opts.Buffered)
+	if opts.Link != nil {
+		p1, p2 = NewLinkConn(p1, *opts.Link), NewLinkConn(p2, *opts.Link)
+	}
+	if opts.WrapClient != nil {
+		p1 = opts.WrapClient(p1)
+	}
+	if opts.WrapServer != nil {
+		p2 = opts.WrapServer(p2)
+	}
+	client, err = noiseconn.NewConnWithOptions(p1, clientConfig, opts.Client)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	server, err = noiseconn.NewConnWithOptions(p2, serverConfig, opts.Server)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+
+	if !opts.SkipHandshake {
+		var eg errgroup.Group
+		eg.Go(client.Handshake)
+		eg.Go(server.Handshake)
+		if err := eg.Wait(); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return client, server
+}
+
+func hasStatic(tokens []noise.MessagePattern) bool {
+	for _, token := range tokens {
+		if token == noise.MessagePatternS {
+			return true
+		}
+	}
+	return false
+}
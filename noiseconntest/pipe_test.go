@@ -0,0 +1,70 @@
+package noiseconntest
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestPipe(t *testing.T) {
+	for _, buffered := range []bool{false, true} {
+		for _, pattern := range []noise.HandshakePattern{noise.HandshakeNN, noise.HandshakeXX, noise.HandshakeIK, noise.HandshakeKK} {
+			client, server := PipeWithOptions(t, Options{Pattern: pattern, Buffered: buffered})
+			if !client.HandshakeComplete() || !server.HandshakeComplete() {
+				t.Fatal("handshake not complete")
+			}
+
+			data := make([]byte, 100000)
+			for i := range data {
+				data[i] = byte(i % 251)
+			}
+			var eg errgroup.Group
+			eg.Go(func() error {
+				_, err := client.Write(data)
+				return err
+			})
+			got := make([]byte, len(data))
+			if _, err := io.ReadFull(server, got); err != nil {
+				panic(err)
+			}
+			if err := eg.Wait(); err != nil {
+				panic(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("%s (buffered %v): mismatch", pattern.Name, buffered)
+			}
+		}
+	}
+}
+
+func TestPipeBufferedWrite(t *testing.T) {
+	client, server := PipeWithOptions(t, Options{Buffered: true, SkipHandshake: true})
+	// with a buffered transport, writes complete without a reader.
+	if _, err := client.Write([]byte("early")); err != nil {
+		panic(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(server, buf); err != nil {
+		panic(err)
+	}
+	if string(buf) != "early" {
+		t.Fatalf("got %q", buf)
+	}
+}
+
+func TestPipeDeterministic(t *testing.T) {
+	var hashes [][]byte
+	for i := 0; i < 2; i++ {
+		client, server := PipeWithOptions(t, Options{Random: bytes.NewReader(make([]byte, 16))})
+		if !bytes.Equal(client.HandshakeHash(), server.HandshakeHash()) {
+			t.Fatal("handshake hashes differ")
+		}
+		hashes = append(hashes, client.HandshakeHash())
+	}
+	if !bytes.Equal(hashes[0], hashes[1]) {
+		t.Fatal("handshakes with the same randomness differ")
+	}
+}
@@ -0,0 +1,182 @@
+package noiseconntest
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// NewTransport returns the two ends of an in-memory connection. If
+// buffered is false, it is a synchronous net.Pipe. Otherwise, writes are
+// buffered without limit and never block.
+func NewTransport(buffered bool) (net.Conn, net.Conn) {
+	if !buffered {
+		return net.Pipe()
+	}
+	ab, ba := newPipeBuffer(), newPipeBuffer()
+	return newBufferedConn(ba, ab, "a"), newBufferedConn(ab, ba, "b")
+}
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// pipeBuffer is one direction of a buffered connection.
+type pipeBuffer struct {
+	mu      sync.Mutex
+	buf     []byte
+	closed  bool
+	changed chan struct{}
+}
+
+func newPipeBuffer() *pipeBuffer {
+	return &pipeBuffer{changed: make(chan struct{})}
+}
+
+// signal wakes up waiting readers. p.mu must be held.
+func (p *pipeBuffer) signal() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+func (p *pipeBuffer) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.closed = true
+		p.signal()
+	}
+}
+
+type bufferedConn struct {
+	r, w  *pipeBuffer
+	local pipeAddr
+
+	mu            sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+	deadlineSet   chan struct{}
+	closed        chan struct{}
+	closeOnce     sync.Once
+}
+
+func newBufferedConn(r, w *pipeBuffer, local pipeAddr) *bufferedConn {
+	return &bufferedConn{
+		r:           r,
+		w:           w,
+		local:       local,
+		deadlineSet: make(chan struct{}),
+		closed:      make(chan struct{}),
+	}
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	for {
+		select {
+		case <-c.closed:
+			return 0, net.ErrClosed
+		default:
+		}
+		c.r.mu.Lock()
+		if len(c.r.buf) > 0 {
+			n := copy(b, c.r.buf)
+			c.r.buf = c.r.buf[n:]
+			c.r.mu.Unlock()
+			return n, nil
+		}
+		if c.r.closed {
+			c.r.mu.Unlock()
+			return 0, io.EOF
+		}
+		changed := c.r.changed
+		c.r.mu.Unlock()
+
+		c.mu.Lock()
+		deadline, deadlineSet := c.readDeadline, c.deadlineSet
+		c.mu.Unlock()
+		var timeout <-chan time.Time
+		var timer *time.Timer
+		if !deadline.IsZero() {
+			timer = time.NewTimer(time.Until(deadline))
+			timeout = timer.C
+		}
+		var err error
+		select {
+		case <-changed:
+		case <-deadlineSet:
+		case <-c.closed:
+		case <-timeout:
+			err = os.ErrDeadlineExceeded
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (c *bufferedConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	c.mu.Lock()
+	deadline := c.writeDeadline
+	c.mu.Unlock()
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return 0, os.ErrDeadlineExceeded
+	}
+	c.w.mu.Lock()
+	defer c.w.mu.Unlock()
+	if c.w.closed {
+		return 0, io.ErrClosedPipe
+	}
+	c.w.buf = append(c.w.buf, b...)
+	c.w.signal()
+	return len(b), nil
+}
+
+func (c *bufferedConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.r.close()
+		c.w.close()
+	})
+	return nil
+}
+
+func (c *bufferedConn) LocalAddr() net.Addr { return c.local }
+
+func (c *bufferedConn) RemoteAddr() net.Addr {
+	if c.local == "a" {
+		return pipeAddr("b")
+	}
+	return pipeAddr("a")
+}
+
+func (c *bufferedConn) SetDeadline(t time.Time) error {
+	_ = c.SetWriteDeadline(t)
+	return c.SetReadDeadline(t)
+}
+
+func (c *bufferedConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	close(c.deadlineSet)
+	c.deadlineSet = make(chan struct{})
+	return nil
+}
+
+func (c *bufferedConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	return nil
+}
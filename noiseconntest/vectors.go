@@ -0,0 +1,236 @@
+package noiseconntest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flynn/noise"
+	"github.com/jtolio/noiseconn"
+	"github.com/jtolio/noiseconn/internal/cmdutil"
+	"github.com/zeebo/errs"
+)
+
+// ErrUnsupportedVector is returned by RunVector for vectors that use
+// features this package doesn't support, such as other DH functions,
+// multiple PSKs or fallback patterns.
+var ErrUnsupportedVector = errors.New("unsupported test vector")
+
+// Hex is a byte slice encoded as a hex string in JSON.
+type Hex []byte
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (h *Hex) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errs.Wrap(err)
+	}
+	decoded, err := hex.DecodeString(s)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	*h = decoded
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (h Hex) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(h))
+}
+
+// Vector is a Noise test vector, in the JSON format used by cacophony and
+// snow. Keys are private keys, except for the remote static keys, which
+// are public keys.
+type Vector struct {
+	Name             string          `json:"name,omitempty"`
+	ProtocolName     string          `json:"protocol_name"`
+	InitPrologue     Hex             `json:"init_prologue"`
+	InitPSKs         []Hex           `json:"init_psks,omitempty"`
+	InitStatic       Hex             `json:"init_static,omitempty"`
+	InitEphemeral    Hex             `json:"init_ephemeral"`
+	InitRemoteStatic Hex             `json:"init_remote_static,omitempty"`
+	RespPrologue     Hex             `json:"resp_prologue"`
+	RespPSKs         []Hex           `json:"resp_psks,omitempty"`
+	RespStatic       Hex             `json:"resp_static,omitempty"`
+	RespEphemeral    Hex             `json:"resp_ephemeral"`
+	RespRemoteStatic Hex             `json:"resp_remote_static,omitempty"`
+	HandshakeHash    Hex             `json:"handshake_hash,omitempty"`
+	Messages         []VectorMessage `json:"messages"`
+}
+
+// VectorMessage is a message of a Vector. Messages alternate between the
+// initiator and the responder, starting with the initiator, except for
+// one-way patterns, where the initiator sends all of them.
+type VectorMessage struct {
+	Payload    Hex `json:"payload"`
+	Ciphertext Hex `json:"ciphertext"`
+}
+
+// LoadVectors reads a test vector file, a JSON object with the vectors in
+// a "vectors" field.
+func LoadVectors(r io.Reader) ([]Vector, error) {
+	var file struct {
+		Vectors []Vector `json:"vectors"`
+	}
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, errs.Wrap(err)
+	}
+	return file.Vectors, nil
+}
+
+var pskModifier = regexp.MustCompile(`^([A-Z]+)psk([0-9])$`)
+
+// parseVectorProtocol parses the protocol name of a vector, which may have
+// a single psk modifier.
+func parseVectorProtocol(name string) (pattern noise.HandshakePattern, suite noise.CipherSuite, placement int, err error) {
+	parts := strings.Split(name, "_")
+	placement = -1
+	if len(parts) == 5 {
+		if m := pskModifier.FindStringSubmatch(parts[1]); m != nil {
+			parts[1] = m[1]
+			placement, _ = strconv.Atoi(m[2])
+		}
+	}
+	pattern, suite, err = cmdutil.ParseProtocol(strings.Join(parts, "_"))
+	if err != nil {
+		return pattern, nil, 0, fmt.Errorf("%w: %v", ErrUnsupportedVector, err)
+	}
+	return pattern, suite, placement, nil
+}
+
+// RunVector runs the handshake and transport messages of v through a pair
+// of connected Conns (as MessageConns) and checks that every message is
+// sent with the expected ciphertext and received with the expected
+// payload, and that the handshake hash matches, if v has one. It returns
+// an error wrapping ErrUnsupportedVector if v can't be run.
+func RunVector(v Vector) error {
+	name := v.ProtocolName
+	if name == "" {
+		name = v.Name
+	}
+	pattern, suite, placement, err := parseVectorProtocol(name)
+	if err != nil {
+		return err
+	}
+	if len(v.InitPSKs) > 1 || len(v.RespPSKs) > 1 || (placement >= 0) != (len(v.InitPSKs) == 1) {
+		return fmt.Errorf("%w: %s: psk count doesn't match the pattern", ErrUnsupportedVector, name)
+	}
+
+	config := func(initiator bool, prologue, static, ephemeral, remoteStatic Hex, psks []Hex) (noise.Config, error) {
+		c := noise.Config{
+			CipherSuite: suite,
+			Pattern:     pattern,
+			Initiator:   initiator,
+			Prologue:    prologue,
+			PeerStatic:  remoteStatic,
+			// the ephemeral key is the only randomness a handshake uses.
+			Random: bytes.NewReader(ephemeral),
+		}
+		if len(static) > 0 {
+			key, err := suite.GenerateKeypair(bytes.NewReader(static))
+			if err != nil {
+				return c, errs.Wrap(err)
+			}
+			c.StaticKeypair = key
+		}
+		if len(psks) == 1 {
+			c.PresharedKey, c.PresharedKeyPlacement = psks[0], placement
+		}
+		return c, nil
+	}
+	initConfig, err := config(true, v.InitPrologue, v.InitStatic, v.InitEphemeral, v.InitRemoteStatic, v.InitPSKs)
+	if err != nil {
+		return err
+	}
+	respConfig, err := config(false, v.RespPrologue, v.RespStatic, v.RespEphemeral, v.RespRemoteStatic, v.RespPSKs)
+	if err != nil {
+		return err
+	}
+
+	rawInit, rawResp := NewTransport(true)
+	tapInit, tapResp := &tapConn{Conn: rawInit}, &tapConn{Conn: rawResp}
+	deadline := time.Now().Add(10 * time.Second)
+	for _, raw := range []net.Conn{rawInit, rawResp} {
+		if err := raw.SetDeadline(deadline); err != nil {
+			return errs.Wrap(err)
+		}
+	}
+	initConn, err := noiseconn.NewConn(tapInit, initConfig)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = initConn.Close() }()
+	respConn, err := noiseconn.NewConn(tapResp, respConfig)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = respConn.Close() }()
+	initMsg, respMsg := noiseconn.NewMessageConn(initConn), noiseconn.NewMessageConn(respConn)
+
+	for i, m := range v.Messages {
+		sender, receiver, tap := initMsg, respMsg, tapInit
+		if i%2 == 1 && len(pattern.Messages) > 1 {
+			sender, receiver, tap = respMsg, initMsg, tapResp
+		}
+
+		tap.sent.Reset()
+		if err := sender.WriteMsg(m.Payload); err != nil {
+			return errs.New("message %d: write: %v", i, err)
+		}
+		frame := tap.sent.Bytes()
+		if len(frame) < 4 || int(binary.BigEndian.Uint32(frame)&^(noiseconn.HeaderByte<<24)) != len(frame)-4 {
+			return errs.New("message %d: not sent as a single frame", i)
+		}
+		if !bytes.Equal(frame[4:], m.Ciphertext) {
+			return errs.New("message %d: ciphertext mismatch:\n got %x\nwant %x", i, frame[4:], m.Ciphertext)
+		}
+
+		// empty handshake payloads aren't returned by ReadMsg. Such a
+		// message is read when the receiver sends the next one, or, if it
+		// is the last one, by completing the handshake.
+		if i < len(pattern.Messages) && len(m.Payload) == 0 {
+			if i == len(pattern.Messages)-1 {
+				if err := receiver.Handshake(); err != nil {
+					return errs.New("message %d: read: %v", i, err)
+				}
+			}
+			continue
+		}
+		payload, err := receiver.ReadMsg()
+		if err != nil {
+			return errs.New("message %d: read: %v", i, err)
+		}
+		if !bytes.Equal(payload, m.Payload) {
+			return errs.New("message %d: payload mismatch:\n got %x\nwant %x", i, payload, m.Payload)
+		}
+	}
+
+	if len(v.HandshakeHash) > 0 {
+		for _, conn := range []*noiseconn.Conn{initConn, respConn} {
+			if hh := conn.HandshakeHash(); !bytes.Equal(hh, v.HandshakeHash) {
+				return errs.New("handshake hash mismatch:\n got %x\nwant %x", hh, v.HandshakeHash)
+			}
+		}
+	}
+	return nil
+}
+
+// tapConn records what is written to it.
+type tapConn struct {
+	net.Conn
+	sent bytes.Buffer
+}
+
+func (c *tapConn) Write(b []byte) (int, error) {
+	c.sent.Write(b)
+	return c.Conn.Write(b)
+}
@@ -0,0 +1,38 @@
+package noiseconntest_test
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/jtolio/noiseconn/noiseconntest"
+)
+
+// testdata/vectors.json contains a subset of the flynn/noise test vectors,
+// converted to the cacophony format.
+var vectorsFile = flag.String("vectors", "testdata/vectors.json", "Noise test vector file to run, such as cacophony.txt or snow's vectors")
+
+func TestVectors(t *testing.T) {
+	fh, err := os.Open(*vectorsFile)
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = fh.Close() }()
+	vectors, err := noiseconntest.LoadVectors(fh)
+	if err != nil {
+		panic(err)
+	}
+	for _, v := range vectors {
+		v := v
+		t.Run(v.ProtocolName+v.Name, func(t *testing.T) {
+			err := noiseconntest.RunVector(v)
+			if errors.Is(err, noiseconntest.ErrUnsupportedVector) {
+				t.Skip(err)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+// Package noisedrpc wires noiseconn into drpc.
+//
+// Clients created by Dial don't wait for the Noise handshake before handing
+// the connection to drpc, so the first invoke is carried in the handshake
+// payload when the handshake pattern allows for 0-RTT (such as IK).
+package noisedrpc
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/flynn/noise"
+	"github.com/jtolio/noiseconn"
+	"github.com/zeebo/errs"
+	"storj.io/drpc/drpcconn"
+	"storj.io/drpc/drpcctx"
+	"storj.io/drpc/drpcserver"
+)
+
+// Dial connects to address and returns a drpc client connection secured
+// with config. The Initiator field of config is ignored.
+func Dial(ctx context.Context, network, address string, config noise.Config) (*drpcconn.Conn, error) {
+	return DialWithOptions(ctx, network, address, config, noiseconn.Options{})
+}
+
+// DialWithOptions is like Dial, but with options provided by
+// noiseconn.Options.
+func DialWithOptions(ctx context.Context, network, address string, config noise.Config, opts noiseconn.Options) (*drpcconn.Conn, error) {
+	var d net.Dialer
+	raw, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	config.Initiator = true
+	conn, err := noiseconn.NewConnWithOptions(raw, config, opts)
+	if err != nil {
+		_ = raw.Close()
+		return nil, err
+	}
+	return drpcconn.New(conn), nil
+}
+
+// Serve accepts connections from lis and serves drpc requests on them with
+// srv, securing every connection with config. The Initiator field of config
+// is ignored. Serve returns when ctx is canceled or lis fails.
+func Serve(ctx context.Context, srv *drpcserver.Server, lis net.Listener, config noise.Config) error {
+	return ServeWithOptions(ctx, srv, lis, config, noiseconn.Options{})
+}
+
+// ServeWithOptions is like Serve, but with options provided by
+// noiseconn.Options.
+func ServeWithOptions(ctx context.Context, srv *drpcserver.Server, lis net.Listener, config noise.Config, opts noiseconn.Options) error {
+	config.Initiator = false
+	nlis := noiseconn.NewListenerWithOptions(lis, config, opts)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		_ = nlis.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := nlis.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return errs.Wrap(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// the connection is stored in the context so handlers can find
+			// the peer identity.
+			_ = srv.ServeOne(drpcctx.WithTransport(ctx, conn), conn)
+		}()
+	}
+}
+
+// Conn returns the noiseconn.Conn serving the drpc request associated with
+// ctx, if any.
+func Conn(ctx context.Context) (*noiseconn.Conn, bool) {
+	tr, ok := drpcctx.Transport(ctx)
+	if !ok {
+		return nil, false
+	}
+	conn, ok := tr.(*noiseconn.Conn)
+	return conn, ok
+}
+
+// PeerStatic returns the static public key of the peer making the drpc
+// request associated with ctx, if the handshake pattern provided one.
+func PeerStatic(ctx context.Context) ([]byte, bool) {
+	conn, ok := Conn(ctx)
+	if !ok {
+		return nil, false
+	}
+	peerStatic := conn.PeerStatic()
+	return peerStatic, peerStatic != nil
+}
+
+// ServerPeerStatic returns the static public key of the server of a client
+// connection created with Dial. For patterns where the server's key isn't
+// known in advance, it is nil until a response has been received.
+func ServerPeerStatic(conn *drpcconn.Conn) ([]byte, bool) {
+	nc, ok := conn.Transport().(*noiseconn.Conn)
+	if !ok {
+		return nil, false
+	}
+	peerStatic := nc.PeerStatic()
+	return peerStatic, peerStatic != nil
+}
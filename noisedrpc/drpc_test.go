@@ -0,0 +1,83 @@
+package noisedrpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+	"storj.io/drpc"
+	"storj.io/drpc/drpcserver"
+)
+
+type rawEncoding struct{}
+
+func (rawEncoding) Marshal(msg drpc.Message) ([]byte, error) {
+	return *msg.(*[]byte), nil
+}
+
+func (rawEncoding) Unmarshal(buf []byte, msg drpc.Message) error {
+	*msg.(*[]byte) = append([]byte(nil), buf...)
+	return nil
+}
+
+type whoami struct{}
+
+func (whoami) HandleRPC(stream drpc.Stream, rpc string) error {
+	var in []byte
+	if err := stream.MsgRecv(&in, rawEncoding{}); err != nil {
+		return err
+	}
+	peerStatic, _ := PeerStatic(stream.Context())
+	return stream.MsgSend(&peerStatic, rawEncoding{})
+}
+
+func TestDRPC(t *testing.T) {
+	clientKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	serverKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = Serve(ctx, drpcserver.New(whoami{}), lis, noise.Config{
+			CipherSuite:   noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+			Pattern:       noise.HandshakeIK,
+			StaticKeypair: serverKey,
+		})
+	}()
+
+	conn, err := Dial(ctx, "tcp", lis.Addr().String(), noise.Config{
+		CipherSuite:   noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+		Pattern:       noise.HandshakeIK,
+		StaticKeypair: clientKey,
+		PeerStatic:    serverKey.Public,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	in, out := []byte("who am i"), []byte(nil)
+	err = conn.Invoke(ctx, "/whoami", rawEncoding{}, &in, &out)
+	if err != nil {
+		panic(err)
+	}
+	if !bytes.Equal(out, clientKey.Public) {
+		panic("failure")
+	}
+	if peerStatic, _ := ServerPeerStatic(conn); !bytes.Equal(peerStatic, serverKey.Public) {
+		panic("failure")
+	}
+}
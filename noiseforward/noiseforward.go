@@ -0,0 +1,395 @@
+// Package noiseforward implements SSH-style port forwarding over a
+// noisemux.Session.
+//
+// Every forwarded connection is carried in its own stream. A stream starts
+// with a request from the side that opened it, answered by a reply from
+// the other side:
+//
+//   - a connect request asks the peer to dial a target and relay the
+//     stream to it (local forwarding);
+//   - a listen request asks the peer to listen on an address. The stream
+//     stays open for as long as the forward is active, and the peer opens a
+//     forwarded stream back for every connection it accepts (remote
+//     forwarding).
+//
+// Both peers of a session must use a Forwarder, and each peer decides with
+// its Config which requests it grants.
+package noiseforward
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/jtolio/noiseconn/noisemux"
+	"github.com/zeebo/errs"
+)
+
+const (
+	reqConnect   = 0x01
+	reqListen    = 0x02
+	reqForwarded = 0x03
+
+	statusOK    = 0x00
+	statusError = 0x01
+
+	maxFieldLen = 0xffff
+)
+
+// Config decides which requests of the peer are granted.
+type Config struct {
+	// AllowConnect decides whether the peer may ask to connect to target.
+	// If nil, all connect requests are refused.
+	AllowConnect func(target string) bool
+
+	// AllowListen decides whether the peer may ask to listen on address.
+	// If nil, all listen requests are refused.
+	AllowListen func(address string) bool
+
+	// Dial dials targets of connect requests. If nil, a zero net.Dialer
+	// is used.
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+
+	// Listen listens for remote forwards. If nil, net.Listen is used.
+	Listen func(network, address string) (net.Listener, error)
+}
+
+// Forwarder serves forwarding requests of the peer of a session, and makes
+// requests of its own.
+type Forwarder struct {
+	sess   *noisemux.Session
+	config Config
+
+	mu       sync.Mutex
+	forwards map[uint32]string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New starts serving the requests of the peer of sess.
+func New(sess *noisemux.Session, config Config) *Forwarder {
+	if config.Dial == nil {
+		config.Dial = (&net.Dialer{}).DialContext
+	}
+	if config.Listen == nil {
+		config.Listen = net.Listen
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &Forwarder{
+		sess:     sess,
+		config:   config,
+		forwards: map[uint32]string{},
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	f.wg.Add(1)
+	go f.serve()
+	return f
+}
+
+// Close stops all forwards and closes the session.
+func (f *Forwarder) Close() error {
+	f.cancel()
+	err := f.sess.Close()
+	f.wg.Wait()
+	return err
+}
+
+// Dial asks the peer to connect to target and returns the stream relayed
+// to it.
+func (f *Forwarder) Dial(target string) (*noisemux.Stream, error) {
+	st, _, err := f.request(reqConnect, []byte(target))
+	return st, err
+}
+
+// LocalForward accepts connections from lis and relays every one of them
+// to target through the peer, until ctx is canceled or lis fails. It
+// closes lis when it returns.
+func (f *Forwarder) LocalForward(ctx context.Context, lis net.Listener, target string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		_ = lis.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return errs.Wrap(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { _ = conn.Close() }()
+			st, err := f.Dial(target)
+			if err != nil {
+				return
+			}
+			relay(ctx, conn, st)
+		}()
+	}
+}
+
+// RemoteForward is a remote forward: the peer listens on an address and
+// every connection it accepts is relayed to a local target.
+type RemoteForward struct {
+	f      *Forwarder
+	st     *noisemux.Stream
+	addr   string
+	done   chan struct{}
+	closed sync.Once
+}
+
+// RemoteForward asks the peer to listen on address and relays every
+// connection the peer accepts to the local target, until the forward is
+// closed or the peer stops listening.
+func (f *Forwarder) RemoteForward(address, target string) (*RemoteForward, error) {
+	st, addr, err := f.request(reqListen, []byte(address))
+	if err != nil {
+		return nil, err
+	}
+	r := &RemoteForward{f: f, st: st, addr: string(addr), done: make(chan struct{})}
+	f.mu.Lock()
+	f.forwards[st.ID()] = target
+	f.mu.Unlock()
+
+	// the peer closes the control stream when its listener fails.
+	go func() {
+		_, _ = io.Copy(io.Discard, st)
+		f.mu.Lock()
+		delete(f.forwards, st.ID())
+		f.mu.Unlock()
+		close(r.done)
+	}()
+	return r, nil
+}
+
+// Addr returns the address the peer listens on.
+func (r *RemoteForward) Addr() string { return r.addr }
+
+// Done is closed when the forward has ended.
+func (r *RemoteForward) Done() <-chan struct{} { return r.done }
+
+// Close asks the peer to stop listening. Connections that were already
+// forwarded are not affected.
+func (r *RemoteForward) Close() error {
+	r.closed.Do(func() { _ = r.st.Reset() })
+	<-r.done
+	return nil
+}
+
+func (f *Forwarder) request(typ byte, payload []byte) (_ *noisemux.Stream, reply []byte, err error) {
+	st, err := f.sess.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writeField(st, typ, payload); err != nil {
+		_ = st.Reset()
+		return nil, nil, err
+	}
+	status, reply, err := readField(st)
+	if err != nil {
+		_ = st.Reset()
+		return nil, nil, err
+	}
+	if status != statusOK {
+		_ = st.Reset()
+		return nil, nil, errs.New("peer refused request: %s", reply)
+	}
+	return st, reply, nil
+}
+
+func (f *Forwarder) serve() {
+	defer f.wg.Done()
+	for {
+		st, err := f.sess.Accept()
+		if err != nil {
+			return
+		}
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			f.handle(st)
+		}()
+	}
+}
+
+func (f *Forwarder) handle(st *noisemux.Stream) {
+	typ, payload, err := readField(st)
+	if err != nil {
+		_ = st.Reset()
+		return
+	}
+	switch typ {
+	case reqConnect:
+		f.handleConnect(st, string(payload))
+	case reqListen:
+		f.handleListen(st, string(payload))
+	case reqForwarded:
+		f.handleForwarded(st, payload)
+	default:
+		refuse(st, errs.New("unknown request %d", typ))
+	}
+}
+
+func (f *Forwarder) handleConnect(st *noisemux.Stream, target string) {
+	if f.config.AllowConnect == nil || !f.config.AllowConnect(target) {
+		refuse(st, errs.New("connect to %q not allowed", target))
+		return
+	}
+	conn, err := f.config.Dial(f.ctx, "tcp", target)
+	if err != nil {
+		refuse(st, err)
+		return
+	}
+	defer func() { _ = conn.Close() }()
+	if err := writeField(st, statusOK, nil); err != nil {
+		_ = st.Reset()
+		return
+	}
+	relay(f.ctx, conn, st)
+}
+
+func (f *Forwarder) handleListen(st *noisemux.Stream, address string) {
+	if f.config.AllowListen == nil || !f.config.AllowListen(address) {
+		refuse(st, errs.New("listen on %q not allowed", address))
+		return
+	}
+	lis, err := f.config.Listen("tcp", address)
+	if err != nil {
+		refuse(st, err)
+		return
+	}
+	defer func() { _ = lis.Close() }()
+	if err := writeField(st, statusOK, []byte(lis.Addr().String())); err != nil {
+		_ = st.Reset()
+		return
+	}
+
+	// the forward ends when the requester closes or resets the control
+	// stream, or when the session ends.
+	go func() {
+		_, _ = io.Copy(io.Discard, st)
+		_ = lis.Close()
+	}()
+	defer func() { _ = st.Close() }()
+
+	id := binary.BigEndian.AppendUint32(nil, st.ID())
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { _ = conn.Close() }()
+			fst, _, err := f.request(reqForwarded, id)
+			if err != nil {
+				return
+			}
+			relay(f.ctx, conn, fst)
+		}()
+	}
+}
+
+func (f *Forwarder) handleForwarded(st *noisemux.Stream, payload []byte) {
+	if len(payload) != 4 {
+		refuse(st, errs.New("invalid forwarded request"))
+		return
+	}
+	f.mu.Lock()
+	target, ok := f.forwards[binary.BigEndian.Uint32(payload)]
+	f.mu.Unlock()
+	if !ok {
+		refuse(st, errs.New("unknown forward"))
+		return
+	}
+	conn, err := f.config.Dial(f.ctx, "tcp", target)
+	if err != nil {
+		refuse(st, err)
+		return
+	}
+	defer func() { _ = conn.Close() }()
+	if err := writeField(st, statusOK, nil); err != nil {
+		_ = st.Reset()
+		return
+	}
+	relay(f.ctx, conn, st)
+}
+
+func refuse(st *noisemux.Stream, err error) {
+	msg := err.Error()
+	if len(msg) > maxFieldLen {
+		msg = msg[:maxFieldLen]
+	}
+	_ = writeField(st, statusError, []byte(msg))
+	_ = st.Close()
+}
+
+// relay copies data between conn and st until both directions are done,
+// propagating half-closes, or until ctx is canceled.
+func relay(ctx context.Context, conn net.Conn, st *noisemux.Stream) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = st.Reset()
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := io.Copy(st, conn); err != nil {
+			_ = st.Reset()
+			return
+		}
+		_ = st.Close()
+	}()
+	if _, err := io.Copy(conn, st); err != nil {
+		_ = conn.Close()
+	} else if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+	} else {
+		_ = conn.Close()
+	}
+	wg.Wait()
+}
+
+func writeField(w io.Writer, typ byte, payload []byte) error {
+	if len(payload) > maxFieldLen {
+		return errs.New("field too long")
+	}
+	buf := append([]byte{typ}, binary.BigEndian.AppendUint16(nil, uint16(len(payload)))...)
+	_, err := w.Write(append(buf, payload...))
+	return errs.Wrap(err)
+}
+
+func readField(r io.Reader) (typ byte, payload []byte, err error) {
+	var hdr [3]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, errs.Wrap(err)
+	}
+	payload = make([]byte, binary.BigEndian.Uint16(hdr[1:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, errs.Wrap(err)
+	}
+	return hdr[0], payload, nil
+}
@@ -0,0 +1,130 @@
+package noiseforward
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+	"github.com/jtolio/noiseconn"
+	"github.com/jtolio/noiseconn/noisemux"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestForward(t *testing.T) {
+	serverKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	p1, p2 := net.Pipe()
+	client, err := noiseconn.NewConn(p1, noise.Config{
+		CipherSuite: noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+		Pattern:     noise.HandshakeNK,
+		Initiator:   true,
+		PeerStatic:  serverKey.Public,
+	})
+	if err != nil {
+		panic(err)
+	}
+	server, err := noiseconn.NewConn(p2, noise.Config{
+		CipherSuite:   noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+		Pattern:       noise.HandshakeNK,
+		StaticKeypair: serverKey,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var eg errgroup.Group
+	var csess, ssess *noisemux.Session
+	eg.Go(func() (err error) {
+		csess, err = noisemux.Client(ctx, client)
+		return err
+	})
+	eg.Go(func() (err error) {
+		ssess, err = noisemux.Server(ctx, server)
+		return err
+	})
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	allow := func(string) bool { return true }
+	cf := New(csess, Config{AllowConnect: allow})
+	defer cf.Close()
+	sf := New(ssess, Config{AllowConnect: allow, AllowListen: allow})
+	defer sf.Close()
+
+	roundTrip := func(addr string) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			panic(err)
+		}
+		_ = conn.(*net.TCPConn).CloseWrite()
+		got, err := io.ReadAll(conn)
+		if err != nil {
+			panic(err)
+		}
+		if string(got) != "hello" {
+			t.Fatalf("unexpected response %q", got)
+		}
+	}
+
+	t.Run("local", func(t *testing.T) {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			panic(err)
+		}
+		lctx, lcancel := context.WithCancel(ctx)
+		var leg errgroup.Group
+		leg.Go(func() error { return cf.LocalForward(lctx, lis, echo.Addr().String()) })
+		roundTrip(lis.Addr().String())
+		lcancel()
+		if err := leg.Wait(); err != nil {
+			panic(err)
+		}
+	})
+
+	t.Run("remote", func(t *testing.T) {
+		fwd, err := cf.RemoteForward("127.0.0.1:0", echo.Addr().String())
+		if err != nil {
+			panic(err)
+		}
+		roundTrip(fwd.Addr())
+		if err := fwd.Close(); err != nil {
+			panic(err)
+		}
+	})
+
+	t.Run("refused", func(t *testing.T) {
+		if _, err := sf.RemoteForward("127.0.0.1:0", echo.Addr().String()); err == nil {
+			t.Fatal("expected listen request to be refused")
+		}
+	})
+}
@@ -0,0 +1,126 @@
+// Package noisegrpc provides gRPC transport credentials backed by
+// noiseconn, so gRPC can run over Noise instead of TLS.
+package noisegrpc
+
+import (
+	"context"
+	"net"
+
+	"github.com/flynn/noise"
+	"github.com/jtolio/noiseconn"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// AuthType is the value returned by AuthInfo.AuthType.
+const AuthType = "noise"
+
+// AuthInfo is the credentials.AuthInfo for connections established with
+// Credentials. It is available to handlers through peer.FromContext.
+type AuthInfo struct {
+	credentials.CommonAuthInfo
+
+	// PeerStatic is the static public key of the peer, if the handshake
+	// pattern provided one.
+	PeerStatic []byte
+
+	// HandshakeHash is the hash of the completed handshake, which can be
+	// used for channel binding.
+	HandshakeHash []byte
+}
+
+// AuthType returns AuthType.
+func (AuthInfo) AuthType() string { return AuthType }
+
+// PeerStatic returns the static public key of the peer of the gRPC call
+// associated with ctx, if the call came in over Credentials.
+func PeerStatic(ctx context.Context) ([]byte, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	info, ok := p.AuthInfo.(AuthInfo)
+	if !ok || info.PeerStatic == nil {
+		return nil, false
+	}
+	return info.PeerStatic, true
+}
+
+// Credentials implements credentials.TransportCredentials. The same
+// Credentials can be used for both clients and servers; the Initiator field
+// of the noise.Config is set depending on the side.
+type Credentials struct {
+	config noise.Config
+	opts   noiseconn.Options
+}
+
+var _ credentials.TransportCredentials = (*Credentials)(nil)
+
+// New returns Credentials that secure connections with config.
+func New(config noise.Config) *Credentials {
+	return NewWithOptions(config, noiseconn.Options{})
+}
+
+// NewWithOptions returns Credentials that secure connections with config
+// and options provided by noiseconn.Options.
+func NewWithOptions(config noise.Config, opts noiseconn.Options) *Credentials {
+	return &Credentials{config: config, opts: opts}
+}
+
+// ClientHandshake runs the Noise handshake as an initiator. The handshake
+// is bounded by ctx.
+func (c *Credentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	config := c.config
+	config.Initiator = true
+	conn, err := noiseconn.NewConnWithOptions(rawConn, config, c.opts)
+	if err != nil {
+		_ = rawConn.Close()
+		return nil, nil, err
+	}
+	if err := conn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	return conn, authInfo(conn), nil
+}
+
+// ServerHandshake runs the Noise handshake as a responder. The handshake
+// is bounded by the deadline gRPC sets on rawConn.
+func (c *Credentials) ServerHandshake(rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	config := c.config
+	config.Initiator = false
+	conn, err := noiseconn.NewConnWithOptions(rawConn, config, c.opts)
+	if err != nil {
+		_ = rawConn.Close()
+		return nil, nil, err
+	}
+	if err := conn.Handshake(); err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	return conn, authInfo(conn), nil
+}
+
+func authInfo(conn *noiseconn.Conn) AuthInfo {
+	return AuthInfo{
+		CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity},
+		PeerStatic:     conn.PeerStatic(),
+		HandshakeHash:  conn.HandshakeHash(),
+	}
+}
+
+// Info returns the protocol info of the Credentials.
+func (c *Credentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: AuthType}
+}
+
+// Clone returns a copy of the Credentials.
+func (c *Credentials) Clone() credentials.TransportCredentials {
+	clone := *c
+	return &clone
+}
+
+// OverrideServerName is a no-op, as Noise has no notion of server names.
+func (c *Credentials) OverrideServerName(string) error {
+	return nil
+}
@@ -0,0 +1,65 @@
+package noisegrpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestCredentials(t *testing.T) {
+	clientKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	serverKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	seen := make(chan []byte, 1)
+	server := grpc.NewServer(
+		grpc.Creds(New(noise.Config{
+			CipherSuite:   noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+			Pattern:       noise.HandshakeIK,
+			StaticKeypair: serverKey,
+		})),
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			peerStatic, _ := PeerStatic(ctx)
+			seen <- peerStatic
+			return handler(ctx, req)
+		}))
+	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+	go func() { _ = server.Serve(lis) }()
+	defer server.Stop()
+
+	cc, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(New(noise.Config{
+		CipherSuite:   noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+		Pattern:       noise.HandshakeIK,
+		StaticKeypair: clientKey,
+		PeerStatic:    serverKey.Public,
+	})))
+	if err != nil {
+		panic(err)
+	}
+	defer cc.Close()
+
+	_, err = grpc_health_v1.NewHealthClient(cc).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		panic(err)
+	}
+	if !bytes.Equal(<-seen, clientKey.Public) {
+		panic("failure")
+	}
+}
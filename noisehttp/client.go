@@ -0,0 +1,43 @@
+// Package noisehttp runs HTTP over noiseconn.
+package noisehttp
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/jtolio/noiseconn"
+	"golang.org/x/net/http2"
+)
+
+// NewTransport returns an *http.Transport for HTTP/1.1 whose connections
+// are established with d. Requests must use the http scheme, as the
+// connections are already secured by Noise.
+func NewTransport(d *noiseconn.Dialer) *http.Transport {
+	return &http.Transport{
+		DialContext:           d.DialContext,
+		ForceAttemptHTTP2:     false,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// NewH2CTransport returns an *http2.Transport for HTTP/2 without TLS (h2c)
+// whose connections are established with d. Requests must use the http
+// scheme.
+func NewH2CTransport(d *noiseconn.Dialer) *http2.Transport {
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// NewClient returns an *http.Client using NewTransport(d).
+func NewClient(d *noiseconn.Dialer) *http.Client {
+	return &http.Client{Transport: NewTransport(d)}
+}
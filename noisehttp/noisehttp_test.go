@@ -0,0 +1,92 @@
+package noisehttp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/flynn/noise"
+	"github.com/jtolio/noiseconn"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestHTTP(t *testing.T) {
+	clientKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	serverKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	otherKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if peerStatic, _ := PeerStatic(r); !bytes.Equal(peerStatic, clientKey.Public) {
+			http.Error(w, "unknown peer", http.StatusForbidden)
+			return
+		}
+		_, _ = io.WriteString(w, r.Proto)
+	})
+	server := &http.Server{Handler: h2c.NewHandler(handler, &http2.Server{})}
+	go func() {
+		_ = Serve(server, lis, noise.Config{
+			CipherSuite:   noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+			Pattern:       noise.HandshakeXX,
+			StaticKeypair: serverKey,
+		})
+	}()
+	defer server.Close()
+
+	dialer := func(pinned []byte) *noiseconn.Dialer {
+		return &noiseconn.Dialer{
+			Config: noise.Config{
+				CipherSuite:   noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+				Pattern:       noise.HandshakeXX,
+				StaticKeypair: clientKey,
+			},
+			Options: noiseconn.Options{VerifyPeer: noiseconn.PinPeers(pinned)},
+		}
+	}
+	get := func(rt http.RoundTripper) (string, error) {
+		resp, err := (&http.Client{Transport: rt}).Get("http://" + lis.Addr().String())
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	proto, err := get(NewTransport(dialer(serverKey.Public)))
+	if err != nil {
+		panic(err)
+	}
+	if proto != "HTTP/1.1" {
+		panic("failure: " + proto)
+	}
+
+	proto, err = get(NewH2CTransport(dialer(serverKey.Public)))
+	if err != nil {
+		panic(err)
+	}
+	if proto != "HTTP/2.0" {
+		panic("failure: " + proto)
+	}
+
+	_, err = get(NewTransport(dialer(otherKey.Public)))
+	if err == nil {
+		panic("expected pinning failure")
+	}
+}
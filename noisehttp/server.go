@@ -0,0 +1,64 @@
+package noisehttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/flynn/noise"
+	"github.com/jtolio/noiseconn"
+)
+
+type connKey struct{}
+
+// ConnContext stores c in ctx, so handlers can find the Noise connection
+// (and with it the peer's identity) their request arrived on. It is meant
+// to be used as the ConnContext field of an http.Server.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if nc, ok := c.(*noiseconn.Conn); ok {
+		ctx = context.WithValue(ctx, connKey{}, nc)
+	}
+	return ctx
+}
+
+// Conn returns the Noise connection r arrived on, if the http.Server was
+// configured with ConnContext.
+func Conn(r *http.Request) (*noiseconn.Conn, bool) {
+	conn, ok := r.Context().Value(connKey{}).(*noiseconn.Conn)
+	return conn, ok
+}
+
+// PeerStatic returns the static public key of the peer that sent r, if the
+// http.Server was configured with ConnContext and the handshake pattern
+// provided one.
+func PeerStatic(r *http.Request) ([]byte, bool) {
+	conn, ok := Conn(r)
+	if !ok {
+		return nil, false
+	}
+	peerStatic := conn.PeerStatic()
+	return peerStatic, peerStatic != nil
+}
+
+// NewListener wraps inner so that accepted connections are secured with
+// config. The Initiator field of config is ignored.
+func NewListener(inner net.Listener, config noise.Config) net.Listener {
+	return NewListenerWithOptions(inner, config, noiseconn.Options{})
+}
+
+// NewListenerWithOptions is like NewListener, but with options provided by
+// noiseconn.Options.
+func NewListenerWithOptions(inner net.Listener, config noise.Config, opts noiseconn.Options) net.Listener {
+	config.Initiator = false
+	return noiseconn.NewListenerWithOptions(inner, config, opts)
+}
+
+// Serve serves HTTP requests with srv on connections accepted from lis and
+// secured with config. If srv.ConnContext is unset, it is set to
+// ConnContext.
+func Serve(srv *http.Server, lis net.Listener, config noise.Config) error {
+	if srv.ConnContext == nil {
+		srv.ConnContext = ConnContext
+	}
+	return srv.Serve(NewListener(lis, config))
+}
@@ -0,0 +1,238 @@
+// Package noisemux multiplexes many streams over a single noiseconn.Conn.
+//
+// Unlike layering a generic multiplexer over the byte stream, every mux
+// frame is carried in exactly one Noise message (see
+// noiseconn.MessageConn), so frames are not length-prefixed or buffered a
+// second time.
+package noisemux
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/flynn/noise"
+	"github.com/jtolio/noiseconn"
+	"github.com/zeebo/errs"
+)
+
+const (
+	frameOpen   = 0x01
+	frameData   = 0x02
+	frameFin    = 0x03
+	frameRst    = 0x04
+	frameWindow = 0x05
+
+	headerLen = 4 + 1
+
+	// maxPayload is the largest amount of stream data in a single frame.
+	maxPayload = noise.MaxMsgLen - headerLen
+
+	// initialWindow is how much data a stream may have in flight before
+	// the receiver grants more.
+	initialWindow = 256 * 1024
+
+	acceptBacklog = 256
+)
+
+// ErrSessionClosed is returned when using a closed Session.
+var ErrSessionClosed = errors.New("session closed")
+
+// Session multiplexes streams over a single Noise connection.
+type Session struct {
+	mc     *noiseconn.MessageConn
+	client bool
+
+	writeMu sync.Mutex
+	wbuf    []byte
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	nextID  uint32
+	err     error
+
+	accept    chan *Stream
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Client completes the handshake of conn, bounded by ctx, and starts a
+// client Session over it. conn must not have been read from or written to.
+func Client(ctx context.Context, conn *noiseconn.Conn) (*Session, error) {
+	return newSession(ctx, conn, true)
+}
+
+// Server completes the handshake of conn, bounded by ctx, and starts a
+// server Session over it. conn must not have been read from or written to.
+func Server(ctx context.Context, conn *noiseconn.Conn) (*Session, error) {
+	return newSession(ctx, conn, false)
+}
+
+func newSession(ctx context.Context, conn *noiseconn.Conn, client bool) (*Session, error) {
+	mc := noiseconn.NewMessageConn(conn)
+	// frames are read and written concurrently, which is only supported
+	// once the handshake is complete.
+	if err := conn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	s := &Session{
+		mc:      mc,
+		client:  client,
+		streams: map[uint32]*Stream{},
+		accept:  make(chan *Stream, acceptBacklog),
+		closed:  make(chan struct{}),
+	}
+	// clients use odd stream ids, servers even ones.
+	s.nextID = 2
+	if client {
+		s.nextID = 1
+	}
+	go s.recvLoop()
+	return s, nil
+}
+
+// Open opens a new stream.
+func (s *Session) Open() (*Stream, error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	id := s.nextID
+	s.nextID += 2
+	st := newStream(s, id)
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	if err := s.writeFrame(id, frameOpen, nil); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Accept waits for and returns the next stream opened by the peer.
+func (s *Session) Accept() (*Stream, error) {
+	select {
+	case st := <-s.accept:
+		return st, nil
+	case <-s.closed:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return nil, s.err
+	}
+}
+
+// Close closes the session, all of its streams, and the underlying
+// connection.
+func (s *Session) Close() error {
+	s.fail(ErrSessionClosed)
+	return nil
+}
+
+// Conn returns the underlying Noise connection.
+func (s *Session) Conn() *noiseconn.Conn {
+	return s.mc.Conn
+}
+
+func (s *Session) fail(err error) {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.err = err
+		streams := s.streams
+		s.streams = map[uint32]*Stream{}
+		s.mu.Unlock()
+		for _, st := range streams {
+			st.reset(err)
+		}
+		close(s.closed)
+		_ = s.mc.Close()
+	})
+}
+
+func (s *Session) writeFrame(id uint32, typ byte, payload []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.wbuf = binary.BigEndian.AppendUint32(s.wbuf[:0], id)
+	s.wbuf = append(append(s.wbuf, typ), payload...)
+	if err := s.mc.WriteMsg(s.wbuf); err != nil {
+		s.fail(err)
+		return err
+	}
+	return nil
+}
+
+func (s *Session) recvLoop() {
+	for {
+		msg, err := s.mc.ReadMsg()
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		if len(msg) < headerLen {
+			s.fail(errs.New("short frame"))
+			return
+		}
+		id, typ, payload := binary.BigEndian.Uint32(msg[:4]), msg[4], msg[headerLen:]
+		if err := s.handleFrame(id, typ, payload); err != nil {
+			s.fail(err)
+			return
+		}
+	}
+}
+
+func (s *Session) handleFrame(id uint32, typ byte, payload []byte) error {
+	if typ == frameOpen {
+		if (id%2 == 1) == s.client {
+			return errs.New("peer opened stream with invalid id %d", id)
+		}
+		s.mu.Lock()
+		if _, exists := s.streams[id]; exists {
+			s.mu.Unlock()
+			return errs.New("peer reopened stream %d", id)
+		}
+		st := newStream(s, id)
+		s.streams[id] = st
+		s.mu.Unlock()
+		select {
+		case s.accept <- st:
+		default:
+			s.removeStream(id)
+			go func() { _ = s.writeFrame(id, frameRst, nil) }()
+		}
+		return nil
+	}
+
+	s.mu.Lock()
+	st := s.streams[id]
+	s.mu.Unlock()
+	if st == nil {
+		// frames for streams that were already closed locally.
+		return nil
+	}
+
+	switch typ {
+	case frameData:
+		return st.receive(payload)
+	case frameFin:
+		st.receiveFin()
+	case frameRst:
+		s.removeStream(id)
+		st.reset(errs.New("stream reset by peer"))
+	case frameWindow:
+		if len(payload) != 4 {
+			return errs.New("invalid window update")
+		}
+		st.grant(binary.BigEndian.Uint32(payload))
+	default:
+		return errs.New("unknown frame type %d", typ)
+	}
+	return nil
+}
+
+func (s *Session) removeStream(id uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.streams, id)
+}
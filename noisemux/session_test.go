@@ -0,0 +1,102 @@
+package noisemux
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+	"github.com/jtolio/noiseconn"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestSession(t *testing.T) {
+	serverKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	p1, p2 := net.Pipe()
+	client, err := noiseconn.NewConn(p1, noise.Config{
+		CipherSuite: noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+		Pattern:     noise.HandshakeNK,
+		Initiator:   true,
+		PeerStatic:  serverKey.Public,
+	})
+	if err != nil {
+		panic(err)
+	}
+	server, err := noiseconn.NewConn(p2, noise.Config{
+		CipherSuite:   noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+		Pattern:       noise.HandshakeNK,
+		StaticKeypair: serverKey,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	ctx := context.Background()
+	var eg errgroup.Group
+	var csess, ssess *Session
+	eg.Go(func() (err error) {
+		csess, err = Client(ctx, client)
+		return err
+	})
+	eg.Go(func() (err error) {
+		ssess, err = Server(ctx, server)
+		return err
+	})
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	defer csess.Close()
+	defer ssess.Close()
+
+	// larger than the receive window, so flow control is exercised.
+	data := make([]byte, 3*initialWindow+123)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	const streams = 4
+	for i := 0; i < streams; i++ {
+		eg.Go(func() error {
+			stream, err := csess.Open()
+			if err != nil {
+				return err
+			}
+			var weg errgroup.Group
+			weg.Go(func() error {
+				if _, err := stream.Write(data); err != nil {
+					return err
+				}
+				return stream.Close()
+			})
+			got, err := io.ReadAll(stream)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(got, data) {
+				panic("failure")
+			}
+			return weg.Wait()
+		})
+	}
+	for i := 0; i < streams; i++ {
+		stream, err := ssess.Accept()
+		if err != nil {
+			panic(err)
+		}
+		eg.Go(func() error {
+			if _, err := io.Copy(stream, stream); err != nil {
+				return err
+			}
+			return stream.Close()
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,168 @@
+package noisemux
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/zeebo/errs"
+)
+
+// Stream is a single bidirectional stream of a Session.
+type Stream struct {
+	s  *Session
+	id uint32
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	buf      []byte
+	consumed uint32
+	window   uint32
+	recvFin  bool
+	sentFin  bool
+	err      error
+}
+
+func newStream(s *Session, id uint32) *Stream {
+	st := &Stream{s: s, id: id, window: initialWindow}
+	st.cond = sync.NewCond(&st.mu)
+	return st
+}
+
+// ID returns the stream id.
+func (st *Stream) ID() uint32 { return st.id }
+
+// Read reads data sent by the peer. It returns io.EOF once the peer closed
+// the stream and all data was read.
+func (st *Stream) Read(b []byte) (n int, err error) {
+	st.mu.Lock()
+	for len(st.buf) == 0 && !st.recvFin && st.err == nil {
+		st.cond.Wait()
+	}
+	if len(st.buf) == 0 {
+		defer st.mu.Unlock()
+		if st.err != nil {
+			return 0, st.err
+		}
+		return 0, io.EOF
+	}
+	n = copy(b, st.buf)
+	st.buf = st.buf[n:]
+	st.consumed += uint32(n)
+	var grant uint32
+	if st.consumed >= initialWindow/2 && !st.recvFin {
+		grant, st.consumed = st.consumed, 0
+	}
+	st.mu.Unlock()
+
+	if grant > 0 {
+		var payload [4]byte
+		binary.BigEndian.PutUint32(payload[:], grant)
+		if err := st.s.writeFrame(st.id, frameWindow, payload[:]); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Write sends b to the peer, blocking while the peer's receive window is
+// exhausted.
+func (st *Stream) Write(b []byte) (n int, err error) {
+	for len(b) > 0 {
+		st.mu.Lock()
+		for st.window == 0 && st.err == nil && !st.sentFin {
+			st.cond.Wait()
+		}
+		if st.err != nil {
+			defer st.mu.Unlock()
+			return n, st.err
+		}
+		if st.sentFin {
+			st.mu.Unlock()
+			return n, errs.New("write on closed stream")
+		}
+		l := len(b)
+		if l > maxPayload {
+			l = maxPayload
+		}
+		if uint32(l) > st.window {
+			l = int(st.window)
+		}
+		st.window -= uint32(l)
+		st.mu.Unlock()
+
+		if err := st.s.writeFrame(st.id, frameData, b[:l]); err != nil {
+			return n, err
+		}
+		n += l
+		b = b[l:]
+	}
+	return n, nil
+}
+
+// Close closes the stream for writing. Reads continue until the peer closes
+// the stream too.
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	if st.sentFin || st.err != nil {
+		st.mu.Unlock()
+		return nil
+	}
+	st.sentFin = true
+	done := st.recvFin
+	st.cond.Broadcast()
+	st.mu.Unlock()
+
+	if done {
+		st.s.removeStream(st.id)
+	}
+	return st.s.writeFrame(st.id, frameFin, nil)
+}
+
+// Reset abortively closes the stream in both directions.
+func (st *Stream) Reset() error {
+	st.s.removeStream(st.id)
+	st.reset(errs.New("stream reset"))
+	return st.s.writeFrame(st.id, frameRst, nil)
+}
+
+func (st *Stream) receive(payload []byte) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.recvFin {
+		return errs.New("data after fin on stream %d", st.id)
+	}
+	if len(st.buf)+len(payload) > initialWindow {
+		return errs.New("peer exceeded receive window on stream %d", st.id)
+	}
+	st.buf = append(st.buf, payload...)
+	st.cond.Broadcast()
+	return nil
+}
+
+func (st *Stream) receiveFin() {
+	st.mu.Lock()
+	st.recvFin = true
+	done := st.sentFin
+	st.cond.Broadcast()
+	st.mu.Unlock()
+	if done {
+		st.s.removeStream(st.id)
+	}
+}
+
+func (st *Stream) grant(n uint32) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.window += n
+	st.cond.Broadcast()
+}
+
+func (st *Stream) reset(err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.err == nil {
+		st.err = err
+	}
+	st.cond.Broadcast()
+}
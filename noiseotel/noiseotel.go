@@ -0,0 +1,146 @@
+// Package noiseotel traces noiseconn connections with OpenTelemetry.
+//
+// A Tracer emits a noiseconn.handshake span for every handshake, with an
+// event per handshake message, and a noiseconn.close span when the
+// connection is closed. Dials through Tracer.DialContext are wrapped in a
+// noiseconn.dial span, which is the parent of the other spans. The spans
+// carry the fingerprint of the static public key of the peer, if known.
+package noiseotel
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jtolio/noiseconn"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/jtolio/noiseconn/noiseotel"
+
+// Attribute keys set on the spans.
+const (
+	PeerFingerprintKey = attribute.Key("noiseconn.peer.fingerprint")
+	RemoteAddrKey      = attribute.Key("noiseconn.remote_addr")
+	MessageSentKey     = attribute.Key("noiseconn.message.sent")
+	MessageSizeKey     = attribute.Key("noiseconn.message.size")
+)
+
+// Tracer traces the connections it provides the hooks for. Connections
+// must be closed for their state to be released.
+type Tracer struct {
+	tracer trace.Tracer
+	conns  sync.Map // *noiseconn.Conn -> *connState
+	hooks  *noiseconn.Hooks
+}
+
+type connState struct {
+	handshake trace.Span
+
+	mu    sync.Mutex
+	ended bool
+}
+
+// New returns a Tracer using tp. If tp is nil, the global TracerProvider
+// is used.
+func New(tp trace.TracerProvider) *Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	t := &Tracer{tracer: tp.Tracer(instrumentationName)}
+	t.hooks = t.newHooks(context.Background())
+	return t
+}
+
+// Hooks returns the hooks to set as noiseconn.Options.Hooks to trace a
+// connection. The spans of these connections have no parent.
+func (t *Tracer) Hooks() *noiseconn.Hooks {
+	return t.hooks
+}
+
+// DialContext dials address with d in a noiseconn.dial span, which is the
+// parent of the spans of the returned connection. Any Hooks of d are kept.
+func (t *Tracer) DialContext(ctx context.Context, d *noiseconn.Dialer, network, address string) (_ net.Conn, err error) {
+	ctx, span := t.tracer.Start(ctx, "noiseconn.dial", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("net.transport", network), attribute.String("net.peer.name", address)))
+	defer span.End()
+
+	dialer := *d
+	dialer.Options.Hooks = noiseconn.CombineHooks(d.Options.Hooks, t.newHooks(ctx))
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	if peerStatic := conn.(*noiseconn.Conn).PeerStatic(); len(peerStatic) > 0 {
+		span.SetAttributes(PeerFingerprintKey.String(noiseconn.Fingerprint(peerStatic)))
+	}
+	return conn, nil
+}
+
+func (t *Tracer) newHooks(parent context.Context) *noiseconn.Hooks {
+	return &noiseconn.Hooks{
+		HandshakeMessage: func(c *noiseconn.Conn, sent bool, size int) {
+			state := t.state(parent, c, time.Now())
+			state.handshake.AddEvent("noiseconn.handshake.message", trace.WithAttributes(
+				MessageSentKey.Bool(sent), MessageSizeKey.Int(size)))
+		},
+		HandshakeDone: func(c *noiseconn.Conn, d time.Duration, peerStatic []byte, err error) {
+			state := t.state(parent, c, time.Now().Add(-d))
+			span := state.handshake
+			if len(peerStatic) > 0 {
+				span.SetAttributes(PeerFingerprintKey.String(noiseconn.Fingerprint(peerStatic)))
+			}
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			span.End()
+			state.mu.Lock()
+			state.ended = true
+			state.mu.Unlock()
+		},
+		Closed: func(c *noiseconn.Conn) {
+			v, ok := t.conns.LoadAndDelete(c)
+			ctx := parent
+			if ok {
+				state := v.(*connState)
+				state.mu.Lock()
+				ended := state.ended
+				state.mu.Unlock()
+				if !ended {
+					state.handshake.SetStatus(codes.Error, "closed during the handshake")
+					state.handshake.End()
+				}
+				ctx = trace.ContextWithSpan(parent, state.handshake)
+			}
+			_, span := t.tracer.Start(ctx, "noiseconn.close", trace.WithAttributes(remoteAddr(c)))
+			span.End()
+		},
+	}
+}
+
+// state returns the state of c, starting its handshake span at start if
+// needed.
+func (t *Tracer) state(parent context.Context, c *noiseconn.Conn, start time.Time) *connState {
+	if v, ok := t.conns.Load(c); ok {
+		return v.(*connState)
+	}
+	_, span := t.tracer.Start(parent, "noiseconn.handshake",
+		trace.WithTimestamp(start), trace.WithAttributes(remoteAddr(c)))
+	state := &connState{handshake: span}
+	t.conns.Store(c, state)
+	return state
+}
+
+func remoteAddr(c *noiseconn.Conn) attribute.KeyValue {
+	if addr := c.RemoteAddr(); addr != nil {
+		return RemoteAddrKey.String(addr.String())
+	}
+	return RemoteAddrKey.String("")
+}
@@ -0,0 +1,97 @@
+package noiseotel
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+	"github.com/jtolio/noiseconn"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracer(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tr := New(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	serverKey, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	l := noiseconn.NewListenerWithOptions(inner, noise.Config{
+		CipherSuite: cs, Pattern: noise.HandshakeNK, StaticKeypair: serverKey,
+	}, noiseconn.Options{Hooks: tr.Hooks()})
+	defer func() { _ = l.Close() }()
+	served := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			served <- err
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_, err = io.Copy(conn, conn)
+		served <- err
+	}()
+
+	conn, err := tr.DialContext(context.Background(), &noiseconn.Dialer{
+		Config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNK, PeerStatic: serverKey.Public},
+	}, "tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+	if err := <-served; err != nil && !errors.Is(err, io.EOF) {
+		t.Fatal(err)
+	}
+
+	fingerprint := noiseconn.Fingerprint(serverKey.Public)
+	var dial, handshakes, closes int
+	for _, span := range sr.Ended() {
+		switch span.Name() {
+		case "noiseconn.dial":
+			dial++
+			if !hasAttr(span, PeerFingerprintKey, fingerprint) {
+				t.Error("expected the dial span to have the peer fingerprint")
+			}
+		case "noiseconn.handshake":
+			handshakes++
+			if len(span.Events()) != 2 {
+				t.Errorf("expected 2 handshake messages, got %d", len(span.Events()))
+			}
+			if span.Parent().IsValid() && !hasAttr(span, PeerFingerprintKey, fingerprint) {
+				t.Error("expected the client handshake span to have the peer fingerprint")
+			}
+		case "noiseconn.close":
+			closes++
+		}
+	}
+	if dial != 1 || handshakes != 2 || closes != 2 {
+		t.Fatalf("unexpected spans: %d dial, %d handshake, %d close", dial, handshakes, closes)
+	}
+}
+
+func hasAttr(span sdktrace.ReadOnlySpan, key attribute.Key, value string) bool {
+	for _, attr := range span.Attributes() {
+		if attr.Key == key && attr.Value.AsString() == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,262 @@
+// Package noisep2p implements libp2p's sec.SecureTransport on top of
+// noiseconn.
+//
+// Noise static keys are bound to libp2p identities by an identity record
+// every side sends first: its libp2p public key, and a signature by that key
+// over its Noise static public key. When the first handshake message of the
+// pattern is encrypted (such as with IK), the initiator's identity record
+// travels in the handshake payload, so no extra round trip is needed.
+package noisep2p
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+
+	"github.com/flynn/noise"
+	"github.com/jtolio/noiseconn"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/sec"
+	"github.com/zeebo/errs"
+)
+
+// ID is the protocol ID of the Transport.
+const ID protocol.ID = "/noiseconn/1.0.0"
+
+const (
+	signaturePrefix   = "noiseconn-libp2p-static-key:"
+	maxIdentityRecord = 8 * 1024
+)
+
+// Transport is a sec.SecureTransport using noiseconn.
+type Transport struct {
+	localID peer.ID
+	privKey crypto.PrivKey
+	config  noise.Config
+	opts    noiseconn.Options
+}
+
+var _ sec.SecureTransport = (*Transport)(nil)
+
+// New returns a Transport for the identity privKey, using the Noise XX
+// pattern with a freshly generated static key.
+func New(privKey crypto.PrivKey) (*Transport, error) {
+	static, err := noise.DH25519.GenerateKeypair(nil)
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	return NewWithConfig(privKey, noise.Config{
+		CipherSuite:   noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashSHA256),
+		Pattern:       noise.HandshakeXX,
+		StaticKeypair: static,
+	}, noiseconn.Options{})
+}
+
+// NewWithConfig returns a Transport for the identity privKey, securing
+// connections with config and options provided by noiseconn.Options. The
+// pattern must transmit or pre-share the static keys of both sides. The
+// Initiator field of config is ignored.
+func NewWithConfig(privKey crypto.PrivKey, config noise.Config, opts noiseconn.Options) (*Transport, error) {
+	localID, err := peer.IDFromPrivateKey(privKey)
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	return &Transport{
+		localID: localID,
+		privKey: privKey,
+		config:  config,
+		opts:    opts,
+	}, nil
+}
+
+// ID returns the protocol ID of the Transport.
+func (t *Transport) ID() protocol.ID { return ID }
+
+// SecureInbound secures an inbound connection. If p is empty, connections
+// from any peer are accepted.
+func (t *Transport) SecureInbound(ctx context.Context, insecure net.Conn, p peer.ID) (sec.SecureConn, error) {
+	return t.secure(ctx, insecure, p, false)
+}
+
+// SecureOutbound secures an outbound connection to p.
+func (t *Transport) SecureOutbound(ctx context.Context, insecure net.Conn, p peer.ID) (sec.SecureConn, error) {
+	return t.secure(ctx, insecure, p, true)
+}
+
+func (t *Transport) secure(ctx context.Context, insecure net.Conn, p peer.ID, initiator bool) (_ sec.SecureConn, err error) {
+	config := t.config
+	config.Initiator = initiator
+	conn, err := noiseconn.NewConnWithOptions(insecure, config, t.opts)
+	if err != nil {
+		_ = insecure.Close()
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = conn.Close()
+		}
+	}()
+
+	// ctx bounds the handshake and the identity exchange, so the handshake
+	// is driven under the same deadline instead of with HandshakeContext,
+	// which clears it.
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := insecure.SetDeadline(deadline); err != nil {
+			return nil, errs.Wrap(err)
+		}
+		defer func() { _ = insecure.SetDeadline(time.Time{}) }()
+	}
+	if ctx.Done() != nil {
+		done := make(chan struct{})
+		interrupted := make(chan struct{})
+		go func() {
+			defer close(interrupted)
+			select {
+			case <-ctx.Done():
+				_ = insecure.SetDeadline(time.Unix(1, 0))
+			case <-done:
+			}
+		}()
+		defer func() {
+			close(done)
+			<-interrupted
+			// an interrupt leaves the deadline in the past, so the conn
+			// fails and is closed even if the exchange finished.
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				err = errs.Wrap(ctxErr)
+			}
+		}()
+	}
+
+	var remoteKey crypto.PubKey
+	if initiator {
+		if !firstPayloadEncrypted(config) {
+			// don't reveal our identity to passive observers.
+			if err := conn.Handshake(); err != nil {
+				return nil, err
+			}
+		}
+		if err := t.writeIdentity(conn); err != nil {
+			return nil, err
+		}
+		if remoteKey, err = readIdentity(conn); err != nil {
+			return nil, err
+		}
+	} else {
+		if remoteKey, err = readIdentity(conn); err != nil {
+			return nil, err
+		}
+		if err := t.writeIdentity(conn); err != nil {
+			return nil, err
+		}
+	}
+
+	remoteID, err := peer.IDFromPublicKey(remoteKey)
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	if p != "" && remoteID != p {
+		return nil, errs.New("peer id mismatch: expected %s, got %s", p, remoteID)
+	}
+	return &secureConn{
+		Conn:      conn,
+		localID:   t.localID,
+		remoteID:  remoteID,
+		remoteKey: remoteKey,
+	}, nil
+}
+
+func (t *Transport) writeIdentity(conn *noiseconn.Conn) error {
+	key, err := crypto.MarshalPublicKey(t.privKey.GetPublic())
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	sig, err := t.privKey.Sign(append([]byte(signaturePrefix), t.config.StaticKeypair.Public...))
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	record := make([]byte, 0, 4+len(key)+len(sig))
+	record = binary.BigEndian.AppendUint16(record, uint16(len(key)))
+	record = append(record, key...)
+	record = binary.BigEndian.AppendUint16(record, uint16(len(sig)))
+	record = append(record, sig...)
+	_, err = conn.Write(record)
+	return errs.Wrap(err)
+}
+
+func readIdentity(conn *noiseconn.Conn) (crypto.PubKey, error) {
+	readField := func() ([]byte, error) {
+		var size [2]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return nil, errs.Wrap(err)
+		}
+		field := make([]byte, binary.BigEndian.Uint16(size[:]))
+		if len(field) > maxIdentityRecord {
+			return nil, errs.New("identity record too large")
+		}
+		_, err := io.ReadFull(conn, field)
+		return field, errs.Wrap(err)
+	}
+	keyBytes, err := readField()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := readField()
+	if err != nil {
+		return nil, err
+	}
+
+	peerStatic := conn.PeerStatic()
+	if peerStatic == nil {
+		return nil, errs.New("handshake pattern does not authenticate the peer")
+	}
+	key, err := crypto.UnmarshalPublicKey(keyBytes)
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	ok, err := key.Verify(append([]byte(signaturePrefix), peerStatic...), sig)
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	if !ok {
+		return nil, errs.New("invalid identity signature")
+	}
+	return key, nil
+}
+
+// firstPayloadEncrypted returns whether the payload of the first handshake
+// message is encrypted.
+func firstPayloadEncrypted(config noise.Config) bool {
+	if len(config.PresharedKey) > 0 && config.PresharedKeyPlacement <= 1 {
+		return true
+	}
+	for _, token := range config.Pattern.Messages[0] {
+		switch token {
+		case noise.MessagePatternDHEE, noise.MessagePatternDHES,
+			noise.MessagePatternDHSE, noise.MessagePatternDHSS:
+			return true
+		}
+	}
+	return false
+}
+
+type secureConn struct {
+	*noiseconn.Conn
+	localID   peer.ID
+	remoteID  peer.ID
+	remoteKey crypto.PubKey
+}
+
+var _ sec.SecureConn = (*secureConn)(nil)
+
+func (c *secureConn) LocalPeer() peer.ID             { return c.localID }
+func (c *secureConn) RemotePeer() peer.ID            { return c.remoteID }
+func (c *secureConn) RemotePublicKey() crypto.PubKey { return c.remoteKey }
+func (c *secureConn) ConnState() network.ConnectionState {
+	return network.ConnectionState{Security: ID}
+}
@@ -0,0 +1,155 @@
+package noisep2p
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+	"github.com/jtolio/noiseconn"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/sec"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestTransport(t *testing.T) {
+	newTransport := func() (*Transport, peer.ID) {
+		priv, _, err := crypto.GenerateEd25519Key(nil)
+		if err != nil {
+			panic(err)
+		}
+		tr, err := New(priv)
+		if err != nil {
+			panic(err)
+		}
+		id, err := peer.IDFromPrivateKey(priv)
+		if err != nil {
+			panic(err)
+		}
+		return tr, id
+	}
+	client, clientID := newTransport()
+	server, serverID := newTransport()
+
+	p1, p2 := net.Pipe()
+	ctx := context.Background()
+
+	var eg errgroup.Group
+	var inbound, outbound sec.SecureConn
+	eg.Go(func() (err error) {
+		outbound, err = client.SecureOutbound(ctx, p1, serverID)
+		if err != nil {
+			return err
+		}
+		_, err = outbound.Write([]byte("hello"))
+		return err
+	})
+	eg.Go(func() (err error) {
+		inbound, err = server.SecureInbound(ctx, p2, "")
+		if err != nil {
+			return err
+		}
+		b := make([]byte, 5)
+		_, err = io.ReadFull(inbound, b)
+		if err != nil {
+			return err
+		}
+		if string(b) != "hello" {
+			panic("failure")
+		}
+		return nil
+	})
+	err := eg.Wait()
+	if err != nil {
+		panic(err)
+	}
+	if inbound.RemotePeer() != clientID || outbound.RemotePeer() != serverID ||
+		inbound.LocalPeer() != serverID || outbound.LocalPeer() != clientID {
+		panic("failure")
+	}
+}
+
+func TestTransportPeerMismatch(t *testing.T) {
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		panic(err)
+	}
+	other, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		panic(err)
+	}
+	otherID, err := peer.IDFromPrivateKey(other)
+	if err != nil {
+		panic(err)
+	}
+	client, err := New(priv)
+	if err != nil {
+		panic(err)
+	}
+	server, err := New(priv)
+	if err != nil {
+		panic(err)
+	}
+
+	p1, p2 := net.Pipe()
+	go func() { _, _ = server.SecureInbound(context.Background(), p2, "") }()
+	_, err = client.SecureOutbound(context.Background(), p1, otherID)
+	if err == nil {
+		panic("expected error")
+	}
+}
+
+func TestTransportIdentityTimeout(t *testing.T) {
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		panic(err)
+	}
+	client, err := New(priv)
+	if err != nil {
+		panic(err)
+	}
+	serverID, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		panic(err)
+	}
+
+	// the server completes the Noise handshake, but never sends its
+	// identity record.
+	p1, p2 := net.Pipe()
+	defer func() { _ = p2.Close() }()
+	server, err := noiseconn.NewConn(p2, noise.Config{
+		CipherSuite: client.config.CipherSuite,
+		Pattern:     noise.HandshakeXX,
+		StaticKeypair: func() noise.DHKey {
+			key, err := noise.DH25519.GenerateKeypair(nil)
+			if err != nil {
+				panic(err)
+			}
+			return key
+		}(),
+	})
+	if err != nil {
+		panic(err)
+	}
+	go func() {
+		if err := server.Handshake(); err == nil {
+			_, _ = io.Copy(io.Discard, server)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = client.SecureOutbound(ctx, p1, serverID)
+	if !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the deadline to expire, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("returned after %v", elapsed)
+	}
+}
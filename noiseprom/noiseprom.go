@@ -0,0 +1,168 @@
+// Package noiseprom exports metrics of noiseconn connections as Prometheus
+// collectors.
+package noiseprom
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jtolio/noiseconn"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is a prometheus.Collector with the metrics of the connections
+// it is set as noiseconn.Options.Hooks for:
+//
+//   - noiseconn_handshakes_total, by result: "ok", or the reason of the
+//     failure ("peer_rejected", "replayed", "timeout", "closed" or
+//     "error").
+//   - noiseconn_handshake_duration_seconds, of successful handshakes.
+//   - noiseconn_active_connections, with a completed handshake that
+//     weren't closed yet.
+//   - noiseconn_bytes_total and noiseconn_frames_total, of Noise messages
+//     by direction ("sent" or "received").
+//   - noiseconn_rekeys_total, of changed transport keys by direction.
+//   - noiseconn_decrypt_failures_total, of received transport frames that
+//     failed authentication or were malformed.
+type Collector struct {
+	handshakes *prometheus.CounterVec
+	duration   prometheus.Histogram
+	active     prometheus.Gauge
+	bytes      *prometheus.CounterVec
+	frames     *prometheus.CounterVec
+	rekeys     *prometheus.CounterVec
+	decrypt    prometheus.Counter
+
+	sent, received         prometheus.Counter
+	sentFrames, recvFrames prometheus.Counter
+
+	established sync.Map
+	hooks       noiseconn.Hooks
+}
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+// New returns a Collector. It has to be registered to be exported.
+func New() *Collector {
+	c := &Collector{
+		handshakes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "noiseconn_handshakes_total",
+			Help: "Number of Noise handshakes by result.",
+		}, []string{"result"}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "noiseconn_handshake_duration_seconds",
+			Help:    "Duration of successful Noise handshakes.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+		}),
+		active: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "noiseconn_active_connections",
+			Help: "Number of connections with a completed handshake that weren't closed.",
+		}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "noiseconn_bytes_total",
+			Help: "Size of the Noise messages by direction.",
+		}, []string{"direction"}),
+		frames: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "noiseconn_frames_total",
+			Help: "Number of Noise messages by direction.",
+		}, []string{"direction"}),
+		rekeys: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "noiseconn_rekeys_total",
+			Help: "Number of changed transport keys by direction.",
+		}, []string{"direction"}),
+		decrypt: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "noiseconn_decrypt_failures_total",
+			Help: "Number of received transport frames that failed authentication or were malformed.",
+		}),
+	}
+	c.sent = c.bytes.WithLabelValues("sent")
+	c.received = c.bytes.WithLabelValues("received")
+	c.sentFrames = c.frames.WithLabelValues("sent")
+	c.recvFrames = c.frames.WithLabelValues("received")
+	c.hooks = noiseconn.Hooks{
+		HandshakeDone: c.handshakeDone,
+		FrameSent: func(_ *noiseconn.Conn, size int) {
+			c.sent.Add(float64(size))
+			c.sentFrames.Inc()
+		},
+		FrameReceived: func(_ *noiseconn.Conn, size int) {
+			c.received.Add(float64(size))
+			c.recvFrames.Inc()
+		},
+		DecryptFailed: func(*noiseconn.Conn, error) {
+			c.decrypt.Inc()
+		},
+		Rekey: func(_ *noiseconn.Conn, sent bool) {
+			if sent {
+				c.rekeys.WithLabelValues("sent").Inc()
+			} else {
+				c.rekeys.WithLabelValues("received").Inc()
+			}
+		},
+		Closed: func(conn *noiseconn.Conn) {
+			if _, ok := c.established.LoadAndDelete(conn); ok {
+				c.active.Dec()
+			}
+		},
+	}
+	return c
+}
+
+// Hooks returns the hooks to set as noiseconn.Options.Hooks to collect the
+// metrics of a connection.
+func (c *Collector) Hooks() *noiseconn.Hooks {
+	return &c.hooks
+}
+
+func (c *Collector) handshakeDone(conn *noiseconn.Conn, d time.Duration, _ []byte, err error) {
+	if err != nil {
+		c.handshakes.WithLabelValues(Reason(err)).Inc()
+		return
+	}
+	c.handshakes.WithLabelValues("ok").Inc()
+	c.duration.Observe(d.Seconds())
+	c.established.Store(conn, struct{}{})
+	c.active.Inc()
+}
+
+// Reason classifies a handshake error into the failure reasons used as
+// metric labels.
+func Reason(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, noiseconn.ErrPeerRejected):
+		return "peer_rejected"
+	case errors.Is(err, noiseconn.ErrReplayedHandshake):
+		return "replayed"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, net.ErrClosed), errors.Is(err, io.ErrClosedPipe):
+		return "closed"
+	}
+	return "error"
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.handshakes.Describe(ch)
+	c.duration.Describe(ch)
+	c.active.Describe(ch)
+	c.bytes.Describe(ch)
+	c.frames.Describe(ch)
+	c.rekeys.Describe(ch)
+	c.decrypt.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.handshakes.Collect(ch)
+	c.duration.Collect(ch)
+	c.active.Collect(ch)
+	c.bytes.Collect(ch)
+	c.frames.Collect(ch)
+	c.rekeys.Collect(ch)
+	c.decrypt.Collect(ch)
+}
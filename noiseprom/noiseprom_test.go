@@ -0,0 +1,89 @@
+package noiseprom
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+	"github.com/jtolio/noiseconn"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestCollector(t *testing.T) {
+	c := New()
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(c); err != nil {
+		panic(err)
+	}
+
+	p1, p2 := net.Pipe()
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	opts := noiseconn.Options{Hooks: c.Hooks()}
+	client, err := noiseconn.NewConnWithOptions(p1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true}, opts)
+	if err != nil {
+		panic(err)
+	}
+	server, err := noiseconn.NewConnWithOptions(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, opts)
+	if err != nil {
+		panic(err)
+	}
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		if _, err := client.Write([]byte("hello")); err != nil {
+			return err
+		}
+		_, err := io.ReadFull(client, make([]byte, 5))
+		return err
+	})
+	eg.Go(func() error {
+		if _, err := io.ReadFull(server, make([]byte, 5)); err != nil {
+			return err
+		}
+		_, err := server.Write([]byte("world"))
+		return err
+	})
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+
+	if got := testutil.ToFloat64(c.handshakes.WithLabelValues("ok")); got != 2 {
+		t.Fatalf("expected 2 handshakes, got %v", got)
+	}
+	if got := testutil.ToFloat64(c.active); got != 2 {
+		t.Fatalf("expected 2 active connections, got %v", got)
+	}
+	// the data is carried by the two handshake messages.
+	if got := testutil.ToFloat64(c.sentFrames); got != 2 {
+		t.Fatalf("expected 2 frames, got %v", got)
+	}
+	if testutil.ToFloat64(c.sent) != testutil.ToFloat64(c.received) {
+		t.Fatal("expected the sent and received bytes to match")
+	}
+
+	_ = client.Close()
+	_ = server.Close()
+	if got := testutil.ToFloat64(c.active); got != 0 {
+		t.Fatalf("expected no active connections, got %v", got)
+	}
+
+	// a peer that disconnects during the handshake.
+	p1, p2 = net.Pipe()
+	server, err = noiseconn.NewConnWithOptions(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, opts)
+	if err != nil {
+		panic(err)
+	}
+	_ = p1.Close()
+	if err := server.Handshake(); err == nil {
+		t.Fatal("expected the handshake to fail")
+	}
+	if got := testutil.ToFloat64(c.handshakes.WithLabelValues("closed")); got != 1 {
+		t.Fatalf("expected a failed handshake, got %v", got)
+	}
+	if _, err := reg.Gather(); err != nil {
+		t.Fatal(err)
+	}
+}
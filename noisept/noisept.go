@@ -0,0 +1,171 @@
+// Package noisept exposes noiseconn as a Pluggable Transport, following
+// the shape of the Pluggable Transports 2.x Go API: clients have a Dial
+// method, servers a Listen method, and both are constructed from the
+// transport's key=value arguments.
+//
+// The transport uses the Noise NK pattern: the server is authenticated by
+// its static key, which clients learn out of band (as part of a bridge
+// line, for example), and clients are anonymous.
+//
+// The transport is not resistant to deep packet inspection. Every frame
+// starts with the fixed noiseconn header byte and a length, and the
+// ephemeral keys of the handshake are sent as plain Curve25519 points,
+// which can be told apart from random bytes. It protects the contents and
+// the metadata inside the connection, but a censor can recognize and block
+// the protocol. Use a transport that obfuscates its traffic, such as one
+// encoding the keys with Elligator and randomizing the framing, where
+// that matters.
+package noisept
+
+import (
+	"encoding/base64"
+	"net"
+	"strings"
+
+	"github.com/flynn/noise"
+	"github.com/jtolio/noiseconn"
+	"github.com/zeebo/errs"
+	"golang.org/x/crypto/curve25519"
+)
+
+// TransportName is the name of the transport.
+const TransportName = "noise"
+
+const (
+	// ArgServerKey is the client argument holding the base64-encoded
+	// static public key of the server.
+	ArgServerKey = "server-key"
+
+	// ArgPrivateKey is the server argument holding the base64-encoded
+	// static private key of the server.
+	ArgPrivateKey = "private-key"
+)
+
+var cipherSuite = noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2s)
+
+// Args are the key=value arguments of a transport.
+type Args map[string]string
+
+// ParseArgs parses arguments in the k=v;k=v format used by Pluggable
+// Transports, where '\' escapes '=', ';' and '\'.
+func ParseArgs(s string) (Args, error) {
+	args := Args{}
+	if s == "" {
+		return args, nil
+	}
+	var key, cur strings.Builder
+	inValue := false
+	finish := func() error {
+		if !inValue {
+			return errs.New("argument %q has no value", cur.String())
+		}
+		args[key.String()] = cur.String()
+		key.Reset()
+		cur.Reset()
+		inValue = false
+		return nil
+	}
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case ch == '\\':
+			i++
+			if i == len(s) {
+				return nil, errs.New("trailing escape in arguments")
+			}
+			cur.WriteByte(s[i])
+		case ch == '=' && !inValue:
+			key.WriteString(cur.String())
+			cur.Reset()
+			inValue = true
+		case ch == ';':
+			if err := finish(); err != nil {
+				return nil, err
+			}
+		default:
+			cur.WriteByte(ch)
+		}
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+func (args Args) key(name string, size int) ([]byte, error) {
+	encoded, ok := args[name]
+	if !ok {
+		return nil, errs.New("missing argument %q", name)
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errs.New("invalid argument %q: %v", name, err)
+	}
+	if len(key) != size {
+		return nil, errs.New("invalid argument %q: expected %d bytes", name, size)
+	}
+	return key, nil
+}
+
+// Client is the client side of the transport.
+type Client struct {
+	dialer noiseconn.Dialer
+}
+
+// NewClient returns a Client configured by args, which must contain
+// ArgServerKey.
+func NewClient(args Args) (*Client, error) {
+	serverKey, err := args.key(ArgServerKey, noise.DH25519.DHLen())
+	if err != nil {
+		return nil, err
+	}
+	return &Client{dialer: noiseconn.Dialer{
+		Config: noise.Config{
+			CipherSuite: cipherSuite,
+			Pattern:     noise.HandshakeNK,
+			PeerStatic:  serverKey,
+		},
+	}}, nil
+}
+
+// Dial connects to the server at address.
+func (c *Client) Dial(address string) (net.Conn, error) {
+	return c.dialer.Dial("tcp", address)
+}
+
+// Server is the server side of the transport.
+type Server struct {
+	config noise.Config
+}
+
+// NewServer returns a Server configured by args, which must contain
+// ArgPrivateKey.
+func NewServer(args Args) (*Server, error) {
+	private, err := args.key(ArgPrivateKey, noise.DH25519.DHLen())
+	if err != nil {
+		return nil, err
+	}
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	return &Server{config: noise.Config{
+		CipherSuite:   cipherSuite,
+		Pattern:       noise.HandshakeNK,
+		StaticKeypair: noise.DHKey{Private: private, Public: public},
+	}}, nil
+}
+
+// Listen listens for client connections on address.
+func (s *Server) Listen(address string) (net.Listener, error) {
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	return noiseconn.NewListener(lis, s.config), nil
+}
+
+// ServerArgs returns the client arguments for connecting to a server with
+// the static key keypair.
+func ServerArgs(keypair noise.DHKey) Args {
+	return Args{ArgServerKey: base64.StdEncoding.EncodeToString(keypair.Public)}
+}
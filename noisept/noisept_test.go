@@ -0,0 +1,77 @@
+package noisept
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestParseArgs(t *testing.T) {
+	args, err := ParseArgs(`a=b;c=d\;e;f=g\=h\\`)
+	if err != nil {
+		panic(err)
+	}
+	if len(args) != 3 || args["a"] != "b" || args["c"] != "d;e" || args["f"] != `g=h\` {
+		t.Fatalf("unexpected args: %v", args)
+	}
+	if _, err := ParseArgs("a"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestTransport(t *testing.T) {
+	serverKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	server, err := NewServer(Args{ArgPrivateKey: base64.StdEncoding.EncodeToString(serverKey.Private)})
+	if err != nil {
+		panic(err)
+	}
+	lis, err := server.Listen("127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	defer lis.Close()
+
+	client, err := NewClient(ServerArgs(serverKey))
+	if err != nil {
+		panic(err)
+	}
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		conn, err := lis.Accept()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+		return nil
+	})
+
+	conn, err := client.Dial(lis.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+	data := []byte("hello, bridge")
+	if _, err := conn.Write(data); err != nil {
+		panic(err)
+	}
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		panic(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("mismatch")
+	}
+	_ = conn.Close()
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,196 @@
+// Package noisesocks implements a SOCKS5 proxy whose client traffic is
+// carried inside noiseconn tunnels.
+//
+// Every proxied connection uses its own tunnel. Peers authenticate with
+// their Noise static keys rather than SOCKS credentials, so the server can
+// decide which destinations each peer may reach with Server.Authorize.
+// Requests are refused unless Authorize allows them, as an open proxy
+// would let anyone reach the internal network of the server.
+package noisesocks
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/flynn/noise"
+	"github.com/jtolio/noiseconn"
+	"github.com/jtolio/noiseconn/internal/socks5"
+	"github.com/zeebo/errs"
+)
+
+// Server is a SOCKS5 server accepting Noise tunnels.
+type Server struct {
+	// Config is the Noise configuration for accepted tunnels. The
+	// Initiator field is ignored.
+	Config noise.Config
+
+	// Options are the options for accepted tunnels.
+	Options noiseconn.Options
+
+	// Dial dials the destinations requested by clients. If nil, a zero
+	// net.Dialer is used.
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+
+	// Authorize is called with the static public key of the peer (nil if
+	// the pattern doesn't transmit one) and the requested destination.
+	// Returning an error refuses the request. If nil, every request is
+	// refused; AllowAll allows every peer to reach any destination,
+	// including ones only the server can reach, such as internal
+	// addresses.
+	Authorize func(peerStatic []byte, address string) error
+
+	// RequestTimeout bounds the handshake and reading the SOCKS request of
+	// a tunnel, so idle clients don't hold on to the server. Defaults to
+	// DefaultRequestTimeout.
+	RequestTimeout time.Duration
+}
+
+// DefaultRequestTimeout is the default Server.RequestTimeout.
+const DefaultRequestTimeout = 10 * time.Second
+
+// AllowAll is a Server.Authorize function allowing every request.
+func AllowAll(peerStatic []byte, address string) error { return nil }
+
+// Serve accepts tunnels from lis and serves them until ctx is canceled or
+// lis fails.
+func (s *Server) Serve(ctx context.Context, lis net.Listener) error {
+	config := s.Config
+	config.Initiator = false
+	nlis := noiseconn.NewListenerWithOptions(lis, config, s.Options)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		_ = nlis.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := nlis.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return errs.Wrap(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = s.ServeConn(ctx, conn.(*noiseconn.Conn))
+		}()
+	}
+}
+
+// ServeConn serves a single SOCKS5 request on conn and proxies the
+// connection. It closes conn when done.
+func (s *Server) ServeConn(ctx context.Context, conn *noiseconn.Conn) error {
+	defer func() { _ = conn.Close() }()
+
+	timeout := s.RequestTimeout
+	if timeout <= 0 {
+		timeout = DefaultRequestTimeout
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return errs.Wrap(err)
+	}
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return err
+	}
+	req, err := socks5.ReadRequest(conn, nil)
+	if err != nil {
+		return err
+	}
+	if req.Command != socks5.CmdConnect {
+		_ = socks5.WriteReply(conn, socks5.ReplyCommandNotSupported, nil)
+		return errs.New("unsupported socks command %d", req.Command)
+	}
+	authorize := s.Authorize
+	if authorize == nil {
+		authorize = func([]byte, string) error { return errs.New("no Authorize function configured") }
+	}
+	if err := authorize(conn.PeerStatic(), req.Address); err != nil {
+		_ = socks5.WriteReply(conn, socks5.ReplyNotAllowed, nil)
+		return err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return errs.Wrap(err)
+	}
+
+	dial := s.Dial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	dst, err := dial(ctx, "tcp", req.Address)
+	if err != nil {
+		_ = socks5.WriteReply(conn, socks5.ReplyHostUnreachable, nil)
+		return errs.Wrap(err)
+	}
+	defer func() { _ = dst.Close() }()
+	if err := socks5.WriteReply(conn, socks5.ReplySucceeded, dst.LocalAddr()); err != nil {
+		return err
+	}
+
+	return proxy(conn, dst)
+}
+
+func proxy(conn, dst net.Conn) error {
+	errc := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(dst, conn)
+		// propagate the client's end of stream while still relaying the
+		// response.
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok && err == nil {
+			_ = cw.CloseWrite()
+			return
+		}
+		errc <- err
+	}()
+	go func() {
+		_, err := io.Copy(conn, dst)
+		errc <- err
+	}()
+	return errs.Wrap(<-errc)
+}
+
+// Dialer dials destinations through a Server.
+type Dialer struct {
+	// Dialer establishes the tunnels to the server.
+	Dialer noiseconn.Dialer
+
+	// Network and Address locate the server.
+	Network string
+	Address string
+}
+
+// Dial connects to address through the server.
+func (d *Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to address through the server. Only TCP networks
+// are supported.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, errs.New("unsupported network %q", network)
+	}
+	conn, err := d.Dialer.DialContext(ctx, d.Network, d.Address)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+		defer func() { _ = conn.SetDeadline(time.Time{}) }()
+	}
+	if err := socks5.Connect(conn, address, nil); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
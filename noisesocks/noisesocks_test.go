@@ -0,0 +1,152 @@
+package noisesocks
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+	"github.com/jtolio/noiseconn"
+	"github.com/zeebo/errs"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestProxy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clientKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	serverKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	srv := &Server{
+		Config: noise.Config{
+			CipherSuite:   cs,
+			Pattern:       noise.HandshakeIK,
+			StaticKeypair: serverKey,
+		},
+		Options: noiseconn.Options{VerifyPeer: noiseconn.PinPeers(clientKey.Public)},
+		Authorize: func(peerStatic []byte, address string) error {
+			if address != echo.Addr().String() {
+				return errs.New("not allowed")
+			}
+			return nil
+		},
+	}
+	var eg errgroup.Group
+	eg.Go(func() error { return srv.Serve(ctx, lis) })
+
+	d := &Dialer{
+		Dialer: noiseconn.Dialer{Config: noise.Config{
+			CipherSuite:   cs,
+			Pattern:       noise.HandshakeIK,
+			StaticKeypair: clientKey,
+			PeerStatic:    serverKey.Public,
+		}},
+		Network: "tcp",
+		Address: lis.Addr().String(),
+	}
+
+	conn, err := d.DialContext(ctx, "tcp", echo.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+	data := []byte("through the tunnel")
+	if _, err := conn.Write(data); err != nil {
+		panic(err)
+	}
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		panic(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("mismatch")
+	}
+	_ = conn.Close()
+
+	if _, err := d.DialContext(ctx, "tcp", "127.0.0.1:1"); err == nil {
+		t.Fatal("expected unauthorized destination to fail")
+	}
+
+	cancel()
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+}
+
+func TestServerDefaults(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	srv := &Server{
+		Config:         noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN},
+		RequestTimeout: 100 * time.Millisecond,
+	}
+	var eg errgroup.Group
+	eg.Go(func() error { return srv.Serve(ctx, lis) })
+	d := &Dialer{
+		Dialer:  noiseconn.Dialer{Config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}},
+		Network: "tcp",
+		Address: lis.Addr().String(),
+	}
+
+	// without Authorize, requests are refused.
+	if _, err := d.DialContext(ctx, "tcp", lis.Addr().String()); err == nil {
+		t.Fatal("expected the request to be refused")
+	}
+
+	// tunnels that don't send a request are closed.
+	conn, err := d.Dialer.DialContext(ctx, d.Network, d.Address)
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the server to close the tunnel, got %v", err)
+	}
+
+	cancel()
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,38 @@
+// Package noisews runs noiseconn over WebSocket connections, carrying every
+// Noise message in a single binary WebSocket message.
+package noisews
+
+import (
+	"github.com/flynn/noise"
+	"github.com/jtolio/noiseconn"
+	"golang.org/x/net/websocket"
+)
+
+// Transport adapts a *websocket.Conn to a noiseconn.MessageTransport.
+type Transport struct {
+	*websocket.Conn
+}
+
+var _ noiseconn.MessageTransport = Transport{}
+
+// ReadMessage returns the next WebSocket message.
+func (t Transport) ReadMessage() (msg []byte, err error) {
+	err = websocket.Message.Receive(t.Conn, &msg)
+	return msg, err
+}
+
+// WriteMessage sends b as a binary WebSocket message.
+func (t Transport) WriteMessage(b []byte) error {
+	return websocket.Message.Send(t.Conn, b)
+}
+
+// NewConn secures ws with config.
+func NewConn(ws *websocket.Conn, config noise.Config) (*noiseconn.Conn, error) {
+	return noiseconn.NewConn(Transport{Conn: ws}, config)
+}
+
+// NewConnWithOptions secures ws with config and options provided by
+// noiseconn.Options.
+func NewConnWithOptions(ws *websocket.Conn, config noise.Config, opts noiseconn.Options) (*noiseconn.Conn, error) {
+	return noiseconn.NewConnWithOptions(Transport{Conn: ws}, config, opts)
+}
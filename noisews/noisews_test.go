@@ -0,0 +1,70 @@
+package noisews
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/net/websocket"
+)
+
+func TestConn(t *testing.T) {
+	clientKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	serverKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	data := make([]byte, 100000)
+	for i := range data {
+		data[i] = byte(i % 256)
+	}
+
+	server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		conn, err := NewConn(ws, noise.Config{
+			CipherSuite:   noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+			Pattern:       noise.HandshakeIK,
+			StaticKeypair: serverKey,
+		})
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, err := websocket.Dial(url, "", server.URL)
+	if err != nil {
+		panic(err)
+	}
+	client, err := NewConn(ws, noise.Config{
+		CipherSuite:   noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+		Pattern:       noise.HandshakeIK,
+		Initiator:     true,
+		StaticKeypair: clientKey,
+		PeerStatic:    serverKey.Public,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer client.Close()
+
+	go func() { _, _ = client.Write(data) }()
+	got := make([]byte, len(data))
+	_, err = io.ReadFull(client, got)
+	if err != nil {
+		panic(err)
+	}
+	if !bytes.Equal(got, data) {
+		panic("failure")
+	}
+}
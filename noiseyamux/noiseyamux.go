@@ -0,0 +1,65 @@
+// Package noiseyamux layers yamux stream multiplexing over noiseconn.
+package noiseyamux
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"github.com/jtolio/noiseconn"
+	"github.com/zeebo/errs"
+)
+
+// DefaultConfig returns the yamux configuration used when nil is passed.
+// It enables keepalives, so dead peers are noticed even on idle sessions.
+func DefaultConfig() *yamux.Config {
+	config := yamux.DefaultConfig()
+	config.EnableKeepAlive = true
+	config.KeepAliveInterval = 15 * time.Second
+	config.ConnectionWriteTimeout = 10 * time.Second
+	config.LogOutput = io.Discard
+	return config
+}
+
+// Client completes the handshake of conn, bounded by ctx, and starts a
+// yamux client session over it. yamux reads and writes concurrently, which
+// Conn only supports once the handshake is complete. If config is nil,
+// DefaultConfig is used.
+func Client(ctx context.Context, conn *noiseconn.Conn, config *yamux.Config) (*yamux.Session, error) {
+	return session(ctx, conn, config, yamux.Client)
+}
+
+// Server completes the handshake of conn, bounded by ctx, and starts a
+// yamux server session over it. If config is nil, DefaultConfig is used.
+func Server(ctx context.Context, conn *noiseconn.Conn, config *yamux.Config) (*yamux.Session, error) {
+	return session(ctx, conn, config, yamux.Server)
+}
+
+// Dial dials address with d and starts a yamux client session over the
+// resulting connection. If config is nil, DefaultConfig is used.
+func Dial(ctx context.Context, d *noiseconn.Dialer, network, address string, config *yamux.Config) (*yamux.Session, error) {
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return Client(ctx, conn.(*noiseconn.Conn), config)
+}
+
+func session(ctx context.Context, conn *noiseconn.Conn,
+	config *yamux.Config, start func(io.ReadWriteCloser, *yamux.Config) (*yamux.Session, error),
+) (*yamux.Session, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	if err := conn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	sess, err := start(conn, config)
+	if err != nil {
+		_ = conn.Close()
+		return nil, errs.Wrap(err)
+	}
+	return sess, nil
+}
@@ -0,0 +1,89 @@
+package noiseyamux
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+	"github.com/hashicorp/yamux"
+	"github.com/jtolio/noiseconn"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestSession(t *testing.T) {
+	serverKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	p1, p2 := net.Pipe()
+	client, err := noiseconn.NewConn(p1, noise.Config{
+		CipherSuite: noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+		Pattern:     noise.HandshakeNK,
+		Initiator:   true,
+		PeerStatic:  serverKey.Public,
+	})
+	if err != nil {
+		panic(err)
+	}
+	server, err := noiseconn.NewConn(p2, noise.Config{
+		CipherSuite:   noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+		Pattern:       noise.HandshakeNK,
+		StaticKeypair: serverKey,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	ctx := context.Background()
+	var eg errgroup.Group
+	var csess, ssess *yamux.Session
+	eg.Go(func() (err error) {
+		csess, err = Client(ctx, client, nil)
+		return err
+	})
+	eg.Go(func() (err error) {
+		ssess, err = Server(ctx, server, nil)
+		return err
+	})
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	defer csess.Close()
+	defer ssess.Close()
+
+	for i := 0; i < 3; i++ {
+		eg.Go(func() error {
+			stream, err := csess.Open()
+			if err != nil {
+				return err
+			}
+			defer stream.Close()
+			if _, err := stream.Write([]byte("ping")); err != nil {
+				return err
+			}
+			b := make([]byte, 4)
+			if _, err := io.ReadFull(stream, b); err != nil {
+				return err
+			}
+			if string(b) != "ping" {
+				panic("failure")
+			}
+			return nil
+		})
+		stream, err := ssess.Accept()
+		if err != nil {
+			panic(err)
+		}
+		eg.Go(func() error {
+			defer stream.Close()
+			_, err := io.CopyN(stream, stream, 4)
+			return err
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+}
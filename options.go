@@ -0,0 +1,319 @@
+package noiseconn
+
+import (
+	"crypto/ed25519"
+	"io"
+	"net"
+	"time"
+
+	"github.com/flynn/noise"
+)
+
+// Option configures a Conn. Every field of Options has a corresponding
+// With function returning an Option, and Options itself is an Option that
+// replaces everything set by the options before it, so existing Options
+// values can be passed where an Option is expected.
+type Option interface {
+	apply(opts *Options)
+}
+
+type optionFunc func(opts *Options)
+
+func (f optionFunc) apply(opts *Options) { f(opts) }
+
+func (o Options) apply(opts *Options) { *opts = o }
+
+// applyOptions returns the Options configured by opts, in order.
+func applyOptions(opts []Option) Options {
+	var o Options
+	for _, opt := range opts {
+		if opt != nil {
+			opt.apply(&o)
+		}
+	}
+	return o
+}
+
+// WithResponderFirstMessageValidator sets Options.ResponderFirstMessageValidator.
+func WithResponderFirstMessageValidator(validate MessageInspector) Option {
+	return optionFunc(func(opts *Options) { opts.ResponderFirstMessageValidator = validate })
+}
+
+// WithVerifyPeer sets Options.VerifyPeer.
+func WithVerifyPeer(verify PeerVerifier) Option {
+	return optionFunc(func(opts *Options) { opts.VerifyPeer = verify })
+}
+
+// WithProxyProtocol sets Options.ProxyProtocol.
+func WithProxyProtocol() Option {
+	return optionFunc(func(opts *Options) { opts.ProxyProtocol = true })
+}
+
+// WithKeyLog sets Options.KeyLog.
+func WithKeyLog(w io.Writer) Option {
+	return optionFunc(func(opts *Options) { opts.KeyLog = w })
+}
+
+// WithCapture sets Options.Capture.
+func WithCapture(capture *CaptureWriter) Option {
+	return optionFunc(func(opts *Options) { opts.Capture = capture })
+}
+
+// WithTranscript sets Options.Transcript.
+func WithTranscript(fn func(*HandshakeTranscript)) Option {
+	return optionFunc(func(opts *Options) { opts.Transcript = fn })
+}
+
+// WithRandom sets Options.Random.
+func WithRandom(random io.Reader) Option {
+	return optionFunc(func(opts *Options) { opts.Random = random })
+}
+
+// WithHandshakeExtensions sets Options.HandshakeExtensions.
+func WithHandshakeExtensions() Option {
+	return optionFunc(func(opts *Options) { opts.HandshakeExtensions = true })
+}
+
+// WithIdentity sets Options.Identity.
+func WithIdentity(chain ...Certificate) Option {
+	return optionFunc(func(opts *Options) { opts.Identity = chain })
+}
+
+// WithIdentityRoots sets Options.IdentityRoots.
+func WithIdentityRoots(roots ...ed25519.PublicKey) Option {
+	return optionFunc(func(opts *Options) { opts.IdentityRoots = roots })
+}
+
+// WithNextStatic sets Options.NextStatic.
+func WithNextStatic(next []byte) Option {
+	return optionFunc(func(opts *Options) { opts.NextStatic = next })
+}
+
+// WithNextPeerStatic sets Options.NextPeerStatic.
+func WithNextPeerStatic(fn func(addr net.Addr, peerStatic, next []byte) error) Option {
+	return optionFunc(func(opts *Options) { opts.NextPeerStatic = fn })
+}
+
+// WithPostQuantum sets Options.PostQuantum.
+func WithPostQuantum(mode PostQuantumMode) Option {
+	return optionFunc(func(opts *Options) { opts.PostQuantum = mode })
+}
+
+// WithStaticHint sets Options.StaticHint.
+func WithStaticHint(hint []byte) Option {
+	return optionFunc(func(opts *Options) { opts.StaticHint = hint })
+}
+
+// WithSelectStatic sets Options.SelectStatic.
+func WithSelectStatic(fn func(addr net.Addr, hint []byte) (noise.DHKey, error)) Option {
+	return optionFunc(func(opts *Options) { opts.SelectStatic = fn })
+}
+
+// WithStaticKey sets Options.StaticKey.
+func WithStaticKey(key StaticKey) Option {
+	return optionFunc(func(opts *Options) { opts.StaticKey = key })
+}
+
+// WithAuthToken sets Options.AuthToken.
+func WithAuthToken(token []byte) Option {
+	return optionFunc(func(opts *Options) { opts.AuthToken = token })
+}
+
+// WithVerifyToken sets Options.VerifyToken.
+func WithVerifyToken(fn func(addr net.Addr, peerStatic, token []byte) error) Option {
+	return optionFunc(func(opts *Options) { opts.VerifyToken = fn })
+}
+
+// WithSendTimestamp sets Options.SendTimestamp.
+func WithSendTimestamp() Option {
+	return optionFunc(func(opts *Options) { opts.SendTimestamp = true })
+}
+
+// WithMaxTimestampAge sets Options.MaxTimestampAge.
+func WithMaxTimestampAge(age time.Duration) Option {
+	return optionFunc(func(opts *Options) { opts.MaxTimestampAge = age })
+}
+
+// WithReplayCache sets Options.ReplayCache.
+func WithReplayCache(cache ReplayCache) Option {
+	return optionFunc(func(opts *Options) { opts.ReplayCache = cache })
+}
+
+// WithHooks sets Options.Hooks.
+func WithHooks(hooks *Hooks) Option {
+	return optionFunc(func(opts *Options) { opts.Hooks = hooks })
+}
+
+// WithLogger sets Options.Logger.
+func WithLogger(logger Logger) Option {
+	return optionFunc(func(opts *Options) { opts.Logger = logger })
+}
+
+// WithOnConnected sets Options.OnConnected.
+func WithOnConnected(fn func(c *Conn)) Option {
+	return optionFunc(func(opts *Options) { opts.OnConnected = fn })
+}
+
+// WithOnClosed sets Options.OnClosed.
+func WithOnClosed(fn func(c *Conn, reason error)) Option {
+	return optionFunc(func(opts *Options) { opts.OnClosed = fn })
+}
+
+// WithExplicitHandshake sets Options.ExplicitHandshake.
+func WithExplicitHandshake() Option {
+	return optionFunc(func(opts *Options) { opts.ExplicitHandshake = true })
+}
+
+// WithWriteWaitsForHandshake sets Options.WriteWaitsForHandshake.
+func WithWriteWaitsForHandshake() Option {
+	return optionFunc(func(opts *Options) { opts.WriteWaitsForHandshake = true })
+}
+
+// WithDetectConcurrentUse sets Options.DetectConcurrentUse.
+func WithDetectConcurrentUse() Option {
+	return optionFunc(func(opts *Options) { opts.DetectConcurrentUse = true })
+}
+
+// WithSendRate sets Options.SendRate and SendBurst.
+func WithSendRate(rate, burst int) Option {
+	return optionFunc(func(opts *Options) { opts.SendRate, opts.SendBurst = rate, burst })
+}
+
+// WithSendLimiter sets Options.SendLimiter.
+func WithSendLimiter(l *BandwidthLimiter) Option {
+	return optionFunc(func(opts *Options) { opts.SendLimiter = l })
+}
+
+// WithAdaptiveFrameSize sets Options.AdaptiveFrameSize, with frames of at
+// least minFrameSize bytes, or the default if zero.
+func WithAdaptiveFrameSize(minFrameSize int) Option {
+	return optionFunc(func(opts *Options) {
+		opts.AdaptiveFrameSize, opts.MinFrameSize = true, minFrameSize
+	})
+}
+
+// WithRekeyInterval sets Options.RekeyInterval.
+func WithRekeyInterval(interval time.Duration) Option {
+	return optionFunc(func(opts *Options) { opts.RekeyInterval = interval })
+}
+
+// WithRekeyAfterIdle sets Options.RekeyAfterIdle.
+func WithRekeyAfterIdle(idle time.Duration) Option {
+	return optionFunc(func(opts *Options) { opts.RekeyAfterIdle = idle })
+}
+
+// WithRekeyAfterBytes sets Options.RekeyAfterBytes.
+func WithRekeyAfterBytes(n int64) Option {
+	return optionFunc(func(opts *Options) { opts.RekeyAfterBytes = n })
+}
+
+// WithRequireMutualAuth sets Options.RequireMutualAuth.
+func WithRequireMutualAuth() Option {
+	return optionFunc(func(opts *Options) { opts.RequireMutualAuth = true })
+}
+
+// WithRegistry sets Options.Registry.
+func WithRegistry(registry *Registry) Option {
+	return optionFunc(func(opts *Options) { opts.Registry = registry })
+}
+
+// WithProfileLabels sets Options.ProfileLabels.
+func WithProfileLabels() Option {
+	return optionFunc(func(opts *Options) { opts.ProfileLabels = true })
+}
+
+// WithMaxHandshakePayload sets Options.MaxHandshakePayload.
+func WithMaxHandshakePayload(n int) Option {
+	return optionFunc(func(opts *Options) { opts.MaxHandshakePayload = n })
+}
+
+// WithMaxDecryptFailures sets Options.MaxDecryptFailures.
+func WithMaxDecryptFailures(n int) Option {
+	return optionFunc(func(opts *Options) { opts.MaxDecryptFailures = n })
+}
+
+// WithFIPS sets Options.FIPS.
+func WithFIPS() Option {
+	return optionFunc(func(opts *Options) { opts.FIPS = true })
+}
+
+// WithReadFull sets Options.ReadFull.
+func WithReadFull() Option {
+	return optionFunc(func(opts *Options) { opts.ReadFull = true })
+}
+
+// WithExtensions sets Options.Extensions.
+func WithExtensions(exts ...Extension) Option {
+	return optionFunc(func(opts *Options) { opts.Extensions = exts })
+}
+
+// WithMaxFrameSize sets Options.MaxFrameSize.
+func WithMaxFrameSize(size int) Option {
+	return optionFunc(func(opts *Options) { opts.MaxFrameSize = size })
+}
+
+// WithKeepaliveInterval sets Options.KeepaliveInterval.
+func WithKeepaliveInterval(interval time.Duration) Option {
+	return optionFunc(func(opts *Options) { opts.KeepaliveInterval = interval })
+}
+
+// WithIdleTimeout sets Options.IdleTimeout.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return optionFunc(func(opts *Options) { opts.IdleTimeout = timeout })
+}
+
+// WithPingInterval sets Options.PingInterval.
+func WithPingInterval(interval time.Duration) Option {
+	return optionFunc(func(opts *Options) { opts.PingInterval = interval })
+}
+
+// WithLinger sets Options.Linger.
+func WithLinger(timeout time.Duration) Option {
+	return optionFunc(func(opts *Options) { opts.Linger = timeout })
+}
+
+// WithSendCloseReason sets Options.SendCloseReason.
+func WithSendCloseReason() Option {
+	return optionFunc(func(opts *Options) { opts.SendCloseReason = true })
+}
+
+// WithServerName sets Options.ServerName.
+func WithServerName(name string) Option {
+	return optionFunc(func(opts *Options) { opts.ServerName = name })
+}
+
+// WithStaticKeys sets Options.StaticKeys.
+func WithStaticKeys(keys *StaticKeys) Option {
+	return optionFunc(func(opts *Options) { opts.StaticKeys = keys })
+}
+
+// WithFallbackPattern sets Options.FallbackPattern.
+func WithFallbackPattern(pattern noise.HandshakePattern) Option {
+	return optionFunc(func(opts *Options) { opts.FallbackPattern = pattern })
+}
+
+// WithFraming sets Options.Framing.
+func WithFraming(framing Framing) Option {
+	return optionFunc(func(opts *Options) { opts.Framing = framing })
+}
+
+// WithInsecureNullCipher sets Options.InsecureNullCipher, which disables
+// encryption.
+func WithInsecureNullCipher() Option {
+	return optionFunc(func(opts *Options) { opts.InsecureNullCipher = true })
+}
+
+// WithEarlyDataTokens sets Options.EarlyDataTokens.
+func WithEarlyDataTokens(store EarlyDataTokenStore) Option {
+	return optionFunc(func(opts *Options) { opts.EarlyDataTokens = store })
+}
+
+// WithEarlyDataToken sets Options.EarlyDataToken.
+func WithEarlyDataToken(token []byte) Option {
+	return optionFunc(func(opts *Options) { opts.EarlyDataToken = token })
+}
+
+// WithOnEarlyDataToken sets Options.OnEarlyDataToken.
+func WithOnEarlyDataToken(fn func(token []byte)) Option {
+	return optionFunc(func(opts *Options) { opts.OnEarlyDataToken = fn })
+}
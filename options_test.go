@@ -0,0 +1,65 @@
+package noiseconn
+
+import (
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestApplyOptions(t *testing.T) {
+	opts := applyOptions([]Option{
+		WithSendTimestamp(),
+		WithMaxTimestampAge(time.Minute),
+		nil,
+	})
+	if !opts.SendTimestamp || opts.MaxTimestampAge != time.Minute {
+		t.Fatalf("unexpected options %+v", opts)
+	}
+
+	// Options replaces the options before it.
+	opts = applyOptions([]Option{
+		WithSendTimestamp(),
+		Options{HandshakeExtensions: true},
+		WithPostQuantum(PostQuantumPreferred),
+	})
+	if opts.SendTimestamp || !opts.HandshakeExtensions || opts.PostQuantum != PostQuantumPreferred {
+		t.Fatalf("unexpected options %+v", opts)
+	}
+}
+
+func TestNewConnOptions(t *testing.T) {
+	p1, p2 := net.Pipe()
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	serverKey, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	errUnknown := errors.New("unknown peer")
+	client, err := NewConn(p1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNX, Initiator: true},
+		WithVerifyPeer(func(net.Addr, []byte) error { return errUnknown }))
+	if err != nil {
+		panic(err)
+	}
+	server, err := NewConn(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNX, StaticKeypair: serverKey})
+	if err != nil {
+		panic(err)
+	}
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		defer func() { _ = client.Close() }()
+		return client.Handshake()
+	})
+	eg.Go(func() error {
+		_ = server.Handshake()
+		return server.Close()
+	})
+	if err := eg.Wait(); !errors.Is(err, errUnknown) {
+		t.Fatal("expected the peer to be rejected, got", err)
+	}
+}
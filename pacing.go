@@ -0,0 +1,211 @@
+package noiseconn
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+// BandwidthLimiter is a token bucket limiting how many bytes per second are
+// sent, for Options.SendLimiter. It may be shared by several connections,
+// such as all bulk transfers of a host, to cap their combined rate. It may
+// be used concurrently.
+type BandwidthLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBandwidthLimiter returns a BandwidthLimiter allowing rate bytes per
+// second, in bursts of up to burst bytes.
+func NewBandwidthLimiter(rate, burst int) *BandwidthLimiter {
+	return &BandwidthLimiter{rate: float64(rate), burst: float64(burst), tokens: float64(burst)}
+}
+
+// SetRate changes the rate and burst. Connections waiting to send are
+// affected once their current wait ends.
+func (l *BandwidthLimiter) SetRate(rate, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.rate, l.burst = float64(rate), float64(burst)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+func (l *BandwidthLimiter) refill(now time.Time) {
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+}
+
+// reserve takes n bytes from the bucket, which may go into debt, and
+// returns how long to wait before sending them.
+func (l *BandwidthLimiter) reserve(n int, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(now)
+	l.tokens -= float64(n)
+	if l.tokens >= 0 || l.rate <= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// cancel returns n bytes that weren't sent after all.
+func (l *BandwidthLimiter) cancel(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens += float64(n)
+}
+
+func (l *BandwidthLimiter) burstSize() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.burst)
+}
+
+// pacer delays the frames written by a Conn to the rates of its limiters,
+// Options.SendRate and SendLimiter. Frames are paced where they are
+// written to the underlying net.Conn, after Write coalesced them, in groups
+// of whole frames no larger than the smallest burst.
+type pacer struct {
+	limiters []*BandwidthLimiter
+
+	// waited is the time spent waiting, in nanoseconds.
+	waited atomic.Int64
+
+	// deadline is the write deadline in Unix nanoseconds, or zero.
+	deadline atomic.Int64
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newPacer(opts Options) *pacer {
+	var limiters []*BandwidthLimiter
+	if opts.SendRate > 0 {
+		burst := opts.SendBurst
+		if burst == 0 {
+			burst = opts.SendRate / 10
+		}
+		limiters = append(limiters, NewBandwidthLimiter(opts.SendRate, burst))
+	}
+	if opts.SendLimiter != nil {
+		limiters = append(limiters, opts.SendLimiter)
+	}
+	if len(limiters) == 0 {
+		return nil
+	}
+	return &pacer{limiters: limiters, stop: make(chan struct{})}
+}
+
+// wait waits until n bytes may be sent.
+func (p *pacer) wait(n int) error {
+	now := time.Now()
+	var delay time.Duration
+	for _, l := range p.limiters {
+		if d := l.reserve(n, now); d > delay {
+			delay = d
+		}
+	}
+	if delay <= 0 {
+		return nil
+	}
+	cancel := func() {
+		for _, l := range p.limiters {
+			l.cancel(n)
+		}
+	}
+	if deadline := p.deadline.Load(); deadline != 0 && now.Add(delay).UnixNano() > deadline {
+		cancel()
+		return errs.Wrap(os.ErrDeadlineExceeded)
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		p.waited.Add(int64(delay))
+		return nil
+	case <-p.stop:
+		cancel()
+		return errs.Wrap(net.ErrClosed)
+	}
+}
+
+// chunk returns the largest group of frames to pace at once.
+func (p *pacer) chunk() int {
+	size := 0
+	for _, l := range p.limiters {
+		if burst := l.burstSize(); size == 0 || burst < size {
+			size = burst
+		}
+	}
+	return size
+}
+
+// close interrupts waits.
+func (p *pacer) close() {
+	p.stopOnce.Do(func() { close(p.stop) })
+}
+
+// writePaced writes buf, a buffer of framed messages, in paced groups of
+// whole frames. c.writeMu must be held, or c.hsMu during the handshake.
+func (c *Conn) writePaced(buf []byte) error {
+	chunk := c.pacer.chunk()
+	for len(buf) > 0 {
+		end := 0
+		for end < len(buf) {
+			size := 4 + int(binary.BigEndian.Uint32(buf[end:end+4])&0xffffff)
+			if end > 0 && end+size > chunk {
+				break
+			}
+			end += size
+		}
+		if err := c.pacer.wait(end); err != nil {
+			return err
+		}
+		if err := c.writeFramesNow(buf[:end]); err != nil {
+			return err
+		}
+		buf = buf[end:]
+	}
+	return nil
+}
+
+// SetDeadline sets the read and write deadlines of the underlying
+// net.Conn. The write deadline also bounds waiting for Options.SendRate
+// and SendLimiter.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.setPacerDeadline(t)
+	return c.Conn.SetDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the underlying net.Conn,
+// which also bounds waiting for Options.SendRate and SendLimiter.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.setPacerDeadline(t)
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *Conn) setPacerDeadline(t time.Time) {
+	if c.pacer == nil {
+		return
+	}
+	var deadline int64
+	if !t.IsZero() {
+		deadline = t.UnixNano()
+	}
+	c.pacer.deadline.Store(deadline)
+}
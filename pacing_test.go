@@ -0,0 +1,58 @@
+package noiseconn
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestSendRate(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	c1, c2 := tcpPair()
+	client, err := NewConn(c1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true},
+		WithSendRate(1<<20, 64<<10))
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	server, err := NewConn(c2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+	var eg errgroup.Group
+	eg.Go(client.Handshake)
+	eg.Go(server.Handshake)
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+
+	// after the burst, 448KiB take about 440ms at 1MiB/s.
+	start := time.Now()
+	eg.Go(func() error {
+		_, err := client.Write(make([]byte, 512<<10))
+		return err
+	})
+	if _, err := io.ReadFull(server, make([]byte, 512<<10)); err != nil {
+		panic(err)
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("sending took %v", elapsed)
+	}
+
+	// waits that would pass the write deadline fail.
+	if err := client.SetWriteDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+		panic(err)
+	}
+	if _, err := client.Write(make([]byte, 512<<10)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the deadline to pass, got %v", err)
+	}
+}
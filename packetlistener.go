@@ -0,0 +1,329 @@
+package noiseconn
+
+import (
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/flynn/noise"
+	"github.com/zeebo/errs"
+)
+
+const (
+	sessionQueueLen = 64
+	acceptQueueLen  = 64
+)
+
+// PacketListener is a net.Listener that accepts Noise sessions from many
+// remote addresses sharing a single net.PacketConn (such as a
+// *net.UDPConn). Accept returns a *DatagramConn per remote address.
+type PacketListener struct {
+	pc     net.PacketConn
+	config noise.Config
+	opts   DatagramOptions
+
+	mu       sync.Mutex
+	sessions map[string]*packetSession
+	err      error
+
+	accept    chan *DatagramConn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+var _ net.Listener = (*PacketListener)(nil)
+
+// NewPacketListener starts accepting Noise sessions on pc.
+func NewPacketListener(pc net.PacketConn, config noise.Config) *PacketListener {
+	return NewPacketListenerWithOptions(pc, config, DatagramOptions{})
+}
+
+// NewPacketListenerWithOptions starts accepting Noise sessions on pc, with
+// every session configured with opts. If opts.Cookies is set, the cookie
+// round trip happens before any per-session state is allocated. If
+// opts.DontFragment can't be set on pc, Accept returns the error.
+func NewPacketListenerWithOptions(pc net.PacketConn, config noise.Config, opts DatagramOptions) *PacketListener {
+	if opts.MaxSessions <= 0 {
+		opts.MaxSessions = DefaultMaxSessions
+	}
+	if opts.SessionHandshakeTimeout <= 0 {
+		opts.SessionHandshakeTimeout = DefaultSessionHandshakeTimeout
+	}
+	l := &PacketListener{
+		pc:       pc,
+		config:   config,
+		opts:     opts,
+		sessions: map[string]*packetSession{},
+		accept:   make(chan *DatagramConn, acceptQueueLen),
+		closed:   make(chan struct{}),
+	}
+	if opts.DontFragment {
+		// the sessions share pc, so its socket is configured once.
+		l.opts.DontFragment = false
+		if err := setDontFragment(pc); err != nil {
+			l.err = errs.Wrap(err)
+			l.close()
+			return l
+		}
+	}
+	go l.serve()
+	go l.expire()
+	return l
+}
+
+func (l *PacketListener) serve() {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, addr, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			l.mu.Lock()
+			l.err = errs.Wrap(err)
+			l.mu.Unlock()
+			l.close()
+			return
+		}
+		l.dispatch(addr, buf[:n])
+	}
+}
+
+func (l *PacketListener) dispatch(addr net.Addr, pkt []byte) {
+	key := addr.String()
+	l.mu.Lock()
+	sess, full := l.sessions[key], len(l.sessions) >= l.opts.MaxSessions
+	l.mu.Unlock()
+	if sess != nil {
+		sess.deliver(pkt)
+		return
+	}
+
+	if len(pkt) == 0 || (pkt[0] != dgHandshake && pkt[0] != dgHandshakeCookie) {
+		return
+	}
+	if cc := l.opts.Cookies; cc != nil && cc.required() {
+		var cookie []byte
+		if pkt[0] == dgHandshakeCookie && len(pkt) > cookieLen {
+			cookie = pkt[1 : 1+cookieLen]
+		}
+		if !cc.valid(addr, cookie) {
+			_, _ = l.pc.WriteTo(append([]byte{dgCookieReply}, cc.cookie(addr)...), addr)
+			return
+		}
+	}
+	if full || len(l.accept) == cap(l.accept) {
+		// the application isn't keeping up with Accept, or expiring
+		// sessions, so shed load.
+		return
+	}
+
+	sess = newPacketSession(l, addr)
+	conn, err := NewDatagramConnWithOptions(sess, l.config, l.opts)
+	if err != nil {
+		return
+	}
+	sess.conn = conn
+	l.mu.Lock()
+	l.sessions[key] = sess
+	l.mu.Unlock()
+	sess.deliver(pkt)
+
+	select {
+	case l.accept <- conn:
+	case <-l.closed:
+	}
+}
+
+// expire closes the sessions whose handshake didn't complete within
+// SessionHandshakeTimeout, or that were idle for SessionIdleTimeout.
+func (l *PacketListener) expire() {
+	interval := l.opts.SessionHandshakeTimeout
+	if idle := l.opts.SessionIdleTimeout; idle > 0 && idle < interval {
+		interval = idle
+	}
+	ticker := time.NewTicker(interval / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.closed:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		var expired []*packetSession
+		l.mu.Lock()
+		for _, sess := range l.sessions {
+			if sess.expired(now) {
+				expired = append(expired, sess)
+			}
+		}
+		l.mu.Unlock()
+		for _, sess := range expired {
+			_ = sess.Close()
+		}
+	}
+}
+
+func (l *PacketListener) removeSession(sess *packetSession) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sessions[sess.addr.String()] == sess {
+		delete(l.sessions, sess.addr.String())
+	}
+}
+
+// Accept waits for and returns the next session. The returned net.Conn is
+// a *DatagramConn whose handshake has not necessarily completed.
+func (l *PacketListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.accept:
+		return conn, nil
+	case <-l.closed:
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.err != nil {
+			return nil, l.err
+		}
+		return nil, errs.Wrap(net.ErrClosed)
+	}
+}
+
+// Close closes the listener, the underlying net.PacketConn, and with it all
+// sessions.
+func (l *PacketListener) Close() error {
+	l.close()
+	return l.pc.Close()
+}
+
+func (l *PacketListener) close() {
+	l.closeOnce.Do(func() { close(l.closed) })
+}
+
+// Addr returns the local address of the underlying net.PacketConn.
+func (l *PacketListener) Addr() net.Addr {
+	return l.pc.LocalAddr()
+}
+
+// packetSession is a net.Conn for a single remote address of a
+// PacketListener.
+type packetSession struct {
+	l       *PacketListener
+	addr    net.Addr
+	in      chan []byte
+	conn    *DatagramConn
+	created time.Time
+	// last is when a packet was last received, in Unix nanoseconds.
+	last atomic.Int64
+
+	mu           sync.Mutex
+	readDeadline time.Time
+	deadlineSet  chan struct{}
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPacketSession(l *PacketListener, addr net.Addr) *packetSession {
+	s := &packetSession{
+		l:           l,
+		addr:        addr,
+		in:          make(chan []byte, sessionQueueLen),
+		created:     time.Now(),
+		deadlineSet: make(chan struct{}),
+		closed:      make(chan struct{}),
+	}
+	s.last.Store(s.created.UnixNano())
+	return s
+}
+
+// expired returns whether the handshake of the session didn't complete in
+// time, or the session was idle for too long.
+func (s *packetSession) expired(now time.Time) bool {
+	opts := &s.l.opts
+	if atomic.LoadUint32(&s.conn.hsDone) == 0 {
+		return now.Sub(s.created) >= opts.SessionHandshakeTimeout
+	}
+	return opts.SessionIdleTimeout > 0 && now.Sub(time.Unix(0, s.last.Load())) >= opts.SessionIdleTimeout
+}
+
+func (s *packetSession) deliver(pkt []byte) {
+	s.last.Store(time.Now().UnixNano())
+	select {
+	case s.in <- append([]byte(nil), pkt...):
+	default:
+		// like any other datagram socket, drop when the reader is behind.
+	}
+}
+
+func (s *packetSession) Read(b []byte) (int, error) {
+	for {
+		s.mu.Lock()
+		deadline, deadlineSet := s.readDeadline, s.deadlineSet
+		s.mu.Unlock()
+
+		var timeout <-chan time.Time
+		var timer *time.Timer
+		if !deadline.IsZero() {
+			timer = time.NewTimer(time.Until(deadline))
+			timeout = timer.C
+		}
+		n, err, retry := 0, error(nil), false
+		select {
+		case pkt := <-s.in:
+			n = copy(b, pkt)
+		case <-s.closed:
+			err = net.ErrClosed
+		case <-s.l.closed:
+			err = net.ErrClosed
+		case <-timeout:
+			err = os.ErrDeadlineExceeded
+		case <-deadlineSet:
+			retry = true
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if !retry {
+			return n, err
+		}
+	}
+}
+
+func (s *packetSession) Write(b []byte) (int, error) {
+	select {
+	case <-s.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	return s.l.pc.WriteTo(b, s.addr)
+}
+
+func (s *packetSession) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		s.l.removeSession(s)
+	})
+	return nil
+}
+
+func (s *packetSession) LocalAddr() net.Addr  { return s.l.pc.LocalAddr() }
+func (s *packetSession) RemoteAddr() net.Addr { return s.addr }
+
+func (s *packetSession) SetDeadline(t time.Time) error {
+	return s.SetReadDeadline(t)
+}
+
+func (s *packetSession) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readDeadline = t
+	close(s.deadlineSet)
+	s.deadlineSet = make(chan struct{})
+	return nil
+}
+
+// SetWriteDeadline is a no-op, as write deadlines on the shared
+// net.PacketConn would affect every session.
+func (s *packetSession) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,142 @@
+package noiseconn
+
+import (
+	"crypto/rand"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestPacketListener(t *testing.T) {
+	testPacketListener(t, DatagramOptions{Cookies: NewCookieChecker(nil)})
+}
+
+func TestPacketListenerDontFragment(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("don't-fragment is only supported on linux")
+	}
+	testPacketListener(t, DatagramOptions{DontFragment: true})
+}
+
+func testPacketListener(t *testing.T, opts DatagramOptions) {
+	serverKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	l := NewPacketListenerWithOptions(pc, noise.Config{
+		CipherSuite:   noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+		Pattern:       noise.HandshakeNK,
+		StaticKeypair: serverKey,
+	}, opts)
+	defer l.Close()
+
+	var eg errgroup.Group
+	for i := 0; i < 3; i++ {
+		eg.Go(func() error {
+			udp, err := net.Dial("udp", l.Addr().String())
+			if err != nil {
+				return err
+			}
+			client, err := NewDatagramConnWithOptions(udp, noise.Config{
+				CipherSuite: noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+				Pattern:     noise.HandshakeNK,
+				Initiator:   true,
+				PeerStatic:  serverKey.Public,
+			}, DatagramOptions{DontFragment: opts.DontFragment})
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+			if _, err := client.Write([]byte("ping")); err != nil {
+				return err
+			}
+			b := make([]byte, 1024)
+			n, err := client.Read(b)
+			if err != nil {
+				return err
+			}
+			if string(b[:n]) != "pong" {
+				panic("failure")
+			}
+			return nil
+		})
+	}
+	for i := 0; i < 3; i++ {
+		conn, err := l.Accept()
+		if err != nil {
+			panic(err)
+		}
+		eg.Go(func() error {
+			defer conn.Close()
+			b := make([]byte, 1024)
+			n, err := conn.Read(b)
+			if err != nil {
+				return err
+			}
+			if string(b[:n]) != "ping" {
+				panic("failure")
+			}
+			_, err = conn.Write([]byte("pong"))
+			return err
+		})
+	}
+	err = eg.Wait()
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestPacketListenerSessionLimits(t *testing.T) {
+	serverKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	l := NewPacketListenerWithOptions(pc, noise.Config{
+		CipherSuite:   noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+		Pattern:       noise.HandshakeNK,
+		StaticKeypair: serverKey,
+	}, DatagramOptions{MaxSessions: 2, SessionHandshakeTimeout: 200 * time.Millisecond})
+	defer l.Close()
+	sessions := func() int {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return len(l.sessions)
+	}
+
+	// initiations that never complete, from more addresses than allowed.
+	for i := 0; i < 4; i++ {
+		udp, err := net.Dial("udp", l.Addr().String())
+		if err != nil {
+			panic(err)
+		}
+		defer func() { _ = udp.Close() }()
+		if _, err := udp.Write([]byte{dgHandshake, 1, 2, 3}); err != nil {
+			panic(err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := sessions(); n != 2 {
+		t.Fatalf("expected 2 sessions, got %d", n)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for sessions() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("sessions with incomplete handshakes didn't expire")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
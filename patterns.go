@@ -0,0 +1,90 @@
+package noiseconn
+
+import (
+	"net"
+
+	"github.com/flynn/noise"
+)
+
+// The pattern constructors wrap conn in a Conn for the initiator (client)
+// or responder (server) of a handshake pattern, with the keys the pattern
+// needs as arguments. They use Curve25519, ChaChaPoly and BLAKE2s; use
+// NewConn for other cipher suites. localKey is the static keypair of the
+// local peer, and peerKey the static public key of the remote peer.
+
+func newPatternConn(conn net.Conn, config noise.Config, initiator bool, localKey noise.DHKey, peerKey []byte, opts []Option) (*Conn, error) {
+	config.Initiator = initiator
+	config.StaticKeypair = localKey
+	config.PeerStatic = peerKey
+	return NewConn(conn, config, opts...)
+}
+
+// NewNNClient returns the initiator of an NN handshake, where neither peer
+// is authenticated.
+func NewNNClient(conn net.Conn, opts ...Option) (*Conn, error) {
+	return newPatternConn(conn, ProtocolNN25519ChaChaPolyBLAKE2s(), true, noise.DHKey{}, nil, opts)
+}
+
+// NewNNServer returns the responder of an NN handshake.
+func NewNNServer(conn net.Conn, opts ...Option) (*Conn, error) {
+	return newPatternConn(conn, ProtocolNN25519ChaChaPolyBLAKE2s(), false, noise.DHKey{}, nil, opts)
+}
+
+// NewNKClient returns the initiator of an NK handshake, which
+// authenticates the server with a key known in advance.
+func NewNKClient(conn net.Conn, peerKey []byte, opts ...Option) (*Conn, error) {
+	return newPatternConn(conn, ProtocolNK25519ChaChaPolyBLAKE2s(), true, noise.DHKey{}, peerKey, opts)
+}
+
+// NewNKServer returns the responder of an NK handshake.
+func NewNKServer(conn net.Conn, localKey noise.DHKey, opts ...Option) (*Conn, error) {
+	return newPatternConn(conn, ProtocolNK25519ChaChaPolyBLAKE2s(), false, localKey, nil, opts)
+}
+
+// NewXXClient returns the initiator of an XX handshake, where both peers
+// transmit their static keys. Use Options.VerifyPeer to check the key of
+// the server.
+func NewXXClient(conn net.Conn, localKey noise.DHKey, opts ...Option) (*Conn, error) {
+	return newPatternConn(conn, ProtocolXX25519ChaChaPolyBLAKE2s(), true, localKey, nil, opts)
+}
+
+// NewXXServer returns the responder of an XX handshake. Use
+// Options.VerifyPeer to check the key of the client.
+func NewXXServer(conn net.Conn, localKey noise.DHKey, opts ...Option) (*Conn, error) {
+	return newPatternConn(conn, ProtocolXX25519ChaChaPolyBLAKE2s(), false, localKey, nil, opts)
+}
+
+// NewXKClient returns the initiator of an XK handshake, which
+// authenticates the server with a key known in advance, and transmits the
+// key of the client.
+func NewXKClient(conn net.Conn, localKey noise.DHKey, peerKey []byte, opts ...Option) (*Conn, error) {
+	return newPatternConn(conn, ProtocolXK25519ChaChaPolyBLAKE2s(), true, localKey, peerKey, opts)
+}
+
+// NewXKServer returns the responder of an XK handshake.
+func NewXKServer(conn net.Conn, localKey noise.DHKey, opts ...Option) (*Conn, error) {
+	return newPatternConn(conn, ProtocolXK25519ChaChaPolyBLAKE2s(), false, localKey, nil, opts)
+}
+
+// NewIKClient returns the initiator of an IK handshake, which
+// authenticates the server with a key known in advance, and transmits the
+// key of the client in the first message.
+func NewIKClient(conn net.Conn, localKey noise.DHKey, peerKey []byte, opts ...Option) (*Conn, error) {
+	return newPatternConn(conn, ProtocolIK25519ChaChaPolyBLAKE2s(), true, localKey, peerKey, opts)
+}
+
+// NewIKServer returns the responder of an IK handshake.
+func NewIKServer(conn net.Conn, localKey noise.DHKey, opts ...Option) (*Conn, error) {
+	return newPatternConn(conn, ProtocolIK25519ChaChaPolyBLAKE2s(), false, localKey, nil, opts)
+}
+
+// NewKKClient returns the initiator of a KK handshake, where both peers
+// know the key of the other in advance.
+func NewKKClient(conn net.Conn, localKey noise.DHKey, peerKey []byte, opts ...Option) (*Conn, error) {
+	return newPatternConn(conn, ProtocolKK25519ChaChaPolyBLAKE2s(), true, localKey, peerKey, opts)
+}
+
+// NewKKServer returns the responder of a KK handshake.
+func NewKKServer(conn net.Conn, localKey noise.DHKey, peerKey []byte, opts ...Option) (*Conn, error) {
+	return newPatternConn(conn, ProtocolKK25519ChaChaPolyBLAKE2s(), false, localKey, peerKey, opts)
+}
@@ -0,0 +1,56 @@
+package noiseconn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestPatternConstructors(t *testing.T) {
+	clientKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	serverKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	for _, test := range []struct {
+		name           string
+		client, server func(net.Conn) (*Conn, error)
+	}{
+		{"NN", func(c net.Conn) (*Conn, error) { return NewNNClient(c) }, func(c net.Conn) (*Conn, error) { return NewNNServer(c) }},
+		{"NK", func(c net.Conn) (*Conn, error) { return NewNKClient(c, serverKey.Public) }, func(c net.Conn) (*Conn, error) { return NewNKServer(c, serverKey) }},
+		{"XX", func(c net.Conn) (*Conn, error) { return NewXXClient(c, clientKey) }, func(c net.Conn) (*Conn, error) { return NewXXServer(c, serverKey) }},
+		{"XK", func(c net.Conn) (*Conn, error) { return NewXKClient(c, clientKey, serverKey.Public) }, func(c net.Conn) (*Conn, error) { return NewXKServer(c, serverKey) }},
+		{"IK", func(c net.Conn) (*Conn, error) { return NewIKClient(c, clientKey, serverKey.Public) }, func(c net.Conn) (*Conn, error) { return NewIKServer(c, serverKey) }},
+		{"KK", func(c net.Conn) (*Conn, error) { return NewKKClient(c, clientKey, serverKey.Public) }, func(c net.Conn) (*Conn, error) {
+			return NewKKServer(c, serverKey, clientKey.Public)
+		}},
+	} {
+		a, b := net.Pipe()
+		client, err := test.client(a)
+		if err != nil {
+			t.Fatal(test.name, err)
+		}
+		server, err := test.server(b)
+		if err != nil {
+			t.Fatal(test.name, err)
+		}
+		var eg errgroup.Group
+		eg.Go(client.Handshake)
+		eg.Go(server.Handshake)
+		if err := eg.Wait(); err != nil {
+			t.Fatal(test.name, err)
+		}
+		if test.name != "NN" && !bytes.Equal(client.PeerStatic(), serverKey.Public) {
+			t.Error(test.name, "unexpected server key")
+		}
+		_ = client.Close()
+		_ = server.Close()
+	}
+}
@@ -0,0 +1,86 @@
+package noiseconn
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// ErrPeerRejected is returned when a PeerPolicy rejects a peer.
+var ErrPeerRejected = errors.New("peer rejected")
+
+// PeerPolicy decides which peers may connect, by their authenticated
+// static public key.
+type PeerPolicy interface {
+	// AllowPeer returns an error if the peer at addr with the static
+	// public key peerStatic must be rejected.
+	AllowPeer(addr net.Addr, peerStatic []byte) error
+}
+
+// AllowPeer implements PeerPolicy, so that PeerVerifiers such as PinPeers
+// and TOFU.Verify can be used as policies.
+func (v PeerVerifier) AllowPeer(addr net.Addr, peerStatic []byte) error {
+	return v(addr, peerStatic)
+}
+
+// PeerList is a PeerPolicy with an allowlist and a denylist of static
+// public keys. Denied keys are always rejected. If the allowlist isn't
+// empty, only keys on it are allowed, and otherwise all keys that aren't
+// denied are. The lists can be changed while in use.
+type PeerList struct {
+	mu    sync.RWMutex
+	allow map[string]struct{}
+	deny  map[string]struct{}
+}
+
+// NewPeerList returns a PeerList that allows the keys in allow.
+func NewPeerList(allow ...[]byte) *PeerList {
+	l := &PeerList{
+		allow: make(map[string]struct{}),
+		deny:  make(map[string]struct{}),
+	}
+	l.Allow(allow...)
+	return l
+}
+
+// Allow adds keys to the allowlist.
+func (l *PeerList) Allow(keys ...[]byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		l.allow[string(key)] = struct{}{}
+	}
+}
+
+// Deny adds keys to the denylist.
+func (l *PeerList) Deny(keys ...[]byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		l.deny[string(key)] = struct{}{}
+	}
+}
+
+// Remove removes keys from both lists.
+func (l *PeerList) Remove(keys ...[]byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		delete(l.allow, string(key))
+		delete(l.deny, string(key))
+	}
+}
+
+// AllowPeer implements PeerPolicy.
+func (l *PeerList) AllowPeer(addr net.Addr, peerStatic []byte) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if _, denied := l.deny[string(peerStatic)]; denied {
+		return fmt.Errorf("%w: %s is denied", ErrPeerRejected, Fingerprint(peerStatic))
+	}
+	if _, allowed := l.allow[string(peerStatic)]; !allowed && len(l.allow) > 0 {
+		return fmt.Errorf("%w: %s is not allowed", ErrPeerRejected, Fingerprint(peerStatic))
+	}
+	return nil
+}
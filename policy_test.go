@@ -0,0 +1,125 @@
+package noiseconn
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+)
+
+func TestPeerList(t *testing.T) {
+	a, b, c := []byte("a"), []byte("b"), []byte("c")
+	l := NewPeerList()
+	if err := l.AllowPeer(nil, a); err != nil {
+		t.Fatal(err)
+	}
+	l.Deny(a)
+	if err := l.AllowPeer(nil, a); !errors.Is(err, ErrPeerRejected) {
+		t.Fatal("expected a to be denied")
+	}
+	l.Allow(a, b)
+	if err := l.AllowPeer(nil, a); err == nil {
+		t.Fatal("expected the denylist to take precedence")
+	}
+	if err := l.AllowPeer(nil, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.AllowPeer(nil, c); err == nil {
+		t.Fatal("expected c not to be allowed")
+	}
+	l.Remove(a)
+	if err := l.AllowPeer(nil, a); err == nil {
+		t.Fatal("expected a not to be allowed")
+	}
+}
+
+func TestListenerPolicy(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	newKey := func() noise.DHKey {
+		key, err := cs.GenerateKeypair(rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+		return key
+	}
+	serverKey, allowed, denied := newKey(), newKey(), newKey()
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	l := NewListener(inner, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeXX, StaticKeypair: serverKey})
+	l.Policy = NewPeerList(allowed.Public)
+	l.HandshakeTimeout = 5 * time.Second
+	defer func() { _ = l.Close() }()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	dial := func(key noise.DHKey) (*Conn, error) {
+		raw, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			panic(err)
+		}
+		conn, err := NewConn(raw, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeXX, Initiator: true, StaticKeypair: key})
+		if err != nil {
+			panic(err)
+		}
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		// the responder only fails the handshake after the last message.
+		if _, err := conn.Read(make([]byte, 1)); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	if _, err := dial(denied); err == nil {
+		t.Fatal("expected the denied peer to be rejected")
+	}
+	select {
+	case conn := <-accepted:
+		t.Fatalf("unexpected accepted connection from %v", conn.RemoteAddr())
+	default:
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		conn, err := dial(allowed)
+		if err == nil {
+			err = conn.Close()
+		}
+		done <- err
+	}()
+	conn := <-accepted
+	if conn == nil {
+		t.Fatal("listener closed")
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Fatal("unexpected data", err)
+	}
+	if _, err := conn.Write([]byte("!")); err != nil {
+		panic(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+}
@@ -0,0 +1,361 @@
+package noiseconn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+// idleProbeTimeout is how long checking an idle connection may block when
+// the platform doesn't support peeking. A deadline in the past would fail
+// the read before it is attempted.
+const idleProbeTimeout = time.Millisecond
+
+// PoolOptions configure a Pool.
+type PoolOptions struct {
+	// MaxIdle is the maximum number of idle connections kept per
+	// destination. If zero, 2 is used.
+	MaxIdle int
+
+	// MinIdle is the number of idle connections the pool tries to keep
+	// per destination, by dialing replacements in the background when
+	// connections are taken. Destinations are only kept warm after their
+	// first use.
+	MinIdle int
+
+	// IdleTimeout, if nonzero, closes connections idle for longer.
+	IdleTimeout time.Duration
+
+	// MaxLifetime, if nonzero, closes connections older than this instead
+	// of reusing them.
+	MaxLifetime time.Duration
+
+	// CheckInterval is how often idle connections are checked, expired,
+	// and replenished. If zero, 30 seconds is used.
+	CheckInterval time.Duration
+
+	// DestinationTimeout is how long a destination is kept warm after a
+	// connection to it was last taken or released. Then, its idle
+	// connections are closed and it is forgotten. If zero, 10 minutes is
+	// used.
+	DestinationTimeout time.Duration
+
+	// HealthCheck, if set, is called on idle connections during checks and
+	// before they are reused. Connections for which it returns an error
+	// are closed. Connections the peer has closed or sent unexpected data
+	// on are always detected.
+	HealthCheck func(*Conn) error
+}
+
+// Pool maintains warm, handshaked connections per destination, so that
+// short-lived uses don't pay for a handshake every time.
+type Pool struct {
+	dialer *Dialer
+	opts   PoolOptions
+
+	mu     sync.Mutex
+	idle   map[poolKey][]*PooledConn
+	dests  map[poolKey]*poolDest
+	closed bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type poolKey struct {
+	network, address string
+}
+
+// poolDest is a destination that was used, with how many connections are
+// being dialed to keep it warm.
+type poolDest struct {
+	warming  int
+	lastUsed time.Time
+}
+
+// PooledConn is a connection handed out by a Pool. Release returns it to
+// the pool, and Close closes it.
+type PooledConn struct {
+	*Conn
+	pool     *Pool
+	key      poolKey
+	created  time.Time
+	idleAt   time.Time
+	released bool
+}
+
+var _ net.Conn = (*PooledConn)(nil)
+
+// NewPool returns a Pool dialing with d.
+func NewPool(d *Dialer) *Pool {
+	return NewPoolWithOptions(d, PoolOptions{})
+}
+
+// NewPoolWithOptions returns a Pool dialing with d, configured by opts.
+func NewPoolWithOptions(d *Dialer, opts PoolOptions) *Pool {
+	if opts.MaxIdle <= 0 {
+		opts.MaxIdle = 2
+	}
+	if opts.MinIdle > opts.MaxIdle {
+		opts.MinIdle = opts.MaxIdle
+	}
+	if opts.CheckInterval <= 0 {
+		opts.CheckInterval = 30 * time.Second
+	}
+	if opts.DestinationTimeout <= 0 {
+		opts.DestinationTimeout = 10 * time.Minute
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pool{
+		dialer: d,
+		opts:   opts,
+		idle:   map[poolKey][]*PooledConn{},
+		dests:  map[poolKey]*poolDest{},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	p.wg.Add(1)
+	go p.maintain()
+	return p
+}
+
+// Get returns an idle connection to address, or dials a new one.
+func (p *Pool) Get(ctx context.Context, network, address string) (*PooledConn, error) {
+	key := poolKey{network: network, address: address}
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, errs.New("pool closed")
+		}
+		p.touch(key, time.Now())
+		conns := p.idle[key]
+		if len(conns) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		pc := conns[len(conns)-1]
+		p.idle[key] = conns[:len(conns)-1]
+		p.mu.Unlock()
+		p.replenish(key)
+
+		if p.usable(pc, time.Now()) {
+			pc.released = false
+			return pc, nil
+		}
+		_ = pc.Conn.Close()
+	}
+	p.replenish(key)
+	return p.dial(ctx, key)
+}
+
+func (p *Pool) dial(ctx context.Context, key poolKey) (*PooledConn, error) {
+	conn, err := p.dialer.DialContext(ctx, key.network, key.address)
+	if err != nil {
+		return nil, err
+	}
+	return &PooledConn{Conn: conn.(*Conn), pool: p, key: key, created: time.Now()}, nil
+}
+
+// Release returns the connection to the pool. The connection must be in a
+// clean state: all data of the last exchange has been read, and the peer
+// won't send more until the connection is used again. Otherwise, use
+// Close.
+func (pc *PooledConn) Release() {
+	if pc.released {
+		return
+	}
+	pc.released = true
+	p := pc.pool
+	pc.idleAt = time.Now()
+
+	p.mu.Lock()
+	p.touch(pc.key, pc.idleAt)
+	if p.closed || len(p.idle[pc.key]) >= p.opts.MaxIdle || p.expired(pc, pc.idleAt) {
+		p.mu.Unlock()
+		_ = pc.Conn.Close()
+		return
+	}
+	p.idle[pc.key] = append(p.idle[pc.key], pc)
+	p.mu.Unlock()
+}
+
+// Close closes the connection without returning it to the pool.
+func (pc *PooledConn) Close() error {
+	pc.released = true
+	return pc.Conn.Close()
+}
+
+// Close closes the pool and all idle connections. Connections that are in
+// use are closed when released.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	idle := p.idle
+	p.idle = map[poolKey][]*PooledConn{}
+	p.mu.Unlock()
+
+	p.cancel()
+	p.wg.Wait()
+
+	var group errs.Group
+	for _, conns := range idle {
+		for _, pc := range conns {
+			group.Add(pc.Conn.Close())
+		}
+	}
+	return group.Err()
+}
+
+// touch notes that the destination key was used at now. p.mu must be held.
+func (p *Pool) touch(key poolKey, now time.Time) {
+	d, ok := p.dests[key]
+	if !ok {
+		d = &poolDest{}
+		p.dests[key] = d
+	}
+	d.lastUsed = now
+}
+
+func (p *Pool) expired(pc *PooledConn, now time.Time) bool {
+	if p.opts.MaxLifetime > 0 && now.Sub(pc.created) >= p.opts.MaxLifetime {
+		return true
+	}
+	return p.opts.IdleTimeout > 0 && !pc.idleAt.IsZero() && now.Sub(pc.idleAt) >= p.opts.IdleTimeout
+}
+
+// usable reports whether an idle connection may be handed out.
+func (p *Pool) usable(pc *PooledConn, now time.Time) bool {
+	if p.expired(pc, now) || !pc.Conn.idleAlive() {
+		return false
+	}
+	return p.opts.HealthCheck == nil || p.opts.HealthCheck(pc.Conn) == nil
+}
+
+// idleAlive reports whether an idle connection has neither been closed by
+// the peer nor received unexpected data.
+func (c *Conn) idleAlive() bool {
+	if !c.HandshakeComplete() || len(c.readBuf) > 0 || len(c.readMsgs) > 0 {
+		return false
+	}
+	if c.mt != nil {
+		// message transports can't be peeked at without consuming data.
+		return true
+	}
+	if pending, ok := peekIdle(c.Conn); ok {
+		return !pending
+	}
+	// without peeking, any data read is lost, but then the conn is unusable
+	// anyway.
+	var b [1]byte
+	_ = c.Conn.SetReadDeadline(time.Now().Add(idleProbeTimeout))
+	n, err := c.Conn.Read(b[:])
+	_ = c.Conn.SetReadDeadline(time.Time{})
+	return n == 0 && errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+func (p *Pool) replenish(key poolKey) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	d, ok := p.dests[key]
+	if p.closed || !ok {
+		return
+	}
+	missing := p.opts.MinIdle - len(p.idle[key]) - d.warming
+	for i := 0; i < missing; i++ {
+		d.warming++
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			pc, err := p.dial(p.ctx, key)
+			p.mu.Lock()
+			d.warming--
+			p.mu.Unlock()
+			if err != nil {
+				return
+			}
+			pc.released = true
+			pc.idleAt = time.Now()
+			p.mu.Lock()
+			if p.closed || len(p.idle[key]) >= p.opts.MaxIdle {
+				p.mu.Unlock()
+				_ = pc.Conn.Close()
+				return
+			}
+			p.idle[key] = append(p.idle[key], pc)
+			p.mu.Unlock()
+		}()
+	}
+}
+
+func (p *Pool) maintain() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.opts.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// destinations that weren't used for long are forgotten, unless
+		// connections to them are being dialed.
+		now := time.Now()
+		var evicted []*PooledConn
+		p.mu.Lock()
+		for key, d := range p.dests {
+			if d.warming == 0 && now.Sub(d.lastUsed) >= p.opts.DestinationTimeout {
+				evicted = append(evicted, p.idle[key]...)
+				delete(p.dests, key)
+				delete(p.idle, key)
+			}
+		}
+		idle := p.idle
+		p.idle = map[poolKey][]*PooledConn{}
+		keys := make([]poolKey, 0, len(p.dests))
+		for key := range p.dests {
+			keys = append(keys, key)
+		}
+		p.mu.Unlock()
+		for _, pc := range evicted {
+			_ = pc.Conn.Close()
+		}
+
+		// idle connections are checked outside of the lock, so Get may dial
+		// new connections in the meantime.
+		for key, conns := range idle {
+			var keep []*PooledConn
+			for _, pc := range conns {
+				if p.usable(pc, now) {
+					keep = append(keep, pc)
+				} else {
+					_ = pc.Conn.Close()
+				}
+			}
+			p.mu.Lock()
+			if p.closed {
+				p.mu.Unlock()
+				for _, pc := range keep {
+					_ = pc.Conn.Close()
+				}
+				continue
+			}
+			if keep = append(keep, p.idle[key]...); len(keep) > 0 {
+				p.idle[key] = keep
+			} else {
+				delete(p.idle, key)
+			}
+			p.mu.Unlock()
+		}
+		for _, key := range keys {
+			p.replenish(key)
+		}
+	}
+}
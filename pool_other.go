@@ -0,0 +1,9 @@
+//go:build !unix
+
+package noiseconn
+
+import "net"
+
+func peekIdle(conn net.Conn) (pending, ok bool) {
+	return false, false
+}
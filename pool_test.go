@@ -0,0 +1,158 @@
+package noiseconn
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+)
+
+func TestPool(t *testing.T) {
+	serverKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	lis := NewListener(inner, noise.Config{
+		CipherSuite:   cs,
+		Pattern:       noise.HandshakeNK,
+		StaticKeypair: serverKey,
+	})
+	defer lis.Close()
+
+	var accepted int32
+	handled := make(chan struct{}, 10)
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			go func() {
+				defer func() { handled <- struct{}{} }()
+				defer conn.Close()
+				buf := make([]byte, 4)
+				for {
+					if _, err := io.ReadFull(conn, buf); err != nil {
+						return
+					}
+					if string(buf) == "quit" {
+						return
+					}
+					if _, err := conn.Write(buf); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	pool := NewPoolWithOptions(&Dialer{Config: noise.Config{
+		CipherSuite: cs,
+		Pattern:     noise.HandshakeNK,
+		PeerStatic:  serverKey.Public,
+	}}, PoolOptions{MaxIdle: 1})
+	defer pool.Close()
+
+	ctx := context.Background()
+	addr := inner.Addr().String()
+	exchange := func(msg string) *PooledConn {
+		pc, err := pool.Get(ctx, "tcp", addr)
+		if err != nil {
+			panic(err)
+		}
+		if _, err := pc.Write([]byte(msg)); err != nil {
+			panic(err)
+		}
+		if msg == "quit" {
+			return pc
+		}
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(pc, buf); err != nil {
+			panic(err)
+		}
+		if string(buf) != msg {
+			t.Fatalf("unexpected response %q", buf)
+		}
+		return pc
+	}
+
+	first := exchange("ping")
+	first.Release()
+	second := exchange("pong")
+	if second.Conn != first.Conn {
+		t.Fatal("expected the idle connection to be reused")
+	}
+	second.Release()
+
+	// the server closes the connection, which must be detected.
+	exchange("quit").Release()
+	<-handled
+	third := exchange("ping")
+	third.Release()
+	if third.Conn == second.Conn {
+		t.Fatal("closed connection was reused")
+	}
+	if n := atomic.LoadInt32(&accepted); n != 2 {
+		t.Fatalf("expected 2 connections, got %d", n)
+	}
+}
+
+func TestPoolDestinationTimeout(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	lis := NewListener(inner, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN})
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+
+	pool := NewPoolWithOptions(&Dialer{Config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}}, PoolOptions{
+		CheckInterval:      10 * time.Millisecond,
+		DestinationTimeout: 50 * time.Millisecond,
+	})
+	defer pool.Close()
+	pc, err := pool.Get(context.Background(), "tcp", inner.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+	pc.Release()
+
+	// the unused destination and its idle connection are dropped.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pool.mu.Lock()
+		dests, idle := len(pool.dests), len(pool.idle)
+		pool.mu.Unlock()
+		if dests == 0 && idle == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("destination not evicted: %d destinations, %d idle", dests, idle)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
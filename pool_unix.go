@@ -0,0 +1,34 @@
+//go:build unix
+
+package noiseconn
+
+import (
+	"net"
+	"syscall"
+)
+
+// peekIdle reports whether an idle conn has pending data or was closed by
+// the peer, without consuming anything. ok is false if conn doesn't
+// support peeking.
+func peekIdle(conn net.Conn) (pending, ok bool) {
+	sc, isSyscall := conn.(syscall.Conn)
+	if !isSyscall {
+		return false, false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return false, false
+	}
+	var buf [1]byte
+	err = raw.Read(func(fd uintptr) bool {
+		_, _, rerr := syscall.Recvfrom(int(fd), buf[:], syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		// EAGAIN means nothing is pending. anything else, including EOF,
+		// means the conn can't be reused.
+		pending = rerr != syscall.EAGAIN && rerr != syscall.EWOULDBLOCK
+		return true
+	})
+	if err != nil {
+		return true, true
+	}
+	return pending, true
+}
@@ -0,0 +1,153 @@
+package noiseconn
+
+import (
+	"github.com/flynn/noise"
+	"github.com/zeebo/errs"
+)
+
+// PostQuantumMode selects whether handshakes are augmented with a
+// post-quantum key encapsulation mechanism (ML-KEM-768).
+//
+// In a hybrid handshake, the initiator sends a KEM encapsulation key in
+// the first handshake message and the responder answers with a
+// ciphertext in the second. Once the Noise handshake completes, both
+// sides derive the transport keys from the Noise transport keys and the
+// KEM shared secret, so transport messages stay confidential unless both
+// the Diffie-Hellman and the KEM key exchange are broken. Handshake
+// payloads are only protected by the Noise handshake. The KEM messages
+// are part of the handshake payloads, so the handshake fails if they are
+// tampered with or removed.
+//
+// Hybrid handshakes need a pattern with at least two messages, handshake
+// extensions on both peers, and Go 1.24 or later. The KEM keys are always
+// generated with crypto/rand, regardless of Options.Random.
+type PostQuantumMode int
+
+const (
+	// PostQuantumDisabled performs plain Noise handshakes.
+	PostQuantumDisabled PostQuantumMode = iota
+	// PostQuantumPreferred performs hybrid handshakes with peers that
+	// support them, and plain Noise handshakes with other peers that use
+	// handshake extensions.
+	PostQuantumPreferred
+	// PostQuantumRequired fails handshakes with peers that don't perform
+	// hybrid handshakes.
+	PostQuantumRequired
+)
+
+// kemFuncs is a key encapsulation mechanism.
+type kemFuncs struct {
+	generate    func() (encapsulationKey []byte, decapsulate func(ciphertext []byte) ([]byte, error), err error)
+	encapsulate func(encapsulationKey []byte) (sharedKey, ciphertext []byte, err error)
+}
+
+// hybridKEM is the KEM of hybrid handshakes. It is nil if it isn't
+// supported by the Go version.
+var hybridKEM *kemFuncs
+
+// hybridPattern is the pattern used to derive hybrid transport keys. It has
+// a single message without tokens other than the psk, so running it is a
+// key derivation from the psk.
+var hybridPattern = noise.HandshakePattern{
+	Name:     "noiseconnhybrid",
+	Messages: [][]noise.MessagePattern{{}},
+}
+
+// setupPostQuantum validates mode for the pattern and returns the KEM
+// state of the connection.
+func setupPostQuantum(mode PostQuantumMode, config noise.Config) (enabled bool, encapsulationKey []byte, decapsulate func([]byte) ([]byte, error), err error) {
+	switch mode {
+	case PostQuantumDisabled:
+		return false, nil, nil, nil
+	case PostQuantumPreferred, PostQuantumRequired:
+	default:
+		return false, nil, nil, errs.New("invalid post-quantum mode %d", mode)
+	}
+	if hybridKEM == nil || len(config.Pattern.Messages) < 2 {
+		if mode == PostQuantumRequired {
+			if hybridKEM == nil {
+				return false, nil, nil, errs.New("post-quantum handshakes need Go 1.24 or later")
+			}
+			return false, nil, nil, errs.New("pattern %s is too short for a post-quantum handshake", config.Pattern.Name)
+		}
+		return false, nil, nil, nil
+	}
+	if config.Initiator {
+		encapsulationKey, decapsulate, err = hybridKEM.generate()
+		if err != nil {
+			return false, nil, nil, errs.Wrap(err)
+		}
+	}
+	return true, encapsulationKey, decapsulate, nil
+}
+
+// readKEM handles the KEM extension of a received handshake message.
+// c.hsMu must be held.
+func (c *Conn) readKEM(value []byte) (err error) {
+	if !c.pqEnabled {
+		return nil
+	}
+	switch index := c.hs.MessageIndex() - 1; {
+	case !c.initiator && index == 0:
+		c.kemSecret, c.kemCiphertext, err = hybridKEM.encapsulate(value)
+	case c.initiator && index == 1 && c.kemDecapsulate != nil:
+		c.kemSecret, err = c.kemDecapsulate(value)
+		c.kemDecapsulate = nil
+	}
+	return errs.Wrap(err)
+}
+
+// checkKEM fails the handshake if a hybrid handshake is required and the
+// peer skipped its KEM message. c.hsMu must be held.
+func (c *Conn) checkKEM() error {
+	if c.pqMode != PostQuantumRequired || c.kemSecret != nil {
+		return nil
+	}
+	if index := c.hs.MessageIndex() - 1; index == 0 || (c.initiator && index == 1) {
+		return errs.New("peer doesn't support post-quantum handshakes")
+	}
+	return nil
+}
+
+// hybridCipherStates derives the transport cipher states of a hybrid
+// handshake from the cipher states of the Noise handshake, cs1 and cs2, and
+// the KEM shared secret.
+func hybridCipherStates(suite noise.CipherSuite, hh []byte, cs1, cs2 *noise.CipherState, kemSecret []byte) (_, _ *noise.CipherState, err error) {
+	// encrypting zeros is a PRF of the Noise transport keys, like the
+	// Noise REKEY function. The cipher states aren't used afterwards.
+	var zeros [32]byte
+	h := suite.Hash()
+	for _, cs := range []*noise.CipherState{cs1, cs2} {
+		prf, err := cs.Encrypt(nil, []byte(hybridPattern.Name), zeros[:])
+		if err != nil {
+			return nil, nil, errs.Wrap(err)
+		}
+		_, _ = h.Write(prf)
+		zero(prf)
+	}
+	_, _ = h.Write(kemSecret)
+	psk := h.Sum(nil)
+	defer zero(psk)
+
+	hs, err := noise.NewHandshakeState(noise.Config{
+		CipherSuite:  suite,
+		Pattern:      hybridPattern,
+		Initiator:    true,
+		Prologue:     hh,
+		PresharedKey: psk[:32],
+	})
+	if err != nil {
+		return nil, nil, errs.Wrap(err)
+	}
+	_, cs1, cs2, err = hs.WriteMessage(nil, nil)
+	return cs1, cs2, errs.Wrap(err)
+}
+
+// PostQuantum returns whether the transport keys of the connection were
+// derived from a hybrid handshake. This returns false until the handshake
+// is completed.
+func (c *Conn) PostQuantum() bool {
+	c.hsMu.Lock()
+	defer c.hsMu.Unlock()
+	return c.postQuantum
+}
@@ -0,0 +1,25 @@
+//go:build go1.24
+
+package noiseconn
+
+import "crypto/mlkem"
+
+func init() {
+	hybridKEM = &kemFuncs{
+		generate: func() ([]byte, func([]byte) ([]byte, error), error) {
+			dk, err := mlkem.GenerateKey768()
+			if err != nil {
+				return nil, nil, err
+			}
+			return dk.EncapsulationKey().Bytes(), dk.Decapsulate, nil
+		},
+		encapsulate: func(encapsulationKey []byte) ([]byte, []byte, error) {
+			ek, err := mlkem.NewEncapsulationKey768(encapsulationKey)
+			if err != nil {
+				return nil, nil, err
+			}
+			sharedKey, ciphertext := ek.Encapsulate()
+			return sharedKey, ciphertext, nil
+		},
+	}
+}
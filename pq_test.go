@@ -0,0 +1,115 @@
+//go:build go1.24
+
+package noiseconn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestPostQuantum(t *testing.T) {
+	handshake := func(pattern noise.HandshakePattern, clientOpts, serverOpts Options) (client, server *Conn, err error) {
+		p1, p2 := net.Pipe()
+		cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+		serverKey, err := cs.GenerateKeypair(rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+		var peerStatic []byte
+		if len(pattern.ResponderPreMessages) > 0 {
+			peerStatic = serverKey.Public
+		}
+		client, err = NewConnWithOptions(p1, noise.Config{
+			CipherSuite: cs, Pattern: pattern, Initiator: true, PeerStatic: peerStatic,
+		}, clientOpts)
+		if err != nil {
+			panic(err)
+		}
+		t.Cleanup(func() { _ = client.Close() })
+		server, err = NewConnWithOptions(p2, noise.Config{
+			CipherSuite: cs, Pattern: pattern, StaticKeypair: serverKey,
+		}, serverOpts)
+		if err != nil {
+			panic(err)
+		}
+		t.Cleanup(func() { _ = server.Close() })
+
+		var eg errgroup.Group
+		eg.Go(func() error {
+			defer func() { _ = client.Close() }()
+			if _, err := client.Write([]byte("hello")); err != nil {
+				return err
+			}
+			buf := make([]byte, 5)
+			if _, err := io.ReadFull(client, buf); err != nil {
+				return err
+			}
+			if string(buf) != "world" {
+				t.Error("unexpected data")
+			}
+			return nil
+		})
+		eg.Go(func() error {
+			defer func() { _ = server.Close() }()
+			buf := make([]byte, 5)
+			if _, err := io.ReadFull(server, buf); err != nil {
+				return err
+			}
+			if string(buf) != "hello" {
+				t.Error("unexpected data")
+			}
+			_, err := server.Write([]byte("world"))
+			return err
+		})
+		return client, server, eg.Wait()
+	}
+
+	for _, pattern := range []noise.HandshakePattern{noise.HandshakeNN, noise.HandshakeNK} {
+		client, server, err := handshake(pattern,
+			Options{PostQuantum: PostQuantumRequired},
+			Options{PostQuantum: PostQuantumPreferred})
+		if err != nil {
+			t.Fatal(pattern.Name, err)
+		}
+		if !client.PostQuantum() || !server.PostQuantum() {
+			t.Fatal(pattern.Name, "expected a hybrid handshake")
+		}
+		if !bytes.Equal(client.HandshakeHash(), server.HandshakeHash()) {
+			t.Fatal(pattern.Name, "handshake hash mismatch")
+		}
+	}
+
+	// falls back to plain Noise with peers that don't support it.
+	client, server, err := handshake(noise.HandshakeNN,
+		Options{PostQuantum: PostQuantumPreferred},
+		Options{HandshakeExtensions: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.PostQuantum() || server.PostQuantum() {
+		t.Fatal("unexpected hybrid handshake")
+	}
+
+	for _, opts := range [][2]Options{
+		{{PostQuantum: PostQuantumRequired}, {HandshakeExtensions: true}},
+		{{HandshakeExtensions: true}, {PostQuantum: PostQuantumRequired}},
+	} {
+		if _, _, err := handshake(noise.HandshakeNN, opts[0], opts[1]); err == nil {
+			t.Fatal("expected the handshake to fail")
+		}
+	}
+
+	_, err = NewConnWithOptions(nil, noise.Config{
+		CipherSuite: noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+		Pattern:     noise.HandshakeN, Initiator: true, PeerStatic: make([]byte, 32),
+	}, Options{PostQuantum: PostQuantumRequired})
+	if err == nil {
+		t.Fatal("expected an error for a one-way pattern")
+	}
+}
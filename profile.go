@@ -0,0 +1,47 @@
+package noiseconn
+
+import (
+	"context"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+// profileLabels is the state behind Options.ProfileLabels.
+type profileLabels struct {
+	enabled bool
+	// peer is the fingerprint of the static key of the peer, set once the
+	// handshake completed.
+	peer string
+}
+
+func noop() {}
+
+// profile sets the pprof labels of the Conn for op on the calling
+// goroutine, with Options.ProfileLabels, and returns a function restoring
+// them. c.hsMu must be held during the handshake.
+func (c *Conn) profile(op string) func() {
+	if !c.profileLabels.enabled {
+		return noop
+	}
+	peer := c.profileLabels.peer
+	if c.hs != nil && len(c.hs.PeerStatic()) > 0 {
+		peer = Fingerprint(c.hs.PeerStatic())
+	}
+	direction := "inbound"
+	if c.initiator {
+		direction = "outbound"
+	}
+	ctx := context.Background()
+	pprof.SetGoroutineLabels(pprof.WithLabels(ctx, pprof.Labels(
+		"noiseconn.peer", peer, "noiseconn.direction", direction, "noiseconn.op", op)))
+	return func() { pprof.SetGoroutineLabels(ctx) }
+}
+
+// region starts a runtime/trace region for op while tracing is enabled,
+// and returns a function ending it.
+func (c *Conn) region(op string) func() {
+	if !trace.IsEnabled() {
+		return noop
+	}
+	return trace.StartRegion(context.Background(), "noiseconn."+op).End
+}
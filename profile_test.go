@@ -0,0 +1,62 @@
+package noiseconn
+
+import (
+	"bytes"
+	"net"
+	"runtime/pprof"
+	"runtime/trace"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+// profilingDH records the goroutine profile during its first DH.
+type profilingDH struct {
+	noise.DHFunc
+	once    sync.Once
+	profile bytes.Buffer
+}
+
+func (d *profilingDH) DH(privkey, pubkey []byte) ([]byte, error) {
+	d.once.Do(func() { _ = pprof.Lookup("goroutine").WriteTo(&d.profile, 1) })
+	return d.DHFunc.DH(privkey, pubkey)
+}
+
+func TestConnProfileLabels(t *testing.T) {
+	dh := &profilingDH{DHFunc: noise.DH25519}
+	cs := noise.NewCipherSuite(dh, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	p1, p2 := net.Pipe()
+	client, err := NewConn(p1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true}, WithProfileLabels())
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	server, err := NewConn(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, WithProfileLabels())
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+
+	var tr bytes.Buffer
+	if err := trace.Start(&tr); err != nil {
+		panic(err)
+	}
+	var eg errgroup.Group
+	eg.Go(client.Handshake)
+	eg.Go(server.Handshake)
+	err = eg.Wait()
+	trace.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	if profile := dh.profile.String(); !strings.Contains(profile, `"noiseconn.op":"handshake"`) {
+		t.Fatalf("expected handshake labels in the goroutine profile:\n%s", profile)
+	}
+	if !bytes.Contains(tr.Bytes(), []byte("noiseconn.handshake")) {
+		t.Fatal("expected a handshake region in the trace")
+	}
+}
@@ -0,0 +1,126 @@
+package noiseconn
+
+import (
+	"strings"
+
+	"github.com/flynn/noise"
+	"github.com/zeebo/errs"
+)
+
+var (
+	protocolPatterns = map[string]noise.HandshakePattern{}
+	protocolDHs      = map[string]noise.DHFunc{"25519": noise.DH25519}
+	protocolCiphers  = map[string]noise.CipherFunc{"ChaChaPoly": noise.CipherChaChaPoly, "AESGCM": noise.CipherAESGCM}
+	protocolHashes   = map[string]noise.HashFunc{
+		"SHA256": noise.HashSHA256, "SHA512": noise.HashSHA512,
+		"BLAKE2s": noise.HashBLAKE2s, "BLAKE2b": noise.HashBLAKE2b,
+	}
+)
+
+func init() {
+	for _, p := range []noise.HandshakePattern{
+		noise.HandshakeNN, noise.HandshakeKN, noise.HandshakeNK, noise.HandshakeKK,
+		noise.HandshakeNX, noise.HandshakeKX, noise.HandshakeXN, noise.HandshakeIN,
+		noise.HandshakeXK, noise.HandshakeIK, noise.HandshakeXX, noise.HandshakeIX,
+		noise.HandshakeN, noise.HandshakeK, noise.HandshakeX,
+	} {
+		protocolPatterns[p.Name] = p
+	}
+}
+
+// ParseProtocol returns a Config with the pattern and cipher suite of a
+// Noise protocol name such as Noise_XX_25519_ChaChaPoly_BLAKE2s. The keys
+// and the role still need to be set. Protocol names with modifiers, such
+// as psk, aren't supported.
+func ParseProtocol(name string) (noise.Config, error) {
+	parts := strings.Split(name, "_")
+	if len(parts) != 5 || parts[0] != "Noise" {
+		return noise.Config{}, errs.New("invalid protocol name %q", name)
+	}
+	pattern, ok := protocolPatterns[parts[1]]
+	if !ok {
+		return noise.Config{}, errs.New("unsupported pattern %q", parts[1])
+	}
+	dh, ok := protocolDHs[parts[2]]
+	if !ok {
+		return noise.Config{}, errs.New("unsupported DH function %q", parts[2])
+	}
+	cipher, ok := protocolCiphers[parts[3]]
+	if !ok {
+		return noise.Config{}, errs.New("unsupported cipher %q", parts[3])
+	}
+	hash, ok := protocolHashes[parts[4]]
+	if !ok {
+		return noise.Config{}, errs.New("unsupported hash %q", parts[4])
+	}
+	return noise.Config{CipherSuite: noise.NewCipherSuite(dh, cipher, hash), Pattern: pattern}, nil
+}
+
+func protocol(pattern noise.HandshakePattern, cipher noise.CipherFunc, hash noise.HashFunc) noise.Config {
+	return noise.Config{CipherSuite: noise.NewCipherSuite(noise.DH25519, cipher, hash), Pattern: pattern}
+}
+
+// The Protocol functions return a Config with the pattern and cipher suite
+// of a common Noise protocol. The keys and the role still need to be set.
+// ChaChaPoly with BLAKE2s is a good default, and AESGCM with SHA256 is
+// faster on hardware with AES instructions.
+
+// ProtocolNN25519ChaChaPolyBLAKE2s is Noise_NN_25519_ChaChaPoly_BLAKE2s.
+func ProtocolNN25519ChaChaPolyBLAKE2s() noise.Config {
+	return protocol(noise.HandshakeNN, noise.CipherChaChaPoly, noise.HashBLAKE2s)
+}
+
+// ProtocolNN25519AESGCMSHA256 is Noise_NN_25519_AESGCM_SHA256.
+func ProtocolNN25519AESGCMSHA256() noise.Config {
+	return protocol(noise.HandshakeNN, noise.CipherAESGCM, noise.HashSHA256)
+}
+
+// ProtocolNK25519ChaChaPolyBLAKE2s is Noise_NK_25519_ChaChaPoly_BLAKE2s.
+func ProtocolNK25519ChaChaPolyBLAKE2s() noise.Config {
+	return protocol(noise.HandshakeNK, noise.CipherChaChaPoly, noise.HashBLAKE2s)
+}
+
+// ProtocolNK25519AESGCMSHA256 is Noise_NK_25519_AESGCM_SHA256.
+func ProtocolNK25519AESGCMSHA256() noise.Config {
+	return protocol(noise.HandshakeNK, noise.CipherAESGCM, noise.HashSHA256)
+}
+
+// ProtocolXX25519ChaChaPolyBLAKE2s is Noise_XX_25519_ChaChaPoly_BLAKE2s.
+func ProtocolXX25519ChaChaPolyBLAKE2s() noise.Config {
+	return protocol(noise.HandshakeXX, noise.CipherChaChaPoly, noise.HashBLAKE2s)
+}
+
+// ProtocolXX25519AESGCMSHA256 is Noise_XX_25519_AESGCM_SHA256.
+func ProtocolXX25519AESGCMSHA256() noise.Config {
+	return protocol(noise.HandshakeXX, noise.CipherAESGCM, noise.HashSHA256)
+}
+
+// ProtocolXK25519ChaChaPolyBLAKE2s is Noise_XK_25519_ChaChaPoly_BLAKE2s.
+func ProtocolXK25519ChaChaPolyBLAKE2s() noise.Config {
+	return protocol(noise.HandshakeXK, noise.CipherChaChaPoly, noise.HashBLAKE2s)
+}
+
+// ProtocolXK25519AESGCMSHA256 is Noise_XK_25519_AESGCM_SHA256.
+func ProtocolXK25519AESGCMSHA256() noise.Config {
+	return protocol(noise.HandshakeXK, noise.CipherAESGCM, noise.HashSHA256)
+}
+
+// ProtocolIK25519ChaChaPolyBLAKE2s is Noise_IK_25519_ChaChaPoly_BLAKE2s.
+func ProtocolIK25519ChaChaPolyBLAKE2s() noise.Config {
+	return protocol(noise.HandshakeIK, noise.CipherChaChaPoly, noise.HashBLAKE2s)
+}
+
+// ProtocolIK25519AESGCMSHA256 is Noise_IK_25519_AESGCM_SHA256.
+func ProtocolIK25519AESGCMSHA256() noise.Config {
+	return protocol(noise.HandshakeIK, noise.CipherAESGCM, noise.HashSHA256)
+}
+
+// ProtocolKK25519ChaChaPolyBLAKE2s is Noise_KK_25519_ChaChaPoly_BLAKE2s.
+func ProtocolKK25519ChaChaPolyBLAKE2s() noise.Config {
+	return protocol(noise.HandshakeKK, noise.CipherChaChaPoly, noise.HashBLAKE2s)
+}
+
+// ProtocolKK25519AESGCMSHA256 is Noise_KK_25519_AESGCM_SHA256.
+func ProtocolKK25519AESGCMSHA256() noise.Config {
+	return protocol(noise.HandshakeKK, noise.CipherAESGCM, noise.HashSHA256)
+}
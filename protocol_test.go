@@ -0,0 +1,66 @@
+package noiseconn
+
+import (
+	"crypto/rand"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestProtocols(t *testing.T) {
+	for name, config := range map[string]noise.Config{
+		"Noise_NN_25519_ChaChaPoly_BLAKE2s": ProtocolNN25519ChaChaPolyBLAKE2s(),
+		"Noise_NK_25519_AESGCM_SHA256":      ProtocolNK25519AESGCMSHA256(),
+		"Noise_XX_25519_ChaChaPoly_BLAKE2s": ProtocolXX25519ChaChaPolyBLAKE2s(),
+		"Noise_IK_25519_AESGCM_SHA256":      ProtocolIK25519AESGCMSHA256(),
+		"Noise_KK_25519_ChaChaPoly_BLAKE2s": ProtocolKK25519ChaChaPolyBLAKE2s(),
+	} {
+		if got := protocolName(config); got != name {
+			t.Errorf("expected %s, got %s", name, got)
+		}
+		parsed, err := ParseProtocol(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if protocolName(parsed) != name {
+			t.Errorf("expected %s, got %s", name, protocolName(parsed))
+		}
+	}
+	for _, name := range []string{"Noise_XX_448_ChaChaPoly_BLAKE2s", "Noise_XXpsk3_25519_ChaChaPoly_BLAKE2s", "XX"} {
+		if _, err := ParseProtocol(name); err == nil {
+			t.Errorf("expected an error for %s", name)
+		}
+	}
+}
+
+func TestProtocolHandshake(t *testing.T) {
+	serverKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	server := ProtocolNK25519ChaChaPolyBLAKE2s()
+	server.StaticKeypair = serverKey
+	client := ProtocolNK25519ChaChaPolyBLAKE2s()
+	client.Initiator = true
+	client.PeerStatic = serverKey.Public
+
+	a, b := net.Pipe()
+	ca, err := NewConn(a, client)
+	if err != nil {
+		panic(err)
+	}
+	cb, err := NewConn(b, server)
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = ca.Close() }()
+	defer func() { _ = cb.Close() }()
+	var eg errgroup.Group
+	eg.Go(ca.Handshake)
+	eg.Go(cb.Handshake)
+	if err := eg.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
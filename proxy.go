@@ -0,0 +1,137 @@
+package noiseconn
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jtolio/noiseconn/internal/socks5"
+	"github.com/zeebo/errs"
+)
+
+// dialProxy connects to address through the proxy described by proxy,
+// which must have the http, socks5 or socks5h scheme. With socks5, the
+// host of address is resolved locally, and with socks5h by the proxy.
+func dialProxy(ctx context.Context, netDialer *net.Dialer, proxy *url.URL, network, address string) (_ net.Conn, err error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, errs.New("unsupported network %q for proxy", network)
+	}
+
+	var connect func(net.Conn) error
+	switch proxy.Scheme {
+	case "http":
+		connect = func(conn net.Conn) error { return httpConnect(conn, proxy, address) }
+	case "socks5", "socks5h":
+		var auth *socks5.Auth
+		if proxy.User != nil {
+			password, _ := proxy.User.Password()
+			auth = &socks5.Auth{Username: proxy.User.Username(), Password: password}
+		}
+		if proxy.Scheme == "socks5" {
+			if address, err = resolveAddress(ctx, netDialer, network, address); err != nil {
+				return nil, err
+			}
+		}
+		connect = func(conn net.Conn) error { return socks5.Connect(conn, address, auth) }
+	default:
+		return nil, errs.New("unsupported proxy scheme %q", proxy.Scheme)
+	}
+
+	conn, err := netDialer.DialContext(ctx, "tcp", proxyAddress(proxy))
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	defer func() {
+		if err != nil {
+			_ = conn.Close()
+		}
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+		defer func() { _ = conn.SetDeadline(time.Time{}) }()
+	}
+	if err := connect(conn); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// proxyAddress returns the host and port of proxy, with the default port of
+// its scheme if it has none.
+func proxyAddress(proxy *url.URL) string {
+	if proxy.Port() != "" {
+		return proxy.Host
+	}
+	port := "80"
+	if proxy.Scheme == "socks5" || proxy.Scheme == "socks5h" {
+		port = "1080"
+	}
+	return net.JoinHostPort(proxy.Hostname(), port)
+}
+
+// resolveAddress replaces the host of address with its first IP address
+// of the family of network, unless it is an IP address already.
+func resolveAddress(ctx context.Context, netDialer *net.Dialer, network, address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", errs.Wrap(err)
+	}
+	if net.ParseIP(host) != nil {
+		return address, nil
+	}
+	resolver := netDialer.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	family := "ip"
+	switch network {
+	case "tcp4":
+		family = "ip4"
+	case "tcp6":
+		family = "ip6"
+	}
+	ips, err := resolver.LookupIP(ctx, family, host)
+	if err != nil {
+		return "", errs.Wrap(err)
+	}
+	return net.JoinHostPort(ips[0].String(), port), nil
+}
+
+func httpConnect(conn net.Conn, proxy *url.URL, address string) error {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: http.Header{},
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		creds := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+	if err := req.Write(conn); err != nil {
+		return errs.Wrap(err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errs.New("proxy CONNECT failed: %s", resp.Status)
+	}
+	// the Noise responder never speaks first, so anything buffered past the
+	// response is a protocol violation by the proxy.
+	if br.Buffered() > 0 {
+		return errs.New("proxy sent data after CONNECT response")
+	}
+	return nil
+}
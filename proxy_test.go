@@ -0,0 +1,223 @@
+package noiseconn
+
+import (
+	"bufio"
+	"crypto/rand"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/flynn/noise"
+	"github.com/jtolio/noiseconn/internal/socks5"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestDialerProxy(t *testing.T) {
+	serverKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	config := noise.Config{
+		CipherSuite:   noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+		Pattern:       noise.HandshakeNK,
+		StaticKeypair: serverKey,
+	}
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	lis := NewListener(inner, config)
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	relay := func(client net.Conn, address string) {
+		defer client.Close()
+		server, err := net.Dial("tcp", address)
+		if err != nil {
+			return
+		}
+		defer server.Close()
+		go func() { _, _ = io.Copy(server, client) }()
+		_, _ = io.Copy(client, server)
+	}
+
+	proxies := map[string]func(net.Conn){
+		"http": func(conn net.Conn) {
+			req, err := http.ReadRequest(bufio.NewReader(conn))
+			if err != nil || req.Method != http.MethodConnect ||
+				req.Header.Get("Proxy-Authorization") != "Basic dXNlcjpwYXNz" {
+				_ = conn.Close()
+				return
+			}
+			_, _ = io.WriteString(conn, "HTTP/1.1 200 OK\r\n\r\n")
+			relay(conn, req.Host)
+		},
+		"socks5": func(conn net.Conn) {
+			req, err := socks5.ReadRequest(conn, func(a socks5.Auth) bool {
+				return a.Username == "user" && a.Password == "pass"
+			})
+			if err != nil {
+				_ = conn.Close()
+				return
+			}
+			_ = socks5.WriteReply(conn, socks5.ReplySucceeded, nil)
+			relay(conn, req.Address)
+		},
+	}
+
+	for scheme, serve := range proxies {
+		scheme, serve := scheme, serve
+		t.Run(scheme, func(t *testing.T) {
+			plis, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				panic(err)
+			}
+			defer plis.Close()
+			go func() {
+				for {
+					conn, err := plis.Accept()
+					if err != nil {
+						return
+					}
+					go serve(conn)
+				}
+			}()
+
+			d := &Dialer{
+				Config: noise.Config{
+					CipherSuite: config.CipherSuite,
+					Pattern:     noise.HandshakeNK,
+					PeerStatic:  serverKey.Public,
+				},
+				Proxy: &url.URL{Scheme: scheme, Host: plis.Addr().String(), User: url.UserPassword("user", "pass")},
+			}
+			conn, err := d.Dial("tcp", inner.Addr().String())
+			if err != nil {
+				panic(err)
+			}
+			defer conn.Close()
+
+			var eg errgroup.Group
+			eg.Go(func() error {
+				_, err := conn.Write([]byte("hello"))
+				return err
+			})
+			buf := make([]byte, 5)
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				panic(err)
+			}
+			if string(buf) != "hello" {
+				t.Fatalf("unexpected echo %q", buf)
+			}
+			if err := eg.Wait(); err != nil {
+				panic(err)
+			}
+		})
+	}
+}
+
+func TestDialerProxyResolve(t *testing.T) {
+	config := noise.Config{
+		CipherSuite: noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b),
+		Pattern:     noise.HandshakeNN,
+	}
+	inner, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	lis := NewListener(inner, config)
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	_, port, err := net.SplitHostPort(inner.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+
+	plis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	defer plis.Close()
+	requested := make(chan string, 1)
+	go func() {
+		for {
+			conn, err := plis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := socks5.ReadRequest(conn, nil)
+				if err != nil {
+					return
+				}
+				requested <- req.Address
+				_ = socks5.WriteReply(conn, socks5.ReplySucceeded, nil)
+				server, err := net.Dial("tcp4", req.Address)
+				if err != nil {
+					return
+				}
+				defer server.Close()
+				go func() { _, _ = io.Copy(server, conn) }()
+				_, _ = io.Copy(conn, server)
+			}()
+		}
+	}()
+
+	// socks5 resolves the host locally, and socks5h leaves it to the proxy.
+	for scheme, want := range map[string]string{
+		"socks5":  net.JoinHostPort("127.0.0.1", port),
+		"socks5h": net.JoinHostPort("localhost", port),
+	} {
+		d := &Dialer{Config: config, Proxy: &url.URL{Scheme: scheme, Host: plis.Addr().String()}}
+		conn, err := d.Dial("tcp4", net.JoinHostPort("localhost", port))
+		if err != nil {
+			panic(err)
+		}
+		_ = conn.Close()
+		if got := <-requested; got != want {
+			t.Fatalf("%s: proxy was asked for %s, want %s", scheme, got, want)
+		}
+	}
+
+	for raw, want := range map[string]string{
+		"socks5://proxy.example":      "proxy.example:1080",
+		"socks5h://proxy.example":     "proxy.example:1080",
+		"http://proxy.example":        "proxy.example:80",
+		"socks5://proxy.example:9050": "proxy.example:9050",
+		"socks5://[2001:db8::1]":      "[2001:db8::1]:1080",
+	} {
+		u, err := url.Parse(raw)
+		if err != nil {
+			panic(err)
+		}
+		if got := proxyAddress(u); got != want {
+			t.Fatalf("%s: got %s, want %s", raw, got, want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package noiseconn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+var proxySignature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	proxyVersion      = 0x20
+	proxyCmdLocal     = 0x00
+	proxyCmdProxy     = 0x01
+	proxyFamilyUnspec = 0x00
+	proxyFamilyTCP4   = 0x11
+	proxyFamilyTCP6   = 0x21
+
+	// proxyMaxLen bounds the address and TLV section of a header.
+	proxyMaxLen = 4096
+
+	// proxyHeaderTimeout bounds how long reading a header may take.
+	proxyHeaderTimeout = 10 * time.Second
+)
+
+// ProxyHeader describes the original connection, as conveyed by a PROXY
+// protocol v2 header.
+type ProxyHeader struct {
+	// Source is the address of the original client.
+	Source *net.TCPAddr
+	// Destination is the address the original client connected to.
+	Destination *net.TCPAddr
+}
+
+// appendProxyHeader appends h as a PROXY protocol v2 header. A nil h
+// results in a LOCAL header, and one with a nil address in a header with an
+// unspecified address family, both of which tell the receiver to use the
+// connection's own addresses.
+func appendProxyHeader(b []byte, h *ProxyHeader) []byte {
+	b = append(b, proxySignature...)
+	if h == nil {
+		return append(b, proxyVersion|proxyCmdLocal, proxyFamilyUnspec, 0, 0)
+	}
+	if h.Source == nil || h.Destination == nil {
+		return append(b, proxyVersion|proxyCmdProxy, proxyFamilyUnspec, 0, 0)
+	}
+	src4, dst4 := h.Source.IP.To4(), h.Destination.IP.To4()
+	if src4 != nil && dst4 != nil {
+		b = append(b, proxyVersion|proxyCmdProxy, proxyFamilyTCP4, 0, 12)
+		b = append(append(b, src4...), dst4...)
+	} else {
+		b = append(b, proxyVersion|proxyCmdProxy, proxyFamilyTCP6, 0, 36)
+		b = append(append(b, h.Source.IP.To16()...), h.Destination.IP.To16()...)
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(h.Source.Port))
+	return binary.BigEndian.AppendUint16(b, uint16(h.Destination.Port))
+}
+
+// readProxyHeader reads a PROXY protocol v2 header from r. It returns a nil
+// header for LOCAL headers and for address families other than TCP.
+func readProxyHeader(r io.Reader) (*ProxyHeader, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, errs.Wrap(err)
+	}
+	if !bytes.Equal(hdr[:12], proxySignature) {
+		return nil, errs.New("missing PROXY protocol header")
+	}
+	if hdr[12]&0xf0 != proxyVersion {
+		return nil, errs.New("unsupported PROXY protocol version")
+	}
+	length := int(binary.BigEndian.Uint16(hdr[14:]))
+	if length > proxyMaxLen {
+		return nil, errs.New("PROXY protocol header too long")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, errs.Wrap(err)
+	}
+
+	switch hdr[12] & 0x0f {
+	case proxyCmdLocal:
+		return nil, nil
+	case proxyCmdProxy:
+	default:
+		return nil, errs.New("unknown PROXY protocol command")
+	}
+	ipLen := 0
+	switch hdr[13] {
+	case proxyFamilyTCP4:
+		ipLen = 4
+	case proxyFamilyTCP6:
+		ipLen = 16
+	default:
+		return nil, nil
+	}
+	if len(body) < 2*ipLen+4 {
+		return nil, errs.New("short PROXY protocol header")
+	}
+	return &ProxyHeader{
+		Source: &net.TCPAddr{
+			IP:   net.IP(body[:ipLen]),
+			Port: int(binary.BigEndian.Uint16(body[2*ipLen:])),
+		},
+		Destination: &net.TCPAddr{
+			IP:   net.IP(body[ipLen : 2*ipLen]),
+			Port: int(binary.BigEndian.Uint16(body[2*ipLen+2:])),
+		},
+	}, nil
+}
+
+// proxyConn consumes a PROXY protocol header before any other data, and
+// reports the addresses it contains. The header is read by the first call
+// to Read, RemoteAddr or LocalAddr, within proxyHeaderTimeout of the first
+// call and the read deadline, if earlier. If the read deadline expires
+// first, the bytes read so far are kept and reading the header continues
+// with the next call.
+type proxyConn struct {
+	net.Conn
+
+	mu       sync.Mutex
+	done     bool
+	buffered []byte
+	header   *ProxyHeader
+	err      error
+
+	deadlineMu     sync.Mutex
+	reading        bool
+	headerDeadline time.Time
+	readDeadline   time.Time
+}
+
+func (c *proxyConn) init() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done {
+		return c.err
+	}
+
+	c.deadlineMu.Lock()
+	if c.headerDeadline.IsZero() {
+		c.headerDeadline = time.Now().Add(proxyHeaderTimeout)
+	}
+	c.reading = true
+	err := c.Conn.SetReadDeadline(c.effectiveDeadline())
+	c.deadlineMu.Unlock()
+	if err != nil {
+		return errs.Wrap(err)
+	}
+
+	r := io.MultiReader(bytes.NewReader(c.buffered), proxyRecorder{c})
+	header, err := readProxyHeader(r)
+
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.reading = false
+	if derr := c.Conn.SetReadDeadline(c.readDeadline); err == nil && derr != nil {
+		err = errs.Wrap(derr)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() && time.Now().Before(c.headerDeadline) {
+		// the read deadline expired, so the header may still arrive.
+		return err
+	}
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		err = errs.New("timed out reading PROXY protocol header")
+	}
+	c.done, c.buffered, c.header, c.err = true, nil, header, err
+	return err
+}
+
+// effectiveDeadline returns the read deadline while the header is read.
+// c.deadlineMu must be held.
+func (c *proxyConn) effectiveDeadline() time.Time {
+	if !c.reading || (!c.readDeadline.IsZero() && c.readDeadline.Before(c.headerDeadline)) {
+		return c.readDeadline
+	}
+	return c.headerDeadline
+}
+
+// proxyRecorder reads from the underlying connection of a proxyConn, and
+// keeps the bytes read in case reading the header is resumed.
+type proxyRecorder struct{ c *proxyConn }
+
+func (r proxyRecorder) Read(b []byte) (int, error) {
+	n, err := r.c.Conn.Read(b)
+	r.c.buffered = append(r.c.buffered, b[:n]...)
+	return n, err
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	if err := c.init(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *proxyConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *proxyConn) SetReadDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetReadDeadline(c.effectiveDeadline())
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.init() == nil && c.header != nil {
+		return c.header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.init() == nil && c.header != nil {
+		return c.header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
@@ -0,0 +1,124 @@
+package noiseconn
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+)
+
+func TestProxyProtocol(t *testing.T) {
+	serverKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	lis := NewListenerWithOptions(inner, noise.Config{
+		CipherSuite:   cs,
+		Pattern:       noise.HandshakeNK,
+		StaticKeypair: serverKey,
+	}, Options{ProxyProtocol: true})
+	defer lis.Close()
+
+	for _, header := range []*ProxyHeader{
+		{
+			Source:      &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 41234},
+			Destination: &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 443},
+		},
+		{
+			Source:      &net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 41234},
+			Destination: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443},
+		},
+		{Source: &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 41234}},
+		nil,
+	} {
+		d := &Dialer{
+			Config: noise.Config{
+				CipherSuite: cs,
+				Pattern:     noise.HandshakeNK,
+				PeerStatic:  serverKey.Public,
+			},
+			ProxyHeader: func(ctx context.Context, raw net.Conn) (*ProxyHeader, error) {
+				return header, nil
+			},
+		}
+
+		done := make(chan net.Conn, 1)
+		go func() {
+			conn, err := lis.Accept()
+			if err != nil {
+				panic(err)
+			}
+			_, _ = io.Copy(conn, conn)
+			done <- conn
+		}()
+
+		conn, err := d.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			panic(err)
+		}
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			panic(err)
+		}
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			panic(err)
+		}
+		local := conn.LocalAddr().String()
+		_ = conn.Close()
+
+		sconn := <-done
+		_ = sconn.Close()
+		want := local
+		if header != nil && header.Destination != nil {
+			want = header.Source.String()
+		}
+		if got := sconn.RemoteAddr().String(); got != want {
+			t.Fatalf("expected remote addr %s, got %s", want, got)
+		}
+	}
+}
+
+func TestProxyConnDeadline(t *testing.T) {
+	a, b := net.Pipe()
+	defer func() { _ = a.Close() }()
+	defer func() { _ = b.Close() }()
+	conn := &proxyConn{Conn: a}
+
+	header := appendProxyHeader(nil, &ProxyHeader{
+		Source:      &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 41234},
+		Destination: &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 443},
+	})
+	go func() { _, _ = b.Write(header[:10]) }()
+
+	// the read deadline expires in the middle of the header, which is
+	// resumed by the next read.
+	if err := conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+		panic(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := conn.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		panic(err)
+	}
+	go func() { _, _ = b.Write(append(header[10:], "ping"...)) }()
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("unexpected read %q: %v", buf, err)
+	}
+	if got := conn.RemoteAddr().String(); got != "203.0.113.7:41234" {
+		t.Fatalf("unexpected remote addr %s", got)
+	}
+}
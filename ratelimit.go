@@ -0,0 +1,129 @@
+package noiseconn
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits how often connections are accepted. Listeners consult
+// it before allocating any handshake state, so connections it refuses cost
+// no DH operations.
+type RateLimiter interface {
+	// Allow reports whether a connection from addr may be accepted.
+	Allow(addr net.Addr) bool
+}
+
+// SourceRateLimiter is a RateLimiter with a token bucket per source
+// address prefix. Each bucket holds up to Burst tokens and is refilled at
+// Rate tokens per second, and every accepted connection takes a token.
+// The fields must not be changed once the limiter is in use.
+type SourceRateLimiter struct {
+	// Rate is how many connections per second are allowed from a prefix.
+	Rate float64
+	// Burst is how many connections are allowed from a prefix at once.
+	Burst int
+	// IPv4PrefixLen and IPv6PrefixLen are the lengths of the prefixes
+	// that share a bucket. If zero, they are 32 and 64. Lengths beyond
+	// 32 and 128 are treated as those.
+	IPv4PrefixLen int
+	IPv6PrefixLen int
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	nextGC  time.Time
+	timeNow func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewSourceRateLimiter returns a SourceRateLimiter that allows rate
+// connections per second with bursts of burst connections from every IPv4
+// address and IPv6 /64 prefix.
+func NewSourceRateLimiter(rate float64, burst int) *SourceRateLimiter {
+	return &SourceRateLimiter{Rate: rate, Burst: burst, timeNow: time.Now}
+}
+
+// Allow implements RateLimiter.
+func (l *SourceRateLimiter) Allow(addr net.Addr) bool {
+	key := l.prefix(addr)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.timeNow != nil {
+		now = l.timeNow()
+	}
+	if l.buckets == nil {
+		l.buckets = make(map[string]*tokenBucket)
+	}
+	if now.After(l.nextGC) {
+		// full buckets are equivalent to missing ones.
+		for k, b := range l.buckets {
+			if l.refill(b, now) >= float64(l.Burst) {
+				delete(l.buckets, k)
+			}
+		}
+		l.nextGC = now.Add(time.Minute)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// refill returns the tokens in b at now.
+func (l *SourceRateLimiter) refill(b *tokenBucket, now time.Time) float64 {
+	tokens := b.tokens
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		tokens += elapsed.Seconds() * l.Rate
+	}
+	if tokens > float64(l.Burst) {
+		tokens = float64(l.Burst)
+	}
+	return tokens
+}
+
+// prefix returns the bucket key of addr.
+func (l *SourceRateLimiter) prefix(addr net.Addr) string {
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	case nil:
+		return ""
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return addr.String()
+		}
+		ip = net.ParseIP(host)
+		if ip == nil {
+			return host
+		}
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		bits := l.IPv4PrefixLen
+		if bits <= 0 {
+			bits = 32
+		}
+		return ip4.Mask(net.CIDRMask(min(bits, 32), 32)).String()
+	}
+	bits := l.IPv6PrefixLen
+	if bits <= 0 {
+		bits = 64
+	}
+	return ip.Mask(net.CIDRMask(min(bits, 128), 128)).String()
+}
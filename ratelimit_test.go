@@ -0,0 +1,143 @@
+package noiseconn
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+)
+
+func TestSourceRateLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewSourceRateLimiter(1, 2)
+	l.timeNow = func() time.Time { return now }
+
+	a := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1}
+	b := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 1}
+	a6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1}
+	b6 := &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 2}
+
+	for i, want := range []bool{true, true, false} {
+		if got := l.Allow(a); got != want {
+			t.Fatalf("attempt %d: got %v", i, got)
+		}
+	}
+	if !l.Allow(b) {
+		t.Fatal("expected other addresses to have their own bucket")
+	}
+	now = now.Add(time.Second)
+	if !l.Allow(a) || l.Allow(a) {
+		t.Fatal("expected one token to be refilled")
+	}
+
+	// IPv6 addresses in the same /64 share a bucket.
+	if !l.Allow(a6) || !l.Allow(b6) || l.Allow(a6) {
+		t.Fatal("expected the /64 prefix to share a bucket")
+	}
+
+	now = now.Add(time.Hour)
+	l.Allow(b)
+	if len(l.buckets) != 1 {
+		t.Fatalf("expected full buckets to be collected, got %d", len(l.buckets))
+	}
+
+	// prefix lengths beyond the address length are clamped.
+	l.IPv4PrefixLen, l.IPv6PrefixLen = 40, 200
+	if got := l.prefix(a); got != "192.0.2.1" {
+		t.Fatalf("unexpected prefix %q", got)
+	}
+	if got := l.prefix(a6); got != "2001:db8::1" {
+		t.Fatalf("unexpected prefix %q", got)
+	}
+}
+
+func TestListenerRateLimit(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	l := NewListener(inner, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN})
+	l.RateLimit = NewSourceRateLimiter(0, 1)
+	defer func() { _ = l.Close() }()
+
+	dial := func() net.Conn {
+		raw, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			panic(err)
+		}
+		return raw
+	}
+	first := dial()
+	defer func() { _ = first.Close() }()
+	if _, err := l.Accept(); err != nil {
+		t.Fatal(err)
+	}
+
+	second := dial()
+	defer func() { _ = second.Close() }()
+	accepted := make(chan struct{})
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			_ = conn.Close()
+		}
+		close(accepted)
+	}()
+	_ = second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := second.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal("expected the limited connection to be closed, got", err)
+	}
+	_ = l.Close()
+	<-accepted
+}
+
+func TestListenerRateLimitProxyProtocol(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	l := NewListener(inner, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, WithProxyProtocol())
+	l.RateLimit = NewSourceRateLimiter(0, 1)
+	defer func() { _ = l.Close() }()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	// the limit applies to the sources in the headers, even though every
+	// connection comes from the same address.
+	dial := func(source string) error {
+		d := &Dialer{
+			Config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN},
+			ProxyHeader: func(ctx context.Context, raw net.Conn) (*ProxyHeader, error) {
+				return &ProxyHeader{
+					Source:      &net.TCPAddr{IP: net.ParseIP(source), Port: 1},
+					Destination: raw.RemoteAddr().(*net.TCPAddr),
+				}, nil
+			},
+		}
+		conn, err := d.Dial("tcp", inner.Addr().String())
+		if err == nil {
+			_ = conn.Close()
+		}
+		return err
+	}
+	if err := dial("192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dial("192.0.2.2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dial("192.0.2.1"); err == nil {
+		t.Fatal("expected the limited source to be refused")
+	}
+}
@@ -0,0 +1,369 @@
+package noiseconn
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+// ReconnectOptions configure a ReconnectingConn.
+type ReconnectOptions struct {
+	// MaxAttempts is the number of consecutive failed attempts to
+	// reconnect after which the ReconnectingConn gives up, and its reads
+	// and writes fail. If zero, it never gives up.
+	MaxAttempts int
+
+	// MinBackoff is the delay after the first failed attempt, which is
+	// doubled after each further failure up to MaxBackoff. If zero, 100ms
+	// and 30 seconds are used. The backoff is only reset once a
+	// connection lasted MaxBackoff, so that connections that fail right
+	// after they were established are redialed with increasing delays
+	// too.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// ReconnectOnClose makes the peer closing the connection in an
+	// orderly way, with reads returning io.EOF or a PeerCloseError, count
+	// as a failure. Otherwise, those errors are returned and the
+	// connection isn't replaced.
+	ReconnectOnClose bool
+
+	// Resume, if set, resumes the session when reconnecting, with an IK
+	// handshake using the static key of the responder learned by the
+	// previous connection, as with Dialer.SessionCache. It has no effect
+	// if the Dialer already has a SessionCache or LookupPeerStatic. If
+	// unset, every connection does a fresh handshake with Dialer.Config.
+	Resume bool
+
+	// Replay, if set, is called with every new connection after
+	// reconnecting, before reads and writes continue on it, and returns
+	// the data to write on it first. This is where data written on the
+	// failed connection that the application doesn't know to have been
+	// received by the peer can be sent again. An error fails the attempt.
+	Replay func(conn *Conn) ([]byte, error)
+}
+
+// ReconnectingConn is a connection that, when the underlying Conn fails,
+// dials it again and continues reads and writes on the new one. Any error
+// other than a deadline expiring or the peer closing the connection counts
+// as a failure; see ReconnectOptions.ReconnectOnClose. Data in flight when
+// the connection failed is lost, unless it is sent again by
+// ReconnectOptions.Replay.
+type ReconnectingConn struct {
+	dialer           *Dialer
+	network, address string
+	opts             ReconnectOptions
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// reconnectMu serializes reconnecting, which doesn't hold mu while
+	// dialing or backing off, and protects backoff.
+	reconnectMu sync.Mutex
+	backoff     time.Duration
+
+	mu            sync.Mutex
+	conn          *Conn
+	connected     time.Time
+	gen           uint64
+	reconnects    int
+	err           error
+	closed        bool
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+var _ net.Conn = (*ReconnectingConn)(nil)
+
+// DialReconnecting dials address with d and returns a ReconnectingConn
+// using the connection.
+func DialReconnecting(ctx context.Context, d *Dialer, network, address string) (*ReconnectingConn, error) {
+	return DialReconnectingWithOptions(ctx, d, network, address, ReconnectOptions{})
+}
+
+// DialReconnectingWithOptions is like DialReconnecting, configured by
+// opts. The first dial isn't retried.
+func DialReconnectingWithOptions(ctx context.Context, d *Dialer, network, address string, opts ReconnectOptions) (*ReconnectingConn, error) {
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = 100 * time.Millisecond
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 30 * time.Second
+	}
+	if opts.Resume && d.SessionCache == nil && d.LookupPeerStatic == nil {
+		resuming := *d
+		resuming.SessionCache = NewMemorySessionCache(0)
+		d = &resuming
+	}
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	r := &ReconnectingConn{
+		dialer:    d,
+		network:   network,
+		address:   address,
+		opts:      opts,
+		conn:      conn.(*Conn),
+		connected: time.Now(),
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	return r, nil
+}
+
+// Conn returns the current underlying connection.
+func (r *ReconnectingConn) Conn() *Conn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.conn
+}
+
+// Reconnects returns how many times the connection was replaced.
+func (r *ReconnectingConn) Reconnects() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reconnects
+}
+
+// Read reads from the current connection, reconnecting if it failed.
+func (r *ReconnectingConn) Read(b []byte) (int, error) {
+	for {
+		conn, gen, err := r.current()
+		if err != nil {
+			return 0, err
+		}
+		n, err := conn.Read(b)
+		if err == nil || !r.connFailed(err) {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+		if err := r.reconnect(gen, err); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write writes to the current connection, reconnecting if it failed and
+// writing the rest of b to the new connection.
+func (r *ReconnectingConn) Write(b []byte) (n int, err error) {
+	for {
+		conn, gen, err := r.current()
+		if err != nil {
+			return n, err
+		}
+		m, err := conn.Write(b[n:])
+		n += m
+		if err == nil || !r.connFailed(err) {
+			return n, err
+		}
+		if err := r.reconnect(gen, err); err != nil {
+			return n, err
+		}
+	}
+}
+
+// connFailed returns whether err means that the connection failed, rather
+// than a deadline expiring or, unless ReconnectOnClose is set, the peer
+// closing it.
+func (r *ReconnectingConn) connFailed(err error) bool {
+	var netErr net.Error
+	var closeErr *PeerCloseError
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return false
+	case errors.Is(err, io.EOF), errors.As(err, &closeErr):
+		return r.opts.ReconnectOnClose
+	}
+	return true
+}
+
+func (r *ReconnectingConn) current() (*Conn, uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case r.closed:
+		return nil, 0, net.ErrClosed
+	case r.err != nil:
+		return nil, 0, r.err
+	}
+	return r.conn, r.gen, nil
+}
+
+// reconnect replaces the connection of generation gen, which failed with
+// cause, unless that was done already.
+func (r *ReconnectingConn) reconnect(gen uint64, cause error) error {
+	r.reconnectMu.Lock()
+	defer r.reconnectMu.Unlock()
+	r.mu.Lock()
+	switch {
+	case r.closed:
+		r.mu.Unlock()
+		return net.ErrClosed
+	case r.err != nil:
+		r.mu.Unlock()
+		return r.err
+	case r.gen != gen:
+		r.mu.Unlock()
+		return nil
+	}
+	failed, connected := r.conn, r.connected
+	r.mu.Unlock()
+	_ = failed.Close()
+	r.log(LogWarn, "connection failed, reconnecting", "address", r.address, "error", cause)
+
+	if time.Since(connected) >= r.opts.MaxBackoff {
+		// the connection was stable, so it is redialed right away.
+		r.backoff = 0
+	}
+	for attempt := 1; ; attempt++ {
+		if r.backoff > 0 {
+			timer := time.NewTimer(r.backoff)
+			select {
+			case <-r.ctx.Done():
+				timer.Stop()
+				return net.ErrClosed
+			case <-timer.C:
+			}
+		}
+		if r.backoff *= 2; r.backoff == 0 {
+			r.backoff = r.opts.MinBackoff
+		} else if r.backoff > r.opts.MaxBackoff {
+			r.backoff = r.opts.MaxBackoff
+		}
+
+		conn, err := r.dial()
+		if err == nil {
+			err = r.install(conn)
+			if err == nil {
+				r.log(LogInfo, "reconnected", "address", r.address, "attempts", attempt)
+				return nil
+			}
+		}
+		if r.ctx.Err() != nil {
+			return net.ErrClosed
+		}
+		r.log(LogWarn, "reconnecting failed", "address", r.address, "attempt", attempt, "error", err)
+		if r.opts.MaxAttempts > 0 && attempt >= r.opts.MaxAttempts {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.err = errs.New("giving up reconnecting after %d attempts: %v", attempt, err)
+			return r.err
+		}
+	}
+}
+
+// install makes conn the current connection, with the deadlines set
+// meanwhile.
+func (r *ReconnectingConn) install(conn *Conn) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		_ = conn.Close()
+		return net.ErrClosed
+	}
+	if err := conn.SetReadDeadline(r.readDeadline); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	if err := conn.SetWriteDeadline(r.writeDeadline); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	r.conn, r.connected, r.gen, r.reconnects = conn, time.Now(), r.gen+1, r.reconnects+1
+	return nil
+}
+
+// dial establishes a new connection and replays data on it. r.reconnectMu
+// must be held.
+func (r *ReconnectingConn) dial() (*Conn, error) {
+	nc, err := r.dialer.DialContext(r.ctx, r.network, r.address)
+	if err != nil {
+		return nil, err
+	}
+	conn := nc.(*Conn)
+
+	// Close interrupts the replay.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-r.ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+	if r.opts.Replay != nil {
+		data, err := r.opts.Replay(conn)
+		if err == nil && len(data) > 0 {
+			_, err = conn.Write(data)
+		}
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (r *ReconnectingConn) log(level LogLevel, msg string, keyvals ...interface{}) {
+	if logger := r.dialer.Options.Logger; logger != nil {
+		logger.Log(level, msg, keyvals...)
+	}
+}
+
+// Close closes the connection and stops reconnecting.
+func (r *ReconnectingConn) Close() error {
+	r.cancel()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return net.ErrClosed
+	}
+	r.closed = true
+	return r.conn.Close()
+}
+
+// LocalAddr returns the local address of the current connection.
+func (r *ReconnectingConn) LocalAddr() net.Addr {
+	return r.Conn().LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the current connection.
+func (r *ReconnectingConn) RemoteAddr() net.Addr {
+	return r.Conn().RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines, which also apply to the
+// connections established later.
+func (r *ReconnectingConn) SetDeadline(t time.Time) error {
+	if err := r.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return r.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline, which also applies to the
+// connections established later.
+func (r *ReconnectingConn) SetReadDeadline(t time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.readDeadline = t
+	return r.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline, which also applies to the
+// connections established later.
+func (r *ReconnectingConn) SetWriteDeadline(t time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writeDeadline = t
+	return r.conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,128 @@
+package noiseconn
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+)
+
+func TestReconnectingConn(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	key, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = inner.Close() }()
+	lis := NewListener(inner, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeIK, StaticKeypair: key},
+		WithFallbackPattern(noise.HandshakeXX))
+	// the server echoes one byte per connection and drops it.
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var b [1]byte
+				if _, err := io.ReadFull(conn, b[:]); err != nil {
+					return
+				}
+				_, _ = conn.Write(b[:])
+			}()
+		}
+	}()
+
+	clientKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	d := &Dialer{Config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeXX, StaticKeypair: clientKey}}
+	var protocols []string
+	conn, err := DialReconnectingWithOptions(context.Background(), d, "tcp", inner.Addr().String(), ReconnectOptions{
+		MaxAttempts:      2,
+		Resume:           true,
+		ReconnectOnClose: true,
+		Replay: func(conn *Conn) ([]byte, error) {
+			protocols = append(protocols, conn.ConnectionState().Protocol)
+			return []byte{'b'}, nil
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if _, err := conn.Write([]byte{'a'}); err != nil {
+		panic(err)
+	}
+	var b [1]byte
+	if _, err := io.ReadFull(conn, b[:]); err != nil || b[0] != 'a' {
+		t.Fatalf("unexpected read %q: %v", b[:], err)
+	}
+	// the server dropped the connection, so the next read reconnects and
+	// receives the echo of the replayed byte.
+	if _, err := io.ReadFull(conn, b[:]); err != nil || b[0] != 'b' {
+		t.Fatalf("unexpected read %q: %v", b[:], err)
+	}
+	if conn.Reconnects() != 1 || len(protocols) != 1 || protocols[0] != "Noise_IK_25519_ChaChaPoly_BLAKE2b" {
+		t.Fatalf("unexpected reconnects %d with %v", conn.Reconnects(), protocols)
+	}
+
+	// without the server, reconnecting gives up.
+	_ = inner.Close()
+	if _, err := conn.Read(b[:]); err == nil {
+		t.Fatal("expected reconnecting to give up")
+	}
+}
+
+func TestReconnectingConnPeerClose(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	key, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = inner.Close() }()
+	lis := NewListener(inner, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, StaticKeypair: key})
+	// the server closes every connection after the handshake.
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = conn.(*Conn).Handshake()
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	d := &Dialer{Config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}}
+	conn, err := DialReconnecting(context.Background(), d, "tcp", inner.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	var b [1]byte
+	if _, err := conn.Read(b[:]); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	if conn.Reconnects() != 0 {
+		t.Fatalf("unexpected reconnects %d", conn.Reconnects())
+	}
+}
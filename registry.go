@@ -0,0 +1,146 @@
+package noiseconn
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+// Registry tracks the live connections it is set as Options.Registry for,
+// to list them, like ss does for sockets, and to close them
+// administratively. Connections are added when they are created and
+// removed when they are closed. It may be shared by many connections and
+// used concurrently.
+type Registry struct {
+	mu    sync.Mutex
+	next  uint64
+	conns map[uint64]*registryEntry
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{conns: make(map[uint64]*registryEntry)}
+}
+
+type registryEntry struct {
+	registry *Registry
+	id       uint64
+	conn     *Conn
+	created  time.Time
+	sent     atomic.Int64
+	received atomic.Int64
+	// last is the time of the last activity in Unix nanoseconds.
+	last atomic.Int64
+}
+
+// ConnInfo describes a connection of a Registry.
+type ConnInfo struct {
+	// ID identifies the connection in the Registry.
+	ID         uint64
+	Conn       *Conn
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+	Initiator  bool
+
+	HandshakeComplete bool
+	// PeerStatic is the static public key of the peer, if known.
+	PeerStatic []byte
+
+	// Created is when the connection was created, and LastActivity when
+	// a Noise message was last sent or received.
+	Created      time.Time
+	LastActivity time.Time
+	// BytesSent and BytesReceived are the sizes of the Noise messages
+	// sent and received, without the stream framing.
+	BytesSent     int64
+	BytesReceived int64
+}
+
+// Age returns how long ago the connection was created.
+func (i ConnInfo) Age() time.Duration {
+	return time.Since(i.Created)
+}
+
+// Idle returns how long ago the connection was last active.
+func (i ConnInfo) Idle() time.Duration {
+	return time.Since(i.LastActivity)
+}
+
+func (r *Registry) add(c *Conn) *registryEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.next++
+	e := &registryEntry{registry: r, id: r.next, conn: c, created: c.created}
+	e.last.Store(c.created.UnixNano())
+	r.conns[e.id] = e
+	return e
+}
+
+func (r *Registry) remove(id uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.conns, id)
+}
+
+// Len returns the number of live connections.
+func (r *Registry) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.conns)
+}
+
+// Conns returns the live connections, ordered by ID.
+func (r *Registry) Conns() []ConnInfo {
+	r.mu.Lock()
+	entries := make([]*registryEntry, 0, len(r.conns))
+	for _, e := range r.conns {
+		entries = append(entries, e)
+	}
+	r.mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].id < entries[j].id })
+
+	infos := make([]ConnInfo, 0, len(entries))
+	for _, e := range entries {
+		state := e.conn.ConnectionState()
+		infos = append(infos, ConnInfo{
+			ID:                e.id,
+			Conn:              e.conn,
+			LocalAddr:         e.conn.LocalAddr(),
+			RemoteAddr:        e.conn.RemoteAddr(),
+			Initiator:         state.Initiator,
+			HandshakeComplete: state.HandshakeComplete,
+			PeerStatic:        state.PeerStatic,
+			Created:           e.created,
+			LastActivity:      time.Unix(0, e.last.Load()),
+			BytesSent:         e.sent.Load(),
+			BytesReceived:     e.received.Load(),
+		})
+	}
+	return infos
+}
+
+// Close closes the connection with the given ID.
+func (r *Registry) Close(id uint64) error {
+	r.mu.Lock()
+	e, ok := r.conns[id]
+	r.mu.Unlock()
+	if !ok {
+		return errs.New("no connection with ID %d", id)
+	}
+	return e.conn.Close()
+}
+
+// registryActivity records a sent or received Noise message of size bytes.
+func (c *Conn) registryActivity(sent bool, size int) {
+	e := c.registryEntry
+	if sent {
+		e.sent.Add(int64(size))
+	} else {
+		e.received.Add(int64(size))
+	}
+	e.last.Store(time.Now().UnixNano())
+}
@@ -0,0 +1,68 @@
+package noiseconn
+
+import (
+	"io"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	p1, p2 := tcpPair()
+	client, err := NewConn(p1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true}, WithRegistry(registry))
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	server, err := NewConn(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, WithRegistry(registry))
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+
+	conns := registry.Conns()
+	if len(conns) != 2 || conns[0].Conn != client || conns[1].Conn != server || conns[0].HandshakeComplete {
+		t.Fatalf("unexpected connections %+v", conns)
+	}
+
+	var eg errgroup.Group
+	eg.Go(client.Handshake)
+	eg.Go(server.Handshake)
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	eg.Go(func() error {
+		_, err := client.Write([]byte("hello"))
+		return err
+	})
+	if _, err := io.ReadFull(server, make([]byte, 5)); err != nil {
+		panic(err)
+	}
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+
+	conns = registry.Conns()
+	if !conns[0].HandshakeComplete || conns[0].BytesSent == 0 || conns[1].BytesReceived != conns[0].BytesSent {
+		t.Fatalf("unexpected connections %+v", conns)
+	}
+	if conns[0].LastActivity.Before(conns[0].Created) || conns[0].Age() < 0 {
+		t.Fatalf("unexpected times %+v", conns[0])
+	}
+
+	if err := registry.Close(conns[1].ID); err != nil {
+		panic(err)
+	}
+	if registry.Len() != 1 || registry.Conns()[0].Conn != client {
+		t.Fatal("expected the closed connection to be removed")
+	}
+	if _, err := server.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the connection to be closed")
+	}
+	if err := registry.Close(conns[1].ID); err == nil {
+		t.Fatal("expected an error closing an unknown connection")
+	}
+}
@@ -0,0 +1,111 @@
+package noiseconn
+
+import (
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+// rekeyPolicy is the state behind Options.RekeyInterval, RekeyAfterIdle
+// and RekeyAfterBytes. It is protected by c.writeMu, and initialized when
+// the handshake completes.
+type rekeyPolicy struct {
+	interval time.Duration
+	idle     time.Duration
+	bytes    int64
+
+	// last is when the sending key was last changed, and lastWrite when
+	// data was last written.
+	last      time.Time
+	lastWrite time.Time
+	sent      int64
+}
+
+func (r *rekeyPolicy) enabled() bool {
+	return r.interval > 0 || r.idle > 0 || r.bytes > 0
+}
+
+// due returns whether the sending key should be changed before writing at
+// now.
+func (r *rekeyPolicy) due(now time.Time) bool {
+	return (r.interval > 0 && now.Sub(r.last) >= r.interval) ||
+		(r.idle > 0 && now.Sub(r.lastWrite) >= r.idle) ||
+		(r.bytes > 0 && r.sent >= r.bytes)
+}
+
+// reset notes that the sending key was changed at now.
+func (r *rekeyPolicy) reset(now time.Time) {
+	r.last, r.lastWrite, r.sent = now, now, 0
+}
+
+// appendPolicyRekey appends a rekey control frame to out and changes the
+// sending key if the rekey policy says so, before n bytes of data are
+// written. c.writeMu must be held.
+func (c *Conn) appendPolicyRekey(out []byte, n int) ([]byte, error) {
+	r := &c.rekey
+	if !r.enabled() {
+		return out, nil
+	}
+	now := time.Now()
+	if r.due(now) {
+		var err error
+		if out, err = c.appendRekey(out); err != nil {
+			return nil, err
+		}
+		r.reset(now)
+	}
+	r.lastWrite = now
+	r.sent += int64(n)
+	return out, nil
+}
+
+// appendRekey appends a rekey control frame, encrypted with the current
+// sending key, to out and changes the sending key. The peer changes its
+// receiving key when it reads the frame. c.writeMu must be held.
+func (c *Conn) appendRekey(out []byte) ([]byte, error) {
+	if !c.peerControl {
+		return nil, errs.New("peer doesn't support rekeying")
+	}
+	defer c.region("rekey")()
+	out, err := c.appendControl(out, controlRekey, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.send.Rekey()
+	c.rekeyed(true)
+	return out, nil
+}
+
+// rekeyed reports a change of the sending or receiving key.
+func (c *Conn) rekeyed(sent bool) {
+	c.log(LogDebug, "rekeyed", "sent", sent)
+	if c.hooks != nil && c.hooks.Rekey != nil {
+		c.hooks.Rekey(c, sent)
+	}
+}
+
+// Rekey changes the key used to send data, and tells the peer to change
+// its receiving key accordingly, as described by the Noise specification.
+// Keys are also changed automatically according to Options.RekeyInterval,
+// RekeyAfterIdle and RekeyAfterBytes. It fails if the handshake isn't
+// complete or the peer doesn't support control frames. It may be called
+// concurrently with Read and Write.
+func (c *Conn) Rekey() (err error) {
+	defer c.afterIO(&err)
+	c.hsMu.Lock()
+	complete := c.hs == nil
+	c.hsMu.Unlock()
+	if !complete {
+		return errs.New("handshake not complete")
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	buf, err := c.appendRekey(c.writeMsgBuf[:0])
+	if err != nil {
+		return err
+	}
+	c.writeMsgBuf = buf
+	c.rekey.reset(time.Now())
+	return c.writeFrames(buf)
+}
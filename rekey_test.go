@@ -0,0 +1,119 @@
+package noiseconn
+
+import (
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestConnRekey(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	for _, test := range []struct {
+		name     string
+		opts     Options
+		pause    time.Duration
+		rekeys   int64
+		explicit bool
+	}{
+		{name: "explicit", opts: Options{HandshakeExtensions: true}, rekeys: 1, explicit: true},
+		{name: "bytes", opts: Options{RekeyAfterBytes: 10}, rekeys: 1},
+		{name: "idle", opts: Options{RekeyAfterIdle: 20 * time.Millisecond}, pause: 50 * time.Millisecond, rekeys: 3},
+		{name: "interval", opts: Options{RekeyInterval: 20 * time.Millisecond}, pause: 50 * time.Millisecond, rekeys: 3},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var sent, received int64
+			clientOpts := test.opts
+			clientOpts.Hooks = &Hooks{Rekey: func(_ *Conn, s bool) {
+				if s {
+					atomic.AddInt64(&sent, 1)
+				}
+			}}
+			serverOpts := Options{HandshakeExtensions: true, Hooks: &Hooks{Rekey: func(_ *Conn, s bool) {
+				if !s {
+					atomic.AddInt64(&received, 1)
+				}
+			}}}
+
+			p1, p2 := tcpPair()
+			client, err := NewConnWithOptions(p1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true}, clientOpts)
+			if err != nil {
+				panic(err)
+			}
+			defer func() { _ = client.Close() }()
+			server, err := NewConnWithOptions(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, serverOpts)
+			if err != nil {
+				panic(err)
+			}
+			defer func() { _ = server.Close() }()
+
+			var eg errgroup.Group
+			eg.Go(client.Handshake)
+			eg.Go(server.Handshake)
+			if err := eg.Wait(); err != nil {
+				panic(err)
+			}
+			if test.explicit {
+				if err := client.Rekey(); err != nil {
+					panic(err)
+				}
+			}
+			for i := 0; i < 3; i++ {
+				time.Sleep(test.pause)
+				if _, err := client.Write([]byte("eight by")); err != nil {
+					panic(err)
+				}
+				b := make([]byte, 8)
+				if _, err := io.ReadFull(server, b); err != nil {
+					panic(err)
+				}
+				if string(b) != "eight by" {
+					t.Fatalf("unexpected data %q", b)
+				}
+			}
+			if atomic.LoadInt64(&sent) != test.rekeys || atomic.LoadInt64(&received) != test.rekeys {
+				t.Fatalf("expected %d rekeys, got %d sent and %d received", test.rekeys, sent, received)
+			}
+
+			// the keys of the other direction are unchanged.
+			if _, err := server.Write([]byte("reply")); err != nil {
+				panic(err)
+			}
+			b := make([]byte, 5)
+			if _, err := io.ReadFull(client, b); err != nil {
+				panic(err)
+			}
+		})
+	}
+}
+
+func TestConnRekeyUnsupported(t *testing.T) {
+	p1, p2 := tcpPair()
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	client, err := NewConn(p1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	server, err := NewConn(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+
+	if err := client.Rekey(); err == nil {
+		t.Fatal("expected an error before the handshake")
+	}
+	var eg errgroup.Group
+	eg.Go(client.Handshake)
+	eg.Go(server.Handshake)
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	if err := client.Rekey(); err == nil {
+		t.Fatal("expected an error without control frames")
+	}
+}
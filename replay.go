@@ -0,0 +1,97 @@
+package noiseconn
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+// ErrReplayedHandshake is returned when a responder rejects a first
+// handshake message as stale or replayed.
+var ErrReplayedHandshake = errors.New("replayed handshake")
+
+// timestampLen is the length of the timestamp extension: a big-endian
+// Unix time in nanoseconds and a random nonce.
+const timestampLen = 8 + 16
+
+// ReplayCache records the first handshake messages a responder has seen,
+// to reject replays within the freshness window of Options.MaxTimestampAge.
+type ReplayCache interface {
+	// Seen records id until expires, and reports whether it was already
+	// recorded.
+	Seen(id []byte, expires time.Time) bool
+}
+
+// MemoryReplayCache is an in-memory ReplayCache. It should be shared by
+// all the connections of a responder.
+type MemoryReplayCache struct {
+	mu      sync.Mutex
+	seen    map[string]time.Time
+	nextGC  time.Time
+	timeNow func() time.Time
+}
+
+// NewMemoryReplayCache returns an empty MemoryReplayCache.
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{seen: make(map[string]time.Time), timeNow: time.Now}
+}
+
+// Seen implements ReplayCache.
+func (c *MemoryReplayCache) Seen(id []byte, expires time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.timeNow()
+	if now.After(c.nextGC) {
+		for k, exp := range c.seen {
+			if now.After(exp) {
+				delete(c.seen, k)
+			}
+		}
+		c.nextGC = now.Add(time.Minute)
+	}
+	if exp, ok := c.seen[string(id)]; ok && !now.After(exp) {
+		return true
+	}
+	c.seen[string(id)] = expires
+	return false
+}
+
+// newTimestamp returns a timestamp extension with a random nonce. The
+// time is set by setTimestamp when the message is written.
+func newTimestamp() ([]byte, error) {
+	b := make([]byte, timestampLen)
+	if _, err := rand.Read(b[8:]); err != nil {
+		return nil, errs.Wrap(err)
+	}
+	return b, nil
+}
+
+func setTimestamp(b []byte, now time.Time) []byte {
+	binary.BigEndian.PutUint64(b, uint64(now.UnixNano()))
+	return b
+}
+
+// checkTimestamp checks the timestamp extension of the first handshake
+// message. A nil value means the initiator didn't send one.
+func (c *Conn) checkTimestamp(value []byte) error {
+	if value == nil {
+		return fmt.Errorf("%w: no timestamp", ErrReplayedHandshake)
+	}
+	if len(value) != timestampLen {
+		return errs.New("invalid handshake timestamp")
+	}
+	ts := time.Unix(0, int64(binary.BigEndian.Uint64(value)))
+	now := time.Now()
+	if ts.Before(now.Add(-c.maxTimestampAge)) || ts.After(now.Add(c.maxTimestampAge)) {
+		return fmt.Errorf("%w: timestamp %v is outside of the freshness window", ErrReplayedHandshake, ts.UTC())
+	}
+	if c.replayCache != nil && c.replayCache.Seen(value, ts.Add(c.maxTimestampAge)) {
+		return fmt.Errorf("%w: timestamp was already seen", ErrReplayedHandshake)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package noiseconn
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+)
+
+func TestReplayProtection(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	serverKey, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	clientKey, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	cache := NewMemoryReplayCache()
+	newServer := func(conn net.Conn) *Conn {
+		server, err := NewConnWithOptions(conn, noise.Config{
+			CipherSuite: cs, Pattern: noise.HandshakeIK, StaticKeypair: serverKey,
+		}, Options{MaxTimestampAge: time.Minute, ReplayCache: cache})
+		if err != nil {
+			panic(err)
+		}
+		t.Cleanup(func() { _ = server.Close() })
+		return server
+	}
+	// send writes the first message of a client and returns what was
+	// written.
+	send := func(opts Options, server *Conn, p net.Conn) ([]byte, error) {
+		rec := &recordingConn{Conn: p}
+		client, err := NewConnWithOptions(rec, noise.Config{
+			CipherSuite: cs, Pattern: noise.HandshakeIK, Initiator: true,
+			StaticKeypair: clientKey, PeerStatic: serverKey.Public,
+		}, opts)
+		if err != nil {
+			panic(err)
+		}
+		t.Cleanup(func() { _ = client.Close() })
+		go func() { _, _ = client.Write([]byte("hello")) }()
+		_, err = io.ReadFull(server, make([]byte, 5))
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		return rec.written, err
+	}
+
+	p1, p2 := net.Pipe()
+	first, err := send(Options{SendTimestamp: true}, newServer(p2), p1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// replaying the first message is rejected.
+	p3, p4 := net.Pipe()
+	replayed := newServer(p4)
+	go func() { _, _ = p3.Write(first) }()
+	if _, err := replayed.Read(make([]byte, 5)); !errors.Is(err, ErrReplayedHandshake) {
+		t.Fatal("expected the replay to be rejected, got", err)
+	}
+
+	// first messages without a timestamp are rejected.
+	p5, p6 := net.Pipe()
+	if _, err := send(Options{HandshakeExtensions: true}, newServer(p6), p5); !errors.Is(err, ErrReplayedHandshake) {
+		t.Fatal("expected the missing timestamp to be rejected, got", err)
+	}
+}
+
+func TestMemoryReplayCache(t *testing.T) {
+	now := time.Now()
+	cache := NewMemoryReplayCache()
+	cache.timeNow = func() time.Time { return now }
+
+	if cache.Seen([]byte("a"), now.Add(time.Minute)) {
+		t.Fatal("unexpected seen")
+	}
+	if !cache.Seen([]byte("a"), now.Add(time.Minute)) {
+		t.Fatal("expected seen")
+	}
+	now = now.Add(2 * time.Minute)
+	if cache.Seen([]byte("a"), now.Add(time.Minute)) {
+		t.Fatal("expected the entry to expire")
+	}
+	if len(cache.seen) != 1 {
+		t.Fatal("expected expired entries to be removed")
+	}
+}
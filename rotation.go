@@ -0,0 +1,101 @@
+package noiseconn
+
+import (
+	"sync"
+	"time"
+
+	"github.com/flynn/noise"
+	"github.com/zeebo/errs"
+)
+
+// StaticKeys holds a static keypair that can be replaced at runtime, such
+// as by a Listener whose key is rotated, along with the keys it replaced
+// during their grace period. Connections pick the keys up when they are
+// created, so rotating doesn't affect established connections. It may be
+// used concurrently.
+type StaticKeys struct {
+	mu       sync.Mutex
+	current  noise.DHKey
+	previous []previousKey
+}
+
+type previousKey struct {
+	key   noise.DHKey
+	until time.Time
+}
+
+// NewStaticKeys returns StaticKeys using key.
+func NewStaticKeys(key noise.DHKey) *StaticKeys {
+	return &StaticKeys{current: key}
+}
+
+// Rotate makes key the current keypair. The replaced keypair is still
+// accepted by responders for grace, so initiators that only know it can
+// connect until they learned about the new one, for example with
+// Options.NextStatic.
+func (k *StaticKeys) Rotate(key noise.DHKey, grace time.Duration) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now := time.Now()
+	k.prune(now)
+	if grace > 0 {
+		k.previous = append(k.previous, previousKey{key: k.current, until: now.Add(grace)})
+	}
+	k.current = key
+}
+
+// Current returns the current keypair.
+func (k *StaticKeys) Current() noise.DHKey {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.current
+}
+
+// keys returns the current keypair followed by the previous ones still in
+// their grace period, most recent first.
+func (k *StaticKeys) keys(now time.Time) []noise.DHKey {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.prune(now)
+	keys := []noise.DHKey{k.current}
+	for i := len(k.previous) - 1; i >= 0; i-- {
+		keys = append(keys, k.previous[i].key)
+	}
+	return keys
+}
+
+func (k *StaticKeys) prune(now time.Time) {
+	live := k.previous[:0]
+	for _, p := range k.previous {
+		if now.Before(p.until) {
+			live = append(live, p)
+		}
+	}
+	k.previous = live
+}
+
+// retryHandshake replaces the handshake state with the next one to try,
+// if any, after the first handshake message failed to be read: those with
+// the previous static keys of Options.StaticKeys, then the one with
+// Options.FallbackPattern. c.hsMu must be held.
+func (c *Conn) retryHandshake() (bool, error) {
+	if len(c.retryConfigs) == 0 {
+		return false, nil
+	}
+	config := c.retryConfigs[0]
+	c.retryConfigs = c.retryConfigs[1:]
+	hs, err := noise.NewHandshakeState(config)
+	if err != nil {
+		return false, errs.Wrap(err)
+	}
+	c.hs = hs
+	c.protocol = protocolName(config)
+	c.peerPreStatic = preMessageStatic(config.Pattern, !config.Initiator)
+	c.identityMsg = identityMessage(config.Pattern, config.Initiator)
+	c.peerIdentityMsg = identityMessage(config.Pattern, !config.Initiator)
+	if c.transcript != nil {
+		c.transcript = newTranscript(config)
+	}
+	c.log(LogDebug, "retrying the handshake", "protocol", c.protocol)
+	return true, nil
+}
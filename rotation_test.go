@@ -0,0 +1,82 @@
+package noiseconn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestStaticKeysRotation(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	var keys []noise.DHKey
+	for i := 0; i < 4; i++ {
+		key, err := cs.GenerateKeypair(rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+		keys = append(keys, key)
+	}
+	clientKey, serverKeys := keys[0], NewStaticKeys(keys[1])
+
+	handshake := func(pattern noise.HandshakePattern, peerStatic []byte) (*Conn, error) {
+		p1, p2 := net.Pipe()
+		client, err := NewConn(p1, noise.Config{
+			CipherSuite: cs, Pattern: pattern, Initiator: true,
+			StaticKeypair: clientKey, PeerStatic: peerStatic,
+		})
+		if err != nil {
+			panic(err)
+		}
+		server, err := NewConnWithOptions(p2, noise.Config{
+			CipherSuite: cs, Pattern: pattern,
+		}, Options{StaticKeys: serverKeys})
+		if err != nil {
+			panic(err)
+		}
+		var eg errgroup.Group
+		eg.Go(func() error {
+			err := client.Handshake()
+			_ = client.Close()
+			return err
+		})
+		eg.Go(func() error {
+			err := server.Handshake()
+			_ = server.Close()
+			return err
+		})
+		return client, eg.Wait()
+	}
+
+	serverKeys.Rotate(keys[2], time.Hour)
+	for _, key := range []noise.DHKey{keys[1], keys[2]} {
+		if _, err := handshake(noise.HandshakeIK, key.Public); err != nil {
+			t.Fatalf("expected key %x to be accepted: %v", key.Public, err)
+		}
+	}
+
+	// keys replaced without a grace period are rejected, while earlier ones
+	// are still in their grace period.
+	serverKeys.Rotate(keys[3], 0)
+	if _, err := handshake(noise.HandshakeIK, keys[2].Public); err == nil {
+		t.Fatal("expected the replaced key to be rejected")
+	}
+	for _, key := range []noise.DHKey{keys[1], keys[3]} {
+		if _, err := handshake(noise.HandshakeIK, key.Public); err != nil {
+			t.Fatalf("expected key %x to be accepted: %v", key.Public, err)
+		}
+	}
+
+	// responders that send their static key use the current one.
+	client, err := handshake(noise.HandshakeXX, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(client.PeerStatic(), keys[3].Public) {
+		t.Fatal("expected the current key")
+	}
+}
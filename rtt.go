@@ -0,0 +1,147 @@
+package noiseconn
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+// rtt is the round-trip time estimate behind Conn.RTT. A ping control frame
+// carries when it was sent, relative to c.created, and the peer echoes it
+// in a pong control frame. The samples are smoothed like the ones of TCP
+// (RFC 6298).
+type rtt struct {
+	// ponging is set while a pong is being sent.
+	ponging uint32
+	// lastPing is when Options.PingInterval last sent a ping, in Unix
+	// nanoseconds.
+	lastPing atomic.Int64
+
+	mu      sync.Mutex
+	srtt    time.Duration
+	samples int
+	// outstanding is the payload of the last ping Options.PingInterval
+	// sent, while it wasn't answered.
+	outstanding    time.Duration
+	hasOutstanding bool
+	// waiters are the Ping calls waiting for pongs, by when their ping was
+	// sent.
+	waiters map[time.Duration]chan time.Duration
+}
+
+// sendPing sends a ping control frame, unless a write is in progress, in
+// which case the next tick tries again.
+func (c *Conn) sendPing() {
+	if !c.writeMu.TryLock() {
+		return
+	}
+	defer c.writeMu.Unlock()
+	sent := time.Since(c.created)
+	payload := binary.BigEndian.AppendUint64(nil, uint64(sent))
+	buf, err := c.appendControl(c.writeMsgBuf[:0], controlPing, payload)
+	if err != nil {
+		return
+	}
+	c.writeMsgBuf = buf
+	c.rtt.lastPing.Store(time.Now().UnixNano())
+	c.rtt.mu.Lock()
+	c.rtt.outstanding, c.rtt.hasOutstanding = sent, true
+	c.rtt.mu.Unlock()
+	if err := c.writeFrames(buf); err != nil {
+		c.log(LogDebug, "sending ping failed", "error", err)
+	}
+}
+
+// readPing answers a ping. The pong is sent in the background, so reads
+// don't wait for writes, and pings received meanwhile aren't answered.
+func (c *Conn) readPing(payload []byte) {
+	if !atomic.CompareAndSwapUint32(&c.rtt.ponging, 0, 1) {
+		return
+	}
+	payload = append([]byte{}, payload...)
+	go func() {
+		defer atomic.StoreUint32(&c.rtt.ponging, 0)
+		if err := c.writeControl(controlPong, payload); err != nil {
+			c.log(LogDebug, "sending pong failed", "error", err)
+		}
+	}()
+}
+
+// readPong adds the round-trip time of the ping answered by a pong to the
+// estimate. Pongs that don't answer an outstanding ping, such as late
+// answers to a ping that was followed by another one, are ignored.
+func (c *Conn) readPong(payload []byte) error {
+	if len(payload) != 8 {
+		return errs.New("malformed pong")
+	}
+	sent := time.Duration(binary.BigEndian.Uint64(payload))
+	sample := time.Since(c.created) - sent
+	if sent < 0 || sample < 0 {
+		return errs.New("pong for a ping that wasn't sent")
+	}
+	r := &c.rtt
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w, waiting := r.waiters[sent]
+	if waiting {
+		delete(r.waiters, sent)
+	} else if r.hasOutstanding && r.outstanding == sent {
+		r.hasOutstanding = false
+	} else {
+		return nil
+	}
+	if r.samples == 0 {
+		r.srtt = sample
+	} else {
+		r.srtt += (sample - r.srtt) / 8
+	}
+	r.samples++
+	if waiting {
+		w <- sample
+	}
+	return nil
+}
+
+// RTT returns the smoothed round-trip time to the peer, or zero until it
+// was measured by Ping or Options.PingInterval.
+func (c *Conn) RTT() time.Duration {
+	c.rtt.mu.Lock()
+	defer c.rtt.mu.Unlock()
+	return c.rtt.srtt
+}
+
+// Ping sends a ping control frame and returns the round-trip time until the
+// peer answered it, which is also added to the RTT estimate. The answer is
+// received by reads, so it only arrives while the connection is read. The
+// peer must support control frames, and peers that don't know about pings
+// never answer them.
+func (c *Conn) Ping(ctx context.Context) (time.Duration, error) {
+	r := &c.rtt
+	w := make(chan time.Duration, 1)
+	r.mu.Lock()
+	sent := time.Since(c.created)
+	if r.waiters == nil {
+		r.waiters = make(map[time.Duration]chan time.Duration)
+	}
+	r.waiters[sent] = w
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.waiters, sent)
+		r.mu.Unlock()
+	}()
+
+	if err := c.writeControl(controlPing, binary.BigEndian.AppendUint64(nil, uint64(sent))); err != nil {
+		return 0, err
+	}
+	select {
+	case sample := <-w:
+		return sample, nil
+	case <-ctx.Done():
+		return 0, errs.Wrap(ctx.Err())
+	}
+}
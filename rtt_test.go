@@ -0,0 +1,69 @@
+package noiseconn
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestRTT(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	c1, c2 := tcpPair()
+	client, err := NewConn(c1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true},
+		WithPingInterval(20*time.Millisecond))
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	server, err := NewConn(c2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN},
+		WithHandshakeExtensions())
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+	var eg errgroup.Group
+	eg.Go(client.Handshake)
+	eg.Go(server.Handshake)
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	if client.RTT() != 0 || server.RTT() != 0 {
+		t.Fatal("RTT measured without pings")
+	}
+
+	// pongs for pings that weren't sent are ignored.
+	if err := server.readPong(binary.BigEndian.AppendUint64(nil, 1)); err != nil {
+		panic(err)
+	}
+	if server.RTT() != 0 {
+		t.Fatal("RTT measured from an unsolicited pong")
+	}
+
+	// pongs are received by reads.
+	go func() { _, _ = io.Copy(io.Discard, client) }()
+	go func() { _, _ = io.Copy(io.Discard, server) }()
+
+	// the client pings on its own.
+	deadline := time.Now().Add(2 * time.Second)
+	for client.RTT() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("PingInterval didn't measure the RTT")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	sample, err := server.Ping(ctx)
+	if err != nil {
+		panic(err)
+	}
+	if sample <= 0 || server.RTT() != sample {
+		t.Fatalf("unexpected RTT: sample %v, estimate %v", sample, server.RTT())
+	}
+}
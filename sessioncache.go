@@ -0,0 +1,76 @@
+package noiseconn
+
+import (
+	"sync"
+	"time"
+)
+
+// SessionCache remembers the static public keys of responders by address,
+// for Dialer.SessionCache. It must be safe for concurrent use.
+type SessionCache interface {
+	// Get returns the static key remembered for address, if any.
+	Get(address string) (peerStatic []byte, ok bool)
+	// Put remembers peerStatic as the static key of address.
+	Put(address string, peerStatic []byte)
+	// Delete forgets the static key of address.
+	Delete(address string)
+}
+
+// MemorySessionCache is an in-memory SessionCache, whose keys expire after
+// a TTL.
+type MemorySessionCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	keys    map[string]cachedStatic
+	nextGC  time.Time
+	timeNow func() time.Time
+}
+
+type cachedStatic struct {
+	key []byte
+	exp time.Time
+}
+
+// NewMemorySessionCache returns a MemorySessionCache whose keys expire
+// after ttl. If ttl is zero, keys don't expire.
+func NewMemorySessionCache(ttl time.Duration) *MemorySessionCache {
+	return &MemorySessionCache{ttl: ttl, keys: make(map[string]cachedStatic), timeNow: time.Now}
+}
+
+// Get implements SessionCache.
+func (s *MemorySessionCache) Get(address string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cached, ok := s.keys[address]
+	if !ok || s.expired(cached, s.timeNow()) {
+		return nil, false
+	}
+	return append([]byte(nil), cached.key...), true
+}
+
+// Put implements SessionCache.
+func (s *MemorySessionCache) Put(address string, peerStatic []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.timeNow()
+	if now.After(s.nextGC) {
+		for k, cached := range s.keys {
+			if s.expired(cached, now) {
+				delete(s.keys, k)
+			}
+		}
+		s.nextGC = now.Add(time.Minute)
+	}
+	s.keys[address] = cachedStatic{key: append([]byte(nil), peerStatic...), exp: now.Add(s.ttl)}
+}
+
+// Delete implements SessionCache.
+func (s *MemorySessionCache) Delete(address string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, address)
+}
+
+func (s *MemorySessionCache) expired(cached cachedStatic, now time.Time) bool {
+	return s.ttl > 0 && now.After(cached.exp)
+}
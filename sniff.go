@@ -0,0 +1,102 @@
+package noiseconn
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+// isNoiseHeader returns whether b can be the first byte sent by an
+// initiator: the frame header of the first handshake message or of the
+// static key hint.
+func isNoiseHeader(b byte) bool {
+	return b == HeaderByte || b == hintHeaderByte
+}
+
+// peekedConn returns bytes that were already read from the net.Conn before
+// reading from it again.
+type peekedConn struct {
+	net.Conn
+	peeked []byte
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) {
+	if len(c.peeked) > 0 {
+		n := copy(b, c.peeked)
+		c.peeked = c.peeked[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+// sniff reads the first n bytes of conn, interrupting the read if ctx is
+// done. The bytes are returned along with a net.Conn that returns them
+// again.
+func sniff(ctx context.Context, conn net.Conn, n int) (_ []byte, _ net.Conn, err error) {
+	deadline, reset := ctx.Deadline()
+	if reset {
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return nil, nil, errs.Wrap(err)
+		}
+	}
+	done := make(chan struct{})
+	interrupted := make(chan struct{})
+	go func() {
+		defer close(interrupted)
+		select {
+		case <-ctx.Done():
+			_ = conn.SetReadDeadline(time.Unix(1, 0))
+			reset = true
+		case <-done:
+		}
+	}()
+	defer func() {
+		close(done)
+		<-interrupted
+		// the deadline set by the interrupt is cleared as well, since conn
+		// is passed on.
+		if reset {
+			_ = conn.SetReadDeadline(time.Time{})
+		}
+		if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+			err = errs.Wrap(ctxErr)
+		}
+	}()
+	peeked := make([]byte, n)
+	if _, err := io.ReadFull(conn, peeked); err != nil {
+		return nil, nil, errs.Wrap(err)
+	}
+	return peeked, &peekedConn{Conn: conn, peeked: peeked}, nil
+}
+
+// tlsRecordHandshake is the first byte of a TLS handshake record, which
+// starts every TLS connection.
+const tlsRecordHandshake = 0x16
+
+// dispatch sniffs the first byte of conn and passes it to l.TLS or
+// l.Fallback if it isn't a Noise connection. Otherwise, it returns conn with
+// the byte preserved.
+func (l *Listener) dispatch(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	peeked, sniffed, err := sniff(ctx, conn, 1)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case isNoiseHeader(peeked[0]):
+		return sniffed, nil
+	case peeked[0] == tlsRecordHandshake && l.TLS != nil:
+		l.log(LogDebug, "connection passed to TLS", "remote", sniffed.RemoteAddr())
+		go l.TLS(tls.Server(sniffed, l.TLSConfig))
+		return nil, nil
+	case l.Fallback != nil:
+		l.log(LogDebug, "connection passed to fallback", "remote", sniffed.RemoteAddr())
+		go l.Fallback(sniffed)
+		return nil, nil
+	}
+	// the handshake fails on its own.
+	return sniffed, nil
+}
@@ -0,0 +1,52 @@
+package noiseconn
+
+import (
+	"bytes"
+	"crypto/rand"
+
+	"github.com/flynn/noise"
+	"github.com/zeebo/errs"
+)
+
+// StaticKey is a static keypair whose private key may be held outside of
+// the process, such as in an HSM, a TPM or a cloud KMS. See
+// Options.StaticKey.
+type StaticKey interface {
+	// Public returns the public key.
+	Public() []byte
+
+	// DH performs a Diffie-Hellman operation between the private key and
+	// peerPublic. The result must be what the DH function of the cipher
+	// suite would return for the private key.
+	DH(peerPublic []byte) ([]byte, error)
+}
+
+// staticKeySuite is a noise.CipherSuite that routes the DH operations with
+// the static private key to a StaticKey. The static keypair of the
+// handshake has a random handle instead of the private key, which is
+// recognized by DH.
+type staticKeySuite struct {
+	noise.CipherSuite
+	key    StaticKey
+	handle []byte
+}
+
+func newStaticKeySuite(cs noise.CipherSuite, key StaticKey) (*staticKeySuite, noise.DHKey, error) {
+	handle := make([]byte, cs.DHLen())
+	if _, err := rand.Read(handle); err != nil {
+		return nil, noise.DHKey{}, errs.Wrap(err)
+	}
+	public := key.Public()
+	if len(public) != cs.DHLen() {
+		return nil, noise.DHKey{}, errs.New("invalid static public key length %d", len(public))
+	}
+	suite := &staticKeySuite{CipherSuite: cs, key: key, handle: handle}
+	return suite, noise.DHKey{Private: handle, Public: public}, nil
+}
+
+func (s *staticKeySuite) DH(privkey, pubkey []byte) ([]byte, error) {
+	if bytes.Equal(privkey, s.handle) {
+		return s.key.DH(pubkey)
+	}
+	return s.CipherSuite.DH(privkey, pubkey)
+}
@@ -0,0 +1,78 @@
+package noiseconn
+
+import (
+	"crypto/rand"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+// testStaticKey is a StaticKey that counts its DH operations.
+type testStaticKey struct {
+	key   noise.DHKey
+	calls int32
+}
+
+func (k *testStaticKey) Public() []byte { return k.key.Public }
+
+func (k *testStaticKey) DH(peerPublic []byte) ([]byte, error) {
+	atomic.AddInt32(&k.calls, 1)
+	return noise.DH25519.DH(k.key.Private, peerPublic)
+}
+
+func TestStaticKey(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	newKey := func() *testStaticKey {
+		key, err := cs.GenerateKeypair(rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+		return &testStaticKey{key: key}
+	}
+	clientKey, serverKey := newKey(), newKey()
+
+	p1, p2 := net.Pipe()
+	client, err := NewConnWithOptions(p1, noise.Config{
+		CipherSuite: cs, Pattern: noise.HandshakeIK, Initiator: true, PeerStatic: serverKey.Public(),
+	}, Options{StaticKey: clientKey})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	server, err := NewConnWithOptions(p2, noise.Config{
+		CipherSuite: cs, Pattern: noise.HandshakeIK,
+	}, Options{StaticKey: serverKey, VerifyPeer: PinPeers(clientKey.Public())})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		if _, err := client.Write([]byte("hello")); err != nil {
+			return err
+		}
+		_, err := io.ReadFull(client, make([]byte, 5))
+		return err
+	})
+	eg.Go(func() error {
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(server, buf); err != nil {
+			return err
+		}
+		_, err := server.Write(buf)
+		return err
+	})
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+	// IK uses the initiator static key in ss and se, and the responder
+	// static key in es and ss.
+	if clientKey.calls != 2 || serverKey.calls != 2 {
+		t.Fatalf("unexpected DH calls %d, %d", clientKey.calls, serverKey.calls)
+	}
+}
@@ -0,0 +1,101 @@
+package noiseconn
+
+import (
+	"time"
+)
+
+// HandshakeStats are the timings of a handshake, to tell apart the time
+// spent establishing the underlying connection, in the Noise handshake and
+// in the application.
+type HandshakeStats struct {
+	// Created is when the Conn was created, typically right after the
+	// underlying connection was established.
+	Created time.Time
+	// Start is when the first handshake message was sent or received, and
+	// is zero until then.
+	Start time.Time
+	// Finish is when the handshake completed, and is zero until then.
+	Finish time.Time
+	// Messages are the handshake messages sent and received so far.
+	Messages []HandshakeMessageStats
+}
+
+// Duration returns how long the handshake took, from its first message
+// until it completed. It returns zero if the handshake isn't complete.
+func (s HandshakeStats) Duration() time.Duration {
+	if s.Start.IsZero() || s.Finish.IsZero() {
+		return 0
+	}
+	return s.Finish.Sub(s.Start)
+}
+
+// HandshakeMessageStats describes a single handshake message.
+type HandshakeMessageStats struct {
+	Sent bool
+	// Size is the size of the message, without the stream framing.
+	Size int
+	// Time is when the message was written or received.
+	Time time.Time
+}
+
+// ConnectionState describes a Conn, like tls.ConnectionState.
+type ConnectionState struct {
+	// Protocol is the full Noise protocol name, such as
+	// Noise_XX_25519_ChaChaPoly_BLAKE2b.
+	Protocol  string
+	Initiator bool
+
+	HandshakeComplete bool
+	// HandshakeHash is nil until the handshake is complete.
+	HandshakeHash []byte
+	PeerStatic    []byte
+	PeerIdentity  *Certificate
+	PostQuantum   bool
+	// EarlyDataRead is whether 0-RTT data was read, as reported by
+	// Conn.EarlyDataRead.
+	EarlyDataRead bool
+	// FIPS is whether the Conn is restricted to approved primitives by
+	// Options.FIPS.
+	FIPS bool
+
+	Stats HandshakeStats
+}
+
+// Stats returns the timings of the handshake so far.
+func (c *Conn) Stats() HandshakeStats {
+	c.hsMu.Lock()
+	defer c.hsMu.Unlock()
+	return c.stats()
+}
+
+// stats returns the timings of the handshake so far. c.hsMu must be held.
+func (c *Conn) stats() HandshakeStats {
+	return HandshakeStats{
+		Created:  c.created,
+		Start:    c.hsStart,
+		Finish:   c.hsFinish,
+		Messages: append([]HandshakeMessageStats(nil), c.hsMessages...),
+	}
+}
+
+// ConnectionState returns the state of the connection.
+func (c *Conn) ConnectionState() ConnectionState {
+	c.hsMu.Lock()
+	defer c.hsMu.Unlock()
+	peerStatic := c.peerStatic
+	if c.hs != nil && c.hs.MessageIndex() > 0 {
+		peerStatic = c.hs.PeerStatic()
+	}
+	return ConnectionState{
+		Protocol:          c.protocol,
+		Initiator:         c.initiator,
+		HandshakeComplete: c.hs == nil,
+		HandshakeHash:     c.hh,
+		PeerStatic:        peerStatic,
+		PeerIdentity:      c.peerIdentity,
+		PostQuantum:       c.postQuantum,
+		EarlyDataRead:     c.EarlyDataRead(),
+		FIPS:              c.fips,
+		Stats:             c.stats(),
+	}
+}
@@ -0,0 +1,68 @@
+package noiseconn
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestConnectionState(t *testing.T) {
+	p1, p2 := net.Pipe()
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	client, err := NewConn(p1, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	server, err := NewConn(p2, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+
+	if state := client.ConnectionState(); state.HandshakeComplete || !state.Stats.Start.IsZero() || state.Stats.Created.IsZero() {
+		t.Fatalf("unexpected state before the handshake %+v", state)
+	}
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		if _, err := client.Write([]byte("hello")); err != nil {
+			return err
+		}
+		_, err := io.ReadFull(client, make([]byte, 5))
+		return err
+	})
+	eg.Go(func() error {
+		if _, err := io.ReadFull(server, make([]byte, 5)); err != nil {
+			return err
+		}
+		_, err := server.Write([]byte("world"))
+		return err
+	})
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+
+	state := client.ConnectionState()
+	if state.Protocol != "Noise_NN_25519_ChaChaPoly_BLAKE2b" || !state.Initiator || !state.HandshakeComplete {
+		t.Fatalf("unexpected state %+v", state)
+	}
+	if !bytes.Equal(state.HandshakeHash, server.HandshakeHash()) {
+		t.Fatal("handshake hash mismatch")
+	}
+	stats := state.Stats
+	if len(stats.Messages) != 2 || !stats.Messages[0].Sent || stats.Messages[1].Sent {
+		t.Fatalf("unexpected messages %+v", stats.Messages)
+	}
+	if stats.Start.Before(stats.Created) || stats.Messages[1].Time.Before(stats.Messages[0].Time) ||
+		stats.Finish.Before(stats.Messages[1].Time) || stats.Duration() <= 0 {
+		t.Fatalf("unexpected timings %+v", stats)
+	}
+	if server.Stats().Messages[0].Size != stats.Messages[0].Size {
+		t.Fatal("message size mismatch")
+	}
+}
@@ -0,0 +1,132 @@
+package noiseconn
+
+import (
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/flynn/noise"
+	"github.com/zeebo/errs"
+)
+
+// NewStreamConn is like NewConn, but runs Noise over any reliable, ordered
+// byte stream, such as a pipe, a serial link or a custom tunnel. The
+// framing is the same as with NewConn.
+//
+// If rwc implements the address and deadline methods of net.Conn, they are
+// used. Otherwise, LocalAddr and RemoteAddr return a StreamAddr, and the
+// deadline methods fail with an error wrapping os.ErrNoDeadline, so
+// HandshakeContext can't be used with a context with a deadline.
+func NewStreamConn(rwc io.ReadWriteCloser, config noise.Config, opts ...Option) (*Conn, error) {
+	return NewStreamConnWithOptions(rwc, config, applyOptions(opts))
+}
+
+// NewStreamConnWithOptions is like NewStreamConn with options.
+func NewStreamConnWithOptions(rwc io.ReadWriteCloser, config noise.Config, opts Options) (*Conn, error) {
+	if conn, ok := rwc.(net.Conn); ok {
+		return NewConnWithOptions(conn, config, opts)
+	}
+	return NewConnWithOptions(&streamConn{ReadWriteCloser: rwc}, config, opts)
+}
+
+// StreamAddr is the address of a stream without network addresses.
+type StreamAddr struct{}
+
+// Network implements net.Addr.
+func (StreamAddr) Network() string { return "stream" }
+
+// String implements net.Addr.
+func (StreamAddr) String() string { return "stream" }
+
+// streamConn adapts an io.ReadWriteCloser to a net.Conn.
+type streamConn struct {
+	io.ReadWriteCloser
+}
+
+func (s *streamConn) LocalAddr() net.Addr {
+	if a, ok := s.ReadWriteCloser.(interface{ LocalAddr() net.Addr }); ok {
+		return a.LocalAddr()
+	}
+	return StreamAddr{}
+}
+
+func (s *streamConn) RemoteAddr() net.Addr {
+	if a, ok := s.ReadWriteCloser.(interface{ RemoteAddr() net.Addr }); ok {
+		return a.RemoteAddr()
+	}
+	return StreamAddr{}
+}
+
+func (s *streamConn) SetDeadline(t time.Time) error {
+	if d, ok := s.ReadWriteCloser.(interface{ SetDeadline(time.Time) error }); ok {
+		return d.SetDeadline(t)
+	}
+	return errs.Wrap(os.ErrNoDeadline)
+}
+
+func (s *streamConn) SetReadDeadline(t time.Time) error {
+	if d, ok := s.ReadWriteCloser.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return d.SetReadDeadline(t)
+	}
+	return errs.Wrap(os.ErrNoDeadline)
+}
+
+func (s *streamConn) SetWriteDeadline(t time.Time) error {
+	if d, ok := s.ReadWriteCloser.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return errs.Wrap(os.ErrNoDeadline)
+}
+
+// NewSplitConn is like NewStreamConn, but runs Noise over a separate reader
+// and writer, such as the stdout and stdin of a subprocess, or two
+// unidirectional streams. Closing the Conn closes w and then r, if they
+// implement io.Closer. The read and write deadlines are set on r and w,
+// respectively, if they support them.
+func NewSplitConn(r io.Reader, w io.Writer, config noise.Config, opts ...Option) (*Conn, error) {
+	return NewSplitConnWithOptions(r, w, config, applyOptions(opts))
+}
+
+// NewSplitConnWithOptions is like NewSplitConn with options.
+func NewSplitConnWithOptions(r io.Reader, w io.Writer, config noise.Config, opts Options) (*Conn, error) {
+	return NewStreamConnWithOptions(&splitStream{r: r, w: w}, config, opts)
+}
+
+// splitStream joins a reader and a writer into an io.ReadWriteCloser.
+type splitStream struct {
+	r io.Reader
+	w io.Writer
+}
+
+func (s *splitStream) Read(p []byte) (int, error)  { return s.r.Read(p) }
+func (s *splitStream) Write(p []byte) (int, error) { return s.w.Write(p) }
+
+func (s *splitStream) Close() error {
+	var group errs.Group
+	if c, ok := s.w.(io.Closer); ok {
+		group.Add(c.Close())
+	}
+	if c, ok := s.r.(io.Closer); ok {
+		group.Add(c.Close())
+	}
+	return group.Err()
+}
+
+func (s *splitStream) SetDeadline(t time.Time) error {
+	return errs.Combine(s.SetReadDeadline(t), s.SetWriteDeadline(t))
+}
+
+func (s *splitStream) SetReadDeadline(t time.Time) error {
+	if d, ok := s.r.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return d.SetReadDeadline(t)
+	}
+	return errs.Wrap(os.ErrNoDeadline)
+}
+
+func (s *splitStream) SetWriteDeadline(t time.Time) error {
+	if d, ok := s.w.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return errs.Wrap(os.ErrNoDeadline)
+}
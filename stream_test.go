@@ -0,0 +1,140 @@
+package noiseconn
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+// pipeRWC is a bidirectional stream made of two io.Pipes, without any
+// net.Conn methods.
+type pipeRWC struct {
+	*io.PipeReader
+	*io.PipeWriter
+}
+
+func (p pipeRWC) Close() error {
+	_ = p.PipeReader.Close()
+	return p.PipeWriter.Close()
+}
+
+func TestStreamConn(t *testing.T) {
+	r1, w1 := io.Pipe()
+	r2, w2 := io.Pipe()
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	client, err := NewStreamConn(pipeRWC{r1, w2}, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, Initiator: true})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	server, err := NewStreamConn(pipeRWC{r2, w1}, noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+
+	if client.RemoteAddr() != (StreamAddr{}) {
+		t.Fatal("unexpected address", client.RemoteAddr())
+	}
+	if err := client.SetDeadline(time.Now()); !errors.Is(err, os.ErrNoDeadline) {
+		t.Fatal("expected deadlines to be unsupported, got", err)
+	}
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		if _, err := client.Write([]byte("hello")); err != nil {
+			return err
+		}
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(client, buf); err != nil {
+			return err
+		}
+		if string(buf) != "world" {
+			t.Error("unexpected data")
+		}
+		return nil
+	})
+	eg.Go(func() error {
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(server, buf); err != nil {
+			return err
+		}
+		if string(buf) != "hello" {
+			t.Error("unexpected data")
+		}
+		_, err := server.Write([]byte("world"))
+		return err
+	})
+	if err := eg.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSplitConn(t *testing.T) {
+	r1, w1, err := os.Pipe()
+	if err != nil {
+		panic(err)
+	}
+	r2, w2, err := os.Pipe()
+	if err != nil {
+		panic(err)
+	}
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	clientKey, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	serverKey, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	client, err := NewSplitConn(r1, w2, noise.Config{
+		CipherSuite: cs, Pattern: noise.HandshakeXX, Initiator: true, StaticKeypair: clientKey,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = client.Close() }()
+	server, err := NewSplitConn(r2, w1, noise.Config{
+		CipherSuite: cs, Pattern: noise.HandshakeXX, StaticKeypair: serverKey,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Close() }()
+
+	// os.Pipe supports deadlines.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var eg errgroup.Group
+	eg.Go(func() error { return client.HandshakeContext(ctx) })
+	eg.Go(func() error { return server.HandshakeContext(ctx) })
+	if err := eg.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(client.PeerStatic(), serverKey.Public) || !bytes.Equal(server.PeerStatic(), clientKey.Public) {
+		t.Fatal("unexpected peer static keys")
+	}
+
+	// closing the client closes both of its files, so the server sees EOF.
+	go func() {
+		if _, err := client.Write([]byte("bye")); err == nil {
+			_ = client.Close()
+		}
+	}()
+	buf, err := io.ReadAll(server)
+	if string(buf) != "bye" {
+		t.Fatalf("unexpected data %q: %v", buf, err)
+	}
+	if _, err := r1.Read(make([]byte, 1)); !errors.Is(err, os.ErrClosed) {
+		t.Fatal("expected the reader to be closed, got", err)
+	}
+}
@@ -0,0 +1,88 @@
+package noiseconn
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/flynn/noise"
+)
+
+// HandshakeTranscript is a record of the messages exchanged during a
+// handshake, for interop analysis and bug reports. It marshals to JSON,
+// with byte slices base64 encoded.
+type HandshakeTranscript struct {
+	// Protocol is the full Noise protocol name, such as
+	// Noise_XX_25519_ChaChaPoly_BLAKE2b.
+	Protocol  string `json:"protocol"`
+	Initiator bool   `json:"initiator"`
+	Prologue  []byte `json:"prologue,omitempty"`
+
+	LocalStatic  []byte `json:"local_static,omitempty"`
+	RemoteStatic []byte `json:"remote_static,omitempty"`
+
+	Messages []TranscriptMessage `json:"messages"`
+
+	// HandshakeHash is the handshake hash of a completed handshake.
+	HandshakeHash []byte `json:"handshake_hash,omitempty"`
+	// Error describes why the handshake failed, if it did.
+	Error string `json:"error,omitempty"`
+}
+
+// TranscriptMessage is a single handshake message, exactly as it was sent
+// or received, without the stream framing.
+type TranscriptMessage struct {
+	Sent bool      `json:"sent"`
+	Time time.Time `json:"time"`
+	Data []byte    `json:"data"`
+}
+
+// protocolName returns the full Noise protocol name of config.
+func protocolName(config noise.Config) string {
+	pskModifier := ""
+	if len(config.PresharedKey) > 0 {
+		pskModifier = "psk" + strconv.Itoa(config.PresharedKeyPlacement)
+	}
+	return "Noise_" + config.Pattern.Name + pskModifier + "_" + string(config.CipherSuite.Name())
+}
+
+func newTranscript(config noise.Config) *HandshakeTranscript {
+	return &HandshakeTranscript{
+		Protocol:    protocolName(config),
+		Initiator:   config.Initiator,
+		Prologue:    append([]byte(nil), config.Prologue...),
+		LocalStatic: append([]byte(nil), config.StaticKeypair.Public...),
+	}
+}
+
+// transcribe records a handshake message, if a transcript was requested.
+func (c *Conn) transcribe(sent bool, msg []byte) {
+	if c.transcript == nil {
+		return
+	}
+	c.transcript.Messages = append(c.transcript.Messages, TranscriptMessage{
+		Sent: sent,
+		Time: time.Now(),
+		Data: append([]byte(nil), msg...),
+	})
+}
+
+// finishTranscript hands the transcript to the callback once the handshake
+// completed or failed.
+func (c *Conn) finishTranscript(err error) {
+	t := c.transcript
+	if t == nil {
+		return
+	}
+	c.transcript = nil
+	if err != nil {
+		t.Error = err.Error()
+	} else {
+		t.HandshakeHash = c.hh
+	}
+	if c.hs != nil {
+		t.RemoteStatic = c.hs.PeerStatic()
+	} else {
+		t.RemoteStatic = c.peerStatic
+	}
+	c.onTranscript(t)
+}
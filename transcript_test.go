@@ -0,0 +1,75 @@
+package noiseconn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestTranscript(t *testing.T) {
+	clientKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	serverKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+
+	p1, p2 := net.Pipe()
+	var ct, st *HandshakeTranscript
+	client, err := NewConnWithOptions(p1, noise.Config{
+		CipherSuite:   cs,
+		Pattern:       noise.HandshakeXX,
+		Initiator:     true,
+		StaticKeypair: clientKey,
+	}, Options{Transcript: func(t *HandshakeTranscript) { ct = t }})
+	if err != nil {
+		panic(err)
+	}
+	defer client.Close()
+	server, err := NewConnWithOptions(p2, noise.Config{
+		CipherSuite:   cs,
+		Pattern:       noise.HandshakeXX,
+		StaticKeypair: serverKey,
+	}, Options{Transcript: func(t *HandshakeTranscript) { st = t }})
+	if err != nil {
+		panic(err)
+	}
+	defer server.Close()
+
+	var eg errgroup.Group
+	eg.Go(client.Handshake)
+	eg.Go(server.Handshake)
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+
+	if ct == nil || st == nil {
+		t.Fatal("missing transcript")
+	}
+	if ct.Protocol != "Noise_XX_25519_ChaChaPoly_BLAKE2b" || ct.Error != "" || st.Error != "" {
+		t.Fatalf("unexpected transcript %+v", ct)
+	}
+	if len(ct.Messages) != 3 || len(st.Messages) != 3 {
+		t.Fatalf("expected 3 messages, got %d and %d", len(ct.Messages), len(st.Messages))
+	}
+	for i := range ct.Messages {
+		if ct.Messages[i].Sent == st.Messages[i].Sent || !bytes.Equal(ct.Messages[i].Data, st.Messages[i].Data) {
+			t.Fatalf("message %d differs", i)
+		}
+	}
+	if !bytes.Equal(ct.HandshakeHash, st.HandshakeHash) || !bytes.Equal(ct.RemoteStatic, serverKey.Public) ||
+		!bytes.Equal(st.RemoteStatic, clientKey.Public) {
+		t.Fatal("transcript mismatch")
+	}
+	if _, err := json.Marshal(ct); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,185 @@
+package noiseconn
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/flynn/noise"
+)
+
+// ErrInvalidConfig is returned when a noise.Config or Options can't work,
+// such as when the pattern needs a key that isn't set.
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// ValidateConfig checks that config and opts can be used together, and
+// returns an error wrapping ErrInvalidConfig describing the first problem
+// otherwise. NewConnWithOptions calls it, so misconfigurations are
+// reported before any handshake messages are exchanged.
+func ValidateConfig(config noise.Config, opts Options) error {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: "+format, append([]interface{}{ErrInvalidConfig}, args...)...)
+	}
+	if config.CipherSuite == nil {
+		return invalid("CipherSuite is not set")
+	}
+	pattern := config.Pattern
+	if len(pattern.Messages) == 0 {
+		return invalid("Pattern is not set")
+	}
+	dhLen := config.CipherSuite.DHLen()
+
+	hasStatic := identityMessage(pattern, config.Initiator) >= 0
+	switch {
+	case !hasStatic:
+	case opts.StaticKey != nil, opts.StaticKeys != nil, opts.SelectStatic != nil && !config.Initiator:
+	case len(config.StaticKeypair.Private) == 0:
+		return invalid("pattern %s needs a local static key in StaticKeypair or StaticKey", pattern.Name)
+	case len(config.StaticKeypair.Public) != dhLen:
+		return invalid("the StaticKeypair public key is %d bytes, but the cipher suite needs %d", len(config.StaticKeypair.Public), dhLen)
+	}
+	if !hasStatic && len(opts.NextStatic) > 0 {
+		return invalid("pattern %s doesn't send a local static key, so NextStatic can't be advertised", pattern.Name)
+	}
+
+	peerHasStatic := identityMessage(pattern, !config.Initiator) >= 0
+	switch needsPeerStatic := preMessageStatic(pattern, !config.Initiator); {
+	case needsPeerStatic && len(config.PeerStatic) == 0:
+		return invalid("pattern %s needs the static public key of the peer in PeerStatic", pattern.Name)
+	case needsPeerStatic && len(config.PeerStatic) != dhLen:
+		return invalid("PeerStatic is %d bytes, but the cipher suite needs %d", len(config.PeerStatic), dhLen)
+	case !needsPeerStatic && len(config.PeerStatic) > 0 && peerHasStatic:
+		return invalid("pattern %s transmits the static key of the peer, so PeerStatic is ignored; use VerifyPeer to check it", pattern.Name)
+	case !needsPeerStatic && len(config.PeerStatic) > 0:
+		return invalid("pattern %s doesn't use a static key of the peer, so PeerStatic is ignored", pattern.Name)
+	}
+	if !peerHasStatic && opts.NextPeerStatic != nil {
+		return invalid("pattern %s doesn't use a static key of the peer, so NextPeerStatic is never called", pattern.Name)
+	}
+
+	if len(config.PresharedKey) > 0 {
+		if len(config.PresharedKey) != 32 {
+			return invalid("PresharedKey is %d bytes, but must be 32", len(config.PresharedKey))
+		}
+		if config.PresharedKeyPlacement < 0 || config.PresharedKeyPlacement > len(pattern.Messages) {
+			return invalid("PresharedKeyPlacement %d is out of range for pattern %s with %d messages",
+				config.PresharedKeyPlacement, pattern.Name, len(pattern.Messages))
+		}
+	}
+
+	if opts.RequireMutualAuth && len(config.PresharedKey) == 0 {
+		switch {
+		case !hasStatic:
+			return invalid("RequireMutualAuth: pattern %s doesn't authenticate this side", pattern.Name)
+		case !peerHasStatic:
+			return invalid("RequireMutualAuth: pattern %s doesn't authenticate the peer", pattern.Name)
+		}
+	}
+
+	if opts.InsecureNullCipher && nullCipher == nil {
+		return invalid("InsecureNullCipher needs the %s build tag", InsecureBuildTag)
+	}
+	if opts.FIPS {
+		if opts.InsecureNullCipher {
+			return invalid("FIPS doesn't allow InsecureNullCipher")
+		}
+		if primitive := checkFIPS(config.CipherSuite); primitive != "" {
+			return invalid("FIPS doesn't allow the %s", primitive)
+		}
+	}
+
+	if opts.SendTimestamp && !config.Initiator {
+		return invalid("SendTimestamp is only used by initiators")
+	}
+	if opts.MaxTimestampAge != 0 && config.Initiator {
+		return invalid("MaxTimestampAge is only used by responders")
+	}
+	if opts.ReplayCache != nil && opts.MaxTimestampAge == 0 {
+		return invalid("ReplayCache needs MaxTimestampAge")
+	}
+	if opts.SendRate < 0 || opts.SendBurst < 0 {
+		return invalid("SendRate and SendBurst must not be negative")
+	}
+	if opts.SendBurst > 0 && opts.SendRate == 0 {
+		return invalid("SendBurst is only used with SendRate")
+	}
+	if opts.MinFrameSize != 0 && !opts.AdaptiveFrameSize {
+		return invalid("MinFrameSize needs AdaptiveFrameSize")
+	}
+	if opts.MinFrameSize < 0 || opts.MinFrameSize > noise.MaxMsgLen {
+		return invalid("MinFrameSize %d is out of range, the maximum is %d", opts.MinFrameSize, noise.MaxMsgLen)
+	}
+	if opts.MaxDecryptFailures < 0 || opts.MaxHandshakePayload < 0 {
+		return invalid("MaxDecryptFailures and MaxHandshakePayload must not be negative")
+	}
+	if opts.RekeyInterval < 0 || opts.RekeyAfterIdle < 0 || opts.RekeyAfterBytes < 0 {
+		return invalid("rekey thresholds must not be negative")
+	}
+	if opts.MaxFrameSize != 0 && (opts.MaxFrameSize < minMaxFrameSize || opts.MaxFrameSize > noise.MaxMsgLen) {
+		return invalid("MaxFrameSize %d is out of range, it must be between %d and %d", opts.MaxFrameSize, minMaxFrameSize, noise.MaxMsgLen)
+	}
+	if opts.StaticKeys != nil && (opts.StaticKey != nil || opts.SelectStatic != nil || len(opts.Identity) > 0) {
+		return invalid("StaticKeys can't be used with StaticKey, SelectStatic or Identity")
+	}
+	if len(opts.FallbackPattern.Messages) > 0 {
+		switch {
+		case config.Initiator:
+			return invalid("FallbackPattern is only used by responders")
+		case !preMessageStatic(pattern, false):
+			return invalid("pattern %s can't fail to read the first message, so FallbackPattern is never used", pattern.Name)
+		case opts.SelectStatic != nil:
+			return invalid("FallbackPattern can't be used with SelectStatic")
+		}
+		fallback, fallbackOpts := config, opts
+		fallback.Pattern, fallbackOpts.FallbackPattern = opts.FallbackPattern, noise.HandshakePattern{}
+		if err := ValidateConfig(fallback, fallbackOpts); err != nil {
+			return fmt.Errorf("FallbackPattern: %w", err)
+		}
+	}
+	seen := make(map[byte]bool)
+	for _, ext := range opts.Extensions {
+		if ext.Type < minExtensionType {
+			return invalid("extension type %d is reserved, types must be at least %d", ext.Type, minExtensionType)
+		}
+		if seen[ext.Type] {
+			return invalid("extension type %d is registered twice", ext.Type)
+		}
+		seen[ext.Type] = true
+	}
+	if opts.Framing != FramingDefault && opts.Framing != FramingLengthPrefixed {
+		return invalid("unknown Framing %d", opts.Framing)
+	}
+	if opts.Framing != FramingDefault && (opts.RekeyInterval > 0 || opts.RekeyAfterIdle > 0 || opts.RekeyAfterBytes > 0) {
+		return invalid("rekeying needs control frames, which %s framing can't carry", opts.Framing)
+	}
+	if opts.KeepaliveInterval < 0 || (opts.IdleTimeout != 0 && opts.IdleTimeout < minIdleTimeout) {
+		return invalid("KeepaliveInterval must not be negative, and IdleTimeout must be at least %v", minIdleTimeout)
+	}
+	if opts.PingInterval < 0 || opts.Linger < 0 {
+		return invalid("PingInterval and Linger must not be negative")
+	}
+	if opts.Framing != FramingDefault && (opts.KeepaliveInterval > 0 || opts.IdleTimeout > 0 || opts.PingInterval > 0) {
+		return invalid("keepalives need control frames, which %s framing can't carry", opts.Framing)
+	}
+	if opts.EarlyDataTokens != nil && config.Initiator {
+		return invalid("EarlyDataTokens is only used by responders")
+	}
+	if (opts.EarlyDataToken != nil || opts.OnEarlyDataToken != nil) && !config.Initiator {
+		return invalid("EarlyDataToken and OnEarlyDataToken are only used by initiators")
+	}
+	return nil
+}
+
+// preMessageStatic returns whether the static key of the initiator, or of
+// the responder, is a pre-message of pattern.
+func preMessageStatic(pattern noise.HandshakePattern, initiator bool) bool {
+	pre := pattern.ResponderPreMessages
+	if initiator {
+		pre = pattern.InitiatorPreMessages
+	}
+	for _, token := range pre {
+		if token == noise.MessagePatternS {
+			return true
+		}
+	}
+	return false
+}
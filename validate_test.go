@@ -0,0 +1,72 @@
+package noiseconn
+
+import (
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/flynn/noise"
+)
+
+func TestValidateConfig(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	key, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	for _, test := range []struct {
+		name   string
+		config noise.Config
+		opts   Options
+		valid  bool
+	}{
+		{name: "nn", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, valid: true},
+		{name: "no cipher suite", config: noise.Config{Pattern: noise.HandshakeNN}},
+		{name: "no pattern", config: noise.Config{CipherSuite: cs}},
+		{name: "xx without static", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeXX, Initiator: true}},
+		{name: "xx with static", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeXX, Initiator: true, StaticKeypair: key}, valid: true},
+		{name: "nk without peer static", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNK, Initiator: true}},
+		{name: "nk with short peer static", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNK, Initiator: true, PeerStatic: key.Public[:16]}},
+		{name: "nk", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNK, Initiator: true, PeerStatic: key.Public}, valid: true},
+		{name: "xx with peer static", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeXX, Initiator: true, StaticKeypair: key, PeerStatic: key.Public}},
+		{name: "short psk", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, PresharedKey: make([]byte, 16)}},
+		{name: "psk placement", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, PresharedKey: make([]byte, 32), PresharedKeyPlacement: 3}},
+		{name: "psk", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, PresharedKey: make([]byte, 32), PresharedKeyPlacement: 2}, valid: true},
+		{name: "responder timestamp", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, opts: Options{SendTimestamp: true}},
+		{name: "replay cache", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, opts: Options{ReplayCache: NewMemoryReplayCache()}},
+		{name: "mutual nn", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, opts: Options{RequireMutualAuth: true}},
+		{name: "mutual nx responder", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNX, StaticKeypair: key}, opts: Options{RequireMutualAuth: true}},
+		{name: "mutual nk initiator", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNK, Initiator: true, PeerStatic: key.Public}, opts: Options{RequireMutualAuth: true}},
+		{name: "mutual xx", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeXX, StaticKeypair: key}, opts: Options{RequireMutualAuth: true}, valid: true},
+		{name: "mutual kk", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeKK, Initiator: true, StaticKeypair: key, PeerStatic: key.Public}, opts: Options{RequireMutualAuth: true}, valid: true},
+		{name: "mutual psk", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN, PresharedKey: make([]byte, 32)}, opts: Options{RequireMutualAuth: true}, valid: true},
+		{name: "reserved extension", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, opts: Options{Extensions: []Extension{{Type: 5}}}},
+		{name: "duplicate extension", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, opts: Options{Extensions: []Extension{{Type: 200}, {Type: 200}}}},
+		{name: "small max frame size", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, opts: Options{MaxFrameSize: 100}},
+		{name: "max frame size", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, opts: Options{MaxFrameSize: 1024}, valid: true},
+		{name: "static keys", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeXX}, opts: Options{StaticKeys: NewStaticKeys(key)}, valid: true},
+		{name: "static keys with identity", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeXX}, opts: Options{StaticKeys: NewStaticKeys(key), Identity: []Certificate{{}}}},
+		{name: "fallback pattern", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeIK, StaticKeypair: key}, opts: Options{FallbackPattern: noise.HandshakeXX}, valid: true},
+		{name: "fallback pattern without pre-message key", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeXX, StaticKeypair: key}, opts: Options{FallbackPattern: noise.HandshakeNN}},
+		{name: "invalid fallback pattern", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeIK, StaticKeypair: key}, opts: Options{FallbackPattern: noise.HandshakeKK}},
+		{name: "send burst without rate", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, opts: Options{SendBurst: 1024}},
+		{name: "send rate", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, opts: Options{SendRate: 1 << 20, SendBurst: 1024}, valid: true},
+		{name: "negative ping interval", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, opts: Options{PingInterval: -1}},
+		{name: "length-prefixed ping interval", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, opts: Options{Framing: FramingLengthPrefixed, PingInterval: 1}},
+		{name: "negative linger", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, opts: Options{Linger: -1}},
+		{name: "unknown framing", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, opts: Options{Framing: 7}},
+		{name: "length-prefixed rekey", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, opts: Options{Framing: FramingLengthPrefixed, RekeyAfterBytes: 1}},
+		{name: "length-prefixed", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, opts: Options{Framing: FramingLengthPrefixed}, valid: true},
+		{name: "fips chachapoly", config: noise.Config{CipherSuite: cs, Pattern: noise.HandshakeNN}, opts: Options{FIPS: true}},
+		{name: "fips blake2s", config: noise.Config{CipherSuite: noise.NewCipherSuite(noise.DH25519, noise.CipherAESGCM, noise.HashBLAKE2s), Pattern: noise.HandshakeNN}, opts: Options{FIPS: true}},
+		{name: "fips", config: noise.Config{CipherSuite: noise.NewCipherSuite(noise.DH25519, noise.CipherAESGCM, noise.HashSHA256), Pattern: noise.HandshakeNN}, opts: Options{FIPS: true}, valid: true},
+	} {
+		err := ValidateConfig(test.config, test.opts)
+		if test.valid != (err == nil) {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("%s: expected ErrInvalidConfig, got %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package noiseconn
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/flynn/noise"
+	"github.com/zeebo/errs"
+)
+
+// VirtualHost is the configuration a Listener with VirtualHosts uses for
+// the handshakes of connections requesting its server name. The Listener
+// settings, such as Policy and HandshakeTimeout, still apply, and so do
+// the ProxyProtocol and Framing of the Listener options, as they are needed
+// to read the server name.
+type VirtualHost struct {
+	Config  noise.Config
+	Options Options
+}
+
+// ServerName returns the server name, or static key hint, that the
+// initiator sent with Options.ServerName or StaticHint, on responders
+// that read it with Options.SelectStatic or a Listener with VirtualHosts.
+func (c *Conn) ServerName() string {
+	c.hsMu.Lock()
+	defer c.hsMu.Unlock()
+	return c.serverName
+}
+
+// virtualHost reads the server name sent by the initiator on conn and
+// returns the config and options of its VirtualHost. The handshake is
+// interrupted if ctx is done.
+func (l *Listener) virtualHost(ctx context.Context, conn net.Conn, opts Options) (string, noise.Config, Options, error) {
+	framed, mt, err := applyFraming(conn, opts.Framing)
+	if err != nil {
+		return "", noise.Config{}, Options{}, err
+	}
+	if mt == nil {
+		mt, _ = conn.(MessageTransport)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return "", noise.Config{}, Options{}, errs.Wrap(err)
+		}
+		defer func() { _ = conn.SetReadDeadline(time.Time{}) }()
+	}
+	done := make(chan struct{})
+	interrupted := make(chan struct{})
+	go func() {
+		defer close(interrupted)
+		select {
+		case <-ctx.Done():
+			_ = conn.SetReadDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+	name, err := readHintFrame(mt, framed)
+	close(done)
+	<-interrupted
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return "", noise.Config{}, Options{}, errs.Wrap(ctxErr)
+	}
+	if err != nil {
+		return "", noise.Config{}, Options{}, errs.Wrap(err)
+	}
+
+	host, ok := l.VirtualHosts[string(name)]
+	if !ok {
+		return "", noise.Config{}, Options{}, errs.New("unknown server name %q", name)
+	}
+	if host.Options.SelectStatic != nil {
+		return "", noise.Config{}, Options{}, errs.New("virtual host %q can't use SelectStatic", name)
+	}
+	config := host.Config
+	config.Prologue = bindNegotiation(config.Prologue, "hint", name)
+	hostOpts := host.Options
+	hostOpts.ProxyProtocol, hostOpts.Framing = false, opts.Framing
+	return string(name), config, hostOpts, nil
+}